@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/control"
+	"github.com/labi-le/belphegor/internal/paths"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+	flag "github.com/spf13/pflag"
+)
+
+const previewLen = 60
+
+// ctlCommand talks to a running daemon through the control socket instead of starting a node
+type ctlCommand struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error
+	flags func(fs *flag.FlagSet)
+}
+
+var ctlCommands = map[string]ctlCommand{
+	"peers": {
+		usage: "peers",
+		help:  "list connected peers",
+		run:   runPeers,
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("static", false, "List configured addresses and their connection state")
+			fs.Bool("routes", false, "List devices reached through relays and the peer in between")
+		},
+	},
+	"connect": {
+		usage: "connect <addr>",
+		help:  "connect to the node at ip:port",
+		run:   runConnect,
+	},
+	"disconnect": {
+		usage: "disconnect <id>",
+		help:  "drop the connection with the peer",
+		run:   runDisconnect,
+	},
+	"send": {
+		usage: "send <file|->",
+		help:  "put a file or stdin into the clipboard and sync it",
+		run:   runSend,
+		flags: func(fs *flag.FlagSet) {
+			fs.String("mime", "", "Payload type for stdin: text, image (default: detect)")
+			fs.Duration("ttl", 0, "Clear it from every clipboard after this long (default: the daemon ttl)")
+		},
+	},
+	"paste": {
+		usage: "paste [id]",
+		help:  "print the current clipboard or a history entry",
+		run:   runPaste,
+	},
+	"pair": {
+		usage: "pair [accept|reject <code>]",
+		help:  "list or answer pairing requests of unknown devices",
+		run:   runPair,
+	},
+	"devices": {
+		usage: "devices [rename <device> <name>|revoke <device>|relay <device> [on|off]]",
+		help:  "list, rename, revoke paired devices or make them relays, <device> is a name or fingerprint prefix",
+		run:   runDevices,
+	},
+	"transfers": {
+		usage: "transfers [cancel <id> [peer]]",
+		help:  "list running transfers or cancel the ones of a message, with a peer only that one",
+		run:   runTransfers,
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("watch", false, "Print every change until interrupted")
+		},
+	},
+	"history": {
+		usage: "history [query]",
+		help:  "list or search the clipboard history",
+		run:   runHistory,
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("limit", 20, "Maximum number of entries")
+		},
+	},
+}
+
+func ctlUsage() string {
+	var b strings.Builder
+	b.WriteString("Commands (talk to a running daemon):\n")
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, name := range []string{"peers", "connect", "disconnect", "pair", "devices", "send", "paste", "transfers", "history"} {
+		fmt.Fprintf(w, "  belphegor %s\t%s\n", ctlCommands[name].usage, ctlCommands[name].help)
+	}
+	_ = w.Flush()
+
+	return b.String()
+}
+
+// runCtl executes a client subcommand, false means args do not start with one
+func runCtl(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	cmd, ok := ctlCommands[args[0]]
+	if !ok {
+		return 0, false
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	socket := fs.String("control_socket", paths.ControlSocket(), "Path of the daemon control socket")
+	fs.Bool("json", false, "Print raw json")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: belphegor %s\n%s\n", cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, true
+		}
+		return 2, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	if watch, _ := fs.GetBool("watch"); watch {
+		cancel()
+		ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	}
+	defer cancel()
+
+	if err := cmd.run(ctx, control.NewClient(*socket), fs, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "belphegor %s: %s\n", args[0], err)
+		return 1, true
+	}
+
+	return 0, true
+}
+
+func printJSON(fs *flag.FlagSet, v any) bool {
+	if asJSON, _ := fs.GetBool("json"); !asJSON {
+		return false
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+	return true
+}
+
+func runPeers(ctx context.Context, c *control.Client, fs *flag.FlagSet, _ []string) error {
+	if static, _ := fs.GetBool("static"); static {
+		return runStaticPeers(ctx, c, fs)
+	}
+	if routes, _ := fs.GetBool("routes"); routes {
+		return runRoutes(ctx, c, fs)
+	}
+
+	peers, err := c.Peers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, peers) {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tARCH\tADDR\tPOLICY")
+	for _, p := range peers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", p.ID, p.Name, p.Arch, p.Addr, p.Policy)
+	}
+	return w.Flush()
+}
+
+func runStaticPeers(ctx context.Context, c *control.Client, fs *flag.FlagSet) error {
+	peers, err := c.StaticPeers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, peers) {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDR\tSTATE\tATTEMPTS\tRETRY\tERROR")
+	for _, p := range peers {
+		retry := "-"
+		if !p.Retry.IsZero() {
+			retry = humanize.Time(p.Retry)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", p.Addr, p.State, p.Attempts, retry, p.Error)
+	}
+	return w.Flush()
+}
+
+func runRoutes(ctx context.Context, c *control.Client, fs *flag.FlagSet) error {
+	routes, err := c.Routes(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, routes) {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORIGIN\tVIA\tHOPS\tSEEN")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", r.Origin, r.Via, r.Hops, humanize.Time(r.Seen))
+	}
+	return w.Flush()
+}
+
+func runConnect(ctx context.Context, c *control.Client, _ *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one address")
+	}
+
+	return c.Connect(ctx, args[0])
+}
+
+func runDisconnect(ctx context.Context, c *control.Client, _ *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one peer id")
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid peer id: %w", err)
+	}
+
+	return c.Disconnect(ctx, domain.NodeID(id))
+}
+
+func runPair(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return listPairing(ctx, c, fs)
+	}
+
+	if len(args) < 2 {
+		return errors.New("expected a pairing code or fingerprint")
+	}
+	// the code may be passed unquoted: pair accept 123 456
+	ref := strings.Join(args[1:], "")
+
+	switch args[0] {
+	case "accept":
+		dev, err := c.AcceptPairing(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if printJSON(fs, dev) {
+			return nil
+		}
+		fmt.Printf("paired with %s (%s)\n", dev.Name, dev.Fingerprint)
+		return nil
+	case "reject":
+		return c.RejectPairing(ctx, ref)
+	default:
+		return fmt.Errorf("unknown action %q, expected accept or reject", args[0])
+	}
+}
+
+func listPairing(ctx context.Context, c *control.Client, fs *flag.FlagSet) error {
+	status, err := c.Pairing(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, status) {
+		return nil
+	}
+
+	fmt.Printf("this device: %s\n\n", status.Self)
+	if len(status.Pending) == 0 {
+		fmt.Println("no pairing requests")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tFINGERPRINT\tSEEN")
+	for _, r := range status.Pending {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Code, r.Name, r.Fingerprint, humanize.Time(r.Seen))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\ncheck that the other device shows the same code, then run: belphegor pair accept <code>")
+	return nil
+}
+
+func runDevices(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return listDevices(ctx, c, fs)
+	}
+
+	var (
+		dev control.Device
+		err error
+	)
+	switch {
+	case args[0] == "rename" && len(args) >= 3:
+		dev, err = c.RenameDevice(ctx, args[1], strings.Join(args[2:], " "))
+	case args[0] == "revoke" && len(args) == 2:
+		dev, err = c.RevokeDevice(ctx, args[1])
+	case args[0] == "relay" && len(args) == 2:
+		dev, err = c.SetRelay(ctx, args[1], true)
+	case args[0] == "relay" && len(args) == 3 && (args[2] == "on" || args[2] == "off"):
+		dev, err = c.SetRelay(ctx, args[1], args[2] == "on")
+	case args[0] == "rename", args[0] == "revoke", args[0] == "relay":
+		return fmt.Errorf("expected a device and, for rename, the new name, for relay, on or off")
+	default:
+		return fmt.Errorf("unknown action %q, expected rename, revoke or relay", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, dev) {
+		return nil
+	}
+	switch {
+	case args[0] == "revoke":
+		fmt.Printf("revoked %s (%s)\n", dev.Name, dev.Fingerprint)
+	case args[0] == "relay" && dev.Relay:
+		fmt.Printf("%s forwards our payloads without reading them\n", dev.Name)
+	case args[0] == "relay":
+		fmt.Printf("%s reads our payloads again\n", dev.Name)
+	default:
+		fmt.Printf("renamed %s to %s\n", dev.Fingerprint, dev.Name)
+	}
+
+	return nil
+}
+
+func listDevices(ctx context.Context, c *control.Client, fs *flag.FlagSet) error {
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, devices) {
+		return nil
+	}
+
+	if len(devices) == 0 {
+		fmt.Println("no paired devices")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFINGERPRINT\tPAIRED\tROLE")
+	for _, d := range devices {
+		role := "reader"
+		if d.Relay {
+			role = "relay"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, d.Fingerprint, humanize.Time(d.Paired), role)
+	}
+
+	return w.Flush()
+}
+
+func runSend(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a file path or - for stdin")
+	}
+
+	ttl, _ := fs.GetDuration("ttl")
+
+	if args[0] != "-" {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		return c.Push(ctx, mime.TypePath.String(), []byte(path), ttl)
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("read stdin: %w", err)
+	}
+
+	t, _ := fs.GetString("mime")
+	if t == "" {
+		t = mime.From(data).String()
+	}
+
+	return c.Push(ctx, t, data, ttl)
+}
+
+func runPaste(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	var (
+		msg control.Message
+		err error
+	)
+
+	switch len(args) {
+	case 0:
+		msg, err = c.Clipboard(ctx)
+	case 1:
+		id, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid message id: %w", parseErr)
+		}
+		msg, err = c.HistoryEntry(ctx, domain.MessageID(id))
+	default:
+		return errors.New("expected at most one message id")
+	}
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, msg) {
+		return nil
+	}
+
+	_, err = os.Stdout.Write(msg.Data)
+	return err
+}
+
+func runTransfers(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		return cancelTransfer(ctx, c, args)
+	}
+
+	if watch, _ := fs.GetBool("watch"); watch {
+		asJSON, _ := fs.GetBool("json")
+		enc := json.NewEncoder(os.Stdout)
+		return c.WatchTransfers(ctx, func(t control.Transfer) {
+			if asJSON {
+				_ = enc.Encode(t)
+				return
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n", t.ID, t.State, t.Direction, t.Name, progress(t))
+		})
+	}
+
+	transfers, err := c.Transfers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, transfers) {
+		return nil
+	}
+
+	if len(transfers) == 0 {
+		fmt.Println("no transfers")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPEER\tDIRECTION\tNAME\tPROGRESS\tSTARTED")
+	for _, t := range transfers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			t.ID,
+			t.PeerName,
+			t.Direction,
+			t.Name,
+			progress(t),
+			humanize.Time(t.Started),
+		)
+	}
+	return w.Flush()
+}
+
+func cancelTransfer(ctx context.Context, c *control.Client, args []string) error {
+	if args[0] != "cancel" || len(args) < 2 || len(args) > 3 {
+		return errors.New("expected cancel <id> [peer]")
+	}
+
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid message id: %w", err)
+	}
+
+	var peer int64
+	if len(args) == 3 {
+		if peer, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return fmt.Errorf("invalid peer id: %w", err)
+		}
+	}
+
+	return c.CancelTransfer(ctx, domain.MessageID(id), domain.NodeID(peer))
+}
+
+func progress(t control.Transfer) string {
+	percent := 100.0
+	if t.Size > 0 {
+		percent = float64(t.Done) * 100 / float64(t.Size)
+	}
+
+	return fmt.Sprintf("%.0f%% of %s, %s/s", percent, humanize.Bytes(t.Size), humanize.Bytes(t.Rate))
+}
+
+func runHistory(ctx context.Context, c *control.Client, fs *flag.FlagSet, args []string) error {
+	limit, _ := fs.GetInt("limit")
+
+	entries, err := c.History(ctx, strings.Join(args, " "), limit)
+	if err != nil {
+		return err
+	}
+
+	if printJSON(fs, entries) {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOPIED\tFROM\tMIME\tSIZE\tPREVIEW")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n",
+			e.ID,
+			humanize.Time(e.Created),
+			e.From,
+			e.Mime,
+			humanize.Bytes(e.Size),
+			preview(e),
+		)
+	}
+	return w.Flush()
+}
+
+func preview(m control.Message) string {
+	if m.Mime != mime.TypeText.String() {
+		if m.Name != "" {
+			return m.Name
+		}
+		return "-"
+	}
+
+	line := strings.Join(strings.Fields(string(m.Data)), " ")
+	if r := []rune(line); len(r) > previewLen {
+		return string(r[:previewLen]) + "…"
+	}
+	return line
+}
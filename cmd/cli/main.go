@@ -3,74 +3,150 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"time"
 
 	"github.com/labi-le/belphegor/internal/channel"
+	"github.com/labi-le/belphegor/internal/config"
 	"github.com/labi-le/belphegor/internal/console"
+	"github.com/labi-le/belphegor/internal/control"
 	"github.com/labi-le/belphegor/internal/discovering"
+	"github.com/labi-le/belphegor/internal/history"
 	"github.com/labi-le/belphegor/internal/lock"
 	"github.com/labi-le/belphegor/internal/metadata"
 	"github.com/labi-le/belphegor/internal/node"
 	"github.com/labi-le/belphegor/internal/notification"
+	"github.com/labi-le/belphegor/internal/paths"
+	"github.com/labi-le/belphegor/internal/relay"
 	"github.com/labi-le/belphegor/internal/security"
 	"github.com/labi-le/belphegor/internal/service"
 	"github.com/labi-le/belphegor/internal/store"
+	"github.com/labi-le/belphegor/internal/transfer"
 	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/multi"
 	"github.com/labi-le/belphegor/internal/transport/quic"
 	"github.com/labi-le/belphegor/internal/transport/tcp"
+	"github.com/labi-le/belphegor/internal/transport/unix"
+	"github.com/labi-le/belphegor/internal/transport/ws"
+	"github.com/labi-le/belphegor/internal/types/domain"
 	"github.com/labi-le/belphegor/pkg/clipboard"
+	"github.com/labi-le/belphegor/pkg/id"
 	"github.com/rs/zerolog"
 	flag "github.com/spf13/pflag"
 )
 
-func parseFlags() (node.Options, string) {
+// parseFlags builds options from base, the config file and args, in ascending priority.
+// Invalid args exit with flag.ExitOnError and are returned with flag.ContinueOnError
+func parseFlags(args []string, base node.Options, handling flag.ErrorHandling) (node.Options, error) {
 	var (
-		opts      = node.DefaultOptions()
-		defaults  = node.DefaultOptions()
-		connectTo string
+		opts       = base
+		defaults   = base
+		configPath string
+		flags      = flag.NewFlagSet(os.Args[0], handling)
 	)
 
-	flag.IntVarP(&opts.ListenPort, "port", "p", defaults.ListenPort, "Listen port")
-	flag.BoolVar(&opts.Discovering.Enable, "node_discover", defaults.Discovering.Enable, "Find local nodes on the network and connect to them")
-	flag.DurationVar(&opts.Discovering.Delay, "discover_delay", defaults.Discovering.Delay, "Delay between node discovery")
-	flag.DurationVar(&opts.KeepAlive, "keep_alive", defaults.KeepAlive, "Interval for checking connections between nodes")
-	flag.DurationVar(&opts.Deadline.Write, "write_timeout", defaults.Deadline.Write, "Write timeout")
-	flag.DurationVar(&opts.Deadline.Read, "read_timeout", defaults.Deadline.Read, "Read timeout")
-	flag.IntVar(&opts.MaxPeers, "max_peers", defaults.MaxPeers, "Maximum number of discovered peers")
-	flag.StringVar(&opts.Secret, "secret", defaults.Secret, "Key to connect between node (empty=all may connect)")
-	flag.BoolVar(&opts.Clip.AllowCopyFiles, "allow_copy_files", defaults.Clip.AllowCopyFiles, "Allow to copy files")
-	flag.IntVar(&opts.Clip.MaxClipboardFiles, "max_clipboard_files", defaults.Clip.MaxClipboardFiles, "Maximum number of files that can be copied (and announced) in a single copy operation")
-	flag.Var(&opts.Transport, "transport", "Transport protocol: quic, tcp")
-
-	flag.StringVarP(&connectTo, "connect", "c", "", "Address in ip:port format to connect to the node")
-	flag.BoolVar(&opts.Verbose, "verbose", defaults.Verbose, "Verbose logs")
-	flag.BoolVar(&opts.Notify, "notify", defaults.Notify, "Enable notifications")
-	flag.BoolVarP(&opts.ShowVersion, "version", "v", defaults.ShowVersion, "Show version")
-	flag.BoolVarP(&opts.ShowHelp, "help", "h", defaults.ShowHelp, "Show help")
-	flag.BoolVar(&opts.Hidden, "hidden", defaults.Hidden, "Hide console window (for windows user)")
-	flag.BoolVar(&opts.InstallService, "install_service", defaults.InstallService, "Install systemd-unit and start the service")
-
-	flag.Var(&opts.Clip.MaxFileSize, "max_file_size", "Maximum file size to receive (e.g. 500MiB)")
-	flag.StringVar(&opts.FileSavePath, "file_save_path", defaults.FileSavePath, "Folder where the files sent to us will be saved")
-
-	flag.Parse()
+	flags.StringVar(&configPath, "config", paths.ConfigFile(), "Path of the config file, its keys are the flag names")
+
+	flags.IntVarP(&opts.ListenPort, "port", "p", defaults.ListenPort, "Listen port")
+	flags.BoolVar(&opts.Discovering.Enable, "node_discover", defaults.Discovering.Enable, "Find local nodes on the network and connect to them")
+	flags.DurationVar(&opts.Discovering.Delay, "discover_delay", defaults.Discovering.Delay, "Delay between node discovery")
+	flags.Var(&opts.Discovering.Backend, "discover_backend", "How nodes find each other: beacon or mdns (DNS-SD, seen by Avahi and Bonjour)")
+	flags.DurationVar(&opts.KeepAlive, "keep_alive", defaults.KeepAlive, "Interval for checking connections between nodes")
+	flags.DurationVar(&opts.Deadline.Write, "write_timeout", defaults.Deadline.Write, "Write timeout")
+	flags.DurationVar(&opts.Deadline.Read, "read_timeout", defaults.Deadline.Read, "Read timeout")
+	flags.IntVar(&opts.MaxPeers, "max_peers", defaults.MaxPeers, "Maximum number of discovered peers")
+	flags.IntVar(&opts.MaxHops, "max_hops", defaults.MaxHops, "Connections a copy crosses from its device through relaying peers, 1 = direct peers only")
+	flags.StringVar(&opts.Secret, "secret", defaults.Secret, "Shared key, devices that know it connect without pairing")
+	flags.BoolVar(&opts.E2E, "e2e", defaults.E2E, "Encrypt payloads for the paired devices, so relays cannot read them")
+	flags.BoolVar(&opts.Clip.AllowCopyFiles, "allow_copy_files", defaults.Clip.AllowCopyFiles, "Allow to copy files")
+	flags.BoolVar(&opts.Clip.LazyFiles, "lazy_files", defaults.Clip.LazyFiles, "Put received files into the clipboard at once and download them when pasted (wayland, x11)")
+	flags.IntVar(&opts.Clip.MaxClipboardFiles, "max_clipboard_files", defaults.Clip.MaxClipboardFiles, "Maximum number of files that can be copied (and announced) in a single copy operation")
+	flags.Var(&opts.Transport, "transport", "Transport protocols to listen with, dials try them in order: quic, tcp, ws, unix")
+	flags.StringVar(&opts.SocketDir, "socket_dir", defaults.SocketDir, "Folder for the sockets of the unix transport, <port>.sock each (default: abstract namespace)")
+
+	flags.StringSliceVarP(&opts.Peers, "connect", "c", defaults.Peers, "Address in ip:port format (or a ws:// / wss:// URL with --transport ws) to keep connected to, redialed when the connection drops (repeatable)")
+	flags.StringVar(&opts.Relay, "relay", defaults.Relay, "Address of a relay in host:port format, devices on other networks are reached through it")
+	flags.Var(&opts.Scope.Allow, "network", "Interface name or CIDR range to discover peers on and accept connections from, default all (repeatable)")
+	flags.Var(&opts.Scope.Untrusted, "untrusted_network", "Interface name or CIDR range the node does not announce itself on nor connect to the peers found there (repeatable)")
+	flags.Var(&opts.Policies, "policy", "Limit sync with a device, first match wins: name|id|*=both|send|receive[:text,image,file][@ttl] (repeatable)")
+	flags.Var(&opts.Upload, "upload_limit", "Maximum upload rate to all peers together, e.g. 2MiB (0=unlimited)")
+	flags.Var(&opts.Download, "download_limit", "Maximum download rate from all peers together, e.g. 8MiB (0=unlimited)")
+	flags.Var(&opts.RateLimits, "peer_rate_limit", "Limit the rate with a device, first match wins: name|id|*=up:rate[,down:rate] (repeatable)")
+	flags.DurationVar(&opts.TTL, "ttl", defaults.TTL, "Clear what we copy from every clipboard after this long (0=never)")
+	flags.DurationVar(&opts.Reconnect.MinDelay, "reconnect_min_delay", defaults.Reconnect.MinDelay, "Delay before the first redial of a static peer")
+	flags.DurationVar(&opts.Reconnect.MaxDelay, "reconnect_max_delay", defaults.Reconnect.MaxDelay, "Maximum delay between redials of a static peer")
+	flags.BoolVar(&opts.Verbose, "verbose", defaults.Verbose, "Verbose logs")
+	flags.BoolVar(&opts.Notify, "notify", defaults.Notify, "Enable notifications")
+	flags.BoolVarP(&opts.ShowVersion, "version", "v", defaults.ShowVersion, "Show version")
+	flags.BoolVarP(&opts.ShowHelp, "help", "h", defaults.ShowHelp, "Show help")
+	flags.BoolVar(&opts.Hidden, "hidden", defaults.Hidden, "Hide console window (for windows user)")
+	flags.BoolVar(&opts.InstallService, "install_service", defaults.InstallService, "Install systemd-unit and start the service")
+
+	flags.Var(&opts.Clip.MaxFileSize, "max_file_size", "Maximum file size to receive (e.g. 500MiB)")
+	flags.StringVar(&opts.FileSavePath, "file_save_path", defaults.FileSavePath, "Folder where the files sent to us will be saved")
+	flags.StringVar(&opts.StateDir, "state_dir", defaults.StateDir, "Folder for persistent state (history, keys)")
+	flags.StringVar(&opts.ControlSocket, "control_socket", defaults.ControlSocket, "Path of the local control socket (empty=disabled)")
+
+	flags.BoolVar(&opts.Sensitive.Enable, "filter_sensitive", defaults.Sensitive.Enable, "Keep passwords, private keys and API tokens from syncing")
+	flags.StringArrayVar(&opts.Sensitive.Patterns, "filter_pattern", defaults.Sensitive.Patterns, "Regular expression for text that must not be synced (repeatable)")
+	flags.Float64Var(&opts.Sensitive.Entropy, "filter_entropy", defaults.Sensitive.Entropy, "Keep single words of 16+ chars with at least this many bits of entropy per char local, e.g. 3.5 (0=off)")
+
+	flags.BoolVar(&opts.History.Enable, "history", defaults.History.Enable, "Keep a persistent clipboard history")
+	flags.IntVar(&opts.History.MaxEntries, "history_max_entries", defaults.History.MaxEntries, "Maximum number of history entries")
+	flags.DurationVar(&opts.History.MaxAge, "history_max_age", defaults.History.MaxAge, "Drop history entries older than this")
+	flags.Var(&opts.History.MaxSize, "history_max_size", "Maximum total size of history entries (e.g. 64MiB)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flags.PrintDefaults()
+		fmt.Fprint(os.Stderr, "\n"+ctlUsage())
+		fmt.Fprintf(os.Stderr, "\nServer mode:\n  belphegor %s  %s\n", relayUsage, relayHelp)
+	}
+	if err := flags.Parse(args); err != nil {
+		return node.Options{}, err
+	}
 
 	if opts.ShowHelp {
-		flag.Usage()
+		flags.Usage()
 		os.Exit(0)
 	}
 
-	return opts.Validated(), connectTo
+	entries, err := config.Load(configPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && !flags.Changed("config"):
+		// the default config is optional
+	case err != nil:
+		return node.Options{}, err
+	default:
+		if err := config.Apply(flags, entries); err != nil {
+			return node.Options{}, err
+		}
+	}
+
+	return opts.Validated(), nil
 }
 
 func main() {
+	if code, ok := runCtl(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+	if code, ok := runRelay(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	opts, addressIP := parseFlags()
+	opts, err := parseFlags(os.Args[1:], node.DefaultOptions(), flag.ExitOnError)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	applyTagsOverrides(&opts)
 	logger := initLogger(opts.Verbose)
@@ -112,39 +188,147 @@ func main() {
 	opts.Logger = logger
 	opts.Notifier = notification.New(opts.Notify)
 	opts.Store = store.MustFileStore(opts.FileSavePath, logger)
+	opts.Transfers = transfer.NewManager()
+	if opts.Hidden {
+		opts.Transfers.Subscribe(func(transfer.Transfer) {
+			console.ShowTransfers(opts.Transfers.Summary())
+		})
+	}
+
+	if opts.Sensitive.Enable {
+		sensitive, filterErr := opts.Sensitive.Filter()
+		if filterErr != nil {
+			logger.Fatal().Err(filterErr).Msg("invalid filter_pattern")
+		}
+		opts.Filter = sensitive
+	}
+
+	generatorNode, err := id.Load(filepath.Join(opts.StateDir, "node_id"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to load node id")
+	}
+	if err := id.SetNode(generatorNode); err != nil {
+		logger.Fatal().Err(err).Msg("failed to set node id")
+	}
+
+	identity, err := security.LoadIdentity(filepath.Join(opts.StateDir, "identity.pem"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to load device identity")
+	}
+	nodeID := identity.Fingerprint().DeviceID()
+	id.SetMine(nodeID)
+	opts.Metadata.ID = domain.NodeID(nodeID)
+
+	trust, err := security.OpenTrustStore(filepath.Join(opts.StateDir, "trusted.json"), identity.Fingerprint())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to open trusted devices")
+	}
+
+	verifier := security.NewVerifier(trust, opts.Secret)
+	opts.Authorizer = verifier
+	if opts.E2E {
+		opts.Envelope = security.NewSealer(identity, trust)
+	}
 
-	tlsConfig, err := security.MakeTLSConfig(opts.Secret, logger)
+	tlsConfig, err := security.MakeTLSConfig(identity, verifier, opts.Metadata.Name, logger)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("failed to generate TLS config")
 	}
 
+	logger.Info().
+		Int64("node_id", nodeID).
+		Str("fingerprint", identity.Fingerprint().String()).
+		Msg("device identity")
+
+	var (
+		chOpts   []channel.Option
+		ctrlOpts = []control.Option{
+			control.WithLogger(logger),
+			control.WithPairing(trust),
+		}
+	)
+	if opts.History.Enable {
+		hist, histErr := history.Open(filepath.Join(opts.StateDir, "history.log"), opts.History.Retention(), logger)
+		if histErr != nil {
+			logger.Fatal().Err(histErr).Msg("failed to open history")
+		}
+		defer func() {
+			if closeErr := hist.Close(); closeErr != nil {
+				logger.Warn().Err(closeErr).Msg("close history")
+			}
+		}()
+
+		chOpts = append(chOpts, channel.WithRecorder(hist))
+		ctrlOpts = append(ctrlOpts, control.WithHistory(hist))
+	}
+
 	nd := node.New(
-		selectTransport(opts.Transport, tlsConfig, opts.KeepAlive, logger),
+		selectTransports(opts.Transport, opts.SocketDir, tlsConfig, opts.KeepAlive, logger),
 		clipboard.New(logger, opts.Clip),
 		new(node.Storage),
-		channel.New(opts.MaxPeers),
+		channel.New(opts.MaxPeers, chOpts...),
 		opts,
 	)
+	trust.OnRequest(func(req security.PairingRequest) {
+		logger.Info().
+			Str("device", req.Name).
+			Str("fingerprint", req.Fingerprint.Short()).
+			Str("code", req.Code).
+			Msg("pairing request, accept with `belphegor pair accept <code>`")
+		nd.Notify("pairing request from %s, code %s", req.Name, req.Code)
+	})
+	trust.OnRevoke(func(dev security.Device) {
+		closed := nd.Reauthorize()
+		logger.Info().
+			Str("device", dev.Name).
+			Str("fingerprint", dev.Fingerprint.Short()).
+			Int("disconnected", closed).
+			Msg("device revoked")
+	})
+
 	defer func(nd *node.Node) {
 		if closeErr := nd.Close(); closeErr != nil {
 			logger.Warn().Err(closeErr).Msg("close self node")
 		}
 	}(nd)
 
-	if addressIP != "" {
+	if opts.ControlSocket != "" {
 		go func() {
-			if connErr := nd.ConnectTo(ctx, addressIP); connErr != nil {
-				logger.Fatal().AnErr("node.ConnectTo", connErr).Msg("failed to connect to the node")
+			if ctrlErr := control.NewServer(nd, ctrlOpts...).Serve(ctx, opts.ControlSocket); ctrlErr != nil {
+				logger.Error().Err(ctrlErr).Msg("control api stopped")
 			}
 		}()
 	}
 
+	go reloadOnHangup(ctx, nd, opts, logger)
+
+	if opts.Relay != "" {
+		// the relay is not a device, it is neither authorized nor recorded as a pairing request
+		relayConf := tlsConfig.Clone()
+		relayConf.VerifyPeerCertificate = nil
+
+		go relay.NewClient(
+			opts.Relay,
+			selectTransports(opts.Transport, opts.SocketDir, relayConf, opts.KeepAlive, logger),
+			tlsConfig,
+			identity.Fingerprint(),
+			relay.WithLogger(logger),
+			relay.WithKeepAlive(opts.KeepAlive),
+			relay.WithBackoff(opts.Reconnect.MinDelay, opts.Reconnect.MaxDelay),
+			relay.WithWant(func(fp security.Fingerprint) bool {
+				return !trust.Revoked(fp) && (opts.Secret != "" || trust.Trusted(fp))
+			}),
+		).Run(ctx, nd)
+	}
+
 	if opts.Discovering.Enable {
 		go discovering.New(
 			discovering.WithLogger(logger),
 			discovering.WithMaxPeers(opts.MaxPeers),
 			discovering.WithDelay(opts.Discovering.Delay),
 			discovering.WithPort(opts.ListenPort),
+			discovering.WithBackend(opts.Discovering.Backend),
+			discovering.WithScope(opts.Scope),
 		).Discover(ctx, nd)
 	}
 
@@ -153,16 +337,48 @@ func main() {
 	}
 }
 
+// selectTransports listens with every transport of the list at once, dials fall back from one to the next
+func selectTransports(
+	modes node.Transports,
+	socketDir string,
+	tlsConf *tls.Config,
+	keepAlive time.Duration,
+	logger zerolog.Logger,
+) transport.Transport {
+	if len(modes) == 1 {
+		return selectTransport(modes[0], socketDir, tlsConf, keepAlive, logger)
+	}
+
+	offsets := modes.Endpoints(0)
+	members := make([]multi.Member, 0, len(modes))
+	for i, mode := range modes {
+		members = append(members, multi.Member{
+			Name:      mode.String(),
+			Transport: selectTransport(mode, socketDir, tlsConf, keepAlive, logger),
+			Offset:    int(offsets[i].Port),
+		})
+	}
+	return multi.New(members)
+}
+
 func selectTransport(
 	mode node.Transport,
+	socketDir string,
 	tlsConf *tls.Config,
 	keepAlive time.Duration,
 	logger zerolog.Logger,
 ) transport.Transport {
 	ctxLog := logger.With().Str("op", "selectTransport").Logger()
-	if mode == node.TransportTCP {
+	switch mode {
+	case node.TransportTCP:
 		ctxLog.Info().Msg("selected tcp")
 		return tcp.New(tlsConf, keepAlive)
+	case node.TransportWS:
+		ctxLog.Info().Msg("selected ws")
+		return ws.New(tlsConf, keepAlive)
+	case node.TransportUnix:
+		ctxLog.Info().Msg("selected unix")
+		return unix.New(tlsConf, keepAlive, socketDir)
 	}
 
 	ctxLog.Info().Msg("selected quic")
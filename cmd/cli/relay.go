@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/node"
+	"github.com/labi-le/belphegor/internal/paths"
+	"github.com/labi-le/belphegor/internal/relay"
+	"github.com/labi-le/belphegor/internal/security"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	relayUsage = "relay [flags]"
+	relayHelp  = "run a relay, devices on different networks register with it and reach each other through it"
+	// relayPort is the default port of a relay, devices need a fixed one to register
+	relayPort = 7777
+)
+
+// runRelay runs the relay server, false means args do not start with the relay command
+func runRelay(args []string) (int, bool) {
+	if len(args) == 0 || args[0] != "relay" {
+		return 0, false
+	}
+
+	var (
+		port      int
+		mode      = node.TransportQUIC
+		secret    string
+		socketDir string
+		stateDir  string
+		keepAlive time.Duration
+		verbose   bool
+		fs        = flag.NewFlagSet("relay", flag.ContinueOnError)
+	)
+	fs.IntVarP(&port, "port", "p", relayPort, "Port to listen on")
+	fs.Var(&mode, "transport", "Transport protocol: quic, tcp, ws, unix, the devices must use the same")
+	fs.StringVar(&socketDir, "socket_dir", "", "Folder for the socket of the unix transport (default: abstract namespace)")
+	fs.StringVar(&secret, "secret", "", "Shared key, only devices that know it may register (default: any device)")
+	fs.StringVar(&stateDir, "state_dir", filepath.Join(paths.StateDir(), "relay"), "Folder for the relay key")
+	fs.DurationVar(&keepAlive, "keep_alive", time.Minute, "Interval for checking connections to devices")
+	fs.BoolVar(&verbose, "verbose", false, "Verbose logs")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: belphegor %s\n%s\n", relayUsage, relayHelp)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, true
+		}
+		return 2, true
+	}
+
+	logger := initLogger(verbose)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	identity, err := security.LoadIdentity(filepath.Join(stateDir, "identity.pem"))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load relay identity")
+		return 1, true
+	}
+
+	tlsConfig, err := security.MakeTLSConfig(identity, security.NewRelayVerifier(secret), "belphegor relay", logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to generate TLS config")
+		return 1, true
+	}
+
+	l, err := selectTransport(mode, socketDir, tlsConfig, keepAlive, logger).Listen(ctx, fmt.Sprintf(":%d", port))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to listen")
+		return 1, true
+	}
+
+	if secret == "" {
+		logger.Warn().Msg("no secret, any device may register, payloads stay unreadable to the relay")
+	}
+	logger.Info().
+		Stringer("addr", l.Addr()).
+		Str("fingerprint", identity.Fingerprint().String()).
+		Msg("relay started")
+
+	if err := relay.NewServer(relay.WithLogger(logger)).Serve(ctx, l); err != nil {
+		logger.Error().Err(err).Msg("relay stopped")
+		return 1, true
+	}
+
+	return 0, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/labi-le/belphegor/internal/node"
+	"github.com/labi-le/belphegor/internal/notification"
+	"github.com/rs/zerolog"
+	flag "github.com/spf13/pflag"
+)
+
+// reloadOnHangup re-reads the config file on SIGHUP and applies what can change without a restart,
+// live are the options the node runs with
+func reloadOnHangup(ctx context.Context, nd *node.Node, live node.Options, logger zerolog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			opts, err := parseFlags(os.Args[1:], reloadBase(live), flag.ContinueOnError)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to reload config, keeping current options")
+				continue
+			}
+
+			opts.Notifier = notification.New(opts.Notify)
+			nd.Reload(ctx, opts)
+			live = opts
+		}
+	}
+}
+
+// reloadBase is what a reload starts from: the live options, so that values picked at start
+// such as a random port stay, without the repeatable options the config file and args append to
+func reloadBase(live node.Options) node.Options {
+	live.Policies, live.RateLimits, live.Peers = nil, nil, nil
+	live.Scope.Allow, live.Scope.Untrusted = nil, nil
+	return live
+}
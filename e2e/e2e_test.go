@@ -13,10 +13,15 @@ package e2e_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -63,11 +68,9 @@ type node struct {
 }
 
 // startNode launches one headless belphegor process. A distinct HOME/TMPDIR
-// gives it its own single-instance lock and file cache; a distinct
-// BELPHEGOR_NODE_ID gives it a distinct network identity (both nodes would
-// otherwise hash the same MAC to the same id and treat each other's messages
-// as self-originated).
-func startNode(ctx context.Context, t *testing.T, bin, name, home string, port, nodeID int, connectTo, secret string) *node {
+// gives it its own single-instance lock and file cache; BELPHEGOR_NODE_ID
+// pins the node its message ids are generated on, the device id comes from its key
+func startNode(ctx context.Context, t *testing.T, bin, name, home string, port, nodeID int, connectTo, secret string, extra ...string) *node {
 	t.Helper()
 	if err := os.MkdirAll(home, 0o700); err != nil {
 		t.Fatal(err)
@@ -85,11 +88,14 @@ func startNode(ctx context.Context, t *testing.T, bin, name, home string, port,
 		"--transport", "tcp",
 		"--node_discover=false",
 		"--secret", secret,
+		"--state_dir", filepath.Join(home, "state"),
+		"--control_socket", filepath.Join(home, "control.sock"),
 		"--verbose",
 	}
 	if connectTo != "" {
 		args = append(args, "-c", connectTo)
 	}
+	args = append(args, extra...)
 
 	cmd := exec.CommandContext(ctx, bin, args...)
 	cmd.Env = append(os.Environ(),
@@ -240,3 +246,489 @@ func TestE2E_FiveNodeStar(t *testing.T) {
 	waitLog(t, hub, "new update", 5*time.Second)
 	waitLog(t, hub, "announced", 5*time.Second)
 }
+
+// TestE2E_Pairing starts two nodes without a shared secret: they must refuse
+// each other until both sides accept the pairing request via the CLI.
+func TestE2E_Pairing(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	homes := []string{filepath.Join(base, "n1"), filepath.Join(base, "n2")}
+	n1 := startNode(ctx, t, bin, "node1", homes[0], 19201, 1, "", "")
+	waitPort(t, "127.0.0.1:19201", 20*time.Second)
+	n2 := startNode(ctx, t, bin, "node2", homes[1], 19202, 2, "127.0.0.1:19201", "")
+
+	// both sides learn about each other, nobody connects yet
+	waitLog(t, n1, "pairing request", 20*time.Second)
+	waitLog(t, n2, "pairing request", 20*time.Second)
+	if strings.Contains(n2.log.String(), "received greeting") {
+		t.Fatalf("connected before pairing\n%s", n2.log.String())
+	}
+
+	var codes, fingerprints []string
+	for i, home := range homes {
+		out, err := exec.Command(bin, "pair", "--json", "--control_socket", filepath.Join(home, "control.sock")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("pair list on node%d: %v\n%s", i+1, err, out)
+		}
+		var status struct {
+			Pending []struct{ Code, Fingerprint string } `json:"pending"`
+		}
+		if err := json.Unmarshal(out, &status); err != nil || len(status.Pending) != 1 {
+			t.Fatalf("pair list on node%d: %v\n%s", i+1, err, out)
+		}
+		codes = append(codes, status.Pending[0].Code)
+		fingerprints = append(fingerprints, status.Pending[0].Fingerprint)
+	}
+	if codes[0] != codes[1] {
+		t.Fatalf("pairing codes differ: %q", codes)
+	}
+
+	for i, home := range homes {
+		out, err := exec.Command(bin, "pair", "accept", codes[i], "--control_socket", filepath.Join(home, "control.sock")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("pair accept on node%d: %v\n%s", i+1, err, out)
+		}
+	}
+
+	// the static peer is redialed and now passes verification
+	waitLog(t, n2, "received greeting", 30*time.Second)
+
+	// node1 revokes node2, the live connection is dropped and redials are refused
+	out, err := exec.Command(bin, "devices", "revoke", fingerprints[0][:12], "--control_socket", filepath.Join(homes[0], "control.sock")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("devices revoke: %v\n%s", err, out)
+	}
+	waitLog(t, n1, "device revoked", 5*time.Second)
+	waitLog(t, n2, "disconnected", 10*time.Second)
+	waitLog(t, n1, "device is revoked", 30*time.Second)
+}
+
+// TestE2E_NodeIDCollision checks that two devices sharing a copied key refuse
+// each other with a clear error instead of overwriting each other in the peer list
+func TestE2E_NodeIDCollision(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19211, 7, "", "secret")
+	waitPort(t, "127.0.0.1:19211", 20*time.Second)
+
+	key, err := os.ReadFile(filepath.Join(base, "n1", "state", "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(base, "n2", "state"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "n2", "state", "identity.pem"), key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19212, 8, "127.0.0.1:19211", "secret")
+
+	waitLog(t, n1, "node id collision", 20*time.Second)
+	waitLog(t, n2, "node id collision", 20*time.Second)
+}
+
+// TestE2E_ReceiveOnlyPolicy runs node2 as a kiosk: it takes node1's clipboard
+// but never gives its own away
+func TestE2E_ReceiveOnlyPolicy(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19221, 1, "", secret)
+	waitPort(t, "127.0.0.1:19221", 20*time.Second)
+	kiosk := startNode(ctx, t, bin, "kiosk", filepath.Join(base, "n2"), 19222, 2, "127.0.0.1:19221", secret,
+		"--policy", "*=receive")
+	waitLog(t, kiosk, "connected", 20*time.Second)
+
+	const incoming = "e2e-to-kiosk"
+	if err := os.WriteFile(n1.inFile, []byte(incoming), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, kiosk, kiosk.outFile, incoming, 20*time.Second)
+
+	const local = "e2e-kiosk-secret"
+	if err := os.WriteFile(kiosk.inFile, []byte(local), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitLog(t, kiosk, "denied by policy", 10*time.Second)
+
+	// give anything that slipped through the time to arrive
+	time.Sleep(time.Second)
+	if b, err := os.ReadFile(n1.outFile); err == nil && strings.Contains(string(b), local) {
+		t.Fatalf("kiosk clipboard leaked to node1: %q", b)
+	}
+}
+
+func TestE2E_SensitiveFilter(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19231, 1, "", secret,
+		"--filter_pattern", `^e2e-ticket-\d+$`)
+	waitPort(t, "127.0.0.1:19231", 20*time.Second)
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19232, 2, "127.0.0.1:19231", secret)
+	waitLog(t, n2, "connected", 20*time.Second)
+
+	const token = "e2e-ticket-42"
+	if err := os.WriteFile(n1.inFile, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitLog(t, n1, "kept local", 10*time.Second)
+
+	const plain = "e2e-plain-text"
+	if err := os.WriteFile(n1.inFile, []byte(plain), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n2, n2.outFile, plain, 20*time.Second)
+
+	if b, err := os.ReadFile(n2.outFile); err == nil && strings.Contains(string(b), token) {
+		t.Fatalf("secret leaked to node2: %q", b)
+	}
+	if strings.Contains(n1.log.String(), token) {
+		t.Fatal("secret written to the log")
+	}
+}
+
+func TestE2E_ClipboardTTL(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19241, 1, "", secret, "--ttl", "2s")
+	waitPort(t, "127.0.0.1:19241", 20*time.Second)
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19242, 2, "127.0.0.1:19241", secret)
+	waitLog(t, n2, "connected", 20*time.Second)
+
+	const payload = "e2e-one-time-code"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n2, n2.outFile, payload, 20*time.Second)
+
+	// the receiver and the sender both clear the content once the ttl is over
+	waitLog(t, n2, "clipboard cleared", 10*time.Second)
+	waitLog(t, n1, "clipboard cleared", 10*time.Second)
+}
+
+// TestE2E_SealedRelay checks that a device marked as relay forwards the payload
+// to a device behind it without being able to read it
+func TestE2E_SealedRelay(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	home1, home3 := filepath.Join(base, "n1"), filepath.Join(base, "n3")
+	n1 := startNode(ctx, t, bin, "node1", home1, 19251, 1, "", secret, "--e2e")
+	waitPort(t, "127.0.0.1:19251", 20*time.Second)
+
+	// node1 and node3 meet once, so each of them knows the key of the other
+	ctx3, cancel3 := context.WithCancel(ctx)
+	n3 := startNode(ctx3, t, bin, "node3", home3, 19253, 3, "127.0.0.1:19251", secret, "--e2e")
+	waitLog(t, n3, "received greeting", 20*time.Second)
+	cancel3()
+	waitLog(t, n1, "disconnected", 20*time.Second)
+
+	devices := func() []string {
+		out, err := exec.Command(bin, "devices", "--json", "--control_socket", filepath.Join(home1, "control.sock")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("devices on node1: %v\n%s", err, out)
+		}
+		var list []struct{ Fingerprint string }
+		if err := json.Unmarshal(out, &list); err != nil {
+			t.Fatalf("devices on node1: %v\n%s", err, out)
+		}
+		var res []string
+		for _, d := range list {
+			res = append(res, d.Fingerprint)
+		}
+		return res
+	}
+	known := devices()
+
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19252, 2, "127.0.0.1:19251", secret, "--e2e")
+	waitLog(t, n2, "received greeting", 20*time.Second)
+
+	var relay string
+	for _, fp := range devices() {
+		if !slices.Contains(known, fp) {
+			relay = fp
+		}
+	}
+	if relay == "" {
+		t.Fatal("node2 is not among the devices of node1")
+	}
+	out, err := exec.Command(bin, "devices", "relay", relay[:12], "--control_socket", filepath.Join(home1, "control.sock")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("devices relay: %v\n%s", err, out)
+	}
+
+	// node3 is back behind node2, with the same keys. The lock of the stopped
+	// process is left behind in its home
+	waitPort(t, "127.0.0.1:19252", 20*time.Second)
+	n3 = startNode(ctx, t, bin, "node3", home3+"b", 19253, 3, "127.0.0.1:19252", secret,
+		"--e2e", "--state_dir", filepath.Join(home3, "state"))
+	waitLog(t, n3, "received greeting", 20*time.Second)
+
+	const payload = "e2e-sealed-for-node3"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n3, n3.outFile, payload, 20*time.Second)
+
+	waitLog(t, n2, "relaying only", 5*time.Second)
+	if got, _ := os.ReadFile(n2.outFile); strings.Contains(string(got), payload) {
+		t.Fatalf("the relay read the payload: %q", got)
+	}
+}
+
+// TestE2E_MultiHopRing connects four nodes in a ring: a copy on node1 reaches
+// node3 two hops away, and every node requests it once however many peers relay it
+func TestE2E_MultiHopRing(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19261, 1, "", secret)
+	waitPort(t, "127.0.0.1:19261", 20*time.Second)
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19262, 2, "127.0.0.1:19261", secret)
+	waitPort(t, "127.0.0.1:19262", 20*time.Second)
+	n3 := startNode(ctx, t, bin, "node3", filepath.Join(base, "n3"), 19263, 3, "127.0.0.1:19262", secret)
+	waitPort(t, "127.0.0.1:19263", 20*time.Second)
+	n4 := startNode(ctx, t, bin, "node4", filepath.Join(base, "n4"), 19264, 4, "127.0.0.1:19263", secret,
+		"-c", "127.0.0.1:19261")
+
+	for _, n := range []*node{n2, n3} {
+		waitLog(t, n, "connected", 20*time.Second)
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for strings.Count(n4.log.String(), "received greeting") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("node4 did not join the ring\n%s", n4.log.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	const payload = "e2e-two-hops-away"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*node{n2, n3, n4} {
+		waitFileContains(t, n, n.outFile, payload, 20*time.Second)
+	}
+
+	// the relayed copies and the announcements looping back are dropped
+	time.Sleep(2 * time.Second)
+	for _, n := range []*node{n1, n2, n3, n4} {
+		if got := strings.Count(n.log.String(), "requesting message"); n != n1 && got != 1 {
+			t.Fatalf("%s requested the message %d times\n%s", n.name, got, n.log.String())
+		} else if n == n1 && got != 0 {
+			t.Fatalf("node1 requested its own message back\n%s", n.log.String())
+		}
+	}
+	if !strings.Contains(n3.log.String(), `"hops":2`) {
+		t.Fatalf("node3 did not get the message over two hops\n%s", n3.log.String())
+	}
+}
+
+// TestE2E_Relay starts two nodes that cannot dial each other, only the relay:
+// they register with it and sync through a stream it splices
+func TestE2E_Relay(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relayLog := &syncBuf{}
+	cmd := exec.CommandContext(ctx, bin, "relay",
+		"-p", "19271",
+		"--transport", "tcp",
+		"--secret", secret,
+		"--state_dir", filepath.Join(base, "relay"),
+		"--verbose",
+	)
+	cmd.Stdout, cmd.Stderr = relayLog, relayLog
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start relay: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	waitPort(t, "127.0.0.1:19271", 20*time.Second)
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19272, 1, "", secret, "--relay", "127.0.0.1:19271")
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19273, 2, "", secret, "--relay", "127.0.0.1:19271")
+	for _, n := range []*node{n1, n2} {
+		waitLog(t, n, "registered with the relay", 20*time.Second)
+	}
+	waitLog(t, n1, "connected", 20*time.Second)
+	waitLog(t, n2, "connected", 20*time.Second)
+
+	const payload = "e2e-through-the-relay"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n2, n2.outFile, payload, 20*time.Second)
+
+	if !strings.Contains(relayLog.String(), "spliced") {
+		t.Fatalf("relay did not splice the devices\n%s", relayLog.String())
+	}
+	if strings.Contains(relayLog.String(), payload) {
+		t.Fatal("the relay saw the payload")
+	}
+}
+
+// TestE2E_WebSocket syncs two nodes over the ws transport, node2 dials node1
+// through a reverse proxy that serves it under a path prefix.
+func TestE2E_WebSocket(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19281, 1, "", secret, "--transport", "ws")
+	waitPort(t, "127.0.0.1:19281", 20*time.Second)
+
+	mux := http.NewServeMux()
+	mux.Handle("/belphegor/", http.StripPrefix("/belphegor",
+		httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "127.0.0.1:19281"})))
+	proxy := &http.Server{Addr: "127.0.0.1:19283", Handler: mux, ReadHeaderTimeout: time.Second}
+	go func() { _ = proxy.ListenAndServe() }()
+	t.Cleanup(func() { _ = proxy.Close() })
+	waitPort(t, "127.0.0.1:19283", 20*time.Second)
+
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19282, 2,
+		"ws://127.0.0.1:19283/belphegor/", secret, "--transport", "ws")
+	waitLog(t, n2, "selected ws", 20*time.Second)
+	waitLog(t, n2, "connected", 20*time.Second)
+
+	const payload = "e2e-over-websocket"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n2, n2.outFile, payload, 20*time.Second)
+}
+
+// TestE2E_UnixSocket syncs two nodes over sockets in a shared folder, as
+// containers do that mount it but share no network.
+func TestE2E_UnixSocket(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	sockets := filepath.Join(base, "sockets")
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := os.MkdirAll(sockets, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	extra := []string{"--transport", "unix", "--socket_dir", sockets}
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19291, 1, "", secret, extra...)
+	waitLog(t, n1, "19291.sock", 20*time.Second)
+
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19292, 2,
+		filepath.Join(sockets, "19291.sock"), secret, extra...)
+	waitLog(t, n2, "connected", 20*time.Second)
+
+	const payload = "e2e-over-unix-socket"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n2, n2.outFile, payload, 20*time.Second)
+}
+
+// TestE2E_MixedTransports connects a tcp-only node and a quic-only node
+// through a node that listens with both: it falls back to tcp when dialing
+// the first and is reached over quic by the second.
+func TestE2E_MixedTransports(t *testing.T) {
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n1 := startNode(ctx, t, bin, "node1", filepath.Join(base, "n1"), 19301, 1, "", secret, "--transport", "tcp")
+	waitPort(t, "127.0.0.1:19301", 20*time.Second)
+
+	n2 := startNode(ctx, t, bin, "node2", filepath.Join(base, "n2"), 19302, 2, "127.0.0.1:19301", secret,
+		"--transport", "quic,tcp")
+	waitLog(t, n2, "connected", 20*time.Second)
+	waitPort(t, "127.0.0.1:19302", 20*time.Second)
+
+	n3 := startNode(ctx, t, bin, "node3", filepath.Join(base, "n3"), 19303, 3, "127.0.0.1:19302", secret,
+		"--transport", "quic")
+	waitLog(t, n3, "connected", 20*time.Second)
+
+	const payload = "e2e-across-transports"
+	if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFileContains(t, n3, n3.outFile, payload, 20*time.Second)
+}
+
+// TestE2E_IPv6 syncs two nodes that reach each other over the IPv6 loopback
+// only, with both transports.
+func TestE2E_IPv6(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("no IPv6 loopback")
+	} else {
+		_ = l.Close()
+	}
+
+	bin := buildNullBinary(t)
+	base := t.TempDir()
+	const secret = "e2e-secret"
+
+	for i, mode := range []string{"tcp", "quic"} {
+		t.Run(mode, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			port := 19311 + 2*i
+			dir := filepath.Join(base, mode)
+			n1 := startNode(ctx, t, bin, "node1", filepath.Join(dir, "n1"), port, 1, "", secret, "--transport", mode)
+			waitLog(t, n1, "started", 20*time.Second)
+
+			n2 := startNode(ctx, t, bin, "node2", filepath.Join(dir, "n2"), port+1, 2,
+				net.JoinHostPort("::1", strconv.Itoa(port)), secret, "--transport", mode)
+			waitLog(t, n2, "connected", 20*time.Second)
+
+			payload := "e2e-over-ipv6-" + mode
+			if err := os.WriteFile(n1.inFile, []byte(payload), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			waitFileContains(t, n2, n2.outFile, payload, 20*time.Second)
+		})
+	}
+}
@@ -4,7 +4,9 @@ go 1.26
 
 require (
 	deedles.dev/wl v0.0.0-20260216032335-64a434ab53c9
+	filippo.io/edwards25519 v1.2.0
 	fyne.io/systray v1.12.1
+	github.com/BurntSushi/toml v1.6.0
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/cespare/xxhash v1.1.0
 	github.com/dustin/go-humanize v1.0.1
@@ -20,6 +22,7 @@ require (
 	github.com/schollz/peerdiscovery v1.7.6
 	github.com/spf13/pflag v1.0.10
 	golang.org/x/image v0.45.0
+	golang.org/x/net v0.56.0
 	golang.org/x/sys v0.47.0
 	google.golang.org/protobuf v1.36.12
 )
@@ -39,5 +42,4 @@ require (
 	github.com/sergeymakinen/go-ico v1.0.0 // indirect
 	github.com/tadvi/systray v0.0.0-20190226123456-11a2b8fa57af // indirect
 	golang.org/x/crypto v0.54.0 // indirect
-	golang.org/x/net v0.56.0 // indirect
 )
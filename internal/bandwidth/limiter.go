@@ -0,0 +1,95 @@
+// Package bandwidth limits the rate of the traffic exchanged with peers
+// and lets small messages overtake the bulk transfers sharing a connection
+package bandwidth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/pkg/bytesize"
+)
+
+// minBurst keeps low rates from splitting writes into tiny packets
+const minBurst = 32 << 10
+
+// Rate is in bytes per second, 0 is unlimited. It is a flag value (e.g. 2MiB)
+type Rate uint64
+
+func (r Rate) String() string {
+	if r == 0 {
+		return "0"
+	}
+	return bytesize.Size(r).String() + "/s"
+}
+
+func (r *Rate) Set(s string) error {
+	return (*bytesize.Size)(r).Set(s)
+}
+
+func (r *Rate) Type() string {
+	return "rate"
+}
+
+// Limiter is a token bucket holding a second worth of bytes
+type Limiter struct {
+	mu     sync.Mutex
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate Rate) *Limiter {
+	return &Limiter{rate: rate, tokens: float64(burst(rate)), last: time.Now()}
+}
+
+// SetRate changes the rate from now on
+func (l *Limiter) SetRate(rate Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate = rate
+	l.tokens = min(l.tokens, float64(burst(rate)))
+}
+
+func (l *Limiter) Rate() Rate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Burst is the most bytes a write should move at once to keep the rate smooth
+func (l *Limiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return burst(l.rate)
+}
+
+// reserve takes n tokens, running into debt, and returns how long until the debt is paid
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed.Seconds()*float64(l.rate), float64(burst(l.rate)))
+	}
+	l.last = now
+}
+
+func burst(rate Rate) int {
+	return max(int(rate), minBurst)
+}
@@ -0,0 +1,148 @@
+package bandwidth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+// Any matches every device
+const Any = "*"
+
+var ErrInvalid = errors.New("invalid rate limit")
+
+// Rule limits the traffic with the devices matching Match: a device name, a node id or Any
+type Rule struct {
+	Match    string
+	Upload   Rate
+	Download Rate
+}
+
+// Parse reads a rule written as match=up:rate[,down:rate], either side may be left out,
+// e.g. "phone=up:1MiB" or "*=up:4MiB,down:8MiB"
+func Parse(s string) (Rule, error) {
+	match, spec, ok := strings.Cut(s, "=")
+	match = strings.TrimSpace(match)
+	if !ok || match == "" {
+		return Rule{}, fmt.Errorf("%w %q: expected match=up:rate[,down:rate]", ErrInvalid, s)
+	}
+
+	r := Rule{Match: match}
+	for part := range strings.SplitSeq(spec, ",") {
+		side, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return Rule{}, fmt.Errorf("%w %q: expected up:rate or down:rate, got %q", ErrInvalid, s, part)
+		}
+
+		var rate Rate
+		if err := rate.Set(strings.TrimSpace(value)); err != nil {
+			return Rule{}, fmt.Errorf("%w %q: %w", ErrInvalid, s, err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(side)) {
+		case "up":
+			r.Upload = rate
+		case "down":
+			r.Download = rate
+		default:
+			return Rule{}, fmt.Errorf("%w %q: unknown side %q, expected up or down", ErrInvalid, s, side)
+		}
+	}
+
+	return r, nil
+}
+
+func (r Rule) String() string {
+	var sides []string
+	if r.Upload > 0 {
+		sides = append(sides, "up:"+humanizeRate(r.Upload))
+	}
+	if r.Download > 0 {
+		sides = append(sides, "down:"+humanizeRate(r.Download))
+	}
+
+	return r.Match + "=" + strings.Join(sides, ",")
+}
+
+func humanizeRate(r Rate) string {
+	return strings.ReplaceAll(humanize.IBytes(uint64(r)), " ", "")
+}
+
+// Matches compares Match with the device name (case-insensitive) and node id
+func (r Rule) Matches(dev domain.Device) bool {
+	if r.Match == Any || strings.EqualFold(r.Match, dev.Name) {
+		return true
+	}
+
+	nid, err := strconv.ParseInt(r.Match, 10, 64)
+	return err == nil && domain.NodeID(nid) == dev.ID
+}
+
+// Rules are checked in order, the first rule matching a device wins.
+// It is a pflag value, every --peer_rate_limit flag appends a rule
+type Rules []Rule
+
+// For returns the rule for dev, an unlimited one if none matches
+func (rs Rules) For(dev domain.Device) Rule {
+	for _, r := range rs {
+		if r.Matches(dev) {
+			return r
+		}
+	}
+
+	return Rule{Match: Any}
+}
+
+func (rs *Rules) String() string {
+	if len(*rs) == 0 {
+		return ""
+	}
+
+	return "[" + strings.Join(rs.GetSlice(), " ") + "]"
+}
+
+// Set appends a rule, the value is not split on commas, they separate the sides
+func (rs *Rules) Set(s string) error {
+	return rs.Append(s)
+}
+
+func (rs *Rules) Type() string {
+	return "rate_limit"
+}
+
+func (rs *Rules) Append(s string) error {
+	r, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*rs = append(*rs, r)
+	return nil
+}
+
+func (rs *Rules) Replace(ss []string) error {
+	parsed := make(Rules, 0, len(ss))
+	for _, s := range ss {
+		r, err := Parse(s)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, r)
+	}
+
+	*rs = parsed
+	return nil
+}
+
+func (rs *Rules) GetSlice() []string {
+	res := make([]string, 0, len(*rs))
+	for _, r := range *rs {
+		res = append(res, r.String())
+	}
+
+	return res
+}
@@ -0,0 +1,62 @@
+package bandwidth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/bandwidth"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"phone=up:1MiB", "phone=up:1.0MiB"},
+		{"*=up:4MiB,down:8MiB", "*=up:4.0MiB,down:8.0MiB"},
+		{"work laptop = DOWN : 512KiB", "work laptop=down:512KiB"},
+		{"42=up:1mb", "42=up:977KiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := bandwidth.Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.String() != tt.want {
+				t.Fatalf("Parse(%q) = %q, want %q", tt.in, r.String(), tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"phone", "=up:1MiB", "phone=", "phone=1MiB", "phone=sideways:1MiB", "phone=up:fast"} {
+		if _, err := bandwidth.Parse(bad); !errors.Is(err, bandwidth.ErrInvalid) {
+			t.Fatalf("Parse(%q) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestRules_For(t *testing.T) {
+	var rules bandwidth.Rules
+	for _, s := range []string{"phone=up:1MiB", "42=down:2MiB", "*=up:8MiB"} {
+		if err := rules.Append(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r := rules.For(domain.Device{ID: 1, Name: "Phone"}); r.Upload != 1<<20 {
+		t.Fatalf("phone got %s", r)
+	}
+	if r := rules.For(domain.Device{ID: 42, Name: "desktop"}); r.Download != 2<<20 || r.Upload != 0 {
+		t.Fatalf("node 42 got %s", r)
+	}
+	if r := rules.For(domain.Device{ID: 7, Name: "tablet"}); r.Match != bandwidth.Any || r.Upload != 8<<20 {
+		t.Fatalf("tablet got %s", r)
+	}
+
+	if r := (bandwidth.Rules{}).For(domain.Device{ID: 7}); r.Upload != 0 || r.Download != 0 {
+		t.Fatalf("no rules must be unlimited, got %s", r)
+	}
+}
@@ -0,0 +1,131 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+// BulkSize is the payload size from which a message is bulk, smaller ones overtake it
+const BulkSize = 64 << 10
+
+// Shaper limits and orders the traffic of one connection.
+// Bulk writes pause while an urgent one is in flight, urgent traffic is counted but never waits
+type Shaper struct {
+	// own are the limits of this peer, shared are the global ones
+	own    [2]*Limiter
+	shared [2]*Limiter
+
+	mu     sync.Mutex
+	urgent int
+	// idle is closed while no urgent write is in flight
+	idle chan struct{}
+}
+
+const (
+	up = iota
+	down
+)
+
+// NewShaper limits the connection to upload and download, on top of the shared limiters, which may be nil
+func NewShaper(upload, download Rate, sharedUp, sharedDown *Limiter) *Shaper {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &Shaper{
+		own:    [2]*Limiter{NewLimiter(upload), NewLimiter(download)},
+		shared: [2]*Limiter{sharedUp, sharedDown},
+		idle:   idle,
+	}
+}
+
+// SetRates changes the limits of this peer
+func (s *Shaper) SetRates(upload, download Rate) {
+	s.own[up].SetRate(upload)
+	s.own[down].SetRate(download)
+}
+
+// Urgent marks an urgent write in flight until done is called
+func (s *Shaper) Urgent() (done func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.urgent == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.urgent++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.urgent--
+			if s.urgent == 0 {
+				close(s.idle)
+			}
+		})
+	}
+}
+
+// Yield waits until no urgent write is in flight, at most timeout
+func (s *Shaper) Yield(timeout time.Duration) {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+}
+
+// Chunk is the most bytes a bulk write or read should move at once, 0 is any
+func (s *Shaper) Chunk(upload bool) int {
+	dir := direction(upload)
+
+	var chunk int
+	for _, l := range []*Limiter{s.own[dir], s.shared[dir]} {
+		if l == nil || l.Rate() == 0 {
+			continue
+		}
+		if b := l.Burst(); chunk == 0 || b < chunk {
+			chunk = b
+		}
+	}
+
+	return chunk
+}
+
+// Pass accounts for n bytes moved, bulk ones wait until the limits let them through
+func (s *Shaper) Pass(upload, bulk bool, n int) {
+	dir := direction(upload)
+
+	// both limits are paid at once, the wait is the longer one
+	var wait time.Duration
+	for _, l := range []*Limiter{s.own[dir], s.shared[dir]} {
+		if l != nil {
+			wait = max(wait, l.reserve(n))
+		}
+	}
+
+	if bulk && wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func direction(upload bool) int {
+	if upload {
+		return up
+	}
+	return down
+}
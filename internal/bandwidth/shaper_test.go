@@ -0,0 +1,77 @@
+package bandwidth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/bandwidth"
+)
+
+func TestShaper_Pass(t *testing.T) {
+	const rate = 1 << 20
+	s := bandwidth.NewShaper(rate, 0, nil, nil)
+
+	if s.Chunk(true) != rate || s.Chunk(false) != 0 {
+		t.Fatalf("chunks = %d/%d, want %d/0", s.Chunk(true), s.Chunk(false), rate)
+	}
+
+	// the first second worth of bytes is the burst, the next half a second has to wait
+	start := time.Now()
+	s.Pass(true, true, rate)
+	s.Pass(true, true, rate/2)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("1.5MiB at 1MiB/s took %s", elapsed)
+	}
+
+	// urgent bytes are counted but never wait, download is unlimited
+	start = time.Now()
+	s.Pass(true, false, rate)
+	s.Pass(false, true, 10*rate)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("urgent and unlimited traffic waited %s", elapsed)
+	}
+}
+
+func TestShaper_SharedLimit(t *testing.T) {
+	const rate = 1 << 20
+	shared := bandwidth.NewLimiter(rate / 2)
+	s := bandwidth.NewShaper(rate, 0, shared, nil)
+
+	if s.Chunk(true) != rate/2 {
+		t.Fatalf("chunk = %d, want the smaller limit %d", s.Chunk(true), rate/2)
+	}
+
+	s.SetRates(0, 0)
+	shared.SetRate(0)
+	if s.Chunk(true) != 0 {
+		t.Fatalf("chunk = %d after lifting the limits, want 0", s.Chunk(true))
+	}
+}
+
+func TestShaper_Yield(t *testing.T) {
+	s := bandwidth.NewShaper(0, 0, nil, nil)
+
+	start := time.Now()
+	s.Yield(time.Second)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("bulk yielded with nothing urgent in flight")
+	}
+
+	done := s.Urgent()
+	time.AfterFunc(50*time.Millisecond, done)
+
+	start = time.Now()
+	s.Yield(5 * time.Second)
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("bulk yielded for %s, want until the urgent write was done", elapsed)
+	}
+	done()
+
+	// a stuck urgent write holds bulk back at most the timeout
+	s.Urgent()
+	start = time.Now()
+	s.Yield(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("bulk yielded for %s past its timeout", elapsed)
+	}
+}
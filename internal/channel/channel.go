@@ -6,7 +6,24 @@ import (
 	"github.com/labi-le/belphegor/internal/types/domain"
 )
 
-const HistorySize = 5
+const (
+	HistorySize = 5
+	// SeenSize is how many message ids are remembered to drop copies relayed by other peers
+	SeenSize = 256
+)
+
+// Recorder receives every message accepted by Channel.Send
+type Recorder interface {
+	Record(msg domain.EventMessage)
+}
+
+type Option func(*Channel)
+
+func WithRecorder(r Recorder) Option {
+	return func(c *Channel) {
+		c.recorder = r
+	}
+}
 
 type Channel struct {
 	msgMu   sync.RWMutex
@@ -17,15 +34,25 @@ type Channel struct {
 
 	fileHistory *announceHistory
 	servedFiles *servedFilesHistory
+	seen        *seenHistory
+
+	recorder Recorder
 }
 
-func New(peerMaxCount int) *Channel {
-	return &Channel{
+func New(peerMaxCount int, opts ...Option) *Channel {
+	c := &Channel{
 		msg:         make(chan domain.EventMessage),
 		ann:         make(chan domain.EventAnnounce, peerMaxCount),
 		fileHistory: newHistory(HistorySize),
 		servedFiles: newServedFilesHistory(HistorySize),
+		seen:        newSeenHistory(SeenSize),
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 func (c *Channel) LastMsg() domain.EventMessage {
@@ -46,8 +73,13 @@ func (c *Channel) Get(msgID domain.MessageID) (domain.EventMessage, bool) {
 	return c.servedFiles.Get(msgID)
 }
 
+// Send delivers a message unless it duplicates the last one,
+// content that expires or is sealed for other devices is kept out of the recorder
 func (c *Channel) Send(msg domain.EventMessage) {
 	if c.updateLastMsg(msg) {
+		if c.recorder != nil && msg.Payload.TTL == 0 && !msg.Payload.Sealed {
+			c.recorder.Record(msg)
+		}
 		c.msg <- msg
 	}
 }
@@ -61,17 +93,33 @@ func (c *Channel) updateLastMsg(msg domain.EventMessage) bool {
 	}
 
 	c.lastMsg = msg
+	c.seen.Add(seenKey{origin: msg.From, id: msg.Payload.ID}, struct{}{})
 
-	if msg.Payload.MimeType.IsPath() {
+	if msg.Payload.MimeType.IsPath() || msg.Payload.Sealed {
 		c.servedFiles.Add(msg.Payload.ID, msg)
 	}
 	return true
 }
 
+// Expire forgets an expired message: it is no longer served to peers
+// and the same content is accepted again when it is copied later
+func (c *Channel) Expire(msg domain.Message) {
+	c.msgMu.Lock()
+	if c.lastMsg.Payload.ID == msg.ID {
+		c.lastMsg = domain.EventMessage{}
+	}
+	c.msgMu.Unlock()
+
+	c.servedFiles.Delete(msg.ID)
+	c.fileHistory.Delete(msg.ContentHash)
+}
+
 func (c *Channel) Messages() <-chan domain.EventMessage {
 	return c.msg
 }
 
+// Announce delivers an announcement the first time the message is announced,
+// copies relayed by other peers and loops back to us are dropped
 func (c *Channel) Announce(ann domain.EventAnnounce) {
 	if c.shouldUpdateAnn(ann) {
 		c.ann <- ann
@@ -79,9 +127,24 @@ func (c *Channel) Announce(ann domain.EventAnnounce) {
 }
 
 func (c *Channel) shouldUpdateAnn(ann domain.EventAnnounce) bool {
+	if !c.seen.Add(seenKey{origin: ann.From, id: ann.Payload.ID}, struct{}{}) {
+		return false
+	}
+
+	// the hash of content sealed for other devices is unknown
+	if ann.Payload.ContentHash == 0 {
+		return true
+	}
+
 	return c.fileHistory.Add(ann.Payload.ContentHash, ann)
 }
 
+// Forget lets the message be announced again, after requesting it has failed
+func (c *Channel) Forget(ann domain.EventAnnounce) {
+	c.seen.Delete(seenKey{origin: ann.From, id: ann.Payload.ID})
+	c.fileHistory.Delete(ann.Payload.ContentHash)
+}
+
 func (c *Channel) Announcements() <-chan domain.EventAnnounce {
 	return c.ann
 }
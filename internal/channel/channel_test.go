@@ -222,3 +222,92 @@ func TestChannel_Concurrency_Race(_ *testing.T) {
 	wg.Wait()
 	close(done)
 }
+
+type recorderFunc func(domain.EventMessage)
+
+func (f recorderFunc) Record(msg domain.EventMessage) { f(msg) }
+
+func TestChannel_Recorder(t *testing.T) {
+	var recorded []domain.MessageID
+	ch := channel.New(1, channel.WithRecorder(recorderFunc(func(msg domain.EventMessage) {
+		recorded = append(recorded, msg.Payload.ID)
+	})))
+
+	event := domain.EventMessage{Payload: domain.Message{ID: 1, ContentHash: 1, ContentLength: 1, MimeType: mime.TypeText}}
+
+	go func() { <-ch.Messages() }()
+	ch.Send(event)
+
+	// duplicate is rejected before it reaches the recorder
+	ch.Send(event)
+
+	if len(recorded) != 1 || recorded[0] != 1 {
+		t.Fatalf("recorded = %v, want [1]", recorded)
+	}
+}
+
+func TestChannel_Announce_Seen(t *testing.T) {
+	ch := channel.New(10)
+
+	ann := domain.EventAnnounce{
+		From:    domain.NodeID(1),
+		Payload: domain.Announce{ID: 7, ContentHash: 0xBEEF},
+	}
+
+	// the same message relayed by two peers is announced once
+	ch.Announce(domain.EventAnnounce{From: ann.From, Payload: ann.Payload, Via: 2})
+	ch.Announce(domain.EventAnnounce{From: ann.From, Payload: ann.Payload, Via: 3})
+	if got := len(ch.Announcements()); got != 1 {
+		t.Fatalf("relayed copies announced %d times, want 1", got)
+	}
+	<-ch.Announcements()
+
+	// a failed request lets another relay deliver it
+	ch.Forget(ann)
+	ch.Announce(ann)
+	if got := len(ch.Announcements()); got != 1 {
+		t.Fatal("forgotten message was not announced again")
+	}
+	<-ch.Announcements()
+
+	// a message we already hold is not requested again when it loops back
+	go func() { <-ch.Messages() }()
+	ch.Send(domain.EventMessage{From: 1, Payload: domain.Message{ID: 8, ContentHash: 0xF00D, ContentLength: 1}})
+	ch.Announce(domain.EventAnnounce{From: 1, Payload: domain.Announce{ID: 8, ContentHash: 0xF00D}, Via: 3})
+	if got := len(ch.Announcements()); got != 0 {
+		t.Fatal("held message was announced again")
+	}
+}
+
+func TestChannel_Expire(t *testing.T) {
+	var recorded int
+	ch := channel.New(1, channel.WithRecorder(recorderFunc(func(domain.EventMessage) {
+		recorded++
+	})))
+
+	msg := domain.Message{ID: 1, ContentHash: 1, ContentLength: 1, MimeType: mime.TypePath, TTL: time.Minute}
+
+	go func() { <-ch.Messages() }()
+	ch.Send(domain.EventMessage{Payload: msg})
+
+	if recorded != 0 {
+		t.Fatal("expiring content reached the recorder")
+	}
+
+	ch.Expire(msg)
+
+	if _, ok := ch.Get(msg.ID); ok {
+		t.Fatal("expired message is still served")
+	}
+	if !ch.LastMsg().Payload.Zero() {
+		t.Fatal("expired message is still the last one")
+	}
+
+	// the same content copied again is delivered
+	go func() { <-ch.Messages() }()
+	msg.ID = 2
+	ch.Send(domain.EventMessage{Payload: msg})
+	if ch.LastMsg().Payload.ID != 2 {
+		t.Fatal("content copied again after expiry was dropped")
+	}
+}
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"slices"
 	"sync"
 
 	"github.com/labi-le/belphegor/internal/types/domain"
@@ -16,8 +17,15 @@ type fifo[K comparable, V any] struct {
 type (
 	announceHistory    = fifo[uint64, domain.EventAnnounce]
 	servedFilesHistory = fifo[domain.MessageID, domain.EventMessage]
+	seenHistory        = fifo[seenKey, struct{}]
 )
 
+// seenKey identifies a message across the mesh, whichever peer relayed it
+type seenKey struct {
+	origin domain.NodeID
+	id     domain.MessageID
+}
+
 func newHistory(limit int) *announceHistory {
 	return &announceHistory{
 		limit: limit,
@@ -33,6 +41,14 @@ func newServedFilesHistory(limit int) *servedFilesHistory {
 	}
 }
 
+func newSeenHistory(limit int) *seenHistory {
+	return &seenHistory{
+		limit: limit,
+		order: make([]seenKey, 0, limit),
+		data:  make(map[seenKey]struct{}, limit),
+	}
+}
+
 func (h *fifo[K, V]) Add(key K, value V) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -59,3 +75,15 @@ func (h *fifo[K, V]) Get(key K) (V, bool) { //nolint:ireturn // generic getter r
 	val, ok := h.data[key]
 	return val, ok
 }
+
+func (h *fifo[K, V]) Delete(key K) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.data[key]; !ok {
+		return
+	}
+
+	delete(h.data, key)
+	h.order = slices.DeleteFunc(h.order, func(k K) bool { return k == key })
+}
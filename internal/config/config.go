@@ -0,0 +1,172 @@
+// Package config reads the daemon config file.
+//
+// The file is TOML. Keys are command line flag names, a key inside a table
+// or a dotted key is joined with "_", so
+//
+//	[history]
+//	max_entries = 100
+//
+// sets --history_max_entries
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	ErrSyntax     = errors.New("syntax error")
+	ErrUnknownKey = errors.New("unknown key")
+	ErrDuplicate  = errors.New("duplicate key")
+	ErrValue      = errors.New("unsupported value")
+)
+
+// Entry is a single assignment from the config file
+type Entry struct {
+	Key    string
+	Values []string
+	Array  bool
+}
+
+// Load reads and parses the file at path
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config.Load: %w", err)
+	}
+	defer f.Close()
+
+	entries, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("config.Load: %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// Parse reads assignments in file order
+func Parse(r io.Reader) ([]Entry, error) {
+	var doc map[string]any
+	md, err := toml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("line %d: %w: %s", parseErr.Position.Line, ErrSyntax, parseErr.Message)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrSyntax, err)
+	}
+
+	var (
+		entries []Entry
+		seen    = make(map[string]string)
+	)
+	for _, key := range md.Keys() {
+		switch md.Type(key...) {
+		case "Hash":
+			// the keys of a table follow it
+			continue
+		case "ArrayHash":
+			return nil, fmt.Errorf("%s: %w: array of tables", key, ErrValue)
+		}
+
+		name := strings.Join(key, "_")
+		if prev, exists := seen[name]; exists {
+			return nil, fmt.Errorf("%w %q (set as %s and %s)", ErrDuplicate, name, prev, key)
+		}
+		seen[name] = key.String()
+
+		entry, err := newEntry(name, lookup(doc, key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Apply sets the flags named by entries. Flags given on the command line win over the file
+func Apply(fs *flag.FlagSet, entries []Entry) error {
+	for _, e := range entries {
+		f := fs.Lookup(e.Key)
+		if f == nil {
+			return fmt.Errorf("config.Apply: %w %q", ErrUnknownKey, e.Key)
+		}
+
+		if f.Changed {
+			continue
+		}
+
+		var err error
+		switch v := f.Value.(type) {
+		case flag.SliceValue:
+			err = v.Replace(e.Values)
+		default:
+			if e.Array {
+				err = errors.New("expects a single value")
+				break
+			}
+			err = v.Set(e.Values[0])
+		}
+		if err != nil {
+			return fmt.Errorf("config.Apply: %s: %w", e.Key, err)
+		}
+	}
+
+	return nil
+}
+
+func lookup(doc map[string]any, key toml.Key) any {
+	var v any = doc
+	for _, part := range key {
+		table, _ := v.(map[string]any)
+		v = table[part]
+	}
+
+	return v
+}
+
+// newEntry turns a decoded value into the strings a flag is set from
+func newEntry(name string, v any) (Entry, error) {
+	items, isArray := v.([]any)
+	if !isArray {
+		s, err := flagValue(v)
+		return Entry{Key: name, Values: []string{s}}, err
+	}
+
+	entry := Entry{Key: name, Values: make([]string, 0, len(items)), Array: true}
+	for _, item := range items {
+		s, err := flagValue(item)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Values = append(entry.Values, s)
+	}
+
+	return entry, nil
+}
+
+func flagValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("%w %T", ErrValue, v)
+	}
+}
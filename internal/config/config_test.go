@@ -0,0 +1,142 @@
+package config_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/config"
+	flag "github.com/spf13/pflag"
+)
+
+const sample = `
+# belphegor config
+max_peers = 1_0
+notify = true
+file_save_path = 'C:\cache' # literal string
+connect = [
+	"10.0.0.2:7000", # office
+	"10.0.0.3:7000",
+]
+
+filter_pattern = ["\\d{4}-\\d{4}", '''
+secret''']
+scope = { network = "lo" }
+
+[history]
+max_age = "24h"
+"max_entries" = 50
+`
+
+func TestParse(t *testing.T) {
+	entries, err := config.Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][]string)
+	for _, e := range entries {
+		got[e.Key] = e.Values
+	}
+
+	want := map[string][]string{
+		"max_peers":           {"10"},
+		"notify":              {"true"},
+		"file_save_path":      {`C:\cache`},
+		"connect":             {"10.0.0.2:7000", "10.0.0.3:7000"},
+		"history_max_age":     {"24h"},
+		"history_max_entries": {"50"},
+		"filter_pattern":      {`\d{4}-\d{4}`, "secret"},
+		"scope_network":       {"lo"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %v", got)
+	}
+	for k, v := range want {
+		if !slices.Equal(got[k], v) {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		src  string
+		want error
+	}{
+		"no value":     {"port =", config.ErrSyntax},
+		"bare word":    {"transport = tcp", config.ErrSyntax},
+		"no equals":    {"port", config.ErrSyntax},
+		"open array":   {"connect = [\"a\"", config.ErrSyntax},
+		"bad table":    {"[history", config.ErrSyntax},
+		"duplicate":    {"port = 1\nport = 2", config.ErrSyntax},
+		"tables array": {"[[history]]\nmax_age = \"1h\"", config.ErrValue},
+		"nested array": {"connect = [[\"a\"]]", config.ErrValue},
+		"dup in table": {"history_max_age = \"1h\"\n[history]\nmax_age = \"2h\"", config.ErrDuplicate},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := config.Parse(strings.NewReader(tc.src)); !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	var (
+		fs      = flag.NewFlagSet("test", flag.ContinueOnError)
+		port    = fs.Int("port", 1, "")
+		delay   = fs.Duration("delay", time.Second, "")
+		peers   = fs.StringSlice("connect", nil, "")
+		verbose = fs.Bool("verbose", false, "")
+	)
+
+	if err := fs.Parse([]string{"--port", "9000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := config.Parse(strings.NewReader(`
+port = 7000
+delay = "5s"
+verbose = true
+connect = ["a:1", "b:2"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Apply(fs, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if *port != 9000 {
+		t.Errorf("port = %d, command line must win", *port)
+	}
+	if *delay != 5*time.Second || !*verbose {
+		t.Errorf("delay = %s, verbose = %t", *delay, *verbose)
+	}
+	if !slices.Equal(*peers, []string{"a:1", "b:2"}) {
+		t.Errorf("connect = %q", *peers)
+	}
+
+	for src, want := range map[string]string{
+		`unknown = 1`:     "unknown key",
+		`delay = "soon"`:  "delay",
+		`port = [1, 2]`:   "single value",
+		`verbose = "yes"`: "verbose",
+	} {
+		entries, err := config.Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Duration("delay", 0, "")
+		fs.Int("port", 0, "")
+		fs.Bool("verbose", false, "")
+		if err := config.Apply(fs, entries); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Apply(%s) = %v, want error with %q", src, err, want)
+		}
+	}
+}
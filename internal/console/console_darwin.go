@@ -7,3 +7,5 @@ import (
 )
 
 func HideConsoleWindow(context.CancelFunc) {}
+
+func ShowTransfers(string) {}
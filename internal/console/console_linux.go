@@ -29,6 +29,8 @@ func (app *appState) onReady() {
 	systray.SetTitle("belphegor")
 	systray.SetOnTapped(app.toggleIconColor)
 
+	addTransfersItem()
+
 	mQuit := systray.AddMenuItem("Quit", "")
 	go func() {
 		<-mQuit.ClickedCh
@@ -49,6 +49,8 @@ func (app *appState) onReady() {
 	systray.SetTitle("belphegor")
 	systray.SetOnTapped(app.toggleConsoleAndIcon)
 
+	addTransfersItem()
+
 	mQuit := systray.AddMenuItem("Quit", "")
 	go func() {
 		<-mQuit.ClickedCh
@@ -0,0 +1,34 @@
+//go:build linux || windows
+
+package console
+
+import (
+	"sync/atomic"
+
+	"fyne.io/systray"
+)
+
+const noTransfers = "No transfers"
+
+// transfers is the tray line showing the running transfers, nil until the tray is ready
+var transfers atomic.Pointer[systray.MenuItem]
+
+func addTransfersItem() {
+	item := systray.AddMenuItem(noTransfers, "")
+	item.Disable()
+	transfers.Store(item)
+}
+
+// ShowTransfers puts the summary of the running transfers into the tray, empty when there are none
+func ShowTransfers(summary string) {
+	item := transfers.Load()
+	if item == nil {
+		return
+	}
+
+	if summary == "" {
+		summary = noTransfers
+	}
+	item.SetTitle(summary)
+	systray.SetTooltip(summary)
+}
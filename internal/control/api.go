@@ -0,0 +1,226 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/history"
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transfer"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+var (
+	ErrPeerNotFound      = errors.New("peer not found")
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrHistoryDisabled   = errors.New("history is disabled")
+	ErrUnsupportedMime   = errors.New("unsupported mime type")
+	ErrPairingDisabled   = errors.New("pairing is disabled")
+	ErrTransferNotFound  = errors.New("transfer not found")
+	ErrDaemonUnavailable = errors.New("belphegor daemon is not running")
+)
+
+// Controller is the part of the node driven through the control socket
+type Controller interface {
+	Peers() []Peer
+	// StaticPeers reports the configured addresses kept connected by the node
+	StaticPeers() []StaticPeer
+	// Routes reports the devices known from relayed announcements and the peer they are reached through
+	Routes() []Route
+	LastMessage() (Message, bool)
+	// ConnectAsync must keep the connection alive until ctx is done
+	// and report the handshake result through the channel
+	ConnectAsync(ctx context.Context, addr string) <-chan error
+	Disconnect(id domain.NodeID) error
+	// Push makes data the current clipboard content of this node and syncs it to peers,
+	// a zero ttl falls back to the configured one
+	Push(t mime.Type, data []byte, ttl time.Duration) error
+	// Transfers reports the payloads being streamed with peers
+	Transfers() []Transfer
+	// WatchTransfers reports every start, progress and end of a transfer until ctx is done
+	WatchTransfers(ctx context.Context) <-chan Transfer
+	// CancelTransfer aborts the transfers of the message, with the peer only when it is not zero
+	CancelTransfer(id domain.MessageID, peer domain.NodeID) error
+}
+
+type History interface {
+	List(limit int) []history.Entry
+	Search(query string, limit int) []history.Entry
+	Get(id domain.MessageID) (history.Entry, bool)
+}
+
+// Pairing answers pairing requests of unknown devices and manages the paired ones
+type Pairing interface {
+	Self() security.Fingerprint
+	Pending() []security.PairingRequest
+	Accept(ref string) (security.Device, error)
+	Reject(ref string) error
+	Devices() []security.Device
+	Rename(ref, name string) (security.Device, error)
+	// Revoke must disconnect the device if it is connected
+	Revoke(ref string) (security.Device, error)
+	// SetRelay stops sealing payloads for the device, it only forwards them
+	SetRelay(ref string, relay bool) (security.Device, error)
+}
+
+type Peer struct {
+	ID   domain.NodeID `json:"id"`
+	Name string        `json:"name"`
+	Arch string        `json:"arch"`
+	Addr string        `json:"addr"`
+	// Policy is the rule applied to the peer, match=direction[:mime,...]
+	Policy string `json:"policy"`
+}
+
+type StaticPeer struct {
+	Addr     string    `json:"addr"`
+	State    string    `json:"state"`
+	Attempts int       `json:"attempts"`
+	Retry    time.Time `json:"retry,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Route struct {
+	Origin domain.NodeID `json:"origin"`
+	Via    domain.NodeID `json:"via"`
+	Hops   uint32        `json:"hops"`
+	Seen   time.Time     `json:"seen"`
+}
+
+type PairingStatus struct {
+	// Self is the fingerprint of this device
+	Self    string           `json:"self"`
+	Pending []PairingRequest `json:"pending"`
+}
+
+type PairingRequest struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Code        string    `json:"code"`
+	Seen        time.Time `json:"seen"`
+}
+
+type Device struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Paired      time.Time `json:"paired"`
+	Relay       bool      `json:"relay"`
+}
+
+func DeviceFrom(d security.Device) Device {
+	return Device{
+		Fingerprint: d.Fingerprint.String(),
+		Name:        d.Name,
+		Paired:      d.Paired,
+		Relay:       d.Relay,
+	}
+}
+
+type Transfer struct {
+	ID        domain.MessageID `json:"id"`
+	Peer      domain.NodeID    `json:"peer"`
+	PeerName  string           `json:"peer_name"`
+	Name      string           `json:"name"`
+	Direction string           `json:"direction"`
+	State     string           `json:"state"`
+	Size      uint64           `json:"size"`
+	Done      uint64           `json:"done"`
+	// Rate is in bytes per second
+	Rate    uint64    `json:"rate"`
+	Started time.Time `json:"started"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func TransferFrom(t transfer.Transfer) Transfer {
+	res := Transfer{
+		ID:        t.ID,
+		Peer:      t.Peer,
+		PeerName:  t.PeerName,
+		Name:      t.Name,
+		Direction: string(t.Direction),
+		State:     string(t.State),
+		Size:      t.Size,
+		Done:      t.Done,
+		Rate:      t.Rate,
+		Started:   t.Started,
+	}
+	if t.Err != nil {
+		res.Error = t.Err.Error()
+	}
+
+	return res
+}
+
+type Message struct {
+	ID      domain.MessageID `json:"id"`
+	From    domain.NodeID    `json:"from"`
+	Mime    string           `json:"mime"`
+	Size    uint64           `json:"size"`
+	Hash    uint64           `json:"hash"`
+	Created time.Time        `json:"created"`
+	Name    string           `json:"name,omitempty"`
+	// Data raw content for text and images, path for files
+	Data []byte `json:"data,omitempty"`
+}
+
+func MessageFromEvent(ev domain.EventMessage) Message {
+	return Message{
+		ID:      ev.Payload.ID,
+		From:    ev.From,
+		Mime:    ev.Payload.MimeType.String(),
+		Size:    ev.Payload.ContentLength,
+		Hash:    ev.Payload.ContentHash,
+		Created: ev.Created,
+		Name:    ev.Payload.Name,
+		Data:    ev.Payload.Data,
+	}
+}
+
+func MessageFromEntry(e history.Entry) Message {
+	return Message{
+		ID:      e.ID,
+		From:    e.From,
+		Mime:    e.MimeType.String(),
+		Size:    e.Size,
+		Hash:    e.Hash,
+		Created: e.Created,
+		Name:    e.Name,
+		Data:    e.Data,
+	}
+}
+
+type ConnectRequest struct {
+	Addr string `json:"addr"`
+}
+
+type RenameRequest struct {
+	Name string `json:"name"`
+}
+
+type RelayRequest struct {
+	Relay bool `json:"relay"`
+}
+
+type PushRequest struct {
+	Mime string `json:"mime"`
+	Data []byte `json:"data"`
+	// TTL is a duration like 30s after which the content is cleared from clipboards
+	TTL string `json:"ttl,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ParseMime accepts the names printed by mime.Type.String
+func ParseMime(s string) (mime.Type, error) {
+	for _, t := range []mime.Type{mime.TypeText, mime.TypeImage, mime.TypePath} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+
+	return mime.TypeUnknown, ErrUnsupportedMime
+}
@@ -0,0 +1,241 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+// Client talks to a running daemon through its control socket
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(socket string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) Peers(ctx context.Context) ([]Peer, error) {
+	var peers []Peer
+	return peers, c.do(ctx, http.MethodGet, "/v1/peers", nil, &peers)
+}
+
+func (c *Client) StaticPeers(ctx context.Context) ([]StaticPeer, error) {
+	var peers []StaticPeer
+	return peers, c.do(ctx, http.MethodGet, "/v1/peers/static", nil, &peers)
+}
+
+func (c *Client) Routes(ctx context.Context) ([]Route, error) {
+	var routes []Route
+	return routes, c.do(ctx, http.MethodGet, "/v1/routes", nil, &routes)
+}
+
+func (c *Client) Connect(ctx context.Context, addr string) error {
+	return c.do(ctx, http.MethodPost, "/v1/peers", ConnectRequest{Addr: addr}, nil)
+}
+
+func (c *Client) Disconnect(ctx context.Context, id domain.NodeID) error {
+	return c.do(ctx, http.MethodDelete, "/v1/peers/"+id.String(), nil, nil)
+}
+
+func (c *Client) Clipboard(ctx context.Context) (Message, error) {
+	var msg Message
+	return msg, c.do(ctx, http.MethodGet, "/v1/clipboard", nil, &msg)
+}
+
+func (c *Client) Push(ctx context.Context, mimeType string, data []byte, ttl time.Duration) error {
+	req := PushRequest{Mime: mimeType, Data: data}
+	if ttl > 0 {
+		req.TTL = ttl.String()
+	}
+
+	return c.do(ctx, http.MethodPost, "/v1/clipboard", req, nil)
+}
+
+func (c *Client) History(ctx context.Context, query string, limit int) ([]Message, error) {
+	q := url.Values{}
+	if query != "" {
+		q.Set("q", query)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var entries []Message
+	return entries, c.do(ctx, http.MethodGet, "/v1/history?"+q.Encode(), nil, &entries)
+}
+
+func (c *Client) HistoryEntry(ctx context.Context, id domain.MessageID) (Message, error) {
+	var msg Message
+	return msg, c.do(ctx, http.MethodGet, "/v1/history/"+id.String(), nil, &msg)
+}
+
+func (c *Client) Transfers(ctx context.Context) ([]Transfer, error) {
+	var res []Transfer
+	return res, c.do(ctx, http.MethodGet, "/v1/transfers", nil, &res)
+}
+
+// WatchTransfers calls fn with the running transfers and then with every change until ctx is done
+func (c *Client) WatchTransfers(ctx context.Context, fn func(Transfer)) error {
+	resp, err := c.request(ctx, http.MethodGet, "/v1/transfers?watch=1", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var t Transfer
+		if err := dec.Decode(&t); err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("decode response: %w", err)
+		}
+		fn(t)
+	}
+}
+
+// CancelTransfer aborts the transfers of the message, with the peer only when it is not zero
+func (c *Client) CancelTransfer(ctx context.Context, id domain.MessageID, peer domain.NodeID) error {
+	path := "/v1/transfers/" + id.String()
+	if peer != 0 {
+		path += "?peer=" + peer.String()
+	}
+
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func (c *Client) Pairing(ctx context.Context) (PairingStatus, error) {
+	var status PairingStatus
+	return status, c.do(ctx, http.MethodGet, "/v1/pairing", nil, &status)
+}
+
+// AcceptPairing pairs the device whose request matches ref, a pairing code or a fingerprint prefix
+func (c *Client) AcceptPairing(ctx context.Context, ref string) (Device, error) {
+	var dev Device
+	return dev, c.do(ctx, http.MethodPost, "/v1/pairing/"+url.PathEscape(ref), nil, &dev)
+}
+
+func (c *Client) RejectPairing(ctx context.Context, ref string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/pairing/"+url.PathEscape(ref), nil, nil)
+}
+
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var res []Device
+	return res, c.do(ctx, http.MethodGet, "/v1/devices", nil, &res)
+}
+
+// RenameDevice renames the paired device matching ref, a fingerprint prefix or the current name
+func (c *Client) RenameDevice(ctx context.Context, ref, name string) (Device, error) {
+	var dev Device
+	return dev, c.do(ctx, http.MethodPatch, "/v1/devices/"+url.PathEscape(ref), RenameRequest{Name: name}, &dev)
+}
+
+// SetRelay makes the paired device matching ref a relay that cannot read our payloads, or a reader again
+func (c *Client) SetRelay(ctx context.Context, ref string, relay bool) (Device, error) {
+	var dev Device
+	return dev, c.do(ctx, http.MethodPut, "/v1/devices/"+url.PathEscape(ref)+"/relay", RelayRequest{Relay: relay}, &dev)
+}
+
+// RevokeDevice forgets the paired device matching ref and disconnects it
+func (c *Client) RevokeDevice(ctx context.Context, ref string) (Device, error) {
+	var dev Device
+	return dev, c.do(ctx, http.MethodDelete, "/v1/devices/"+url.PathEscape(ref), nil, &dev)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// request sends the request, the body of a successful response is left to the caller
+func (c *Client) request(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	// the host is ignored, every request goes to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://belphegor"+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrDaemonUnavailable
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+
+		var apiErr errorResponse
+		if decodeErr := json.NewDecoder(resp.Body).Decode(&apiErr); decodeErr != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("control api: %s", resp.Status)
+		}
+		return nil, apiError(resp.StatusCode, apiErr.Error)
+	}
+
+	return resp, nil
+}
+
+// apiError restores sentinel errors so callers can match them with errors.Is
+func apiError(status int, msg string) error {
+	for _, known := range []error{
+		ErrPeerNotFound, ErrMessageNotFound, ErrHistoryDisabled, ErrUnsupportedMime, ErrPairingDisabled,
+		ErrTransferNotFound,
+		security.ErrPairingNotFound, security.ErrPairingAmbiguous,
+		security.ErrDeviceNotFound, security.ErrDeviceAmbiguous, security.ErrEmptyName,
+	} {
+		if msg == known.Error() {
+			return known
+		}
+	}
+
+	return fmt.Errorf("control api: %s (%d)", msg, status)
+}
@@ -0,0 +1,500 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/rs/zerolog"
+)
+
+const (
+	connectTimeout    = 30 * time.Second
+	readHeaderTimeout = 5 * time.Second
+	// 512 mb, same as the default max_file_size
+	maxPushSize = 1 << 29
+)
+
+// Server exposes a Controller as HTTP+JSON over a unix socket
+type Server struct {
+	ctrl    Controller
+	history History
+	pairing Pairing
+	logger  zerolog.Logger
+}
+
+type Option func(*Server)
+
+func WithHistory(h History) Option {
+	return func(s *Server) {
+		s.history = h
+	}
+}
+
+func WithPairing(p Pairing) Option {
+	return func(s *Server) {
+		s.pairing = p
+	}
+}
+
+func WithLogger(logger zerolog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+func NewServer(ctrl Controller, opts ...Option) *Server {
+	s := &Server{
+		ctrl:   ctrl,
+		logger: zerolog.Nop(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Serve listens on socket until ctx is done.
+// Connections started through the API live as long as ctx
+func (s *Server) Serve(ctx context.Context, socket string) error {
+	ctxLog := ctxlog.Op(s.logger, "control.Serve")
+
+	if err := removeStale(ctx, socket); err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "unix", socket)
+	if err != nil {
+		return fmt.Errorf("control listen: %w", err)
+	}
+	defer os.Remove(socket)
+
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(socket, 0600); err != nil {
+			_ = l.Close()
+			return fmt.Errorf("control chmod: %w", err)
+		}
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(ctx),
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	ctxLog.Info().Str("socket", socket).Msg("control api started")
+
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("control serve: %w", err)
+	}
+
+	return nil
+}
+
+// removeStale deletes a socket left behind by a crashed daemon
+func removeStale(ctx context.Context, socket string) error {
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("control socket %s is in use", socket)
+	}
+
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("control remove stale socket: %w", err)
+	}
+
+	return nil
+}
+
+// Handler returns the API routes, connections started through it live as long as ctx
+func (s *Server) Handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/peers", s.peers)
+	mux.HandleFunc("GET /v1/peers/static", s.staticPeers)
+	mux.HandleFunc("GET /v1/routes", s.routes)
+	mux.HandleFunc("POST /v1/peers", func(w http.ResponseWriter, r *http.Request) {
+		s.connect(ctx, w, r)
+	})
+	mux.HandleFunc("DELETE /v1/peers/{id}", s.disconnect)
+
+	mux.HandleFunc("GET /v1/clipboard", s.clipboard)
+	mux.HandleFunc("POST /v1/clipboard", s.push)
+
+	mux.HandleFunc("GET /v1/transfers", s.transfers)
+	mux.HandleFunc("DELETE /v1/transfers/{id}", s.cancelTransfer)
+
+	mux.HandleFunc("GET /v1/history", s.historyList)
+	mux.HandleFunc("GET /v1/history/{id}", s.historyGet)
+
+	mux.HandleFunc("GET /v1/pairing", s.pairingList)
+	mux.HandleFunc("POST /v1/pairing/{ref}", s.pairingAccept)
+	mux.HandleFunc("DELETE /v1/pairing/{ref}", s.pairingReject)
+
+	mux.HandleFunc("GET /v1/devices", s.devices)
+	mux.HandleFunc("PATCH /v1/devices/{ref}", s.deviceRename)
+	mux.HandleFunc("DELETE /v1/devices/{ref}", s.deviceRevoke)
+	mux.HandleFunc("PUT /v1/devices/{ref}/relay", s.deviceRelay)
+
+	return mux
+}
+
+func (s *Server) peers(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, http.StatusOK, s.ctrl.Peers())
+}
+
+func (s *Server) staticPeers(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, http.StatusOK, s.ctrl.StaticPeers())
+}
+
+func (s *Server) routes(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, http.StatusOK, s.ctrl.Routes())
+}
+
+func (s *Server) connect(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req ConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+		s.fail(w, http.StatusBadRequest, errors.New("addr is required"))
+		return
+	}
+
+	select {
+	case err := <-s.ctrl.ConnectAsync(ctx, req.Addr):
+		if err != nil {
+			s.fail(w, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	case <-time.After(connectTimeout):
+		s.fail(w, http.StatusGatewayTimeout, errors.New("handshake timeout"))
+	}
+}
+
+func (s *Server) disconnect(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("invalid peer id: %w", err))
+		return
+	}
+
+	if err := s.ctrl.Disconnect(domain.NodeID(id)); err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clipboard(w http.ResponseWriter, _ *http.Request) {
+	msg, ok := s.ctrl.LastMessage()
+	if !ok {
+		s.fail(w, http.StatusNotFound, ErrMessageNotFound)
+		return
+	}
+
+	s.reply(w, http.StatusOK, msg)
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request) {
+	var req PushRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize)).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+
+	t, err := ParseMime(req.Mime)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl < 0 {
+			s.fail(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+			return
+		}
+	}
+
+	if err := s.ctrl.Push(t, req.Data, ttl); err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transfers lists the running transfers, with watch it streams them and every change after as json lines
+func (s *Server) transfers(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("watch") == "" {
+		s.reply(w, http.StatusOK, s.ctrl.Transfers())
+		return
+	}
+
+	events := s.ctrl.WatchTransfers(r.Context())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for _, t := range s.ctrl.Transfers() {
+		if err := enc.Encode(t); err != nil {
+			return
+		}
+	}
+
+	for {
+		if err := rc.Flush(); err != nil {
+			s.logger.Trace().Err(err).Msg("failed to flush transfers")
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case t := <-events:
+			if err := enc.Encode(t); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) cancelTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("invalid message id: %w", err))
+		return
+	}
+
+	var peer int64
+	if q := r.URL.Query().Get("peer"); q != "" {
+		if peer, err = strconv.ParseInt(q, 10, 64); err != nil {
+			s.fail(w, http.StatusBadRequest, fmt.Errorf("invalid peer id: %w", err))
+			return
+		}
+	}
+
+	if err := s.ctrl.CancelTransfer(domain.MessageID(id), domain.NodeID(peer)); err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) historyList(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.fail(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	var entries []Message
+	if q := r.URL.Query().Get("q"); q != "" {
+		for _, e := range s.history.Search(q, limit) {
+			entries = append(entries, MessageFromEntry(e))
+		}
+	} else {
+		for _, e := range s.history.List(limit) {
+			entries = append(entries, MessageFromEntry(e))
+		}
+	}
+
+	s.reply(w, http.StatusOK, entries)
+}
+
+func (s *Server) historyGet(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.fail(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("invalid message id: %w", err))
+		return
+	}
+
+	entry, ok := s.history.Get(domain.MessageID(id))
+	if !ok {
+		s.fail(w, http.StatusNotFound, ErrMessageNotFound)
+		return
+	}
+
+	s.reply(w, http.StatusOK, MessageFromEntry(entry))
+}
+
+func (s *Server) pairingList(w http.ResponseWriter, _ *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	status := PairingStatus{
+		Self:    s.pairing.Self().String(),
+		Pending: []PairingRequest{},
+	}
+	for _, r := range s.pairing.Pending() {
+		status.Pending = append(status.Pending, PairingRequest{
+			Fingerprint: r.Fingerprint.String(),
+			Name:        r.Name,
+			Code:        r.Code,
+			Seen:        r.Seen,
+		})
+	}
+
+	s.reply(w, http.StatusOK, status)
+}
+
+func (s *Server) pairingAccept(w http.ResponseWriter, r *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	dev, err := s.pairing.Accept(r.PathValue("ref"))
+	if err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	s.reply(w, http.StatusOK, DeviceFrom(dev))
+}
+
+func (s *Server) pairingReject(w http.ResponseWriter, r *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	if err := s.pairing.Reject(r.PathValue("ref")); err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) devices(w http.ResponseWriter, _ *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	res := []Device{}
+	for _, d := range s.pairing.Devices() {
+		res = append(res, DeviceFrom(d))
+	}
+
+	s.reply(w, http.StatusOK, res)
+}
+
+func (s *Server) deviceRename(w http.ResponseWriter, r *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+
+	dev, err := s.pairing.Rename(r.PathValue("ref"), req.Name)
+	if err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	s.reply(w, http.StatusOK, DeviceFrom(dev))
+}
+
+func (s *Server) deviceRevoke(w http.ResponseWriter, r *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	dev, err := s.pairing.Revoke(r.PathValue("ref"))
+	if err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	s.reply(w, http.StatusOK, DeviceFrom(dev))
+}
+
+func (s *Server) deviceRelay(w http.ResponseWriter, r *http.Request) {
+	if s.pairing == nil {
+		s.fail(w, http.StatusNotFound, ErrPairingDisabled)
+		return
+	}
+
+	var req RelayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+
+	dev, err := s.pairing.SetRelay(r.PathValue("ref"), req.Relay)
+	if err != nil {
+		s.fail(w, statusOf(err), err)
+		return
+	}
+
+	s.reply(w, http.StatusOK, DeviceFrom(dev))
+}
+
+func (s *Server) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Trace().Err(err).Msg("failed to write response")
+	}
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	s.reply(w, status, errorResponse{Error: err.Error()})
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrPeerNotFound), errors.Is(err, ErrMessageNotFound), errors.Is(err, ErrTransferNotFound),
+		errors.Is(err, security.ErrPairingNotFound), errors.Is(err, security.ErrDeviceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnsupportedMime), errors.Is(err, security.ErrEmptyName):
+		return http.StatusBadRequest
+	case errors.Is(err, security.ErrPairingAmbiguous), errors.Is(err, security.ErrDeviceAmbiguous):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,433 @@
+package control_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/control"
+	"github.com/labi-le/belphegor/internal/history"
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+type fakeController struct {
+	peers      []control.Peer
+	static     []control.StaticPeer
+	routes     []control.Route
+	last       *control.Message
+	connectErr error
+	connected  []string
+	dropped    []domain.NodeID
+	pushed     []byte
+	pushedMime mime.Type
+	pushedTTL  time.Duration
+	transfers  []control.Transfer
+	watched    chan control.Transfer
+	canceled   []domain.MessageID
+}
+
+func (f *fakeController) Peers() []control.Peer { return f.peers }
+
+func (f *fakeController) StaticPeers() []control.StaticPeer { return f.static }
+
+func (f *fakeController) Routes() []control.Route { return f.routes }
+
+func (f *fakeController) LastMessage() (control.Message, bool) {
+	if f.last == nil {
+		return control.Message{}, false
+	}
+	return *f.last, true
+}
+
+func (f *fakeController) ConnectAsync(_ context.Context, addr string) <-chan error {
+	f.connected = append(f.connected, addr)
+	ch := make(chan error, 1)
+	ch <- f.connectErr
+	return ch
+}
+
+func (f *fakeController) Disconnect(id domain.NodeID) error {
+	for _, p := range f.peers {
+		if p.ID == id {
+			f.dropped = append(f.dropped, id)
+			return nil
+		}
+	}
+	return control.ErrPeerNotFound
+}
+
+func (f *fakeController) Push(t mime.Type, data []byte, ttl time.Duration) error {
+	f.pushedMime, f.pushed, f.pushedTTL = t, data, ttl
+	return nil
+}
+
+func (f *fakeController) Transfers() []control.Transfer { return f.transfers }
+
+func (f *fakeController) WatchTransfers(context.Context) <-chan control.Transfer { return f.watched }
+
+func (f *fakeController) CancelTransfer(id domain.MessageID, _ domain.NodeID) error {
+	for _, t := range f.transfers {
+		if t.ID == id {
+			f.canceled = append(f.canceled, id)
+			return nil
+		}
+	}
+	return control.ErrTransferNotFound
+}
+
+type fakeHistory struct {
+	entries []history.Entry
+}
+
+func (h fakeHistory) List(int) []history.Entry { return h.entries }
+
+func (h fakeHistory) Search(q string, _ int) []history.Entry {
+	var res []history.Entry
+	for _, e := range h.entries {
+		if bytes.Contains(e.Data, []byte(q)) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (h fakeHistory) Get(id domain.MessageID) (history.Entry, bool) {
+	for _, e := range h.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return history.Entry{}, false
+}
+
+type fakePairing struct {
+	devices []security.Device
+}
+
+func (p *fakePairing) Self() security.Fingerprint { return security.Fingerprint{} }
+
+func (p *fakePairing) Pending() []security.PairingRequest { return nil }
+
+func (p *fakePairing) Accept(string) (security.Device, error) {
+	return security.Device{}, security.ErrPairingNotFound
+}
+
+func (p *fakePairing) Reject(string) error { return security.ErrPairingNotFound }
+
+func (p *fakePairing) Devices() []security.Device { return p.devices }
+
+func (p *fakePairing) Rename(ref, name string) (security.Device, error) {
+	for i, d := range p.devices {
+		if d.Name == ref {
+			p.devices[i].Name = name
+			return p.devices[i], nil
+		}
+	}
+	return security.Device{}, security.ErrDeviceNotFound
+}
+
+func (p *fakePairing) Revoke(ref string) (security.Device, error) {
+	for i, d := range p.devices {
+		if d.Name == ref {
+			p.devices = append(p.devices[:i], p.devices[i+1:]...)
+			return d, nil
+		}
+	}
+	return security.Device{}, security.ErrDeviceNotFound
+}
+
+func (p *fakePairing) SetRelay(ref string, relay bool) (security.Device, error) {
+	for i, d := range p.devices {
+		if d.Name == ref {
+			p.devices[i].Relay = relay
+			return p.devices[i], nil
+		}
+	}
+	return security.Device{}, security.ErrDeviceNotFound
+}
+
+func serve(t *testing.T, ctrl control.Controller, opts ...control.Option) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(control.NewServer(ctrl, opts...).Handler(t.Context()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method, url string, body any) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequestWithContext(t.Context(), method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestServer_Peers(t *testing.T) {
+	ctrl := &fakeController{peers: []control.Peer{{ID: 1, Name: "laptop", Addr: "10.0.0.2:7000"}}}
+	srv := serve(t, ctrl)
+
+	resp := do(t, http.MethodGet, srv.URL+"/v1/peers", nil)
+	var got []control.Peer
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "laptop" {
+		t.Fatalf("peers = %+v", got)
+	}
+
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/peers/1", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("disconnect status = %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/peers/2", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("disconnect unknown status = %d, want 404", resp.StatusCode)
+	}
+	if len(ctrl.dropped) != 1 || ctrl.dropped[0] != 1 {
+		t.Fatalf("dropped = %v", ctrl.dropped)
+	}
+}
+
+func TestServer_StaticPeers(t *testing.T) {
+	srv := serve(t, &fakeController{static: []control.StaticPeer{
+		{Addr: "10.0.0.2:7000", State: "backoff", Attempts: 3, Error: "refused"},
+	}})
+
+	resp := do(t, http.MethodGet, srv.URL+"/v1/peers/static", nil)
+	var got []control.StaticPeer
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].State != "backoff" || got[0].Attempts != 3 {
+		t.Fatalf("static peers = %+v", got)
+	}
+}
+
+func TestServer_Routes(t *testing.T) {
+	srv := serve(t, &fakeController{routes: []control.Route{
+		{Origin: 3, Via: 2, Hops: 2},
+	}})
+
+	resp := do(t, http.MethodGet, srv.URL+"/v1/routes", nil)
+	var got []control.Route
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Origin != 3 || got[0].Via != 2 || got[0].Hops != 2 {
+		t.Fatalf("routes = %+v", got)
+	}
+}
+
+func TestServer_Connect(t *testing.T) {
+	ctrl := &fakeController{}
+	srv := serve(t, ctrl)
+
+	resp := do(t, http.MethodPost, srv.URL+"/v1/peers", control.ConnectRequest{Addr: "10.0.0.3:7000"})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("connect status = %d", resp.StatusCode)
+	}
+
+	ctrl.connectErr = errors.New("refused")
+	resp = do(t, http.MethodPost, srv.URL+"/v1/peers", control.ConnectRequest{Addr: "10.0.0.4:7000"})
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("failed connect status = %d, want 502", resp.StatusCode)
+	}
+
+	if resp := do(t, http.MethodPost, srv.URL+"/v1/peers", control.ConnectRequest{}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("empty addr status = %d, want 400", resp.StatusCode)
+	}
+	if len(ctrl.connected) != 2 {
+		t.Fatalf("connected = %v", ctrl.connected)
+	}
+}
+
+func TestServer_Clipboard(t *testing.T) {
+	ctrl := &fakeController{}
+	srv := serve(t, ctrl)
+
+	if resp := do(t, http.MethodGet, srv.URL+"/v1/clipboard", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("empty clipboard status = %d, want 404", resp.StatusCode)
+	}
+
+	resp := do(t, http.MethodPost, srv.URL+"/v1/clipboard", control.PushRequest{Mime: "text", Data: []byte("hi")})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("push status = %d", resp.StatusCode)
+	}
+	if string(ctrl.pushed) != "hi" || ctrl.pushedMime != mime.TypeText {
+		t.Fatalf("pushed %q as %s", ctrl.pushed, ctrl.pushedMime)
+	}
+
+	if resp := do(t, http.MethodPost, srv.URL+"/v1/clipboard", control.PushRequest{Mime: "video"}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unsupported mime status = %d, want 400", resp.StatusCode)
+	}
+
+	do(t, http.MethodPost, srv.URL+"/v1/clipboard", control.PushRequest{Mime: "text", Data: []byte("otp"), TTL: "30s"})
+	if ctrl.pushedTTL != 30*time.Second {
+		t.Fatalf("pushed ttl = %s, want 30s", ctrl.pushedTTL)
+	}
+	if resp := do(t, http.MethodPost, srv.URL+"/v1/clipboard", control.PushRequest{Mime: "text", Data: []byte("x"), TTL: "soon"}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid ttl status = %d, want 400", resp.StatusCode)
+	}
+
+	ctrl.last = &control.Message{ID: 5, Mime: "text", Data: []byte("last")}
+	resp = do(t, http.MethodGet, srv.URL+"/v1/clipboard", nil)
+	var got control.Message
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 5 || string(got.Data) != "last" {
+		t.Fatalf("clipboard = %+v", got)
+	}
+}
+
+func TestServer_Transfers(t *testing.T) {
+	ctrl := &fakeController{
+		transfers: []control.Transfer{{ID: 7, Peer: 1, Name: "video.mkv", State: "running", Size: 100, Done: 40}},
+		watched:   make(chan control.Transfer, 1),
+	}
+	srv := serve(t, ctrl)
+
+	resp := do(t, http.MethodGet, srv.URL+"/v1/transfers", nil)
+	var got []control.Transfer
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "video.mkv" || got[0].Done != 40 {
+		t.Fatalf("transfers = %+v", got)
+	}
+
+	ctrl.watched <- control.Transfer{ID: 7, Name: "video.mkv", State: "done", Size: 100, Done: 100}
+	resp = do(t, http.MethodGet, srv.URL+"/v1/transfers?watch=1", nil)
+	dec := json.NewDecoder(resp.Body)
+	for _, want := range []string{"running", "done"} {
+		var ev control.Transfer
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.ID != 7 || ev.State != want {
+			t.Fatalf("watched %+v, want state %s", ev, want)
+		}
+	}
+
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/transfers/7?peer=1", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("cancel status = %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/transfers/8", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("cancel unknown status = %d, want 404", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/transfers/7?peer=x", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("cancel invalid peer status = %d, want 400", resp.StatusCode)
+	}
+	if len(ctrl.canceled) != 1 || ctrl.canceled[0] != 7 {
+		t.Fatalf("canceled = %v", ctrl.canceled)
+	}
+}
+
+func TestServer_History(t *testing.T) {
+	srv := serve(t, &fakeController{})
+	if resp := do(t, http.MethodGet, srv.URL+"/v1/history", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("disabled history status = %d, want 404", resp.StatusCode)
+	}
+
+	hist := fakeHistory{entries: []history.Entry{
+		{ID: 2, MimeType: mime.TypeText, Data: []byte("second")},
+		{ID: 1, MimeType: mime.TypeText, Data: []byte("first")},
+	}}
+	srv = serve(t, &fakeController{}, control.WithHistory(hist))
+
+	var got []control.Message
+	resp := do(t, http.MethodGet, srv.URL+"/v1/history?q=sec", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 2 || got[0].Mime != "text" {
+		t.Fatalf("search = %+v", got)
+	}
+
+	if resp := do(t, http.MethodGet, srv.URL+"/v1/history/1", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("get status = %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodGet, srv.URL+"/v1/history/3", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get missing status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestServer_Devices(t *testing.T) {
+	pairing := &fakePairing{devices: []security.Device{{Fingerprint: security.Fingerprint{1}, Name: "laptop"}}}
+	srv := serve(t, &fakeController{}, control.WithPairing(pairing))
+
+	var renamed control.Device
+	resp := do(t, http.MethodPatch, srv.URL+"/v1/devices/laptop", control.RenameRequest{Name: "work"})
+	if err := json.NewDecoder(resp.Body).Decode(&renamed); err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "work" || renamed.Fingerprint != (security.Fingerprint{1}).String() {
+		t.Fatalf("renamed = %+v", renamed)
+	}
+
+	var relay control.Device
+	resp = do(t, http.MethodPut, srv.URL+"/v1/devices/work/relay", control.RelayRequest{Relay: true})
+	if err := json.NewDecoder(resp.Body).Decode(&relay); err != nil {
+		t.Fatal(err)
+	}
+	if !relay.Relay {
+		t.Fatalf("relay = %+v", relay)
+	}
+
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/devices/laptop", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("revoke old name status = %d, want 404", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, srv.URL+"/v1/devices/work", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoke status = %d", resp.StatusCode)
+	}
+
+	var devices []control.Device
+	resp = do(t, http.MethodGet, srv.URL+"/v1/devices", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		t.Fatal(err)
+	}
+	if devices == nil || len(devices) != 0 {
+		t.Fatalf("devices after revoke = %#v", devices)
+	}
+}
+
+func TestServer_ServeUnixSocket(t *testing.T) {
+	socket := t.TempDir() + "/ctl.sock"
+	ctx, cancel := context.WithCancel(t.Context())
+
+	done := make(chan error, 1)
+	go func() { done <- control.NewServer(&fakeController{}).Serve(ctx, socket) }()
+
+	client := control.NewClient(socket)
+	var err error
+	for range 50 {
+		if _, err = client.Peers(t.Context()); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Peers over unix socket: %v", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
@@ -2,24 +2,68 @@ package discovering
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
+	"slices"
 	"time"
 
+	"github.com/labi-le/belphegor/internal/scope"
 	"github.com/labi-le/belphegor/pkg/ctxlog"
 	"github.com/labi-le/belphegor/pkg/network"
 	"github.com/rs/zerolog"
 	"github.com/schollz/peerdiscovery"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 type Connector interface {
 	DiscoveryPayload() []byte
-	PeerDiscovered(ctx context.Context, addr net.IP, payload []byte)
+	// PeerDiscovered is called with the source of an announcement, a link-local address keeps its zone
+	PeerDiscovered(ctx context.Context, addr netip.Addr, payload []byte)
 }
 
+// Backend is how nodes find each other
+type Backend string
+
+const (
+	// BackendBeacon sends the discovery payload to a multicast group, only belphegor listens to it
+	BackendBeacon Backend = "beacon"
+	// BackendMDNS advertises a DNS-SD service over mDNS, Avahi and Bonjour see it
+	BackendMDNS Backend = "mdns"
+)
+
+var ErrBackend = errors.New("unknown discovery backend")
+
+func (b *Backend) Set(s string) error {
+	switch Backend(s) {
+	case BackendBeacon, BackendMDNS:
+		*b = Backend(s)
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrBackend, s)
+	}
+}
+
+func (b *Backend) String() string { return string(*b) }
+
+func (b *Backend) Type() string { return "string" }
+
+// ipVersion is the IP version a backend announces over
+type ipVersion uint
+
+const (
+	ip4 ipVersion = 4
+	ip6 ipVersion = 6
+)
+
 type Discover struct {
 	maxPeers int
 	delay    time.Duration
 	port     int
+	backend  Backend
+	scope    scope.Scope
 	logger   zerolog.Logger
 }
 
@@ -27,6 +71,7 @@ type Discover struct {
 var defaultConfig = &Discover{
 	maxPeers: 10,
 	delay:    time.Minute * 5,
+	backend:  BackendBeacon,
 }
 
 type Option func(*Discover)
@@ -49,6 +94,19 @@ func WithPort(port int) Option {
 	}
 }
 
+func WithBackend(backend Backend) Option {
+	return func(d *Discover) {
+		d.backend = backend
+	}
+}
+
+// WithScope keeps discovery to the networks of s, both backends announce only over the interfaces in it
+func WithScope(s scope.Scope) Option {
+	return func(d *Discover) {
+		d.scope = s
+	}
+}
+
 func WithLogger(logger zerolog.Logger) Option {
 	return func(d *Discover) {
 		d.logger = logger
@@ -60,6 +118,7 @@ func New(opts ...Option) *Discover {
 		maxPeers: defaultConfig.maxPeers,
 		delay:    defaultConfig.delay,
 		port:     defaultConfig.port,
+		backend:  defaultConfig.backend,
 	}
 
 	for _, opt := range opts {
@@ -69,26 +128,159 @@ func New(opts ...Option) *Discover {
 	return d
 }
 
+// Discover announces the node and finds peers over IPv4 and IPv6 multicast with the backend.
+// It fails only when neither of them works
 func (d *Discover) Discover(ctx context.Context, connector Connector) {
 	ctxLog := ctxlog.Op(d.logger, "discover.Discover")
-	_, err := peerdiscovery.NewPeerDiscovery(
+
+	discover := d.beacon
+	if d.backend == BackendMDNS {
+		discover = d.mdns
+	}
+
+	versions := []ipVersion{ip4, ip6}
+	failed := make(chan error, len(versions))
+	for _, version := range versions {
+		go func() {
+			if err := discover(ctx, connector, version); err != nil {
+				ctxLog.Warn().Err(err).Uint("ip_version", uint(version)).Msg("discovery unavailable")
+				failed <- err
+			}
+		}()
+	}
+
+	var errs []error
+	for range versions {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-failed:
+			errs = append(errs, err)
+		}
+	}
+
+	// a scope may leave no interface to discover on, the node still serves the peers it is given
+	if !slices.ContainsFunc(errs, func(err error) bool { return !errors.Is(err, ErrNoMulticast) }) {
+		ctxLog.Warn().Msg("no multicast interface in scope, discovery is off")
+		return
+	}
+
+	ctxLog.Fatal().Err(errors.Join(errs...)).Msg("failed to start discover")
+}
+
+// beacon groups, the defaults of peerdiscovery
+var (
+	beaconGroup4 = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 9999}
+	beaconGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::c"), Port: 9999}
+)
+
+// beaconTTL is what peerdiscovery sends with
+const beaconTTL = 2
+
+// beacon blocks while the announcements of one IP version are sent and received, ff02::c is the IPv6 group.
+// peerdiscovery sends over every interface, with a scope it only listens and the interfaces in scope are sent over here
+func (d *Discover) beacon(ctx context.Context, connector Connector, version ipVersion) error {
+	payload := connector.DiscoveryPayload()
+
+	restricted := len(d.scope.Allow) > 0 || len(d.scope.Untrusted) > 0
+	if restricted {
+		ifaces := slices.DeleteFunc(multicastInterfaces(version), func(iface net.Interface) bool {
+			return !d.scope.DiscoversOn(iface)
+		})
+		if len(ifaces) == 0 {
+			return ErrNoMulticast
+		}
+
+		sendCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go d.sendBeacon(sendCtx, payload, version, ifaces)
+	}
+
+	// NewPeerDiscovery dereferences its result when it fails to start, Discover does not
+	_, err := peerdiscovery.Discover(
 		peerdiscovery.Settings{
-			Payload:   connector.DiscoveryPayload(),
-			Limit:     d.maxPeers,
-			TimeLimit: -1,
-			Delay:     d.delay,
-			AllowSelf: false,
-			Notify: func(d peerdiscovery.Discovered) {
-				peerIP := net.ParseIP(d.Address)
-				if network.IsLocalIP(peerIP) {
+			Payload:          payload,
+			Limit:            d.maxPeers,
+			TimeLimit:        -1,
+			Delay:            d.delay,
+			AllowSelf:        false,
+			DisableBroadcast: restricted,
+			IPVersion:        peerdiscovery.IPVersion(version),
+			Notify: func(found peerdiscovery.Discovered) {
+				peerAddr, err := netip.ParseAddr(found.Address)
+				if err != nil || network.IsLocalAddr(peerAddr) || !d.scope.Discovers(peerAddr) {
 					return
 				}
-				go connector.PeerDiscovered(ctx, peerIP, d.Payload)
+				go connector.PeerDiscovered(ctx, peerAddr.Unmap(), found.Payload)
 			},
 		},
 	)
 
+	return err
+}
+
+// sendBeacon multicasts payload over ifaces every delay until ctx is done
+func (d *Discover) sendBeacon(ctx context.Context, payload []byte, version ipVersion, ifaces []net.Interface) {
+	ctxLog := ctxlog.Op(d.logger, "discover.sendBeacon")
+
+	network, group := "udp4", beaconGroup4
+	if version == ip6 {
+		network, group = "udp6", beaconGroup6
+	}
+
+	conn, err := net.ListenPacket(network, ":0")
 	if err != nil {
-		ctxLog.Fatal().Err(err).Msg("failed to start discover")
+		ctxLog.Warn().Err(err).Msg("failed to open beacon socket")
+		return
+	}
+	defer conn.Close()
+
+	var pc beaconConn
+	if version == ip6 {
+		p := ipv6.NewPacketConn(conn)
+		_ = p.SetMulticastHopLimit(beaconTTL)
+		pc = beaconConn6{p}
+	} else {
+		p := ipv4.NewPacketConn(conn)
+		_ = p.SetMulticastTTL(beaconTTL)
+		pc = beaconConn4{p}
 	}
+
+	ticker := time.NewTicker(d.delay)
+	defer ticker.Stop()
+	for {
+		for _, iface := range ifaces {
+			if pc.SetMulticastInterface(&iface) != nil {
+				continue
+			}
+			if _, err := pc.WriteTo(payload, group); err != nil {
+				ctxLog.Trace().Err(err).Str("iface", iface.Name).Msg("failed to send beacon")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// beaconConn is what ipv4.PacketConn and ipv6.PacketConn have in common for sending
+type beaconConn interface {
+	SetMulticastInterface(ifi *net.Interface) error
+	WriteTo(b []byte, dst net.Addr) (int, error)
+}
+
+// beaconConn4 and beaconConn6 drop the control message the PacketConns of x/net write with
+type beaconConn4 struct{ *ipv4.PacketConn }
+
+func (c beaconConn4) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, nil, dst)
+}
+
+type beaconConn6 struct{ *ipv6.PacketConn }
+
+func (c beaconConn6) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, nil, dst)
 }
@@ -0,0 +1,31 @@
+package discovering
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/scope"
+)
+
+type nopConnector struct{}
+
+func (nopConnector) DiscoveryPayload() []byte { return []byte("hi") }
+
+func (nopConnector) PeerDiscovered(context.Context, netip.Addr, []byte) {}
+
+func TestBeaconOutOfScope(t *testing.T) {
+	// the interface does not exist, there is nothing to send the beacon over
+	allow, err := scope.Parse("nonexistent0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(WithScope(scope.Scope{Allow: scope.Networks{allow}}))
+
+	for _, version := range []ipVersion{ip4, ip6} {
+		if err := d.beacon(t.Context(), nopConnector{}, version); !errors.Is(err, ErrNoMulticast) {
+			t.Fatalf("beacon over IPv%d = %v, want ErrNoMulticast", version, err)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package discovering
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/scope"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/labi-le/belphegor/pkg/network"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DNS-SD names of the service, QUIC is advertised over udp and the stream transports over tcp
+const (
+	ServiceTCP = "_belphegor._tcp.local."
+	ServiceUDP = "_belphegor._udp.local."
+
+	// serviceEnum lists the service types of a network, RFC 6763 section 9
+	serviceEnum = "_services._dns-sd._udp.local."
+)
+
+const (
+	mdnsPort = 5353
+	// hostTTL is of the records that name the host, serviceTTL of the rest, as RFC 6762 recommends
+	hostTTL    = 120
+	serviceTTL = 4500
+	// cacheFlush marks the records only this node answers for
+	cacheFlush = 1 << 15
+	// unicastResponse is asked for in a question, answers are multicast anyway
+	unicastResponse = 1 << 15
+	maxPacket       = 9000
+	maxLabel        = 63
+	maxTXT          = 255
+	// renotify is how often the same peer is passed to the connector at most
+	renotify = 10 * time.Second
+)
+
+var (
+	mdnsGroup4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+	mdnsGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: mdnsPort}
+)
+
+var ErrNoMulticast = errors.New("no multicast interface")
+
+// mdns blocks while the service is advertised and browsed over one IP version.
+// Answers to queries and announcements both reveal peers, the address they came from is dialed
+func (d *Discover) mdns(ctx context.Context, connector Connector, version ipVersion) error {
+	greet, err := protocol.DecodeExpect[domain.EventHandshake](bytes.NewReader(connector.DiscoveryPayload()))
+	if err != nil {
+		return fmt.Errorf("discover.mdns: %w", err)
+	}
+
+	r, err := listenMDNS(version, d.scope)
+	if err != nil {
+		return fmt.Errorf("discover.mdns: %w", err)
+	}
+	r.self = newService(greet.Payload)
+	r.maxPeers = d.maxPeers
+	r.logger = ctxlog.Op(d.logger, "discover.mdns").With().Uint("ip_version", uint(version)).Logger()
+
+	stop := context.AfterFunc(ctx, func() {
+		r.send(r.announcement(0))
+		_ = r.conn.Close()
+	})
+	defer stop()
+
+	go r.browse(ctx, d.delay)
+
+	return r.serve(func(addr netip.Addr, payload []byte) {
+		go connector.PeerDiscovered(ctx, addr, payload)
+	})
+}
+
+// multicastConn is what ipv4.PacketConn and ipv6.PacketConn have in common
+type multicastConn interface {
+	JoinGroup(ifi *net.Interface, group net.Addr) error
+	SetMulticastInterface(ifi *net.Interface) error
+	SetMulticastLoopback(on bool) error
+}
+
+// responder answers the queries for the service of the node and reads the answers of others
+type responder struct {
+	conn    *net.UDPConn
+	pc      multicastConn
+	group   *net.UDPAddr
+	ifaces  []net.Interface
+	version ipVersion
+
+	// mu keeps the interface a packet is sent over until it is written
+	mu sync.Mutex
+
+	self     service
+	scope    scope.Scope
+	maxPeers int
+	seen     map[domain.NodeID]time.Time
+	logger   zerolog.Logger
+}
+
+// listenMDNS joins the mDNS group of version on every multicast interface in scope
+func listenMDNS(version ipVersion, s scope.Scope) (*responder, error) {
+	network, group := "udp4", mdnsGroup4
+	if version == ip6 {
+		network, group = "udp6", mdnsGroup6
+	}
+
+	ifaces := slices.DeleteFunc(multicastInterfaces(version), func(iface net.Interface) bool {
+		return !s.DiscoversOn(iface)
+	})
+	if len(ifaces) == 0 {
+		return nil, ErrNoMulticast
+	}
+
+	conn, err := net.ListenMulticastUDP(network, &ifaces[0], group)
+	if err != nil {
+		return nil, err
+	}
+
+	var pc multicastConn
+	if version == ip6 {
+		p := ipv6.NewPacketConn(conn)
+		_ = p.SetMulticastHopLimit(255)
+		pc = p
+	} else {
+		p := ipv4.NewPacketConn(conn)
+		_ = p.SetMulticastTTL(255)
+		pc = p
+	}
+	// ListenMulticastUDP turns loopback off, nodes on one host would not see each other
+	_ = pc.SetMulticastLoopback(true)
+
+	joined := ifaces[:1]
+	for _, iface := range ifaces[1:] {
+		if pc.JoinGroup(&iface, group) == nil {
+			joined = append(joined, iface)
+		}
+	}
+
+	return &responder{
+		conn:    conn,
+		pc:      pc,
+		group:   group,
+		ifaces:  joined,
+		version: version,
+		scope:   s,
+		seen:    make(map[domain.NodeID]time.Time),
+	}, nil
+}
+
+// multicastInterfaces are up, multicast capable and have an address of version
+func multicastInterfaces(version ipVersion) []net.Interface {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var res []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if len(interfaceAddrs(iface, version)) > 0 {
+			res = append(res, iface)
+		}
+	}
+	return res
+}
+
+func interfaceAddrs(iface net.Interface, version ipVersion) []netip.Addr {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var res []netip.Addr
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		if addr := prefix.Addr(); addr.Is4() == (version == ip4) {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// browse announces the service twice a second apart and queries for peers, the queries grow apart up to delay
+func (r *responder) browse(ctx context.Context, delay time.Duration) {
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName(ServiceTCP), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+			{Name: dnsmessage.MustNewName(ServiceUDP), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		},
+	}
+
+	interval := time.Second
+	for i := 0; ; i++ {
+		if i < 2 {
+			r.send(r.announcement(1))
+		}
+		r.send(query)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, max(delay, time.Second))
+	}
+}
+
+// serve answers queries and passes the peers found in answers on until the connection is closed
+func (r *responder) serve(found func(netip.Addr, []byte)) error {
+	buf := make([]byte, maxPacket)
+	for {
+		n, src, err := r.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		// the socket also gets packets of the interfaces out of scope, they are not answered either
+		if !network.IsLocalAddr(src.Addr()) && !r.scope.Discovers(src.Addr()) {
+			continue
+		}
+
+		var m dnsmessage.Message
+		if err := m.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		if !m.Header.Response {
+			r.answer(m)
+			continue
+		}
+		for _, greet := range r.peers(m) {
+			r.logger.Trace().Str("peer", greet.MetaData.String()).Str("addr", src.Addr().String()).Msg("found")
+
+			event := domain.NewEvent(greet)
+			event.From = greet.MetaData.ID
+			found(src.Addr().Unmap(), protocol.MustEncode(event))
+		}
+	}
+}
+
+// answer replies to the questions about the service, its instance or host, a known PTR answer is not repeated
+func (r *responder) answer(query dnsmessage.Message) {
+	var answers []dnsmessage.Resource
+	for _, q := range query.Questions {
+		for _, rr := range r.self.answers(q, r.addrs(), 1) {
+			if !contains(answers, rr) && !known(query.Answers, rr) {
+				answers = append(answers, rr)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	var extra []dnsmessage.Resource
+	for _, rr := range r.self.records(r.addrs(), 1) {
+		if !contains(answers, rr) {
+			extra = append(extra, rr)
+		}
+	}
+
+	r.send(dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: extra,
+	})
+}
+
+// announcement carries every record of the service, scale 0 says goodbye
+func (r *responder) announcement(scale uint32) dnsmessage.Message {
+	return dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: r.self.records(r.addrs(), scale),
+	}
+}
+
+// peers reads the handshakes of other nodes from the TXT records of an answer, goodbyes forget them
+func (r *responder) peers(m dnsmessage.Message) []domain.Handshake {
+	ports := make(map[string]uint16)
+	for _, rr := range append(m.Answers, m.Additionals...) {
+		if srv, ok := rr.Body.(*dnsmessage.SRVResource); ok {
+			ports[strings.ToLower(rr.Header.Name.String())] = srv.Port
+		}
+	}
+
+	var res []domain.Handshake
+	for _, rr := range append(m.Answers, m.Additionals...) {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok || !isInstance(rr.Header.Name.String()) {
+			continue
+		}
+
+		greet, ok := parseTXT(txt.TXT, ports[strings.ToLower(rr.Header.Name.String())])
+		if !ok || greet.MetaData.ID == r.self.id {
+			continue
+		}
+		if rr.Header.TTL == 0 {
+			delete(r.seen, greet.MetaData.ID)
+			continue
+		}
+		if r.remember(greet.MetaData.ID) {
+			res = append(res, greet)
+		}
+	}
+	return res
+}
+
+// remember is true when the peer was not passed on lately and there is room for it among maxPeers
+func (r *responder) remember(id domain.NodeID) bool {
+	now := time.Now()
+	for peer, at := range r.seen {
+		if now.Sub(at) > hostTTL*time.Second {
+			delete(r.seen, peer)
+		}
+	}
+
+	at, ok := r.seen[id]
+	if ok && now.Sub(at) < renotify {
+		return false
+	}
+	if !ok && len(r.seen) >= r.maxPeers {
+		return false
+	}
+
+	r.seen[id] = now
+	return true
+}
+
+// addrs are the addresses of the interfaces the responder joined
+func (r *responder) addrs() []netip.Addr {
+	var res []netip.Addr
+	for _, iface := range r.ifaces {
+		res = append(res, interfaceAddrs(iface, r.version)...)
+	}
+	return res
+}
+
+// send multicasts m over every interface, a failing interface is logged and skipped
+func (r *responder) send(m dnsmessage.Message) {
+	b, err := m.Pack()
+	if err != nil {
+		r.logger.Warn().Err(err).Msg("failed to pack")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, iface := range r.ifaces {
+		if err := r.pc.SetMulticastInterface(&iface); err != nil {
+			r.logger.Trace().Err(err).Str("iface", iface.Name).Msg("failed to select interface")
+			continue
+		}
+		if _, err := r.conn.WriteToUDP(b, r.group); err != nil {
+			r.logger.Trace().Err(err).Str("iface", iface.Name).Msg("failed to send")
+		}
+	}
+}
+
+// service is what a node advertises: an instance of each service type it is reachable with
+type service struct {
+	id       domain.NodeID
+	instance string
+	host     dnsmessage.Name
+	types    []serviceType
+	txt      []string
+}
+
+type serviceType struct {
+	name string
+	port uint16
+}
+
+func newService(greet domain.Handshake) service {
+	id := strconv.FormatInt(int64(greet.MetaData.ID), 10)
+
+	return service{
+		id:       greet.MetaData.ID,
+		instance: instanceLabel(greet.MetaData.Name, id),
+		host:     dnsmessage.MustNewName("belphegor-" + id + ".local."),
+		types:    serviceTypes(greet),
+		txt:      txtRecords(greet),
+	}
+}
+
+// instanceLabel is the device name made unique by its id, labels cannot hold dots here
+func instanceLabel(name, id string) string {
+	name = strings.ReplaceAll(name, ".", "-")
+	if limit := maxLabel - len(id) - 1; len(name) > limit {
+		name = name[:limit]
+	}
+	if name == "" {
+		name = "belphegor"
+	}
+	return name + "-" + id
+}
+
+// serviceTypes are the types the node is advertised as, each points at the first port of its transports
+func serviceTypes(greet domain.Handshake) []serviceType {
+	if len(greet.Endpoints) == 0 {
+		return []serviceType{{name: ServiceTCP, port: uint16(greet.Port)}}
+	}
+
+	var res []serviceType
+	for _, e := range greet.Endpoints {
+		name := ServiceTCP
+		if e.Transport == "quic" {
+			name = ServiceUDP
+		}
+		if !slices.ContainsFunc(res, func(t serviceType) bool { return t.name == name }) {
+			res = append(res, serviceType{name: name, port: uint16(e.Port)})
+		}
+	}
+	return res
+}
+
+// txtRecords describe the node to tooling and carry what its handshake does
+func txtRecords(greet domain.Handshake) []string {
+	txt := []string{
+		"txtvers=1",
+		"id=" + strconv.FormatInt(int64(greet.MetaData.ID), 10),
+		"name=" + greet.MetaData.Name,
+		"arch=" + greet.MetaData.Arch,
+		"version=" + greet.Version,
+		"port=" + strconv.FormatUint(uint64(greet.Port), 10),
+	}
+	if len(greet.Endpoints) > 0 {
+		endpoints := make([]string, 0, len(greet.Endpoints))
+		for _, e := range greet.Endpoints {
+			endpoints = append(endpoints, e.String())
+		}
+		txt = append(txt, "transport="+strings.Join(endpoints, ","))
+	}
+
+	for i, s := range txt {
+		if len(s) > maxTXT {
+			txt[i] = s[:maxTXT]
+		}
+	}
+	return txt
+}
+
+// parseTXT reads a handshake from the TXT records of a peer, port of its SRV record is used without a port key
+func parseTXT(txt []string, port uint16) (domain.Handshake, bool) {
+	kv := make(map[string]string, len(txt))
+	for _, s := range txt {
+		k, v, _ := strings.Cut(s, "=")
+		// a repeated key is ignored, RFC 6763 section 6.4
+		if _, ok := kv[strings.ToLower(k)]; !ok {
+			kv[strings.ToLower(k)] = v
+		}
+	}
+
+	id, err := strconv.ParseInt(kv["id"], 10, 64)
+	if err != nil {
+		return domain.Handshake{}, false
+	}
+
+	greet := domain.Handshake{
+		Version: kv["version"],
+		MetaData: domain.Device{
+			ID:   domain.NodeID(id),
+			Name: kv["name"],
+			Arch: kv["arch"],
+		},
+		Port: uint32(port),
+	}
+	if p, err := strconv.ParseUint(kv["port"], 10, 16); err == nil {
+		greet.Port = uint32(p)
+	}
+
+	for _, e := range strings.Split(kv["transport"], ",") {
+		name, p, ok := strings.Cut(e, ":")
+		if !ok {
+			continue
+		}
+		if p, err := strconv.ParseUint(p, 10, 16); err == nil {
+			greet.Endpoints = append(greet.Endpoints, domain.Endpoint{Transport: name, Port: uint32(p)})
+		}
+	}
+
+	return greet, true
+}
+
+func isInstance(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, "."+ServiceTCP) || strings.HasSuffix(name, "."+ServiceUDP)
+}
+
+// answers are the records q asks for, with TTLs scaled by scale
+func (s service) answers(q dnsmessage.Question, addrs []netip.Addr, scale uint32) []dnsmessage.Resource {
+	name := strings.ToLower(q.Name.String())
+	if q.Class&^unicastResponse != dnsmessage.ClassINET && q.Class&^unicastResponse != dnsmessage.ClassANY {
+		return nil
+	}
+
+	var res []dnsmessage.Resource
+	for _, rr := range s.records(addrs, scale) {
+		if !strings.EqualFold(rr.Header.Name.String(), name) {
+			continue
+		}
+		if q.Type == dnsmessage.TypeALL || q.Type == rr.Header.Type {
+			res = append(res, rr)
+		}
+	}
+
+	if name == serviceEnum && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+		for _, t := range s.types {
+			res = append(res, dnsmessage.Resource{
+				Header: header(serviceEnum, dnsmessage.TypePTR, 0, serviceTTL*scale),
+				Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(t.name)},
+			})
+		}
+	}
+
+	return res
+}
+
+// records are every record of the service, the host ones with addrs
+func (s service) records(addrs []netip.Addr, scale uint32) []dnsmessage.Resource {
+	var res []dnsmessage.Resource
+	for _, t := range s.types {
+		instance := s.instance + "." + t.name
+		res = append(res,
+			dnsmessage.Resource{
+				Header: header(t.name, dnsmessage.TypePTR, 0, serviceTTL*scale),
+				Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(instance)},
+			},
+			dnsmessage.Resource{
+				Header: header(instance, dnsmessage.TypeSRV, cacheFlush, hostTTL*scale),
+				Body:   &dnsmessage.SRVResource{Port: t.port, Target: s.host},
+			},
+			dnsmessage.Resource{
+				Header: header(instance, dnsmessage.TypeTXT, cacheFlush, serviceTTL*scale),
+				Body:   &dnsmessage.TXTResource{TXT: s.txt},
+			},
+		)
+	}
+
+	for _, addr := range addrs {
+		if addr.Is4() {
+			res = append(res, dnsmessage.Resource{
+				Header: header(s.host.String(), dnsmessage.TypeA, cacheFlush, hostTTL*scale),
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		} else {
+			res = append(res, dnsmessage.Resource{
+				Header: header(s.host.String(), dnsmessage.TypeAAAA, cacheFlush, hostTTL*scale),
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+
+	return res
+}
+
+func header(name string, typ dnsmessage.Type, flags dnsmessage.Class, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  typ,
+		Class: dnsmessage.ClassINET | flags,
+		TTL:   ttl,
+	}
+}
+
+// known is true when the querier already holds rr for at least half its TTL, RFC 6762 section 7.1
+func known(answers []dnsmessage.Resource, rr dnsmessage.Resource) bool {
+	ptr, ok := rr.Body.(*dnsmessage.PTRResource)
+	if !ok {
+		return false
+	}
+
+	for _, a := range answers {
+		known, ok := a.Body.(*dnsmessage.PTRResource)
+		if ok && strings.EqualFold(a.Header.Name.String(), rr.Header.Name.String()) &&
+			strings.EqualFold(known.PTR.String(), ptr.PTR.String()) && a.Header.TTL >= rr.Header.TTL/2 {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(records []dnsmessage.Resource, rr dnsmessage.Resource) bool {
+	for _, r := range records {
+		if r.Header == rr.Header && r.Body.GoString() == rr.Body.GoString() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package discovering
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testGreet(id domain.NodeID) domain.Handshake {
+	return domain.Handshake{
+		Version:  "1.0.0",
+		MetaData: domain.Device{ID: id, Name: "desk.top", Arch: "amd64"},
+		Port:     7777,
+		Endpoints: []domain.Endpoint{
+			{Transport: "quic", Port: 7777},
+			{Transport: "tcp", Port: 7777},
+			{Transport: "ws", Port: 7778},
+		},
+	}
+}
+
+func TestTXTRoundTrip(t *testing.T) {
+	greet := testGreet(42)
+
+	got, ok := parseTXT(txtRecords(greet), 0)
+	if !ok {
+		t.Fatal("parseTXT rejected its own records")
+	}
+	if diff := cmp.Diff(greet, got); diff != "" {
+		t.Fatalf("handshake mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	tests := []struct {
+		name string
+		txt  []string
+		port uint16
+		want domain.Handshake
+		ok   bool
+	}{
+		{
+			name: "port of the srv record without a port key",
+			txt:  []string{"id=7", "name=a"},
+			port: 9000,
+			want: domain.Handshake{MetaData: domain.Device{ID: 7, Name: "a"}, Port: 9000},
+			ok:   true,
+		},
+		{
+			name: "first of a repeated key wins",
+			txt:  []string{"ID=7", "id=8"},
+			want: domain.Handshake{MetaData: domain.Device{ID: 7}},
+			ok:   true,
+		},
+		{
+			name: "malformed transports are skipped",
+			txt:  []string{"id=7", "transport=quic:1,tcp,ws:x,unix:2"},
+			want: domain.Handshake{
+				MetaData:  domain.Device{ID: 7},
+				Endpoints: []domain.Endpoint{{Transport: "quic", Port: 1}, {Transport: "unix", Port: 2}},
+			},
+			ok: true,
+		},
+		{name: "no id", txt: []string{"name=a"}},
+		{name: "bad id", txt: []string{"id=x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTXT(tt.txt, tt.port)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if diff := cmp.Diff(tt.want, got); ok && diff != "" {
+				t.Fatalf("handshake mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	long := string(make([]byte, 100))
+	if got := instanceLabel(long, "123"); len(got) > maxLabel {
+		t.Fatalf("label of %d bytes, limit %d", len(got), maxLabel)
+	}
+	if got := instanceLabel("my.host", "1"); got != "my-host-1" {
+		t.Fatalf("instanceLabel = %q, want my-host-1", got)
+	}
+	if got := instanceLabel("", "1"); got != "belphegor-1" {
+		t.Fatalf("instanceLabel = %q, want belphegor-1", got)
+	}
+}
+
+func TestServiceAnswers(t *testing.T) {
+	s := newService(testGreet(42))
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+
+	question := func(name string, typ dnsmessage.Type) dnsmessage.Question {
+		return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}
+	}
+
+	tests := []struct {
+		name  string
+		q     dnsmessage.Question
+		types []dnsmessage.Type
+	}{
+		{"udp service", question(ServiceUDP, dnsmessage.TypePTR), []dnsmessage.Type{dnsmessage.TypePTR}},
+		{"tcp service", question(ServiceTCP, dnsmessage.TypePTR), []dnsmessage.Type{dnsmessage.TypePTR}},
+		{"service enumeration", question(serviceEnum, dnsmessage.TypePTR), []dnsmessage.Type{dnsmessage.TypePTR, dnsmessage.TypePTR}},
+		{
+			"instance, any type",
+			question(s.instance+"."+ServiceUDP, dnsmessage.TypeALL),
+			[]dnsmessage.Type{dnsmessage.TypeSRV, dnsmessage.TypeTXT},
+		},
+		{"host", question(s.host.String(), dnsmessage.TypeA), []dnsmessage.Type{dnsmessage.TypeA}},
+		{"host without v6", question(s.host.String(), dnsmessage.TypeAAAA), nil},
+		{"other service", question("_http._tcp.local.", dnsmessage.TypePTR), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []dnsmessage.Type
+			for _, rr := range s.answers(tt.q, addrs, 1) {
+				got = append(got, rr.Header.Type)
+			}
+			if diff := cmp.Diff(tt.types, got); diff != "" {
+				t.Fatalf("answer types mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKnownAnswer(t *testing.T) {
+	s := newService(testGreet(42))
+	ptr := s.records(nil, 1)[0]
+
+	fresh := ptr
+	if !known([]dnsmessage.Resource{fresh}, ptr) {
+		t.Fatal("a fresh known answer must suppress the record")
+	}
+
+	stale := ptr
+	stale.Header.TTL = ptr.Header.TTL/2 - 1
+	if known([]dnsmessage.Resource{stale}, ptr) {
+		t.Fatal("a known answer past half its TTL must not suppress the record")
+	}
+}
+
+func TestResponderPeers(t *testing.T) {
+	self := testGreet(1)
+	other := testGreet(2)
+
+	r := &responder{
+		self:     newService(self),
+		maxPeers: 10,
+		seen:     make(map[domain.NodeID]time.Time),
+	}
+	announce := func(greet domain.Handshake, scale uint32) dnsmessage.Message {
+		return dnsmessage.Message{
+			Header:  dnsmessage.Header{Response: true},
+			Answers: newService(greet).records(nil, scale),
+		}
+	}
+
+	if got := r.peers(announce(self, 1)); len(got) != 0 {
+		t.Fatalf("own announcement found %d peers", len(got))
+	}
+
+	got := r.peers(announce(other, 1))
+	if len(got) != 1 || got[0].MetaData.ID != other.MetaData.ID {
+		t.Fatalf("found %v, want peer %d once", got, other.MetaData.ID)
+	}
+
+	if got := r.peers(announce(other, 1)); len(got) != 0 {
+		t.Fatal("a peer found again right away must not be passed on")
+	}
+
+	r.peers(announce(other, 0))
+	if _, ok := r.seen[other.MetaData.ID]; ok {
+		t.Fatal("goodbye must forget the peer")
+	}
+	if got := r.peers(announce(other, 1)); len(got) != 1 {
+		t.Fatal("a peer that said goodbye must be found again")
+	}
+}
+
+func TestBackendSet(t *testing.T) {
+	var b Backend
+	if err := b.Set("mdns"); err != nil || b != BackendMDNS {
+		t.Fatalf("Set(mdns) = %v, backend %q", err, b)
+	}
+	if err := b.Set("carrier-pigeon"); err == nil {
+		t.Fatal("Set accepted an unknown backend")
+	}
+	if b != BackendMDNS {
+		t.Fatalf("failed Set changed the backend to %q", b)
+	}
+}
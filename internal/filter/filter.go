@@ -0,0 +1,127 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
+)
+
+// maxScan bounds the text the detectors look at, secrets are short
+// and a huge paste should not stall the clipboard loop
+const maxScan = 1 << 20
+
+// Detector recognises a secret in copied text, Name is logged when it matches
+type Detector struct {
+	Name  string
+	Match func(text []byte) bool
+}
+
+// Pattern matches text containing expr
+func Pattern(name, expr string) (Detector, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Detector{}, fmt.Errorf("filter.Pattern: %w", err)
+	}
+
+	return Detector{Name: name, Match: re.Match}, nil
+}
+
+func mustPattern(name, expr string) Detector {
+	d, err := Pattern(name, expr)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+var builtin = []Detector{
+	mustPattern("private_key", `-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`),
+	mustPattern("jwt", `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,}`),
+	mustPattern("aws_access_key", `\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	mustPattern("github_token", `\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`),
+	mustPattern("slack_token", `\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+	mustPattern("google_api_key", `\bAIza[0-9A-Za-z_-]{35}\b`),
+	mustPattern("stripe_key", `\b[rs]k_live_[0-9A-Za-z]{20,}`),
+}
+
+// Builtin detects private keys, JWTs and API keys of well-known services
+func Builtin() []Detector {
+	return slices.Clone(builtin)
+}
+
+// Entropy matches a single word of at least minLen characters that looks random,
+// i.e. its Shannon entropy is at least bits per character. Generated passwords
+// and tokens are around 4.5 and above, words and identifiers stay well below
+func Entropy(bits float64, minLen int) Detector {
+	return Detector{
+		Name: "entropy",
+		Match: func(text []byte) bool {
+			text = bytes.TrimSpace(text)
+			if len(text) < minLen || bytes.ContainsAny(text, " \t\r\n") {
+				return false
+			}
+
+			return shannon(text) >= bits
+		},
+	}
+}
+
+func shannon(text []byte) float64 {
+	var counts [256]int
+	for _, b := range text {
+		counts[b]++
+	}
+
+	var (
+		n       = float64(len(text))
+		entropy float64
+	)
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// Filter keeps secrets on this device: content the source marked as secret
+// and text one of the detectors recognises
+type Filter struct {
+	detectors []Detector
+}
+
+func New(detectors ...Detector) *Filter {
+	return &Filter{detectors: detectors}
+}
+
+// Block reports whether the update must not be synced and why,
+// the reason never contains the content itself
+func (f *Filter) Block(update eventful.Update) (string, bool) {
+	if update.Sensitive {
+		return "marked as secret by the source", true
+	}
+
+	if !update.MimeType.IsText() {
+		return "", false
+	}
+
+	text := update.Data
+	if len(text) > maxScan {
+		text = text[:maxScan]
+	}
+
+	for _, d := range f.detectors {
+		if d.Match(text) {
+			return "looks like " + d.Name, true
+		}
+	}
+
+	return "", false
+}
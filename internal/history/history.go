@@ -0,0 +1,479 @@
+package history
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/internal/types/proto"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/labi-le/belphegor/pkg/protoutil"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrClosed = errors.New("history is closed")
+
+const (
+	// compactThreshold is the minimum amount of dead records in the log before it is rewritten
+	compactThreshold = 64
+	// recordBuffer is how many records may wait for the disk, the log is rewritten from memory when more pile up
+	recordBuffer = 64
+)
+
+type Entry struct {
+	ID       domain.MessageID
+	From     domain.NodeID
+	MimeType mime.Type
+	Size     uint64
+	Hash     uint64
+	Created  time.Time
+	Name     string
+	// Data raw content for text and images, path for files
+	Data []byte
+}
+
+func EntryFromEvent(ev domain.EventMessage) Entry {
+	return Entry{
+		ID:       ev.Payload.ID,
+		From:     ev.From,
+		MimeType: ev.Payload.MimeType,
+		Size:     ev.Payload.ContentLength,
+		Hash:     ev.Payload.ContentHash,
+		Created:  ev.Created,
+		Name:     ev.Payload.Name,
+		Data:     ev.Payload.Data,
+	}
+}
+
+// weight counts against MaxBytes, a file by its size as well since Data is only its path
+func (e Entry) weight() uint64 {
+	w := uint64(len(e.Data) + len(e.Name))
+	if e.MimeType.IsPath() {
+		w += e.Size
+	}
+	return w
+}
+
+func (e Entry) MarshalZerologObject(ev *zerolog.Event) {
+	ev.Object("id", e.ID)
+	ev.Int64("from", e.From.Int64())
+	ev.Stringer("mime", e.MimeType)
+	ev.Str("size", humanize.Bytes(e.Size))
+	ev.Uint64("hash", e.Hash)
+	ev.Time("created", e.Created)
+}
+
+type Retention struct {
+	MaxEntries int
+	MaxAge     time.Duration
+	MaxBytes   uint64
+}
+
+func (r Retention) MarshalZerologObject(e *zerolog.Event) {
+	e.Int("max_entries", r.MaxEntries)
+	e.Str("max_age", r.MaxAge.String())
+	e.Str("max_bytes", humanize.Bytes(r.MaxBytes))
+}
+
+// Store is an append-only on-disk clipboard history.
+// Every entry is kept in memory as well, the log is only read on Open
+// and rewritten when enough records were evicted by retention
+type Store struct {
+	mu        sync.RWMutex
+	path      string
+	retention Retention
+	logger    zerolog.Logger
+
+	// entries ordered from oldest to newest
+	entries []Entry
+	bytes   uint64
+	dead    int
+	index   *index
+	// behind is set when a record did not fit in the buffer, the log misses it until it is rewritten
+	behind bool
+	closed bool
+
+	// records are written to the log by a single goroutine, so a slow disk does not hold up Record
+	records chan Entry
+	written chan struct{}
+
+	// fileMu guards the log, the entries are not locked while it is written
+	fileMu sync.Mutex
+	file   *os.File
+}
+
+func Open(path string, retention Retention, logger zerolog.Logger) (*Store, error) {
+	s := &Store{
+		path:      path,
+		retention: retention,
+		logger:    logger.With().Str("component", "history").Logger(),
+		index:     newIndex(),
+		records:   make(chan Entry, recordBuffer),
+		written:   make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("history mkdir: %w", err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.prune(time.Now())
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	go s.write()
+
+	s.logger.Trace().
+		Str("path", path).
+		Int("entries", len(s.entries)).
+		Object("retention", retention).
+		Msg("opened")
+
+	return s, nil
+}
+
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("history open: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for {
+		var pb proto.HistoryEntry
+		if decodeErr := protoutil.DecodeReader(r, &pb); decodeErr != nil {
+			if !errors.Is(decodeErr, io.EOF) {
+				// a crash in the middle of a write leaves a torn tail, everything before it is intact
+				s.logger.Warn().Err(decodeErr).Msg("history log is truncated, dropping the tail")
+			}
+			return nil
+		}
+
+		s.insert(fromProto(&pb))
+	}
+}
+
+// Record satisfies channel.Recorder. The entry is listed at once and written to the log in background
+func (s *Store) Record(ev domain.EventMessage) {
+	entry := EntryFromEvent(ev)
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.insert(entry)
+	s.prune(time.Now())
+
+	select {
+	case s.records <- entry:
+	default:
+		s.behind = true
+	}
+}
+
+// Add records the entry and writes it to the log before returning
+func (s *Store) Add(entry Entry) error {
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.insert(entry)
+	s.prune(time.Now())
+	s.mu.Unlock()
+
+	return s.persist(entry)
+}
+
+// write appends the records to the log until the store is closed
+func (s *Store) write() {
+	defer close(s.written)
+
+	for entry := range s.records {
+		if err := s.persist(entry); err != nil {
+			s.logger.Warn().Err(err).Object("entry", entry).Msg("failed to record")
+		}
+	}
+}
+
+// persist appends the entry to the log, the log is rewritten instead when it is due
+func (s *Store) persist(entry Entry) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	s.mu.RLock()
+	due := s.behind || (s.dead >= compactThreshold && s.dead > len(s.entries))
+	s.mu.RUnlock()
+	if due {
+		return s.compactLocked()
+	}
+
+	if err := protoutil.EncodeToWriter(s.file, toProto(entry)); err != nil {
+		return fmt.Errorf("history write: %w", err)
+	}
+
+	return nil
+}
+
+// insert appends the entry, an older copy of the same content is dropped so the entry moves to the top
+func (s *Store) insert(entry Entry) {
+	if i := slices.IndexFunc(s.entries, func(e Entry) bool {
+		return e.ID == entry.ID || (e.Hash != 0 && e.Hash == entry.Hash && e.MimeType == entry.MimeType)
+	}); i >= 0 {
+		s.remove(i)
+	}
+
+	s.entries = append(s.entries, entry)
+	s.bytes += entry.weight()
+	s.index.add(entry)
+}
+
+func (s *Store) remove(i int) {
+	old := s.entries[i]
+	s.entries = slices.Delete(s.entries, i, i+1)
+	s.bytes -= old.weight()
+	s.index.remove(old)
+	s.dead++
+}
+
+func (s *Store) prune(now time.Time) {
+	r := s.retention
+	for len(s.entries) > 0 {
+		oldest := s.entries[0]
+
+		switch {
+		case r.MaxEntries > 0 && len(s.entries) > r.MaxEntries,
+			r.MaxBytes > 0 && s.bytes > r.MaxBytes,
+			r.MaxAge > 0 && now.Sub(oldest.Created) > r.MaxAge:
+			s.remove(0)
+		default:
+			return
+		}
+	}
+}
+
+func (s *Store) compact() error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the log with live entries only and reopens it for appending, fileMu is held
+func (s *Store) compactLocked() error {
+	s.mu.Lock()
+	entries := slices.Clone(s.entries)
+	dead, behind := s.dead, s.behind
+	s.dead, s.behind = 0, false
+	s.mu.Unlock()
+
+	if err := s.rewrite(entries); err != nil {
+		// the next write tries again
+		s.mu.Lock()
+		s.dead += dead
+		s.behind = s.behind || behind
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) rewrite(entries []Entry) error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("history compact: %w", err)
+	}
+
+	w := bufio.NewWriter(file)
+	for _, entry := range entries {
+		if err := protoutil.EncodeToWriter(w, toProto(entry)); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("history compact write: %w", err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("history compact flush: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("history compact close: %w", err)
+	}
+
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("history compact rename: %w", err)
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("history reopen: %w", err)
+	}
+
+	return nil
+}
+
+// List returns up to limit entries starting from the newest, limit <= 0 means everything
+func (s *Store) List(limit int) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.collect(limit, func(Entry) bool { return true })
+}
+
+// Search matches every word of the query against words of text entries and file names,
+// the last word of the query may be incomplete
+func (s *Store) Search(query string, limit int) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids, ok := s.index.search(query)
+	if !ok {
+		return nil
+	}
+
+	return s.collect(limit, func(e Entry) bool {
+		_, found := ids[e.ID]
+		return found
+	})
+}
+
+func (s *Store) Get(id domain.MessageID) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if s.entries[i].ID == id {
+			return s.entries[i], true
+		}
+	}
+
+	return Entry{}, false
+}
+
+func (s *Store) collect(limit int, match func(Entry) bool) []Entry {
+	var (
+		res    []Entry
+		now    = time.Now()
+		maxAge = s.retention.MaxAge
+	)
+
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+
+		// Created is the time of the sender, a stale entry may lie between fresh ones
+		entry := s.entries[i]
+		if maxAge > 0 && now.Sub(entry.Created) > maxAge {
+			continue
+		}
+
+		if match(entry) {
+			res = append(res, entry)
+		}
+	}
+
+	return res
+}
+
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.entries)
+}
+
+// Close writes the pending records and closes the log
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+
+	<-s.written
+
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	behind := s.behind
+	s.mu.RUnlock()
+
+	var err error
+	if behind {
+		err = s.compactLocked()
+	}
+	err = errors.Join(err, s.file.Close())
+	s.file = nil
+	return err
+}
+
+func toProto(e Entry) *proto.HistoryEntry {
+	return &proto.HistoryEntry{
+		ID:            e.ID.Int64(),
+		From:          e.From.Int64(),
+		MimeType:      int32(e.MimeType),
+		ContentLength: e.Size,
+		ContentHash:   e.Hash,
+		Created:       timestamppb.New(e.Created),
+		Name:          e.Name,
+		Data:          e.Data,
+	}
+}
+
+func fromProto(pb *proto.HistoryEntry) Entry {
+	return Entry{
+		ID:       domain.MessageID(pb.GetID()),
+		From:     domain.NodeID(pb.GetFrom()),
+		MimeType: mime.Type(pb.GetMimeType()),
+		Size:     pb.GetContentLength(),
+		Hash:     pb.GetContentHash(),
+		Created:  pb.GetCreated().AsTime(),
+		Name:     pb.GetName(),
+		Data:     pb.GetData(),
+	}
+}
@@ -0,0 +1,263 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/history"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/rs/zerolog"
+)
+
+func open(t *testing.T, path string, r history.Retention) *history.Store {
+	t.Helper()
+	s, err := history.Open(path, r, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func text(id int, data string) history.Entry {
+	return history.Entry{
+		ID:       domain.MessageID(id),
+		From:     domain.NodeID(7),
+		MimeType: mime.TypeText,
+		Size:     uint64(len(data)),
+		Hash:     uint64(id),
+		Created:  time.Now(),
+		Data:     []byte(data),
+	}
+}
+
+func TestStore_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+	s := open(t, path, history.Retention{})
+
+	for i, data := range []string{"first", "second", "third"} {
+		if err := s.Add(text(i+1, data)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := open(t, path, history.Retention{})
+	got := reopened.List(0)
+	if len(got) != 3 {
+		t.Fatalf("List len = %d, want 3", len(got))
+	}
+	if string(got[0].Data) != "third" || got[0].From != 7 {
+		t.Fatalf("newest entry = %q from %d, want third from 7", got[0].Data, got[0].From)
+	}
+}
+
+func TestStore_RetentionByCount(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{MaxEntries: 2})
+
+	for i := range 5 {
+		_ = s.Add(text(i+1, "entry"))
+	}
+
+	if s.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", s.Len())
+	}
+	if _, ok := s.Get(1); ok {
+		t.Fatal("oldest entry must be evicted")
+	}
+}
+
+func TestStore_RetentionByAge(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{MaxAge: time.Hour})
+
+	old := text(1, "stale")
+	old.Created = time.Now().Add(-2 * time.Hour)
+	_ = s.Add(old)
+	_ = s.Add(text(2, "fresh"))
+
+	got := s.List(0)
+	if len(got) != 1 || got[0].ID != 2 {
+		t.Fatalf("List = %v, want only the fresh entry", got)
+	}
+}
+
+func TestStore_RetentionByAge_Unordered(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{MaxAge: time.Hour})
+
+	// a peer with a clock behind ours sends an entry that is stale on arrival
+	_ = s.Add(text(1, "fresh"))
+	stale := text(2, "stale")
+	stale.Created = time.Now().Add(-2 * time.Hour)
+	_ = s.Add(stale)
+
+	got := s.List(0)
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("List = %v, want the fresh entry behind the stale one", got)
+	}
+}
+
+func TestStore_RetentionByBytes(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{MaxBytes: 10})
+
+	_ = s.Add(text(1, "12345"))
+	_ = s.Add(text(2, "67890"))
+	_ = s.Add(text(3, "abcde"))
+
+	if s.Len() != 2 {
+		t.Fatalf("Len = %d, want 2 (total bytes capped)", s.Len())
+	}
+}
+
+func TestStore_RetentionByFileSize(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{MaxBytes: 1 << 20})
+
+	for id := range 3 {
+		file := text(id+1, "/tmp/a.iso")
+		file.MimeType = mime.TypePath
+		file.Name = "a.iso"
+		file.Size = 1 << 19
+		_ = s.Add(file)
+	}
+
+	if s.Len() != 1 {
+		t.Fatalf("Len = %d, want 1 (file sizes capped)", s.Len())
+	}
+}
+
+func TestStore_DuplicateMovesToTop(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{})
+
+	a := text(1, "same")
+	_ = s.Add(a)
+	_ = s.Add(text(2, "other"))
+
+	again := text(3, "same")
+	again.Hash = a.Hash
+	_ = s.Add(again)
+
+	got := s.List(0)
+	if len(got) != 2 || got[0].ID != 3 {
+		t.Fatalf("List = %v, want re-copied entry on top without duplicate", got)
+	}
+}
+
+func TestStore_Search(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{})
+
+	_ = s.Add(text(1, "git push origin main"))
+	_ = s.Add(text(2, "kubectl get pods -n staging"))
+	_ = s.Add(history.Entry{
+		ID:       3,
+		MimeType: mime.TypePath,
+		Hash:     3,
+		Name:     "staging-report.pdf",
+		Data:     []byte("/tmp/staging-report.pdf"),
+	})
+	_ = s.Add(history.Entry{ID: 4, MimeType: mime.TypeImage, Hash: 4, Data: []byte("staging")})
+
+	tests := []struct {
+		query string
+		want  []domain.MessageID
+	}{
+		{"push", []domain.MessageID{1}},
+		{"GIT Main", []domain.MessageID{1}},
+		{"stag", []domain.MessageID{3, 2}},
+		{"pods stag", []domain.MessageID{2}},
+		{"missing", nil},
+		{"  ", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := s.Search(tt.query, 0)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Search(%q) returned %d entries, want %d", tt.query, len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i] {
+					t.Fatalf("Search(%q)[%d] = %d, want %d", tt.query, i, got[i].ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStore_TruncatedTailIsDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+	s := open(t, path, history.Retention{})
+	_ = s.Add(text(1, "intact"))
+	_ = s.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	_ = f.Close()
+
+	reopened := open(t, path, history.Retention{})
+	if reopened.Len() != 1 {
+		t.Fatalf("Len = %d, want 1 intact entry", reopened.Len())
+	}
+	if err := reopened.Add(text(2, "after")); err != nil {
+		t.Fatalf("Add after recovery: %v", err)
+	}
+}
+
+func TestStore_Record(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.log"), history.Retention{})
+
+	s.Record(domain.EventMessage{
+		From:    5,
+		Created: time.Now(),
+		Payload: domain.Message{
+			ID:            9,
+			Data:          []byte("hello"),
+			MimeType:      mime.TypeText,
+			ContentHash:   99,
+			ContentLength: 5,
+		},
+	})
+
+	got, ok := s.Get(9)
+	if !ok {
+		t.Fatal("recorded message not found")
+	}
+	if got.From != 5 || got.Size != 5 || got.Hash != 99 {
+		t.Fatalf("entry = %+v, want origin, size and hash preserved", got)
+	}
+}
+
+func TestStore_RecordPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+	s := open(t, path, history.Retention{})
+
+	// more records than wait for the disk, the log catches up from memory
+	const n = 500
+	for i := range n {
+		s.Record(domain.EventMessage{
+			Created: time.Now(),
+			Payload: domain.Message{
+				ID:          domain.MessageID(i + 1),
+				Data:        []byte("entry"),
+				MimeType:    mime.TypeText,
+				ContentHash: uint64(i + 1),
+			},
+		})
+	}
+	if s.Len() != n {
+		t.Fatalf("Len = %d before the log is written, want %d", s.Len(), n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if reopened := open(t, path, history.Retention{}); reopened.Len() != n {
+		t.Fatalf("Len = %d after reopen, want %d", reopened.Len(), n)
+	}
+}
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+type idSet = map[domain.MessageID]struct{}
+
+// index is an inverted word index over text entries and file names
+type index struct {
+	words map[string]idSet
+}
+
+func newIndex() *index {
+	return &index{words: make(map[string]idSet)}
+}
+
+func (x *index) add(e Entry) {
+	for _, word := range entryWords(e) {
+		ids, ok := x.words[word]
+		if !ok {
+			ids = make(idSet, 1)
+			x.words[word] = ids
+		}
+		ids[e.ID] = struct{}{}
+	}
+}
+
+func (x *index) remove(e Entry) {
+	for _, word := range entryWords(e) {
+		ids, ok := x.words[word]
+		if !ok {
+			continue
+		}
+
+		delete(ids, e.ID)
+		if len(ids) == 0 {
+			delete(x.words, word)
+		}
+	}
+}
+
+// search returns ids containing every query word, the last one is matched as a prefix.
+// false means the query has no words at all
+func (x *index) search(query string) (idSet, bool) {
+	terms := words(query)
+	if len(terms) == 0 {
+		return nil, false
+	}
+
+	var res idSet
+	for i, term := range terms {
+		found := make(idSet)
+		if i == len(terms)-1 {
+			for word, ids := range x.words {
+				if strings.HasPrefix(word, term) {
+					merge(found, ids)
+				}
+			}
+		} else {
+			merge(found, x.words[term])
+		}
+
+		if res == nil {
+			res = found
+		} else {
+			for id := range res {
+				if _, ok := found[id]; !ok {
+					delete(res, id)
+				}
+			}
+		}
+
+		if len(res) == 0 {
+			break
+		}
+	}
+
+	return res, true
+}
+
+func merge(dst, src idSet) {
+	for id := range src {
+		dst[id] = struct{}{}
+	}
+}
+
+func entryWords(e Entry) []string {
+	var text string
+	switch {
+	case e.MimeType.IsText():
+		text = string(e.Data)
+	case e.Name != "":
+		text = e.Name
+	default:
+		return nil
+	}
+
+	return uniq(words(text))
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func uniq(list []string) []string {
+	seen := make(map[string]struct{}, len(list))
+	res := list[:0]
+	for _, w := range list {
+		if _, ok := seen[w]; ok {
+			continue
+		}
+		seen[w] = struct{}{}
+		res = append(res, w)
+	}
+	return res
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"github.com/labi-le/belphegor/internal/control"
+	"github.com/labi-le/belphegor/internal/peer"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+var _ control.Controller = (*Node)(nil)
+
+func (n *Node) Peers() []control.Peer {
+	var res []control.Peer
+	n.peers.Tap(func(id domain.NodeID, p *peer.Peer) bool {
+		res = append(res, control.Peer{
+			ID:     id,
+			Name:   p.MetaData().Name,
+			Arch:   p.MetaData().Arch,
+			Addr:   p.Conn().RemoteAddr().String(),
+			Policy: p.Policy().String(),
+		})
+		return true
+	})
+
+	return res
+}
+
+func (n *Node) StaticPeers() []control.StaticPeer {
+	var res []control.StaticPeer
+	for _, st := range n.static.Status() {
+		sp := control.StaticPeer{
+			Addr:     st.Addr,
+			State:    string(st.State),
+			Attempts: st.Attempts,
+			Retry:    st.Retry,
+		}
+		if st.LastErr != nil {
+			sp.Error = st.LastErr.Error()
+		}
+		res = append(res, sp)
+	}
+
+	return res
+}
+
+func (n *Node) Routes() []control.Route {
+	var res []control.Route
+	for _, r := range n.routes.List(time.Now()) {
+		res = append(res, control.Route{
+			Origin: r.Origin,
+			Via:    r.Via,
+			Hops:   r.Hops,
+			Seen:   r.Seen,
+		})
+	}
+
+	return res
+}
+
+func (n *Node) LastMessage() (control.Message, bool) {
+	last := n.channel.LastMsg()
+	// relayed for other devices, we cannot read it
+	if last.Payload.Zero() || last.Payload.Sealed {
+		return control.Message{}, false
+	}
+
+	return control.MessageFromEvent(last), true
+}
+
+func (n *Node) Disconnect(id domain.NodeID) error {
+	p, ok := n.peers.Get(id)
+	if !ok {
+		return control.ErrPeerNotFound
+	}
+
+	return p.Close()
+}
+
+func (n *Node) Push(t mime.Type, data []byte, ttl time.Duration) error {
+	var updates []eventful.Update
+
+	switch {
+	case t.IsPath():
+		path, err := filepath.Abs(string(data))
+		if err != nil {
+			return fmt.Errorf("node.Push: %w", err)
+		}
+
+		info, err := eventful.Stat(path)
+		if err != nil {
+			return fmt.Errorf("node.Push: %w", err)
+		}
+
+		data = []byte(path)
+		updates, _ = eventful.UpdatesFromFileInfo([]eventful.FileInfo{info})
+	case t.IsText(), t.IsImage():
+		if len(data) == 0 {
+			return fmt.Errorf("node.Push: empty payload")
+		}
+
+		updates = []eventful.Update{{
+			Data:     data,
+			Size:     uint64(len(data)),
+			MimeType: t,
+			Hash:     xxhash.Sum64(data),
+		}}
+	default:
+		return control.ErrUnsupportedMime
+	}
+
+	if _, err := n.clipboard.Write(t, data); err != nil {
+		return fmt.Errorf("node.Push: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = n.live().TTL
+	}
+
+	ctxLog := ctxlog.Op(n.opts.Logger, "node.Push")
+	for _, update := range updates {
+		n.held.Store(update.Hash)
+
+		// pushed content is checked the same way as copied content
+		if n.keptLocal(ctxLog, update) {
+			continue
+		}
+
+		msg := messageFromUpdate(update)
+		msg.TTL = ttl
+		n.channel.Send(msg.Event())
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/channel"
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/rs/zerolog"
+)
+
+type memClipboard struct {
+	data []byte
+}
+
+func (c *memClipboard) Watch(ctx context.Context, _ chan<- eventful.Update) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (c *memClipboard) Write(_ mime.Type, src []byte) (int, error) {
+	c.data = src
+	return len(src), nil
+}
+
+func TestPush_Sensitive(t *testing.T) {
+	filter, err := SensitiveOptions{Enable: true}.Filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clip := &memClipboard{}
+	ch := channel.New(1)
+	n := New(&mockTransport{}, clip, &Storage{}, ch, Options{
+		Logger: zerolog.Nop(),
+		Filter: filter,
+	})
+
+	secret := []byte("ghp_" + strings.Repeat("a1B2", 9))
+	if err := n.Push(mime.TypeText, secret, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// it is put into the local clipboard, but never reaches the channel peers are sent from
+	if string(clip.data) != string(secret) {
+		t.Fatal("secret was not written to the local clipboard")
+	}
+	select {
+	case msg := <-ch.Messages():
+		t.Fatalf("secret was synced: %+v", msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !ch.LastMsg().Payload.Zero() {
+		t.Fatal("secret became the last message served to peers")
+	}
+
+	go func() { _ = n.Push(mime.TypeText, []byte("hello world"), 0) }()
+	select {
+	case msg := <-ch.Messages():
+		if string(msg.Payload.Data) != "hello world" {
+			t.Fatalf("synced %q", msg.Payload.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("plain text was not synced")
+	}
+}
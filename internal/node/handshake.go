@@ -8,6 +8,7 @@ import (
 
 	"github.com/labi-le/belphegor/internal/metadata"
 	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/security"
 	"github.com/labi-le/belphegor/internal/transport"
 	"github.com/labi-le/belphegor/internal/types/domain"
 	"github.com/labi-le/belphegor/pkg/ctxlog"
@@ -16,6 +17,8 @@ import (
 
 var (
 	ErrVersionMismatch = errors.New("nodes have major differences, handshake impossible")
+	ErrNodeIDCollision = errors.New("peer uses our node id")
+	ErrNodeIDMismatch  = errors.New("peer node id does not match its key")
 )
 
 type handshake struct {
@@ -23,11 +26,12 @@ type handshake struct {
 	logger zerolog.Logger
 }
 
-func newHandshake(meta domain.Device, port int, logger zerolog.Logger) *handshake {
+func newHandshake(meta domain.Device, port int, endpoints []domain.Endpoint, logger zerolog.Logger) *handshake {
 	return &handshake{
 		my: domain.NewGreet(
 			domain.WithMetadata(meta),
 			domain.WithPort(uint16(port)),
+			domain.WithEndpoints(endpoints),
 		),
 		logger: logger,
 	}
@@ -64,5 +68,16 @@ func (h *handshake) exchange(ctx context.Context, conn transport.Connection, inc
 		return empty, ErrVersionMismatch
 	}
 
+	if from.Payload.MetaData.ID == h.my.Payload.MetaData.ID {
+		return empty, fmt.Errorf("%w %d: %s", ErrNodeIDCollision, from.Payload.MetaData.ID, from.Payload.MetaData.String())
+	}
+
+	// the id is derived from the device key, so that a device cannot pass for another one
+	if cert := conn.PeerCertificate(); cert != nil {
+		if want := domain.NodeID(security.FingerprintOf(cert).DeviceID()); from.Payload.MetaData.ID != want {
+			return empty, fmt.Errorf("%w: %s says %d, its key gives %d", ErrNodeIDMismatch, from.Payload.MetaData.String(), from.Payload.MetaData.ID, want)
+		}
+	}
+
 	return from, nil
 }
@@ -0,0 +1,211 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+// fetchTimeout bounds how long a paste waits for the files offered before they were fetched
+const fetchTimeout = 5 * time.Minute
+
+var (
+	ErrOriginGone = errors.New("peer that offered the file is gone")
+	ErrSealed     = errors.New("file is sealed for other devices")
+)
+
+// Pending are the files put into the clipboard before they are fetched, a paste fetches them
+type Pending struct {
+	mu    sync.Mutex
+	files map[domain.MessageID]*pendingFile
+}
+
+type pendingFile struct {
+	ann domain.EventAnnounce
+	// arrived is closed once the file is received, sealed is set before
+	arrived chan struct{}
+	sealed  bool
+}
+
+func newPending() *Pending {
+	return &Pending{files: make(map[domain.MessageID]*pendingFile)}
+}
+
+// Add registers an announced file, it replaces the same file of the same batch announced before
+func (p *Pending) Add(ann domain.EventAnnounce) *pendingFile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, f := range p.files {
+		if f.ann.Payload.BatchID == ann.Payload.BatchID && f.ann.Payload.ContentHash == ann.Payload.ContentHash {
+			delete(p.files, id)
+		}
+	}
+
+	f := &pendingFile{ann: ann, arrived: make(chan struct{})}
+	p.files[ann.Payload.ID] = f
+	return f
+}
+
+// Batch returns the registered files of the batch
+func (p *Pending) Batch(id domain.MessageID) []*pendingFile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []*pendingFile
+	for _, f := range p.files {
+		if f.ann.Payload.BatchID == id {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Keep drops every file but these, the ones the clipboard offers now
+func (p *Pending) Keep(files []*pendingFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := make(map[domain.MessageID]*pendingFile, len(files))
+	for _, f := range files {
+		kept[f.ann.Payload.ID] = f
+	}
+	p.files = kept
+}
+
+// Arrive marks msg received, false when it was not offered before it was fetched
+func (p *Pending) Arrive(msg domain.Message) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, ok := p.files[msg.ID]
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-f.arrived:
+	default:
+		f.sealed = msg.Sealed
+		close(f.arrived)
+	}
+	return true
+}
+
+// offer puts an announced file into the clipboard before it is fetched,
+// false when the clipboard cannot wait for the content and it has to be fetched now
+func (n *Node) offer(ctx context.Context, ann domain.EventAnnounce) bool {
+	lazy, ok := n.clipboard.(eventful.Lazy)
+	if !ok || !n.opts.Clip.LazyFiles || !ann.Payload.MimeType.IsPath() || ann.Payload.Name == "" {
+		return false
+	}
+
+	logger := ctxlog.Op(n.opts.Logger, "node.offer").With().Object("announce", ann.Payload).Logger()
+
+	msg := domain.Message{
+		ID:          ann.Payload.ID,
+		Name:        ann.Payload.Name,
+		ContentHash: ann.Payload.ContentHash,
+		BatchID:     ann.Payload.BatchID,
+		BatchTotal:  ann.Payload.BatchTotal,
+	}
+	path, err := n.opts.Store.Path(msg)
+	if err != nil {
+		return false
+	}
+
+	files := []*pendingFile{n.pending.Add(ann)}
+	data := []byte(path)
+	if msg.BatchID != 0 && msg.BatchTotal > 1 {
+		var ready bool
+		msg.Data = data
+		if data, ready = n.batches.Add(msg); !ready {
+			return true
+		}
+		files = n.pending.Batch(msg.BatchID)
+	}
+
+	if err := lazy.WriteLazy(mime.TypePath, n.fetcher(ctx, data, files)); err != nil {
+		logger.Error().Err(err).Msg("failed to offer files, fetching them now")
+		return false
+	}
+
+	n.pending.Keep(files)
+	n.held.Store(ann.Payload.ContentHash)
+	logger.Trace().Int("files", len(files)).Msg("offered, fetching on paste")
+
+	return true
+}
+
+// fetcher gets the files on the first paste, later pastes get the paths at once
+func (n *Node) fetcher(ctx context.Context, data []byte, files []*pendingFile) eventful.Fetch {
+	var (
+		mu      sync.Mutex
+		fetched bool
+	)
+
+	return func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if fetched {
+			return data, nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		defer cancel()
+
+		errs := make([]error, len(files))
+		var wg sync.WaitGroup
+		for i, f := range files {
+			wg.Go(func() { errs[i] = n.fetch(ctx, f) })
+		}
+		wg.Wait()
+
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+
+		fetched = true
+		return data, nil
+	}
+}
+
+func (n *Node) fetch(ctx context.Context, f *pendingFile) error {
+	select {
+	case <-f.arrived:
+	default:
+		p, ok := n.peers.Get(f.ann.Via)
+		if !ok {
+			return fmt.Errorf("node.fetch: %w", ErrOriginGone)
+		}
+
+		offset := n.opts.Store.Offset(domain.Message{
+			ID:            f.ann.Payload.ID,
+			ContentHash:   f.ann.Payload.ContentHash,
+			ContentLength: f.ann.Payload.ContentLength,
+			BatchID:       f.ann.Payload.BatchID,
+		})
+		if err := p.RequestMessage(ctx, f.ann.Payload.ID, offset); err != nil {
+			return fmt.Errorf("node.fetch: %w", err)
+		}
+
+		select {
+		case <-f.arrived:
+		case <-ctx.Done():
+			return fmt.Errorf("node.fetch: %w", ctx.Err())
+		}
+	}
+
+	if f.sealed {
+		return fmt.Errorf("node.fetch: %w", ErrSealed)
+	}
+	return nil
+}
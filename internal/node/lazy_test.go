@@ -0,0 +1,136 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/store"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/rs/zerolog"
+)
+
+type lazyClipboard struct {
+	eventful.Eventful
+	offered []eventful.Fetch
+}
+
+func (c *lazyClipboard) WriteLazy(t mime.Type, fetch eventful.Fetch) error {
+	if t != mime.TypePath {
+		return errors.New("only files are offered lazily")
+	}
+	c.offered = append(c.offered, fetch)
+	return nil
+}
+
+func lazyNode(t *testing.T) (*Node, *lazyClipboard) {
+	t.Helper()
+
+	fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clip := &lazyClipboard{}
+	n := New(&mockTransport{}, clip, &Storage{}, nil, Options{
+		Logger: zerolog.Nop(),
+		Store:  fs,
+		Clip:   eventful.Options{LazyFiles: true},
+	})
+	return n, clip
+}
+
+func announce(id, batch domain.MessageID, total uint32, name string) domain.EventAnnounce {
+	return domain.EventAnnounce{
+		Via: 42,
+		Payload: domain.Announce{
+			ID:            id,
+			MimeType:      mime.TypePath,
+			ContentHash:   uint64(id) * 10,
+			ContentLength: 100,
+			BatchID:       batch,
+			BatchTotal:    total,
+			Name:          name,
+		},
+	}
+}
+
+func TestOffer_FetchesOnPaste(t *testing.T) {
+	n, clip := lazyNode(t)
+	ann := announce(1, 7, 1, "report.pdf")
+
+	if !n.offer(context.Background(), ann) {
+		t.Fatal("file was not offered")
+	}
+	if len(clip.offered) != 1 {
+		t.Fatalf("clipboard got %d offers, want 1", len(clip.offered))
+	}
+	paste := clip.offered[0]
+
+	// nothing fetched yet and the origin is not connected
+	if _, err := paste(); !errors.Is(err, ErrOriginGone) {
+		t.Fatalf("paste error = %v, want ErrOriginGone", err)
+	}
+
+	if !n.pending.Arrive(domain.Message{ID: 1}) {
+		t.Fatal("offered file not pending")
+	}
+	data, err := paste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(string(data)) != "report.pdf" {
+		t.Fatalf("paste = %q, want the path of report.pdf", data)
+	}
+}
+
+func TestOffer_Batch(t *testing.T) {
+	n, clip := lazyNode(t)
+
+	if !n.offer(context.Background(), announce(1, 9, 2, "a.txt")) {
+		t.Fatal("first file was not offered")
+	}
+	if len(clip.offered) != 0 {
+		t.Fatal("batch offered before it was complete")
+	}
+	if !n.offer(context.Background(), announce(2, 9, 2, "b.txt")) {
+		t.Fatal("second file was not offered")
+	}
+	if len(clip.offered) != 1 {
+		t.Fatalf("clipboard got %d offers, want 1", len(clip.offered))
+	}
+
+	n.pending.Arrive(domain.Message{ID: 1})
+	n.pending.Arrive(domain.Message{ID: 2})
+
+	data, err := clip.offered[0]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := strings.Split(string(data), "\n")
+	if len(paths) != 2 || filepath.Base(paths[0]) != "a.txt" || filepath.Base(paths[1]) != "b.txt" {
+		t.Fatalf("paste = %q, want both files", data)
+	}
+}
+
+func TestOffer_Eager(t *testing.T) {
+	n, _ := lazyNode(t)
+
+	text := announce(1, 0, 0, "")
+	text.Payload.MimeType = mime.TypeText
+	if n.offer(context.Background(), text) {
+		t.Fatal("text was offered lazily")
+	}
+	if n.offer(context.Background(), announce(2, 0, 0, "")) {
+		t.Fatal("file without a name was offered lazily")
+	}
+
+	n.opts.Clip.LazyFiles = false
+	if n.offer(context.Background(), announce(3, 0, 0, "c.txt")) {
+		t.Fatal("file was offered lazily with lazy files off")
+	}
+}
@@ -3,30 +3,45 @@ package node
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/bandwidth"
 	"github.com/labi-le/belphegor/internal/channel"
 	"github.com/labi-le/belphegor/internal/discovering"
 	"github.com/labi-le/belphegor/internal/peer"
 	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/relay"
 	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transfer"
 	"github.com/labi-le/belphegor/internal/transport"
 	"github.com/labi-le/belphegor/internal/types/domain"
 	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
 	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/rs/zerolog"
 )
 
 var (
 	ErrAlreadyConnected = errors.New("already connected")
 	ErrMaxPeersReached  = errors.New("max peers reached")
+	ErrNotPairedByPeer  = errors.New("peer has not paired with us")
 )
 
-var _ discovering.Connector = (*Node)(nil)
+var (
+	_ discovering.Connector = (*Node)(nil)
+	_ relay.Connector       = (*Node)(nil)
+)
 
 type cleanup func()
 
@@ -35,8 +50,21 @@ type Node struct {
 	peers     *Storage
 	channel   *channel.Channel
 	transport transport.Transport
-	opts      Options
 	batches   *channel.BatchCollector
+	static    *Supervisor
+	routes    *Routes
+	pending   *Pending
+	// upload and download limit the traffic with all peers together
+	upload   *bandwidth.Limiter
+	download *bandwidth.Limiter
+
+	// held is the hash of the content last put into the clipboard,
+	// expiry only clears the clipboard while it still holds that content
+	held atomic.Uint64
+
+	// mu guards the options changed by Reload
+	mu   sync.RWMutex
+	opts Options
 }
 
 func (n *Node) Close() error {
@@ -60,44 +88,82 @@ func New(
 	ch *channel.Channel,
 	opts Options,
 ) *Node {
-	return &Node{
+	n := &Node{
 		transport: tr,
 		clipboard: clipboard,
 		peers:     peers,
 		channel:   ch,
 		opts:      opts,
 		batches:   channel.NewBatchCollector(),
+		pending:   newPending(),
+		upload:    bandwidth.NewLimiter(opts.Upload),
+		download:  bandwidth.NewLimiter(opts.Download),
 	}
+	n.static = newSupervisor(n.connect, peers.Exist, opts.Reconnect, opts.Logger)
+	n.routes = newRoutes(peers.Exist)
+
+	if n.opts.Transfers == nil {
+		n.opts.Transfers = transfer.NewManager()
+	}
+	n.opts.Transfers.Subscribe(n.notifyTransfer)
+
+	return n
 }
 
 func (n *Node) ConnectTo(ctx context.Context, addr string) error {
+	return n.connect(ctx, addr, nil)
+}
+
+// ConnectAsync dials addr and serves the connection in background until ctx is done.
+// The returned channel receives the handshake result exactly once
+func (n *Node) ConnectAsync(ctx context.Context, addr string) <-chan error {
+	ready := make(chan error, 1)
+	go func() {
+		if err := n.connect(ctx, addr, func(_ domain.NodeID, err error) { ready <- err }); err != nil {
+			ctxLog := ctxlog.Op(n.opts.Logger, "node.ConnectAsync")
+			ctxLog.Trace().Err(err).Str("addr", addr).Msg("connection finished")
+		}
+	}()
+
+	return ready
+}
+
+func (n *Node) connect(ctx context.Context, addr string, ready readyFunc) error {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.ConnectTo").
 		With().
 		Str("addr", addr).
 		Logger()
 
-	if n.peers.Len() >= n.opts.MaxPeers {
-		ctxLog.Warn().Int("max_peers", n.opts.MaxPeers).Msg("peer limit")
+	if maxPeers := n.live().MaxPeers; n.peers.Len() >= maxPeers {
+		ctxLog.Warn().Int("max_peers", maxPeers).Msg("peer limit")
+		notifyReady(ready, 0, ErrMaxPeersReached)
 		return ErrMaxPeersReached
 	}
 
 	conn, err := n.transport.Dial(ctx, addr)
 	if err != nil {
-		switch {
-		case errors.Is(err, security.ErrLocalSecretMissing):
-			ctxLog.Warn().Msg("i have no secrets to accept connection")
-			return nil
-		case errors.Is(err, security.ErrPeerSecretMissing):
-			ctxLog.Trace().Msg("node that connects to us has no secrets")
-			return nil
-		case errors.Is(err, security.ErrSecretMismatch):
-			ctxLog.Warn().Msg("we have different secrets")
-			return nil
+		err = refusedByPeer(err)
+		if errors.Is(err, ErrNotPairedByPeer) {
+			ctxLog.Warn().Msg("peer has not paired with us yet")
 		}
+
+		notifyReady(ready, 0, err)
 		return err
 	}
 
-	if connErr := n.handleConnection(ctx, conn, false); connErr != nil {
+	if authErr := n.authorize(conn); authErr != nil {
+		_ = conn.Close()
+		ctxLog.Warn().Err(authErr).Msg("accept the pairing request to connect")
+		notifyReady(ready, 0, authErr)
+		return authErr
+	}
+
+	if connErr := n.handleConnection(ctx, conn, false, ready); connErr != nil {
+		if errors.Is(connErr, ErrNotPairedByPeer) {
+			ctxLog.Warn().Msg("peer has not paired with us yet")
+			return connErr
+		}
+
 		ctxLog.Warn().AnErr("node.handleConnection", connErr).Msg("failed to handle connection")
 		return connErr
 	}
@@ -105,11 +171,96 @@ func (n *Node) ConnectTo(ctx context.Context, addr string) error {
 	return nil
 }
 
+// ServeConn serves a connection established outside of the transport, e.g. spliced by a relay.
+// A dialed connection is checked like the ones of ConnectTo
+func (n *Node) ServeConn(ctx context.Context, conn transport.Connection, dialed bool) error {
+	if !dialed {
+		return n.handleConnection(ctx, conn, true, nil)
+	}
+
+	if n.peers.Len() >= n.live().MaxPeers {
+		_ = conn.Close()
+		return ErrMaxPeersReached
+	}
+
+	if err := n.authorize(conn); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("node.ServeConn: %w", err)
+	}
+
+	return n.handleConnection(ctx, conn, false, nil)
+}
+
+// Connected reports whether the device behind the fingerprint is connected by any means
+func (n *Node) Connected(fp security.Fingerprint) bool {
+	var found bool
+	n.peers.Tap(func(_ domain.NodeID, p *peer.Peer) bool {
+		if cert := p.Conn().PeerCertificate(); cert != nil && security.FingerprintOf(cert) == fp {
+			found = true
+		}
+		return !found
+	})
+
+	return found
+}
+
+// readyFunc receives the handshake result of an outgoing connection exactly once,
+// before the connection is served
+type readyFunc func(id domain.NodeID, err error)
+
+// authorize checks the device we dialed, the listening side is checked during the TLS handshake
+func (n *Node) authorize(conn transport.Connection) error {
+	if n.opts.Authorizer == nil {
+		return nil
+	}
+
+	return n.opts.Authorizer.Authorize(conn.PeerCertificate())
+}
+
+// Reauthorize closes connected peers the authorizer no longer accepts, e.g. revoked devices
+func (n *Node) Reauthorize() int {
+	ctxLog := ctxlog.Op(n.opts.Logger, "node.Reauthorize")
+
+	var closed int
+	n.peers.Tap(func(_ domain.NodeID, p *peer.Peer) bool {
+		if err := n.authorize(p.Conn()); err != nil {
+			ctxLog.Info().Err(err).Str("peer", p.String()).Msg("closing connection")
+			_ = p.Close()
+			closed++
+		}
+		return true
+	})
+
+	return closed
+}
+
+// refusedByPeer reports err as ErrNotPairedByPeer when it carries the alert of a peer refusing our certificate
+func refusedByPeer(err error) error {
+	var alert tls.AlertError
+	if errors.As(err, &alert) && alert == transport.AlertBadCertificate {
+		return fmt.Errorf("%w: %w", ErrNotPairedByPeer, err)
+	}
+
+	return err
+}
+
+func notifyReady(ready readyFunc, id domain.NodeID, err error) {
+	if ready != nil {
+		ready(id, err)
+	}
+}
+
 func (n *Node) addPeer(hisHand domain.Handshake, conn transport.Connection) (*peer.Peer, cleanup, error) {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.addPeer")
 
 	metadata := hisHand.MetaData
 
+	// trust may have changed while the handshake was in flight
+	if err := n.authorize(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("node.addPeer: %w", err)
+	}
+
 	if oldPeer, exists := n.peers.Get(metadata.UniqueID()); exists {
 		ctxLog.Warn().
 			Str("peer", oldPeer.String()).
@@ -117,6 +268,8 @@ func (n *Node) addPeer(hisHand domain.Handshake, conn transport.Connection) (*pe
 		_ = oldPeer.Close()
 	}
 
+	live := n.live()
+	limit := live.RateLimits.For(metadata)
 	pr := peer.New(
 		conn,
 		metadata,
@@ -125,8 +278,12 @@ func (n *Node) addPeer(hisHand domain.Handshake, conn transport.Connection) (*pe
 			Store:          n.opts.Store,
 			Logger:         n.opts.Logger,
 			Deadline:       n.opts.Deadline,
-			MaxReceiveSize: uint64(n.opts.Clip.MaxFileSize),
+			MaxReceiveSize: uint64(live.Clip.MaxFileSize),
 			Batches:        n.batches,
+			Policy:         live.Policies.For(metadata),
+			Envelope:       n.opts.Envelope,
+			Transfers:      n.opts.Transfers,
+			Shaper:         bandwidth.NewShaper(limit.Upload, limit.Download, n.upload, n.download),
 		},
 	)
 
@@ -166,6 +323,8 @@ func (n *Node) Start(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	n.static.Set(ctx, n.live().Peers)
+
 	go func() {
 		defer cancel()
 		if err := n.monitor(ctx); err != nil {
@@ -198,12 +357,18 @@ func (n *Node) Start(ctx context.Context) error {
 				return fmt.Errorf("node.Start: %w", netErr)
 			}
 
+			if !n.accepts(conn.RemoteAddr()) {
+				ctxLog.Debug().Stringer("addr", conn.RemoteAddr()).Msg("rejected connection from outside the scope")
+				_ = conn.Close()
+				continue
+			}
+
 			ctxLog.
 				Trace().
 				Msgf("accepted connection from %s", conn.RemoteAddr())
 
 			go func() {
-				if connErr := n.handleConnection(ctx, conn, true); connErr != nil {
+				if connErr := n.handleConnection(ctx, conn, true, nil); connErr != nil {
 					ctxLog.
 						Err(connErr).
 						Msg("failed to handle connection")
@@ -213,23 +378,47 @@ func (n *Node) Start(ctx context.Context) error {
 	}
 }
 
-func (n *Node) handleConnection(ctx context.Context, conn transport.Connection, accept bool) error {
+// accepts reports whether a connection from addr is in scope.
+// The host itself is not exempt, a reverse proxy on it would let every client through
+func (n *Node) accepts(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return len(n.opts.Scope.Allow) == 0
+	}
+
+	return n.opts.Scope.Accepts(addrPort.Addr())
+}
+
+func (n *Node) handleConnection(ctx context.Context, conn transport.Connection, accept bool, ready readyFunc) error {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.handleConnection").
 		With().
 		Str("node", n.Metadata().String()).
 		Logger()
 
-	hs := newHandshake(n.Metadata(), int(n.opts.ListenPort), n.opts.Logger)
+	hs := newHandshake(n.Metadata(), n.opts.ListenPort, n.opts.Transport.Endpoints(n.opts.ListenPort), n.opts.Logger)
 	hisHand, greetErr := hs.exchange(ctx, conn, accept)
 	if greetErr != nil {
+		// the tls handshake ends before the peer checks our certificate, its refusal ends the greeting
+		greetErr = refusedByPeer(greetErr)
+		notifyReady(ready, 0, greetErr)
 		if errors.Is(greetErr, ErrVersionMismatch) {
 			return nil
 		}
+		if errors.Is(greetErr, ErrNodeIDCollision) {
+			_ = conn.Close()
+			ctxLog.Error().
+				Err(greetErr).
+				Str("addr", conn.RemoteAddr().String()).
+				Msg("node id collision, the peer uses a copy of our key, delete identity.pem in the state dir of one device and pair it again")
+			n.Notify("node id collision with %s", conn.RemoteAddr())
+			return nil
+		}
 
 		return greetErr
 	}
 
 	pr, cleanup, addErr := n.addPeer(hisHand.Payload, conn)
+	notifyReady(ready, hisHand.Payload.MetaData.UniqueID(), addErr)
 	if addErr != nil {
 		if errors.Is(addErr, ErrAlreadyConnected) {
 			return nil
@@ -256,16 +445,31 @@ func openOrAcceptStream(ctx context.Context, conn transport.Connection, accept b
 	return conn.OpenStream(ctx)
 }
 
+// Broadcast announces a message to every peer except the one it came from and its origin,
+// relayed messages are announced further until they have crossed MaxHops connections
 func (n *Node) Broadcast(ctx context.Context, announce domain.EventAnnounce) {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.Broadcast")
 
+	if maxHops := n.live().MaxHops; announce.Payload.Hops >= uint32(maxHops) {
+		ctxLog.Trace().
+			Object("announce", announce.Payload).
+			Int("max_hops", maxHops).
+			Msg("hop limit reached, not relayed")
+		return
+	}
+
 	n.peers.Tap(func(id domain.NodeID, peer *peer.Peer) bool {
 		ctxLog := ctxLog.
 			With().
 			Int64("node_id", peer.MetaData().ID.Int64()).
 			Logger()
 
-		if id == announce.From {
+		if id == announce.From || id == announce.Via {
+			return true
+		}
+
+		if !peer.Policy().CanSend(announce.Payload.MimeType) {
+			ctxLog.Trace().Msg("not announced, denied by policy")
 			return true
 		}
 
@@ -291,6 +495,23 @@ func (n *Node) Broadcast(ctx context.Context, announce domain.EventAnnounce) {
 	})
 }
 
+// keptLocal reports whether the filter keeps update from being synced, the reason is logged instead of the content
+func (n *Node) keptLocal(ctxLog zerolog.Logger, update eventful.Update) bool {
+	if n.opts.Filter == nil {
+		return false
+	}
+
+	reason, blocked := n.opts.Filter.Block(update)
+	if blocked {
+		ctxLog.Info().
+			Str("reason", reason).
+			Stringer("mime", update.MimeType).
+			Uint64("hash", update.Hash).
+			Msg("kept local, not synced")
+	}
+	return blocked
+}
+
 func (n *Node) monitor(ctx context.Context) error {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.monitor").With().Logger()
 
@@ -308,7 +529,14 @@ func (n *Node) monitor(ctx context.Context) error {
 			current domain.Message
 		)
 		for update := range updates {
+			n.held.Store(update.Hash)
+
+			if n.keptLocal(ctxLog, update) {
+				continue
+			}
+
 			msg := messageFromUpdate(update)
+			msg.TTL = n.live().TTL
 			if msg.Zero() {
 				ctxLog.Trace().Object("update", update).Msg("that message type not supported")
 				continue
@@ -339,7 +567,14 @@ func (n *Node) monitor(ctx context.Context) error {
 			if !ok {
 				return nil
 			}
-			if msg.From != n.opts.Metadata.UniqueID() {
+			switch {
+			case msg.From == n.opts.Metadata.UniqueID():
+				// copied here, already in the clipboard
+			case n.pending.Arrive(msg.Payload):
+				ctxLog.Trace().Object("msg", msg.Payload).Msg("fetched on paste, already in the clipboard")
+			case msg.Payload.Sealed:
+				ctxLog.Debug().Object("msg", msg.Payload).Msg("sealed for other devices, relaying only")
+			default:
 				ctxLog.Trace().Object("msg", msg.Payload).Msg("set clipboard data")
 
 				if msg.Payload.BatchID != 0 && msg.Payload.BatchTotal > 1 {
@@ -347,19 +582,28 @@ func (n *Node) monitor(ctx context.Context) error {
 					if ready {
 						if _, err := n.clipboard.Write(msg.Payload.MimeType, batchData); err != nil {
 							ctxLog.Error().Err(err).Msg("failed to write batch to clipboard")
+						} else {
+							n.held.Store(msg.Payload.ContentHash)
 						}
 					}
 				} else {
 					if _, err := n.clipboard.Write(msg.Payload.MimeType, msg.Payload.Data); err != nil {
 						ctxLog.Error().Err(err).Object("msg", msg.Payload).Send()
+					} else {
+						n.held.Store(msg.Payload.ContentHash)
 					}
 				}
 			}
 
+			if msg.Payload.TTL > 0 {
+				go n.expire(ctx, msg.Payload)
+			}
+
 			go n.Broadcast(ctx, domain.EventAnnounce{
 				From:    msg.From,
 				Created: msg.Created,
 				Payload: msg.Payload.Announce(),
+				Via:     msg.Via,
 			})
 
 		case ann := <-n.channel.Announcements():
@@ -368,6 +612,34 @@ func (n *Node) monitor(ctx context.Context) error {
 	}
 }
 
+// expire clears the clipboard once msg outlives its TTL, unless something else was copied since
+func (n *Node) expire(ctx context.Context, msg domain.Message) {
+	ctxLog := ctxlog.Op(n.opts.Logger, "node.expire").With().Object("msg", msg).Logger()
+
+	timer := time.NewTimer(msg.TTL)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	n.channel.Expire(msg)
+
+	if !n.held.CompareAndSwap(msg.ContentHash, 0) {
+		ctxLog.Trace().Msg("content expired, clipboard holds something else")
+		return
+	}
+
+	if _, err := n.clipboard.Write(mime.TypeText, nil); err != nil {
+		ctxLog.Error().Err(err).Msg("failed to clear clipboard")
+		return
+	}
+
+	ctxLog.Debug().Msg("content expired, clipboard cleared")
+}
+
 func messageFromUpdate(update eventful.Update) domain.Message {
 	if update.MimeType.IsPath() {
 		return domain.Message{
@@ -379,6 +651,7 @@ func messageFromUpdate(update eventful.Update) domain.Message {
 			ContentLength: update.Size,
 			BatchID:       domain.MessageID(update.BatchID),
 			BatchTotal:    update.BatchTotal,
+			Dir:           update.Dir,
 		}
 	}
 
@@ -394,36 +667,39 @@ func messageFromUpdate(update eventful.Update) domain.Message {
 }
 
 func (n *Node) Notify(message string, v ...any) {
-	n.opts.Notifier.Notify(message, v...)
+	n.live().Notifier.Notify(message, v...)
 }
 
 func (n *Node) Metadata() domain.Device {
 	return n.opts.Metadata
 }
 
+// handleAnnounce requests the message from the peer that announced it,
+// which is the origin itself or the relay it came through first
 func (n *Node) handleAnnounce(ctx context.Context, ann domain.EventAnnounce) {
-	p, ok := n.peers.Get(ann.From)
+	p, ok := n.peers.Get(ann.Via)
 	if !ok {
 		return
 	}
+	n.routes.Learn(ann.From, ann.Via, ann.Payload.Hops, time.Now())
 
 	logger := ctxlog.Op(n.opts.Logger, "node.handleAnnounce").With().Object("announce", ann.Payload).Logger()
 	logger.Trace().
 		Msg("received announce")
 
-	if ann.Payload.ContentLength > uint64(n.opts.Clip.MaxFileSize) {
+	if !p.Policy().CanReceive(ann.Payload.MimeType) {
+		logger.Trace().Str("policy", p.Policy().String()).Msg("denied by policy, skipping")
+		n.skipBatchPart(ann.Payload)
+		return
+	}
+
+	if maxSize := n.live().Clip.MaxFileSize; ann.Payload.ContentLength > uint64(maxSize) {
 		logger.Warn().
-			Str("max_size", n.opts.Clip.MaxFileSize.String()).
+			Str("max_size", maxSize.String()).
 			Str("received_size", humanize.Bytes(ann.Payload.ContentLength)).
 			Msg("i cannot accept; size exceeds permitted limits")
 
-		if ann.Payload.BatchID != 0 {
-			n.batches.Add(domain.Message{
-				ID:         ann.Payload.ID,
-				BatchID:    ann.Payload.BatchID,
-				BatchTotal: ann.Payload.BatchTotal,
-			})
-		}
+		n.skipBatchPart(ann.Payload)
 		return
 	}
 
@@ -431,10 +707,37 @@ func (n *Node) handleAnnounce(ctx context.Context, ann domain.EventAnnounce) {
 		logger.Trace().Msg("i already have this message, skipping")
 		return
 	}
-	logger.Trace().Msg("requesting message")
 
-	if err := p.RequestMessage(ctx, ann.Payload.ID); err != nil {
+	if n.offer(ctx, ann) {
+		return
+	}
+	// a file whose transfer broke before continues from where it stopped
+	var offset uint64
+	if ann.Payload.MimeType.IsPath() {
+		offset = n.opts.Store.Offset(domain.Message{
+			ID:            ann.Payload.ID,
+			ContentHash:   ann.Payload.ContentHash,
+			ContentLength: ann.Payload.ContentLength,
+			BatchID:       ann.Payload.BatchID,
+		})
+	}
+	logger.Trace().Uint64("offset", offset).Msg("requesting message")
+
+	if err := p.RequestMessage(ctx, ann.Payload.ID, offset); err != nil {
 		logger.Err(err).Str("peer", p.String()).Msg("failed to request")
+		// another peer relaying it may still deliver
+		n.channel.Forget(ann)
+	}
+}
+
+// skipBatchPart counts a part we do not request, so the rest of its batch still completes
+func (n *Node) skipBatchPart(ann domain.Announce) {
+	if ann.BatchID != 0 {
+		n.batches.Add(domain.Message{
+			ID:         ann.ID,
+			BatchID:    ann.BatchID,
+			BatchTotal: ann.BatchTotal,
+		})
 	}
 }
 
@@ -442,11 +745,12 @@ func (n *Node) DiscoveryPayload() []byte {
 	greet := domain.NewGreet(
 		domain.WithMetadata(n.Metadata()),
 		domain.WithPort(uint16(n.opts.ListenPort)),
+		domain.WithEndpoints(n.opts.Transport.Endpoints(n.opts.ListenPort)),
 	)
 	return protocol.MustEncode(greet)
 }
 
-func (n *Node) PeerDiscovered(ctx context.Context, peerIP net.IP, payload []byte) {
+func (n *Node) PeerDiscovered(ctx context.Context, peerIP netip.Addr, payload []byte) {
 	ctxLog := ctxlog.Op(n.opts.Logger, "node.PeerDiscovered")
 
 	greet, err := protocol.DecodeExpect[domain.EventHandshake](bytes.NewReader(payload))
@@ -470,12 +774,65 @@ func (n *Node) PeerDiscovered(ctx context.Context, peerIP net.IP, payload []byte
 		return
 	}
 
-	addr := fmt.Sprintf("%s:%d", peerIP.String(), greet.Payload.Port)
-	if err := n.ConnectTo(ctx, addr); err != nil {
-		if errors.Is(err, ErrMaxPeersReached) {
-			ctxLog.Warn().Str("peer", greet.Payload.MetaData.String()).Msg("discovered but rejected: max peers reached")
-		} else {
-			ctxLog.Warn().Err(err).Str("peer", greet.Payload.MetaData.String()).Msg("discovered but failed to connect")
+	if !n.opts.Scope.Discovers(peerIP) {
+		ctxLog.Trace().
+			Str("peer", greet.Payload.MetaData.String()).
+			Str("addr", peerIP.String()).
+			Msg("discovered on a network out of scope or untrusted, not connecting")
+		return
+	}
+
+	addrs := n.dialAddrs(peerIP, greet.Payload)
+	if len(addrs) == 0 {
+		ctxLog.Warn().
+			Str("peer", greet.Payload.MetaData.String()).
+			Stringers("endpoints", endpointStringers(greet.Payload.Endpoints)).
+			Msg("discovered but no transport in common")
+		return
+	}
+
+	// the next transport is tried only when the peer is not reached with the previous one
+	var connErr error
+	for _, addr := range addrs {
+		if connErr = <-n.ConnectAsync(ctx, addr); connErr == nil || errors.Is(connErr, ErrMaxPeersReached) {
+			break
+		}
+	}
+
+	if errors.Is(connErr, ErrMaxPeersReached) {
+		ctxLog.Warn().Str("peer", greet.Payload.MetaData.String()).Msg("discovered but rejected: max peers reached")
+	} else if connErr != nil {
+		ctxLog.Warn().Err(connErr).Str("peer", greet.Payload.MetaData.String()).Msg("discovered but failed to connect")
+	}
+}
+
+// dialAddrs are the addresses of the endpoints a peer shares with us, in our order.
+// A peer that advertises none is dialed on its port with whatever transport reaches it
+func (n *Node) dialAddrs(ip netip.Addr, greet domain.Handshake) []string {
+	if len(greet.Endpoints) == 0 {
+		return []string{net.JoinHostPort(ip.String(), strconv.Itoa(int(greet.Port)))}
+	}
+
+	var addrs []string
+	for _, tr := range n.opts.Transport {
+		for _, e := range greet.Endpoints {
+			if e.Transport != tr.String() {
+				continue
+			}
+			addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(e.Port)))
+			if len(n.opts.Transport) > 1 {
+				addr = e.Transport + "://" + addr
+			}
+			addrs = append(addrs, addr)
 		}
 	}
+	return addrs
+}
+
+func endpointStringers(endpoints []domain.Endpoint) []fmt.Stringer {
+	res := make([]fmt.Stringer, 0, len(endpoints))
+	for _, e := range endpoints {
+		res = append(res, e)
+	}
+	return res
 }
@@ -2,9 +2,14 @@ package node
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"net"
 	"testing"
 
+	"github.com/labi-le/belphegor/internal/scope"
 	"github.com/labi-le/belphegor/internal/transport"
 )
 
@@ -29,3 +34,93 @@ func TestConnectTo_MaxPeers(t *testing.T) {
 		t.Errorf("expected ErrMaxPeersReached, got %v", err)
 	}
 }
+
+type refusingTransport struct{ mockTransport }
+
+func (r *refusingTransport) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	return nil, fmt.Errorf("handshake: %w", transport.AlertBadCertificate)
+}
+
+func TestConnectTo_NotPairedByPeer(t *testing.T) {
+	n := New(&refusingTransport{}, nil, &Storage{}, nil, Options{MaxPeers: 1})
+
+	err := n.ConnectTo(context.Background(), "localhost:1234")
+	if !errors.Is(err, ErrNotPairedByPeer) {
+		t.Fatalf("expected ErrNotPairedByPeer, got %v", err)
+	}
+
+	var alert tls.AlertError
+	if !errors.As(err, &alert) {
+		t.Fatal("the alert of the peer was lost")
+	}
+}
+
+type stubConn struct{ transport.Connection }
+
+func (stubConn) PeerCertificate() *x509.Certificate { return nil }
+
+func (stubConn) Close() error { return nil }
+
+type stubDialer struct{ mockTransport }
+
+func (stubDialer) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	return stubConn{}, nil
+}
+
+var errUnpaired = errors.New("unpaired")
+
+type rejectingAuthorizer struct{}
+
+func (rejectingAuthorizer) Authorize(*x509.Certificate) error { return errUnpaired }
+
+func TestConnectTo_Unauthorized(t *testing.T) {
+	n := New(&stubDialer{}, nil, &Storage{}, nil, Options{MaxPeers: 1, Authorizer: rejectingAuthorizer{}})
+
+	if err := n.ConnectTo(context.Background(), "localhost:1234"); !errors.Is(err, errUnpaired) {
+		t.Fatalf("expected the authorizer error, got %v", err)
+	}
+}
+
+func TestReload_MaxPeers(t *testing.T) {
+	n := New(&mockTransport{}, nil, &Storage{}, nil, Options{MaxPeers: 0})
+
+	n.Reload(context.Background(), Options{MaxPeers: 1})
+
+	err := n.ConnectTo(context.Background(), "localhost:1234")
+	if errors.Is(err, ErrMaxPeersReached) {
+		t.Fatal("reloaded limit was not applied")
+	}
+}
+
+func TestAccepts_Scope(t *testing.T) {
+	var s scope.Scope
+	if err := s.Allow.Set("192.168.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	n := New(&mockTransport{}, nil, &Storage{}, nil, Options{Scope: s})
+
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 7777}, true},
+		{&net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 7777}, false},
+		// a reverse proxy on the host connects from loopback
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 7777}, false},
+		{&net.UnixAddr{Name: "@belphegor", Net: "unix"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := n.accepts(tt.addr); got != tt.want {
+			t.Errorf("accepts(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	// without a scope every connection is accepted
+	open := New(&mockTransport{}, nil, &Storage{}, nil, Options{})
+	for _, tt := range tests {
+		if !open.accepts(tt.addr) {
+			t.Errorf("accepts(%s) without a scope = false", tt.addr)
+		}
+	}
+}
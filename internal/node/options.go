@@ -1,15 +1,28 @@
 package node
 
 import (
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/labi-le/belphegor/internal/bandwidth"
+	"github.com/labi-le/belphegor/internal/discovering"
+	"github.com/labi-le/belphegor/internal/filter"
+	"github.com/labi-le/belphegor/internal/history"
 	"github.com/labi-le/belphegor/internal/netstack"
 	"github.com/labi-le/belphegor/internal/notification"
+	"github.com/labi-le/belphegor/internal/paths"
+	"github.com/labi-le/belphegor/internal/peer"
+	"github.com/labi-le/belphegor/internal/policy"
+	"github.com/labi-le/belphegor/internal/scope"
 	"github.com/labi-le/belphegor/internal/store"
+	"github.com/labi-le/belphegor/internal/transfer"
 	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/bytesize"
 	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
 	"github.com/labi-le/belphegor/pkg/network"
 	"github.com/rs/zerolog"
@@ -20,6 +33,8 @@ type Transport string
 const (
 	TransportQUIC Transport = "quic"
 	TransportTCP  Transport = "tcp"
+	TransportWS   Transport = "ws"
+	TransportUnix Transport = "unix"
 )
 
 func (t Transport) String() string {
@@ -30,9 +45,11 @@ func (t *Transport) Set(s string) error {
 	*t = Transport(s)
 	if !t.valid() {
 		return fmt.Errorf(
-			"available %s, %s",
+			"available %s, %s, %s, %s",
 			TransportTCP,
 			TransportQUIC,
+			TransportWS,
+			TransportUnix,
 		)
 	}
 	return nil
@@ -43,12 +60,115 @@ func (t *Transport) Type() string {
 }
 
 func (t *Transport) valid() bool {
-	return *t == TransportTCP || *t == TransportQUIC
+	switch *t {
+	case TransportTCP, TransportQUIC, TransportWS, TransportUnix:
+		return true
+	}
+	return false
+}
+
+// Transports are listened with at once, dials try them in order
+type Transports []Transport
+
+func (t *Transports) String() string {
+	return strings.Join(t.GetSlice(), ",")
+}
+
+func (t *Transports) Set(s string) error {
+	var res Transports
+	for name := range strings.SplitSeq(s, ",") {
+		var tr Transport
+		if err := tr.Set(strings.TrimSpace(name)); err != nil {
+			return err
+		}
+		if !slices.Contains(res, tr) {
+			res = append(res, tr)
+		}
+	}
+
+	*t = res
+	return nil
+}
+
+func (t *Transports) Type() string {
+	return "strings"
+}
+
+func (t *Transports) Append(s string) error {
+	var tr Transport
+	if err := tr.Set(s); err != nil {
+		return err
+	}
+	if !slices.Contains(*t, tr) {
+		*t = append(*t, tr)
+	}
+	return nil
+}
+
+func (t *Transports) Replace(vals []string) error {
+	return t.Set(strings.Join(vals, ","))
+}
+
+func (t *Transports) GetSlice() []string {
+	res := make([]string, 0, len(*t))
+	for _, tr := range *t {
+		res = append(res, tr.String())
+	}
+	return res
+}
+
+func (t *Transports) valid() bool {
+	if len(*t) == 0 {
+		return false
+	}
+	for _, tr := range *t {
+		if !tr.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// Endpoints are the ports the transports listen on, the ones sharing a network take consecutive ports
+func (t Transports) Endpoints(port int) []domain.Endpoint {
+	taken := make(map[string]int)
+	res := make([]domain.Endpoint, 0, len(t))
+	for _, tr := range t {
+		network := tr.network()
+		res = append(res, domain.Endpoint{Transport: tr.String(), Port: uint32(port + taken[network])})
+		taken[network]++
+	}
+	return res
+}
+
+// network is what the transport listens on, two transports on one network cannot share a port
+func (t Transport) network() string {
+	switch t {
+	case TransportQUIC:
+		return "udp"
+	case TransportUnix:
+		return "unix"
+	default:
+		return "tcp"
+	}
+}
+
+// Authorizer decides whether the device behind a certificate may connect
+type Authorizer interface {
+	Authorize(cert *x509.Certificate) error
+}
+
+// Filter keeps clipboard updates from being synced, the reason is logged instead of the content
+type Filter interface {
+	Block(update eventful.Update) (reason string, blocked bool)
 }
 
 type Options struct {
-	ListenPort  int
-	Transport   Transport
+	ListenPort int
+	// Transport lists the transports to listen with, the first one is tried first when dialing
+	Transport Transports
+	// SocketDir holds the sockets of the unix transport, empty uses the abstract namespace
+	SocketDir   string
 	KeepAlive   time.Duration
 	Deadline    network.Deadline
 	Notifier    notification.Notifier
@@ -56,11 +176,40 @@ type Options struct {
 	Metadata    domain.Device
 	Logger      zerolog.Logger
 	Secret      string
+	Authorizer  Authorizer
+	Filter      Filter
+	Envelope    peer.Envelope
 	MaxPeers    int
-	Store       store.FileWriter
-	Clip        eventful.Options
+	// MaxHops is how many connections a message crosses from its origin, 1 keeps it to direct peers
+	MaxHops int
+	Store   store.FileWriter
+	// Transfers tracks the payloads streamed with peers, the node makes one without it
+	Transfers *transfer.Manager
+	Clip      eventful.Options
+	History   HistoryOptions
+	Sensitive SensitiveOptions
+	// E2E seals payloads for the paired devices instead of relying on the transport alone
+	E2E bool
+	// Peers are kept connected by the supervisor
+	Peers []string
+	// Relay is the address of a relay to register with, devices on other networks are reached through it
+	Relay string
+	// Policies limit what is exchanged with matching devices
+	Policies policy.Rules
+	// Upload and Download limit the traffic with all peers together, 0 is unlimited
+	Upload   bandwidth.Rate
+	Download bandwidth.Rate
+	// RateLimits limit the traffic with matching devices on top of that
+	RateLimits bandwidth.Rules
+	// Scope limits the networks peers are discovered on and accepted from
+	Scope     scope.Scope
+	Reconnect ReconnectOptions
+	// TTL clears content copied here from every clipboard after it, 0 keeps it
+	TTL time.Duration
 
 	FileSavePath   string
+	StateDir       string
+	ControlSocket  string
 	Verbose        bool
 	Notify         bool
 	ShowVersion    bool
@@ -71,6 +220,7 @@ type Options struct {
 
 func (o Options) MarshalZerologObject(e *zerolog.Event) {
 	e.Int("public_port", int(o.ListenPort))
+	e.Stringer("transport", &o.Transport)
 	e.Str("keep_alive", o.KeepAlive.String())
 	e.Dict(
 		"deadline",
@@ -90,29 +240,125 @@ func (o Options) MarshalZerologObject(e *zerolog.Event) {
 		zerolog.Dict().
 			Bool("enable", o.Discovering.Enable).
 			Str("delay", o.Discovering.Delay.String()).
-			Int("max_peers", o.Discovering.MaxPeers),
+			Int("max_peers", o.Discovering.MaxPeers).
+			Str("backend", string(o.Discovering.Backend)),
 	)
 	e.Bool("has_secret", o.Secret != "")
+	e.Bool("e2e", o.E2E)
 	e.Int("max_peers", o.MaxPeers)
+	e.Int("max_hops", o.MaxHops)
 	e.Dict(
 		"clipboard_options",
 		zerolog.Dict().
 			Bool("allow_copy_files", o.Clip.AllowCopyFiles).
 			Int("max_clipboard_files", o.Clip.MaxClipboardFiles).
+			Bool("lazy_files", o.Clip.LazyFiles).
 			Int64("max_file_size", int64(o.Clip.MaxFileSize)),
 	)
+	e.Strs("peers", o.Peers)
+	e.Str("relay", o.Relay)
+	e.Str("socket_dir", o.SocketDir)
+	e.Strs("policies", o.Policies.GetSlice())
+	e.Dict(
+		"rate_limit",
+		zerolog.Dict().
+			Stringer("upload", o.Upload).
+			Stringer("download", o.Download).
+			Strs("peers", o.RateLimits.GetSlice()),
+	)
+	e.Dict(
+		"scope",
+		zerolog.Dict().
+			Strs("allow", o.Scope.Allow.GetSlice()).
+			Strs("untrusted", o.Scope.Untrusted.GetSlice()),
+	)
+	e.Str("ttl", o.TTL.String())
+	e.Dict(
+		"reconnect",
+		zerolog.Dict().
+			Str("min_delay", o.Reconnect.MinDelay.String()).
+			Str("max_delay", o.Reconnect.MaxDelay.String()),
+	)
+	e.Str("state_dir", o.StateDir)
+	e.Str("control_socket", o.ControlSocket)
+	e.Dict(
+		"history",
+		zerolog.Dict().
+			Bool("enable", o.History.Enable).
+			Int("max_entries", o.History.MaxEntries).
+			Str("max_age", o.History.MaxAge.String()).
+			Stringer("max_size", o.History.MaxSize),
+	)
+	e.Dict(
+		"sensitive",
+		zerolog.Dict().
+			Bool("enable", o.Sensitive.Enable).
+			Int("patterns", len(o.Sensitive.Patterns)).
+			Float64("entropy", o.Sensitive.Entropy),
+	)
 }
 
 type DiscoverOptions struct {
 	Enable   bool
 	Delay    time.Duration
 	MaxPeers int
+	// Backend is how nodes announce themselves, mdns is visible to Avahi and Bonjour
+	Backend discovering.Backend
+}
+
+// ReconnectOptions bound the backoff between redials of static peers
+type ReconnectOptions struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+type HistoryOptions struct {
+	Enable     bool
+	MaxEntries int
+	MaxAge     time.Duration
+	MaxSize    bytesize.Size
+}
+
+func (h HistoryOptions) Retention() history.Retention {
+	return history.Retention{
+		MaxEntries: h.MaxEntries,
+		MaxAge:     h.MaxAge,
+		MaxBytes:   uint64(h.MaxSize),
+	}
+}
+
+// SensitiveOptions configure the filter that keeps secrets from syncing
+type SensitiveOptions struct {
+	Enable bool
+	// Patterns are extra regular expressions for text that must stay local
+	Patterns []string
+	// Entropy in bits per character above which a single long word is a secret, 0 disables it
+	Entropy float64
+}
+
+// entropyMinLen keeps short words out of the entropy check, they look random too easily
+const entropyMinLen = 16
+
+func (s SensitiveOptions) Filter() (*filter.Filter, error) {
+	detectors := filter.Builtin()
+	for _, expr := range s.Patterns {
+		d, err := filter.Pattern(expr, expr)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, d)
+	}
+	if s.Entropy > 0 {
+		detectors = append(detectors, filter.Entropy(s.Entropy, entropyMinLen))
+	}
+
+	return filter.New(detectors...), nil
 }
 
 func DefaultOptions() Options {
 	return Options{
 		ListenPort: netstack.RandomPort(),
-		Transport:  TransportQUIC,
+		Transport:  Transports{TransportQUIC},
 		KeepAlive:  time.Minute,
 		Deadline: network.Deadline{
 			Read:  time.Minute,
@@ -123,16 +369,34 @@ func DefaultOptions() Options {
 			Enable:   true,
 			Delay:    30 * time.Second,
 			MaxPeers: 10,
+			Backend:  discovering.BackendBeacon,
 		},
-		Metadata:     domain.SelfMetaData(),
-		MaxPeers:     10,
-		FileSavePath: path.Join(os.TempDir(), "bfg_cache"),
+		Metadata:      domain.SelfMetaData(),
+		MaxPeers:      10,
+		MaxHops:       8,
+		FileSavePath:  path.Join(os.TempDir(), "bfg_cache"),
+		StateDir:      paths.StateDir(),
+		ControlSocket: paths.ControlSocket(),
 		Clip: eventful.Options{
 			AllowCopyFiles: true,
 			// 512 mb
 			MaxFileSize:       1 << 29,
 			MaxClipboardFiles: 15,
 		},
+		Reconnect: ReconnectOptions{
+			MinDelay: time.Second,
+			MaxDelay: 2 * time.Minute,
+		},
+		Sensitive: SensitiveOptions{
+			Enable: true,
+		},
+		History: HistoryOptions{
+			Enable:     false,
+			MaxEntries: 1000,
+			MaxAge:     7 * 24 * time.Hour,
+			// 64 mb
+			MaxSize: 1 << 26,
+		},
 	}
 }
 
@@ -170,6 +434,10 @@ func (o Options) Validated() Options {
 		o.Discovering.Delay = defaults.Discovering.Delay
 	}
 
+	if o.Discovering.Backend.Set(string(o.Discovering.Backend)) != nil {
+		o.Discovering.Backend = defaults.Discovering.Backend
+	}
+
 	if o.Metadata == (domain.Device{}) {
 		o.Metadata = defaults.Metadata
 	}
@@ -178,6 +446,10 @@ func (o Options) Validated() Options {
 		o.MaxPeers = defaults.MaxPeers
 	}
 
+	if o.MaxHops <= 0 {
+		o.MaxHops = defaults.MaxHops
+	}
+
 	if o.Clip.MaxClipboardFiles <= 0 {
 		o.Clip.MaxClipboardFiles = defaults.Clip.MaxClipboardFiles
 	}
@@ -186,5 +458,29 @@ func (o Options) Validated() Options {
 		o.Clip.MaxClipboardFiles = defaults.Clip.MaxClipboardFiles
 	}
 
+	if o.StateDir == "" {
+		o.StateDir = defaults.StateDir
+	}
+
+	if o.Reconnect.MinDelay <= 0 {
+		o.Reconnect.MinDelay = defaults.Reconnect.MinDelay
+	}
+
+	if o.Reconnect.MaxDelay < o.Reconnect.MinDelay {
+		o.Reconnect.MaxDelay = max(defaults.Reconnect.MaxDelay, o.Reconnect.MinDelay)
+	}
+
+	if o.History.MaxEntries <= 0 {
+		o.History.MaxEntries = defaults.History.MaxEntries
+	}
+
+	if o.History.MaxAge <= 0 {
+		o.History.MaxAge = defaults.History.MaxAge
+	}
+
+	if o.History.MaxSize == 0 {
+		o.History.MaxSize = defaults.History.MaxSize
+	}
+
 	return o
 }
@@ -1,9 +1,11 @@
 package node_test
 
 import (
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/labi-le/belphegor/internal/discovering"
 	"github.com/labi-le/belphegor/internal/node"
 	"github.com/labi-le/belphegor/internal/types/domain"
 	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
@@ -86,6 +88,48 @@ func TestTransport_Set(t *testing.T) {
 	}
 }
 
+func TestTransports_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+		want    node.Transports
+	}{
+		{name: "single", input: "tcp", want: node.Transports{node.TransportTCP}},
+		{name: "ordered", input: "tcp, quic", want: node.Transports{node.TransportTCP, node.TransportQUIC}},
+		{name: "duplicate", input: "quic,tcp,quic", want: node.Transports{node.TransportQUIC, node.TransportTCP}},
+		{name: "invalid", input: "quic,udp", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr node.Transports
+			err := tr.Set(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transports.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(tr, tt.want) {
+				t.Errorf("Transports.Set() = %v, want %v", tr, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransports_Endpoints(t *testing.T) {
+	tr := node.Transports{node.TransportQUIC, node.TransportTCP, node.TransportWS, node.TransportUnix}
+	want := []domain.Endpoint{
+		{Transport: "quic", Port: 7777},
+		{Transport: "tcp", Port: 7777},
+		{Transport: "ws", Port: 7778},
+		{Transport: "unix", Port: 7777},
+	}
+
+	if got := tr.Endpoints(7777); !slices.Equal(got, want) {
+		t.Errorf("Transports.Endpoints() = %v, want %v", got, want)
+	}
+}
+
 func TestTransport_Type(t *testing.T) {
 	var tr node.Transport
 	if got := tr.Type(); got != "string" {
@@ -139,7 +183,7 @@ func TestOptions_MarshalZerologObject(t *testing.T) {
 
 	opts := node.Options{
 		ListenPort: 8080,
-		Transport:  node.TransportTCP,
+		Transport:  node.Transports{node.TransportTCP},
 		KeepAlive:  time.Minute,
 		Deadline: network.Deadline{
 			Read:  30 * time.Second,
@@ -221,36 +265,36 @@ func TestOptions_Validated(t *testing.T) {
 		},
 		{
 			name: "valid transport TCP",
-			opts: node.Options{Transport: node.TransportTCP},
+			opts: node.Options{Transport: node.Transports{node.TransportTCP}},
 			check: func(t *testing.T, o node.Options) {
-				if o.Transport != node.TransportTCP {
+				if !slices.Equal(o.Transport, node.Transports{node.TransportTCP}) {
 					t.Errorf("Transport = %v, want tcp", o.Transport)
 				}
 			},
 		},
 		{
 			name: "valid transport QUIC",
-			opts: node.Options{Transport: node.TransportQUIC},
+			opts: node.Options{Transport: node.Transports{node.TransportQUIC}},
 			check: func(t *testing.T, o node.Options) {
-				if o.Transport != node.TransportQUIC {
+				if !slices.Equal(o.Transport, node.Transports{node.TransportQUIC}) {
 					t.Errorf("Transport = %v, want quic", o.Transport)
 				}
 			},
 		},
 		{
 			name: "invalid transport",
-			opts: node.Options{Transport: node.Transport("udp")},
+			opts: node.Options{Transport: node.Transports{node.TransportTCP, node.Transport("udp")}},
 			check: func(t *testing.T, o node.Options) {
-				if o.Transport != node.TransportQUIC {
+				if !slices.Equal(o.Transport, node.DefaultOptions().Transport) {
 					t.Error("Transport should be set to default")
 				}
 			},
 		},
 		{
 			name: "empty transport",
-			opts: node.Options{Transport: node.Transport("")},
+			opts: node.Options{Transport: node.Transports{}},
 			check: func(t *testing.T, o node.Options) {
-				if o.Transport != node.TransportQUIC {
+				if !slices.Equal(o.Transport, node.DefaultOptions().Transport) {
 					t.Error("Transport should be set to default")
 				}
 			},
@@ -327,6 +371,24 @@ func TestOptions_Validated(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "valid Discovering.Backend",
+			opts: node.Options{Discovering: node.DiscoverOptions{Backend: discovering.BackendMDNS}},
+			check: func(t *testing.T, o node.Options) {
+				if o.Discovering.Backend != discovering.BackendMDNS {
+					t.Errorf("Discovering.Backend = %v, want mdns", o.Discovering.Backend)
+				}
+			},
+		},
+		{
+			name: "invalid Discovering.Backend",
+			opts: node.Options{Discovering: node.DiscoverOptions{Backend: "smoke"}},
+			check: func(t *testing.T, o node.Options) {
+				if o.Discovering.Backend != discovering.BackendBeacon {
+					t.Errorf("Discovering.Backend = %v, want beacon", o.Discovering.Backend)
+				}
+			},
+		},
 		{
 			name: "valid Discovering.Delay",
 			opts: node.Options{Discovering: node.DiscoverOptions{Delay: time.Hour}},
@@ -406,7 +468,7 @@ func TestOptions_Validated(t *testing.T) {
 				if o.ListenPort <= 0 {
 					t.Error("ListenPort should be set")
 				}
-				if o.Transport != node.TransportQUIC {
+				if len(o.Transport) == 0 {
 					t.Error("Transport should be set")
 				}
 				if o.KeepAlive <= 0 {
@@ -430,6 +492,9 @@ func TestOptions_Validated(t *testing.T) {
 				if o.MaxPeers <= 0 {
 					t.Error("MaxPeers should be set")
 				}
+				if o.MaxHops <= 0 {
+					t.Error("MaxHops should be set")
+				}
 				if o.Clip.MaxClipboardFiles <= 0 {
 					t.Error("Clip.MaxClipboardFiles should be set")
 				}
@@ -447,14 +512,13 @@ func TestOptions_Validated(t *testing.T) {
 
 func TestDefaultOptions(t *testing.T) {
 	opts := node.DefaultOptions()
-	t.Logf("Transport value: %q", opts.Transport)
-	t.Logf("Expected TransportQUIC: %q", node.TransportQUIC)
+	t.Logf("Transport value: %v", opts.Transport)
 
 	if opts.ListenPort <= 0 {
 		t.Error("ListenPort should be set")
 	}
-	if opts.Transport != node.TransportQUIC {
-		t.Errorf("Transport should be QUIC, got %v", opts.Transport)
+	if want := (node.Transports{node.TransportQUIC}); !slices.Equal(opts.Transport, want) {
+		t.Errorf("Transport should be %v, got %v", want, opts.Transport)
 	}
 	if opts.KeepAlive <= 0 {
 		t.Error("KeepAlive should be set")
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"context"
+
+	"github.com/labi-le/belphegor/internal/peer"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+)
+
+// Reload applies the options that are safe to change while peers are connected:
+// peer limit, max file size, notifications, policies, rate limits, ttl and static peers.
+// Removed static peers are disconnected, added ones are dialed.
+// Everything else is left as is and needs a restart
+func (n *Node) Reload(ctx context.Context, opts Options) {
+	ctxLog := ctxlog.Op(n.opts.Logger, "node.Reload")
+
+	n.mu.Lock()
+	n.opts.MaxPeers = opts.MaxPeers
+	n.opts.Clip.MaxFileSize = opts.Clip.MaxFileSize
+	n.opts.Notify = opts.Notify
+	n.opts.Notifier = opts.Notifier
+	n.opts.Peers = opts.Peers
+	n.opts.Policies = opts.Policies
+	n.opts.Upload = opts.Upload
+	n.opts.Download = opts.Download
+	n.opts.RateLimits = opts.RateLimits
+	n.opts.TTL = opts.TTL
+	n.mu.Unlock()
+
+	n.upload.SetRate(opts.Upload)
+	n.download.SetRate(opts.Download)
+
+	n.peers.Tap(func(_ domain.NodeID, p *peer.Peer) bool {
+		p.SetMaxReceiveSize(uint64(opts.Clip.MaxFileSize))
+		p.SetPolicy(opts.Policies.For(p.MetaData()))
+		limit := opts.RateLimits.For(p.MetaData())
+		p.SetRateLimit(limit.Upload, limit.Download)
+		return true
+	})
+
+	ctxLog.Info().
+		Int("max_peers", opts.MaxPeers).
+		Stringer("max_file_size", opts.Clip.MaxFileSize).
+		Bool("notify", opts.Notify).
+		Strs("peers", opts.Peers).
+		Strs("policies", opts.Policies.GetSlice()).
+		Stringer("upload_limit", opts.Upload).
+		Stringer("download_limit", opts.Download).
+		Strs("peer_rate_limits", opts.RateLimits.GetSlice()).
+		Stringer("ttl", opts.TTL).
+		Msg("options reloaded")
+
+	n.static.Set(ctx, opts.Peers)
+}
+
+// live returns the current options, including the ones changed by Reload
+func (n *Node) live() Options {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.opts
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+// routeMaxAge is how long a route is kept after the last announcement it was learned from
+const routeMaxAge = 30 * time.Minute
+
+// Route is the path to a device learned from the announcements it originated
+type Route struct {
+	Origin domain.NodeID
+	// Via is the connected peer the announcements came from
+	Via  domain.NodeID
+	Hops uint32
+	Seen time.Time
+}
+
+// Routes keeps the shortest known path to every device that announced something,
+// announcements relayed with a hop count double as route advertisements
+type Routes struct {
+	// exists reports whether a peer is still connected, routes through a gone peer are dropped
+	exists func(id domain.NodeID) bool
+
+	mu     sync.Mutex
+	routes map[domain.NodeID]Route
+}
+
+func newRoutes(exists func(domain.NodeID) bool) *Routes {
+	return &Routes{
+		exists: exists,
+		routes: make(map[domain.NodeID]Route),
+	}
+}
+
+// Learn records that origin is hops away through the peer via,
+// a shorter path replaces the known one, so does any path once the known one is stale
+func (r *Routes) Learn(origin, via domain.NodeID, hops uint32, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	known, ok := r.routes[origin]
+	if ok && known.Via != via && known.Hops < hops && !r.stale(known, now) {
+		return
+	}
+
+	r.routes[origin] = Route{Origin: origin, Via: via, Hops: hops, Seen: now}
+}
+
+// List returns the live routes ordered by distance
+func (r *Routes) List(now time.Time) []Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]Route, 0, len(r.routes))
+	for origin, route := range r.routes {
+		if r.stale(route, now) {
+			delete(r.routes, origin)
+			continue
+		}
+		res = append(res, route)
+	}
+
+	slices.SortFunc(res, func(a, b Route) int {
+		return cmp.Or(cmp.Compare(a.Hops, b.Hops), cmp.Compare(a.Origin, b.Origin))
+	})
+
+	return res
+}
+
+func (r *Routes) stale(route Route, now time.Time) bool {
+	return now.Sub(route.Seen) > routeMaxAge || (r.exists != nil && !r.exists(route.Via))
+}
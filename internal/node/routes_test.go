@@ -0,0 +1,36 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+func TestRoutes_Learn(t *testing.T) {
+	connected := map[domain.NodeID]bool{2: true, 3: true}
+	r := newRoutes(func(id domain.NodeID) bool { return connected[id] })
+	now := time.Now()
+
+	r.Learn(9, 2, 3, now)
+	r.Learn(9, 3, 4, now)
+	if got := r.List(now); len(got) != 1 || got[0].Via != 2 || got[0].Hops != 3 {
+		t.Fatalf("longer path replaced the shorter one: %+v", got)
+	}
+
+	r.Learn(9, 3, 2, now)
+	if got := r.List(now); got[0].Via != 3 || got[0].Hops != 2 {
+		t.Fatalf("shorter path was not taken: %+v", got)
+	}
+
+	// the known path is gone with its peer, the next announcement wins
+	connected[3] = false
+	r.Learn(9, 2, 5, now)
+	if got := r.List(now); len(got) != 1 || got[0].Via != 2 {
+		t.Fatalf("path through a disconnected peer was kept: %+v", got)
+	}
+
+	if got := r.List(now.Add(routeMaxAge + time.Second)); len(got) != 0 {
+		t.Fatalf("stale route was listed: %+v", got)
+	}
+}
@@ -0,0 +1,203 @@
+package node
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+	"github.com/rs/zerolog"
+)
+
+type PeerState string
+
+const (
+	StateConnecting PeerState = "connecting"
+	StateConnected  PeerState = "connected"
+	StateBackoff    PeerState = "backoff"
+)
+
+// PeerStatus is the state of a configured address
+type PeerStatus struct {
+	Addr     string
+	State    PeerState
+	Attempts int
+	// Retry is the time of the next dial while in backoff
+	Retry   time.Time
+	LastErr error
+}
+
+type connectFunc func(ctx context.Context, addr string, ready readyFunc) error
+
+// Supervisor keeps connections to the configured addresses,
+// redialing them with exponential backoff when they drop
+type Supervisor struct {
+	connect connectFunc
+	// exists reports whether the peer is connected by any means, e.g. dialed us itself
+	exists func(id domain.NodeID) bool
+	opts   ReconnectOptions
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	peers map[string]*supervised
+}
+
+type supervised struct {
+	cancel context.CancelFunc
+	status PeerStatus
+}
+
+func newSupervisor(connect connectFunc, exists func(domain.NodeID) bool, opts ReconnectOptions, logger zerolog.Logger) *Supervisor {
+	return &Supervisor{
+		connect: connect,
+		exists:  exists,
+		opts:    opts,
+		logger:  logger,
+		peers:   make(map[string]*supervised),
+	}
+}
+
+// Set starts supervising new addresses and stops the ones missing from addrs.
+// Supervision lasts until ctx is done
+func (s *Supervisor) Set(ctx context.Context, addrs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr, sp := range s.peers {
+		if !slices.Contains(addrs, addr) {
+			sp.cancel()
+			delete(s.peers, addr)
+		}
+	}
+
+	for _, addr := range addrs {
+		if _, ok := s.peers[addr]; ok {
+			continue
+		}
+
+		peerCtx, cancel := context.WithCancel(ctx)
+		sp := &supervised{
+			cancel: cancel,
+			status: PeerStatus{Addr: addr, State: StateConnecting},
+		}
+		s.peers[addr] = sp
+		go s.run(peerCtx, sp)
+	}
+}
+
+// Status returns the state of every supervised address sorted by address
+func (s *Supervisor) Status() []PeerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]PeerStatus, 0, len(s.peers))
+	for _, sp := range s.peers {
+		res = append(res, sp.status)
+	}
+	slices.SortFunc(res, func(a, b PeerStatus) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+
+	return res
+}
+
+func (s *Supervisor) run(ctx context.Context, sp *supervised) {
+	addr := sp.status.Addr
+	ctxLog := ctxlog.Op(s.logger, "node.Supervisor").
+		With().
+		Str("addr", addr).
+		Logger()
+
+	var (
+		attempts int
+		known    domain.NodeID
+	)
+
+	for {
+		if known != 0 && s.exists(known) {
+			// the peer replaced our connection with its own, watch it instead of dialing
+			s.update(sp, PeerStatus{State: StateConnected})
+			if !sleep(ctx, s.opts.MinDelay) {
+				return
+			}
+			continue
+		}
+
+		s.update(sp, PeerStatus{State: StateConnecting, Attempts: attempts})
+
+		var readyErr error
+		connected := false
+		err := s.connect(ctx, addr, func(id domain.NodeID, err error) {
+			if err != nil {
+				readyErr = err
+				return
+			}
+
+			connected, known, attempts = true, id, 0
+			s.update(sp, PeerStatus{State: StateConnected})
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			err = readyErr
+		}
+
+		attempts++
+		delay := s.delay(attempts)
+		s.update(sp, PeerStatus{
+			State:    StateBackoff,
+			Attempts: attempts,
+			Retry:    time.Now().Add(delay),
+			LastErr:  err,
+		})
+
+		if connected {
+			ctxLog.Info().Err(err).Str("retry_in", delay.String()).Msg("connection lost, redialing")
+		} else {
+			ctxLog.Trace().Err(err).Int("attempts", attempts).Str("retry_in", delay.String()).Msg("dial failed")
+		}
+
+		if !sleep(ctx, delay) {
+			return
+		}
+	}
+}
+
+func (s *Supervisor) update(sp *supervised, status PeerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status.Addr = sp.status.Addr
+	sp.status = status
+}
+
+// delay doubles with every attempt up to MaxDelay, with ±20% jitter
+// so that peers restarted together do not redial in lockstep
+func (s *Supervisor) delay(attempts int) time.Duration {
+	d := s.opts.MinDelay
+	for i := 1; i < attempts && d < s.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, s.opts.MaxDelay)
+
+	jitter := time.Duration(rand.Int64N(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
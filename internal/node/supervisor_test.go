@@ -0,0 +1,115 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/rs/zerolog"
+)
+
+func waitState(t *testing.T, s *Supervisor, addr string, want PeerState) PeerStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, st := range s.Status() {
+			if st.Addr == addr && st.State == want {
+				return st
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s never reached %s, status %+v", addr, want, s.Status())
+	return PeerStatus{}
+}
+
+func TestSupervisor_Redial(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+		drop  = make(chan struct{})
+	)
+
+	connect := func(ctx context.Context, _ string, ready readyFunc) error {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+
+		if n <= 2 {
+			notifyReady(ready, 0, errors.New("refused"))
+			return nil
+		}
+
+		notifyReady(ready, 1, nil)
+		select {
+		case <-drop:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	opts := ReconnectOptions{MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	s := newSupervisor(connect, func(domain.NodeID) bool { return false }, opts, zerolog.Nop())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	s.Set(ctx, []string{"10.0.0.2:7000"})
+
+	st := waitState(t, s, "10.0.0.2:7000", StateConnected)
+	if st.Attempts != 0 {
+		t.Fatalf("attempts after connect = %d, want reset to 0", st.Attempts)
+	}
+
+	close(drop)
+	st = waitState(t, s, "10.0.0.2:7000", StateBackoff)
+	if st.Attempts != 1 {
+		t.Fatalf("attempts after drop = %d, want 1", st.Attempts)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls < 3 {
+		t.Fatalf("calls = %d, want at least 3", calls)
+	}
+}
+
+func TestSupervisor_SetRemoves(t *testing.T) {
+	stopped := make(chan struct{})
+	connect := func(ctx context.Context, _ string, ready readyFunc) error {
+		notifyReady(ready, 1, nil)
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	}
+
+	s := newSupervisor(connect, func(domain.NodeID) bool { return false }, ReconnectOptions{MinDelay: time.Millisecond, MaxDelay: time.Millisecond}, zerolog.Nop())
+	s.Set(t.Context(), []string{"a:1"})
+	waitState(t, s, "a:1", StateConnected)
+
+	s.Set(t.Context(), nil)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("removed address was not disconnected")
+	}
+	if len(s.Status()) != 0 {
+		t.Fatalf("status = %+v", s.Status())
+	}
+}
+
+func TestSupervisor_Delay(t *testing.T) {
+	s := newSupervisor(nil, nil, ReconnectOptions{MinDelay: time.Second, MaxDelay: 10 * time.Second}, zerolog.Nop())
+
+	for attempts, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 10 * time.Second} {
+		for range 100 {
+			d := s.delay(attempts)
+			if d < base-base/5 || d > base+base/5 {
+				t.Fatalf("delay(%d) = %s, want %s ±20%%", attempts, d, base)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/control"
+	"github.com/labi-le/belphegor/internal/transfer"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+const (
+	// notifySize is the size from which the start and end of a transfer are notified
+	notifySize = 16 << 20
+	// watchBuffer is how many transfer events a slow watcher may lag behind before it misses some
+	watchBuffer = 64
+)
+
+func (n *Node) Transfers() []control.Transfer {
+	res := []control.Transfer{}
+	for _, t := range n.opts.Transfers.List() {
+		res = append(res, control.TransferFrom(t))
+	}
+
+	return res
+}
+
+func (n *Node) WatchTransfers(ctx context.Context) <-chan control.Transfer {
+	ch := make(chan control.Transfer, watchBuffer)
+	unsubscribe := n.opts.Transfers.Subscribe(func(t transfer.Transfer) {
+		select {
+		case ch <- control.TransferFrom(t):
+		default:
+		}
+	})
+
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+
+	return ch
+}
+
+func (n *Node) CancelTransfer(id domain.MessageID, peer domain.NodeID) error {
+	err := n.opts.Transfers.Cancel(id, peer)
+	if errors.Is(err, transfer.ErrNotFound) {
+		return control.ErrTransferNotFound
+	}
+
+	return err
+}
+
+// notifyTransfer tells about large transfers when they start and end, the tray shows how they go
+func (n *Node) notifyTransfer(t transfer.Transfer) {
+	if t.Size < notifySize {
+		return
+	}
+
+	what := fmt.Sprintf("%s (%s)", t.Name, humanize.Bytes(t.Size))
+	if t.Direction == transfer.Download {
+		what += " from " + t.PeerName
+	} else {
+		what += " to " + t.PeerName
+	}
+
+	switch t.State {
+	case transfer.Started:
+		n.Notify("%s %s", t.Direction, what)
+	case transfer.Done:
+		n.Notify("%s done: %s in %s", t.Direction, what, time.Since(t.Started).Round(time.Second))
+	case transfer.Canceled:
+		n.Notify("%s canceled: %s", t.Direction, what)
+	case transfer.Failed:
+		n.Notify("%s failed: %s", t.Direction, what)
+	}
+}
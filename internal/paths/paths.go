@@ -0,0 +1,47 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const appName = "belphegor"
+
+// StateDir returns the per-user directory for data that must survive restarts
+// ($XDG_STATE_HOME/belphegor on linux, the user config dir elsewhere)
+func StateDir() string {
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return filepath.Join(dir, appName)
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "state", appName)
+		}
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, appName)
+	}
+
+	return filepath.Join(os.TempDir(), appName)
+}
+
+// ControlSocket returns the default path of the local control socket
+func ControlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, appName+".sock")
+	}
+
+	return filepath.Join(os.TempDir(), appName+".sock")
+}
+
+// ConfigFile returns the default path of the daemon config file
+// ($XDG_CONFIG_HOME/belphegor/config.toml on linux)
+func ConfigFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, appName, "config.toml")
+	}
+
+	return filepath.Join(os.TempDir(), appName, "config.toml")
+}
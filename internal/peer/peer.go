@@ -2,6 +2,7 @@ package peer
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -9,18 +10,36 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/cespare/xxhash"
+	"github.com/labi-le/belphegor/internal/bandwidth"
 	"github.com/labi-le/belphegor/internal/channel"
+	"github.com/labi-le/belphegor/internal/policy"
 	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/security"
 	"github.com/labi-le/belphegor/internal/store"
+	"github.com/labi-le/belphegor/internal/transfer"
 	"github.com/labi-le/belphegor/internal/transport"
 	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/archive"
 	"github.com/labi-le/belphegor/pkg/ctxlog"
 	"github.com/labi-le/belphegor/pkg/network"
 	"github.com/rs/zerolog"
 )
 
+// maxYield bounds how long a bulk write waits for the urgent ones when there is no write deadline
+const maxYield = time.Second
+
+// Envelope seals payloads end to end for the devices that may read them,
+// so that a relay forwards content it cannot read
+type Envelope interface {
+	Seal(dst io.Writer, size uint64) (w io.WriteCloser, sealedSize uint64, err error)
+	// Open returns security.ErrNotRecipient with the whole sealed payload when it is not for us
+	Open(src io.Reader) (io.Reader, error)
+}
+
 type Options struct {
 	Channel        *channel.Channel
 	Store          store.FileWriter
@@ -28,6 +47,13 @@ type Options struct {
 	Deadline       network.Deadline
 	MaxReceiveSize uint64
 	Batches        *channel.BatchCollector
+	Policy         policy.Rule
+	// Envelope is optional, without it payloads are protected by the transport only
+	Envelope Envelope
+	// Transfers tracks the payloads streamed with the peer, a private one is used without it
+	Transfers *transfer.Manager
+	// Shaper limits and orders the traffic with the peer, without it only the order is kept
+	Shaper *bandwidth.Shaper
 }
 
 type Peer struct {
@@ -39,8 +65,12 @@ type Peer struct {
 	deadline   network.Deadline
 
 	fileWriter     store.FileWriter
-	maxReceiveSize uint64
+	maxReceiveSize atomic.Uint64
 	batches        *channel.BatchCollector
+	policy         atomic.Pointer[policy.Rule]
+	envelope       Envelope
+	transfers      *transfer.Manager
+	shaper         *bandwidth.Shaper
 }
 
 func New(
@@ -48,17 +78,48 @@ func New(
 	metadata domain.Device,
 	opts Options,
 ) *Peer {
-	return &Peer{
-		conn:           conn,
-		metaData:       metadata,
-		channel:        opts.Channel,
-		fileWriter:     opts.Store,
-		logger:         opts.Logger,
-		deadline:       opts.Deadline,
-		stringRepr:     fmt.Sprintf("%s -> %s", metadata.Name, conn.RemoteAddr().String()),
-		maxReceiveSize: opts.MaxReceiveSize,
-		batches:        opts.Batches,
+	p := &Peer{
+		conn:       conn,
+		metaData:   metadata,
+		channel:    opts.Channel,
+		fileWriter: opts.Store,
+		logger:     opts.Logger,
+		deadline:   opts.Deadline,
+		stringRepr: fmt.Sprintf("%s -> %s", metadata.Name, conn.RemoteAddr().String()),
+		batches:    opts.Batches,
+		envelope:   opts.Envelope,
+		transfers:  opts.Transfers,
+		shaper:     opts.Shaper,
 	}
+	if p.transfers == nil {
+		p.transfers = transfer.NewManager()
+	}
+	if p.shaper == nil {
+		p.shaper = bandwidth.NewShaper(0, 0, nil, nil)
+	}
+	p.maxReceiveSize.Store(opts.MaxReceiveSize)
+	p.SetPolicy(opts.Policy)
+
+	return p
+}
+
+// SetMaxReceiveSize changes the limit for messages received after the call
+func (p *Peer) SetMaxReceiveSize(size uint64) {
+	p.maxReceiveSize.Store(size)
+}
+
+// SetPolicy changes what may be exchanged with the peer from now on
+func (p *Peer) SetPolicy(rule policy.Rule) {
+	p.policy.Store(&rule)
+}
+
+// SetRateLimit changes the limits of the traffic with the peer, 0 is unlimited
+func (p *Peer) SetRateLimit(upload, download bandwidth.Rate) {
+	p.shaper.SetRates(upload, download)
+}
+
+func (p *Peer) Policy() policy.Rule {
+	return *p.policy.Load()
 }
 
 func (p *Peer) MetaData() domain.Device { return p.metaData }
@@ -142,31 +203,235 @@ func (p *Peer) WriteContext(ctx context.Context, meta domain.AnyEvent, raw io.Re
 		return fmt.Errorf("open stream: %w", err)
 	}
 
+	msg, isMsg := meta.(domain.EventMessage)
 	stream := &deadlineStream{
 		stream: rawStream,
 		read:   p.deadline.Read,
 		write:  p.deadline.Write,
+		shaper: p.shaper,
+		bulk:   isMsg && raw != nil && bulk(msg.Payload),
 	}
 	defer stream.Close()
 
+	if !stream.bulk {
+		// bulk transfers on the connection pause until this is sent
+		defer p.shaper.Urgent()()
+	}
+
+	if !isMsg || raw == nil {
+		return p.writeStream(stream, meta, raw)
+	}
+
+	prog := p.transfers.Start(p.transferOf(msg.Payload, transfer.Upload), stream.Reset)
+	err = p.writeStream(stream, meta, prog.Reader(raw))
+	prog.Finish(err)
+
+	return err
+}
+
+// writeStream writes meta to stream and raw after it, sealed and split into chunks as msg needs
+func (p *Peer) writeStream(stream *deadlineStream, meta domain.AnyEvent, raw io.Reader) error {
+	var (
+		body    io.Writer = stream
+		sealed  io.WriteCloser
+		fields  []byte
+		chunked io.WriteCloser
+
+		msg, isMsg = meta.(domain.EventMessage)
+		// files are split into chunks inside the seal, so a relay keeps them as they are
+		chunk = isMsg && raw != nil && chunkedBody(msg.Payload)
+	)
+	if ann, isAnn := meta.(domain.EventAnnounce); isAnn && p.envelope != nil && ann.Payload.Sealed == nil && ann.Payload.ContentHash != 0 {
+		var err error
+		if meta, err = p.sealAnnounce(ann); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+	}
+	// payloads kept sealed for other devices are forwarded as they are
+	if isMsg && raw != nil && p.envelope != nil && !msg.Payload.Sealed {
+		var (
+			size uint64
+			err  error
+		)
+		if fields, err = protocol.EncodeSealedFields(msg.Payload); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+		if sealed, size, err = p.envelope.Seal(stream, uint64(len(fields))+bodySize(msg.Payload)); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+
+		msg.Payload = msg.Payload.Concealed()
+		msg.Payload.Sealed, msg.Payload.SealedLength = true, size
+		meta, body = msg, sealed
+	}
+	if chunk {
+		chunked = protocol.NewChunkWriter(body)
+		body = chunked
+	}
+
 	if err := protocol.WriteEvent(stream, meta); err != nil {
 		return fmt.Errorf("write event: %w", err)
 	}
 
+	if fields != nil {
+		if _, err := sealed.Write(fields); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+	}
+
 	if raw != nil {
-		if _, err := io.Copy(stream, raw); err != nil {
+		if _, err := io.Copy(body, raw); err != nil {
 			return fmt.Errorf("write raw: %w", err)
 		}
 	}
 
+	if chunked != nil {
+		if err := chunked.Close(); err != nil {
+			return fmt.Errorf("write raw: %w", err)
+		}
+	}
+
+	if sealed != nil {
+		if err := sealed.Close(); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// sealAnnounce replaces what a relay must not learn of ann with its sealed fields
+func (p *Peer) sealAnnounce(ann domain.EventAnnounce) (domain.EventAnnounce, error) {
+	fields, err := protocol.EncodeSealedFields(domain.Message{
+		Name:          ann.Payload.Name,
+		ContentLength: ann.Payload.ContentLength,
+		ContentHash:   ann.Payload.ContentHash,
+	})
+	if err != nil {
+		return ann, err
+	}
+
+	var buf bytes.Buffer
+	w, _, err := p.envelope.Seal(&buf, uint64(len(fields)))
+	if err != nil {
+		return ann, err
+	}
+	if _, err := w.Write(fields); err != nil {
+		return ann, err
+	}
+	if err := w.Close(); err != nil {
+		return ann, err
+	}
+
+	ann.Payload = ann.Payload.Concealed()
+	ann.Payload.Sealed = buf.Bytes()
+	return ann, nil
+}
+
+// openAnnounce fills in the sealed fields of ann, it is returned as it is when it is sealed for other devices
+func (p *Peer) openAnnounce(ann domain.EventAnnounce) (domain.EventAnnounce, error) {
+	opened, err := p.envelope.Open(bytes.NewReader(ann.Payload.Sealed))
+	if errors.Is(err, security.ErrNotRecipient) {
+		return ann, nil
+	}
+	if err != nil {
+		return ann, err
+	}
+
+	var fields domain.Message
+	if err := protocol.ReadSealedFields(opened, &fields); err != nil {
+		return ann, err
+	}
+	if err := sealedEnd(opened); err != nil {
+		return ann, err
+	}
+
+	ann.Payload.Name, ann.Payload.ContentLength, ann.Payload.ContentHash = fields.Name, fields.ContentLength, fields.ContentHash
+	ann.Payload.Sealed = nil
+	return ann, nil
+}
+
+// sealedEnd reads the rest of an opened payload, which must be empty:
+// the signature of its sealer is checked at the end
+func sealedEnd(opened io.Reader) error {
+	rest, err := io.Copy(io.Discard, opened)
+	if err != nil {
+		return err
+	}
+	if rest > 0 {
+		return fmt.Errorf("%w: %d bytes after the content", security.ErrBadEnvelope, rest)
+	}
+	return nil
+}
+
+// untilSealedEnd ends with r only once the opened payload r is read from ends too
+type untilSealedEnd struct {
+	io.Reader
+	opened io.Reader
+}
+
+func (u untilSealedEnd) Read(p []byte) (int, error) {
+	n, err := u.Reader.Read(p)
+	if errors.Is(err, io.EOF) {
+		if endErr := sealedEnd(u.opened); endErr != nil {
+			return n, endErr
+		}
+	}
+	return n, err
+}
+
+// transferOf describes the transfer of msg with the peer, sealed payloads are counted as they are sent
+func (p *Peer) transferOf(msg domain.Message, direction transfer.Direction) transfer.Transfer {
+	t := transfer.Transfer{
+		ID:        msg.ID,
+		Peer:      p.metaData.UniqueID(),
+		PeerName:  p.metaData.Name,
+		Name:      msg.Name,
+		Direction: direction,
+		Size:      msg.ContentLength,
+		Done:      msg.Offset,
+	}
+	if msg.Sealed {
+		t.Size, t.Done = msg.SealedLength, 0
+	}
+	if t.Name == "" {
+		t.Name = msg.MimeType.String()
+	}
+
+	return t
+}
+
+// bulk is true for the payloads smaller messages overtake
+func bulk(msg domain.Message) bool {
+	size := bodySize(msg)
+	if msg.Sealed {
+		size = msg.SealedLength
+	}
+
+	return size >= bandwidth.BulkSize
+}
+
+// chunkedBody is true when the raw stream of msg is split into chunks:
+// it carries a file in the clear, not one kept sealed for other devices
+func chunkedBody(msg domain.Message) bool {
+	return msg.MimeType.IsPath() && !msg.Sealed
+}
+
+// bodySize is the length of the raw stream of msg before it is sealed
+func bodySize(msg domain.Message) uint64 {
+	size := msg.ContentLength - msg.Offset
+	if msg.MimeType.IsPath() {
+		return protocol.ChunkedSize(size)
+	}
+	return size
+}
+
 func (p *Peer) handleStream(ctx context.Context, rawStream transport.Stream) error {
 	stream := &deadlineStream{
 		stream: rawStream,
 		read:   p.deadline.Read,
 		write:  p.deadline.Write,
+		shaper: p.shaper,
 	}
 	defer stream.Close()
 
@@ -177,8 +442,18 @@ func (p *Peer) handleStream(ctx context.Context, rawStream transport.Stream) err
 
 	switch payload := event.(type) {
 	case domain.EventMessage:
-		return p.handleMessage(payload, stream)
+		stream.bulk = bulk(payload.Payload)
+		payload.Via = p.metaData.UniqueID()
+		payload.Payload.Hops++
+		return p.handleMessage(ctx, payload, stream)
 	case domain.EventAnnounce:
+		if payload.Payload.Sealed != nil && p.envelope != nil {
+			if payload, err = p.openAnnounce(payload); err != nil {
+				return fmt.Errorf("open sealed announce: %w", err)
+			}
+		}
+		payload.Via = p.metaData.UniqueID()
+		payload.Payload.Hops++
 		p.channel.Announce(payload)
 		return nil
 
@@ -204,37 +479,105 @@ func (p *Peer) sendNack(msg domain.Message) {
 	}
 }
 
-func (p *Peer) handleMessage(msg domain.EventMessage, stream transport.Stream) error {
-	if msg.Payload.ContentLength > p.maxReceiveSize {
+func (p *Peer) handleMessage(ctx context.Context, msg domain.EventMessage, stream transport.Stream) (err error) {
+	if !p.Policy().CanReceive(msg.Payload.MimeType) {
+		p.sendNack(msg.Payload)
+		_ = stream.Reset()
+		return fmt.Errorf("%s from %s is not allowed by policy", msg.Payload.MimeType, p.metaData.Name)
+	}
+
+	limit := p.maxReceiveSize.Load()
+
+	var (
+		body   io.Reader = stream
+		opened io.Reader
+	)
+	if msg.Payload.Sealed {
+		// the size of the content is sealed with it, only the sealed size is known before it is opened
+		if msg.Payload.SealedLength > security.MaxSealedSize(protocol.ChunkedSize(limit)+protocol.MaxSealedFieldsSize) {
+			p.sendNack(msg.Payload)
+			return fmt.Errorf("sealed size exceeds limit: %d", msg.Payload.SealedLength)
+		}
+		if p.envelope == nil {
+			return p.keepSealed(msg, stream, stream)
+		}
+
+		var err error
+		opened, err = p.envelope.Open(stream)
+		if err == nil {
+			err = protocol.ReadSealedFields(opened, &msg.Payload)
+		}
+		switch {
+		case errors.Is(err, security.ErrNotRecipient):
+			return p.keepSealed(msg, opened, stream)
+		case err != nil:
+			p.sendNack(msg.Payload)
+			_ = stream.Reset()
+			return fmt.Errorf("open sealed payload: %w", err)
+		}
+
+		body = opened
+		msg.Payload.Sealed, msg.Payload.SealedLength = false, 0
+	}
+
+	if msg.Payload.ContentLength > limit {
 		p.sendNack(msg.Payload)
 		return fmt.Errorf(
 			"message size exceeds limit: %d > %d",
 			msg.Payload.ContentLength,
-			p.maxReceiveSize,
+			limit,
 		)
 	}
 
+	if msg.Payload.Offset > msg.Payload.ContentLength {
+		p.sendNack(msg.Payload)
+		return fmt.Errorf("offset exceeds size: %d > %d", msg.Payload.Offset, msg.Payload.ContentLength)
+	}
+
+	prog := p.transfers.Start(p.transferOf(msg.Payload, transfer.Download), stream.Reset)
+	defer func() { prog.Finish(err) }()
+
 	if msg.Payload.MimeType.IsPath() {
-		filePath, err := p.fileWriter.Write(stream, msg.Payload)
+		var chunks io.Reader = protocol.NewChunkReader(body, msg.Payload.ContentLength-msg.Payload.Offset)
+		if opened != nil {
+			chunks = untilSealedEnd{Reader: chunks, opened: opened}
+		}
+		filePath, err := p.fileWriter.Write(prog.Reader(chunks), msg.Payload)
 		if errors.Is(err, store.ErrFileExists) {
 			_ = stream.Reset()
 		} else if err != nil {
-			p.sendNack(msg.Payload)
-			return err
+			_ = stream.Reset()
+			// cancelled on either side, there is nothing to resume
+			if prog.Canceled() || errors.Is(err, transport.ErrStreamCanceled) {
+				p.giveUp(msg)
+				return fmt.Errorf("receive %s: %w", msg.Payload.Name, transfer.ErrCanceled)
+			}
+
+			prog.Finish(err)
+			return p.resume(ctx, msg, err)
 		}
 
 		msg.Payload.Data = []byte(filePath)
+		msg.Payload.Offset = 0
 	} else {
 		data := make([]byte, msg.Payload.ContentLength)
 
-		if _, err := io.ReadFull(stream, data); err != nil {
+		if _, err := io.ReadFull(prog.Reader(body), data); err != nil {
 			p.sendNack(msg.Payload)
 			return fmt.Errorf("read raw data: %w", err)
 		}
+		if opened != nil {
+			if err := sealedEnd(opened); err != nil {
+				p.sendNack(msg.Payload)
+				return fmt.Errorf("read raw data: %w", err)
+			}
+		}
 
 		msg.Payload.Data = data
 	}
 
+	msg.Payload.TTL = p.Policy().Expiry(msg.Payload.TTL)
+
 	p.logger.Trace().
 		Object("msg", msg.Payload).
 		Msg("received message")
@@ -244,8 +587,66 @@ func (p *Peer) handleMessage(msg domain.EventMessage, stream transport.Stream) e
 	return nil
 }
 
-func (p *Peer) RequestMessage(ctx context.Context, id domain.MessageID) error {
-	return p.WriteContext(ctx, domain.NewRequest(id), nil)
+// resume requests the rest of a file whose transfer broke, as long as every attempt gets further.
+// Otherwise the file is given up, so that it is requested again when it is announced again
+func (p *Peer) resume(ctx context.Context, msg domain.EventMessage, cause error) error {
+	offset := p.fileWriter.Offset(msg.Payload)
+	if offset <= msg.Payload.Offset || offset >= msg.Payload.ContentLength {
+		p.giveUp(msg)
+		return cause
+	}
+
+	if err := p.RequestMessage(ctx, msg.Payload.ID, offset); err != nil {
+		p.giveUp(msg)
+		return errors.Join(cause, err)
+	}
+
+	p.logger.Debug().
+		Err(cause).
+		Object("msg", msg.Payload).
+		Uint64("offset", offset).
+		Msg("transfer broke, resuming")
+
+	return nil
+}
+
+func (p *Peer) giveUp(msg domain.EventMessage) {
+	p.sendNack(msg.Payload)
+	p.channel.Forget(domain.EventAnnounce{From: msg.From, Payload: msg.Payload.Announce()})
+}
+
+// keepSealed stores a payload sealed for other devices as it is,
+// so that it can be served to the peers that request it
+func (p *Peer) keepSealed(msg domain.EventMessage, sealed io.Reader, stream transport.Stream) (err error) {
+	prog := p.transfers.Start(p.transferOf(msg.Payload, transfer.Download), stream.Reset)
+	defer func() { prog.Finish(err) }()
+
+	path, err := p.fileWriter.Write(prog.Reader(sealed), domain.Message{
+		ID:            msg.Payload.ID,
+		BatchID:       msg.Payload.BatchID,
+		Name:          msg.Payload.ID.String() + ".sealed",
+		ContentLength: msg.Payload.SealedLength,
+	})
+	if err != nil && !errors.Is(err, store.ErrFileExists) {
+		p.sendNack(msg.Payload)
+		return fmt.Errorf("keep sealed payload: %w", err)
+	}
+
+	msg.Payload.Data = []byte(path)
+	msg.Payload.TTL = p.Policy().Expiry(msg.Payload.TTL)
+
+	p.logger.Trace().
+		Object("msg", msg.Payload).
+		Msg("received message sealed for other devices")
+
+	p.channel.Send(msg)
+
+	return nil
+}
+
+// RequestMessage asks the peer for a message, offset bytes of its content we already hold
+func (p *Peer) RequestMessage(ctx context.Context, id domain.MessageID, offset uint64) error {
+	return p.WriteContext(ctx, domain.NewRequest(id, offset), nil)
 }
 
 func (p *Peer) handleRequest(ctx context.Context, ev domain.EventMessage, req domain.EventRequest) error {
@@ -256,11 +657,31 @@ func (p *Peer) handleRequest(ctx context.Context, ev domain.EventMessage, req do
 		return nil
 	}
 
+	if !p.Policy().CanSend(ev.Payload.MimeType) {
+		ctxLog.Debug().Msg("not allowed by policy, ignoring request")
+		return nil
+	}
+
+	if p.transfers.Canceled(ev.Payload.ID) {
+		ctxLog.Debug().Msg("transfer was canceled, ignoring request")
+		return nil
+	}
+
 	ctxLog.Trace().Msg("sending")
 
+	ev.Payload.TTL = p.Policy().Expiry(ev.Payload.TTL)
+
 	var r io.Reader
 
-	if ev.Payload.MimeType.IsPath() {
+	if ev.Payload.Dir && !ev.Payload.Sealed {
+		fp := string(ev.Payload.Data)
+		tree, err := archiveAt(fp, &ev.Payload, req.Payload.Offset)
+		if err != nil {
+			return fmt.Errorf("failed to prepare directory for streaming %s: %w", fp, err)
+		}
+		defer tree.Close()
+		r = tree
+	} else if ev.Payload.MimeType.IsPath() || ev.Payload.Sealed {
 		fp := string(ev.Payload.Data)
 		file, err := os.Open(fp)
 		if err != nil {
@@ -268,6 +689,12 @@ func (p *Peer) handleRequest(ctx context.Context, ev domain.EventMessage, req do
 		}
 		defer file.Close()
 		r = file
+
+		if chunkedBody(ev.Payload) {
+			if r, err = resumeAt(file, &ev.Payload, req.Payload.Offset); err != nil {
+				return fmt.Errorf("failed to prepare file for streaming %s: %w", fp, err)
+			}
+		}
 	} else {
 		r = bytes.NewReader(ev.Payload.Data)
 	}
@@ -281,15 +708,74 @@ func (p *Peer) handleRequest(ctx context.Context, ev domain.EventMessage, req do
 	return err
 }
 
+// resumeAt sets the checksum of the file and the offset the requester holds it up to,
+// the returned reader yields the rest of its content
+func resumeAt(file *os.File, msg *domain.Message, offset uint64) (io.Reader, error) {
+	sum, err := protocol.Checksum(io.LimitReader(file, int64(msg.ContentLength)))
+	if err != nil {
+		return nil, err
+	}
+	msg.Checksum = sum
+
+	msg.Offset = 0
+	if offset < msg.ContentLength {
+		msg.Offset = offset
+	}
+	if _, err := file.Seek(int64(msg.Offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return io.LimitReader(file, int64(msg.ContentLength-msg.Offset)), nil
+}
+
+// archiveAt is resumeAt for a directory, its archive is built again for the checksum and for the stream
+func archiveAt(dir string, msg *domain.Message, offset uint64) (io.ReadCloser, error) {
+	sum := xxhash.New()
+	tree, err := archive.Write(sum, dir)
+	if err != nil {
+		return nil, err
+	}
+	if tree.Size != msg.ContentLength {
+		return nil, fmt.Errorf("%w: archive is %d bytes, %d announced", archive.ErrChanged, tree.Size, msg.ContentLength)
+	}
+	msg.Checksum = sum.Sum64()
+
+	msg.Offset = 0
+	if offset < msg.ContentLength {
+		msg.Offset = offset
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		_, err := archive.Write(w, dir)
+		_ = w.CloseWithError(err)
+	}()
+
+	if _, err := io.CopyN(io.Discard, r, int64(msg.Offset)); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
 type deadlineStream struct {
 	stream    transport.Stream
 	read      time.Duration
 	write     time.Duration
 	lastRead  time.Time
 	lastWrite time.Time
+
+	shaper *bandwidth.Shaper
+	// bulk streams are rate limited and yield to the others
+	bulk bool
 }
 
 func (s *deadlineStream) Read(p []byte) (int, error) {
+	if chunk := s.shaper.Chunk(false); s.bulk && chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+
 	if s.read > 0 {
 		now := time.Now()
 		if now.Sub(s.lastRead) > s.read/4 {
@@ -297,10 +783,40 @@ func (s *deadlineStream) Read(p []byte) (int, error) {
 			s.lastRead = now
 		}
 	}
-	return s.stream.Read(p)
+
+	n, err := s.stream.Read(p)
+	s.shaper.Pass(false, s.bulk, n)
+	return n, err
 }
 
 func (s *deadlineStream) Write(p []byte) (int, error) {
+	if !s.bulk {
+		s.shaper.Pass(true, false, len(p))
+		return s.writeNow(p)
+	}
+
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if chunk := s.shaper.Chunk(true); chunk > 0 {
+			n = min(n, chunk)
+		}
+
+		s.shaper.Yield(cmp.Or(s.write, maxYield))
+		s.shaper.Pass(true, true, n)
+
+		w, err := s.writeNow(p[:n])
+		written += w
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (s *deadlineStream) writeNow(p []byte) (int, error) {
 	if s.write > 0 {
 		now := time.Now()
 		if now.Sub(s.lastWrite) > s.write/4 {
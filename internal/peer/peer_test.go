@@ -0,0 +1,407 @@
+package peer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/channel"
+	"github.com/labi-le/belphegor/internal/peer"
+	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/store"
+	"github.com/labi-le/belphegor/internal/transfer"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/mem"
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/archive"
+	"github.com/labi-le/belphegor/pkg/mime"
+	"github.com/labi-le/belphegor/pkg/network"
+	"github.com/rs/zerolog"
+)
+
+var errBroken = errors.New("connection broke")
+
+// brokenOnce breaks the first write after limit bytes, the way a dropped connection does
+type brokenOnce struct {
+	*store.FileStore
+	limit int64
+
+	mu      sync.Mutex
+	broken  bool
+	offsets []uint64
+}
+
+func (b *brokenOnce) Write(r io.Reader, msg domain.Message) (string, error) {
+	b.mu.Lock()
+	b.offsets = append(b.offsets, msg.Offset)
+	if !b.broken {
+		b.broken = true
+		r = io.MultiReader(io.LimitReader(r, b.limit), brokenReader{})
+	}
+	b.mu.Unlock()
+
+	return b.FileStore.Write(r, msg)
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) { return 0, errBroken }
+
+func connPair(ctx context.Context, t *testing.T) (transport.Connection, transport.Connection) {
+	t.Helper()
+
+	network := mem.NewNetwork()
+	confA, _ := transporttest.TLSConfig(t, "a")
+	confB, _ := transporttest.TLSConfig(t, "b")
+
+	l, err := network.Transport(confB, time.Minute).Listen(ctx, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	accepted := make(chan transport.Connection, 1)
+	go func() {
+		conn, acceptErr := l.Accept(ctx)
+		if acceptErr == nil {
+			accepted <- conn
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	dialed, err := network.Transport(confA, time.Minute).Dial(ctx, net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case conn := <-accepted:
+		return dialed, conn
+	case <-ctx.Done():
+		t.Fatal("no connection accepted")
+		return nil, nil
+	}
+}
+
+// serving connects the peer holding msg, sending it with uploads, and the one receiving it into fw,
+// it returns the receiving side of the connection with the channel msg arrives on.
+// Each side seals and opens payloads with its envelope, if any
+func serving(
+	ctx context.Context,
+	t *testing.T,
+	msg domain.Message,
+	fw store.FileWriter,
+	uploads *transfer.Manager,
+	originEnv, receiverEnv peer.Envelope,
+) (*peer.Peer, *channel.Channel) {
+	t.Helper()
+
+	originConn, receiverConn := connPair(ctx, t)
+	opts := peer.Options{
+		Logger:         zerolog.Nop(),
+		Deadline:       network.Deadline{Read: time.Minute, Write: time.Minute},
+		MaxReceiveSize: 1 << 30,
+		Batches:        channel.NewBatchCollector(),
+	}
+
+	originCh := channel.New(1)
+	go func() { <-originCh.Messages() }()
+	originCh.Send(msg.Event())
+
+	originOpts, receiverOpts := opts, opts
+	originOpts.Channel, originOpts.Transfers, originOpts.Envelope = originCh, uploads, originEnv
+	receiverOpts.Channel, receiverOpts.Store, receiverOpts.Envelope = channel.New(1), fw, receiverEnv
+
+	// each side holds the other one as its peer
+	receiver := peer.New(originConn, domain.Device{Name: "receiver"}, originOpts)
+	origin := peer.New(receiverConn, domain.Device{Name: "origin"}, receiverOpts)
+	go func() { _ = receiver.Receive(ctx) }()
+	go func() { _ = origin.Receive(ctx) }()
+
+	return origin, receiverOpts.Channel
+}
+
+// fetch has msg requested from the peer holding it and returns it as received
+func fetch(ctx context.Context, t *testing.T, msg domain.Message, fw store.FileWriter) domain.Message {
+	t.Helper()
+
+	return fetchWith(ctx, t, msg, fw, nil, nil)
+}
+
+// fetchWith is fetch with the envelopes of the peer holding msg and of the receiver
+func fetchWith(ctx context.Context, t *testing.T, msg domain.Message, fw store.FileWriter, originEnv, receiverEnv peer.Envelope) domain.Message {
+	t.Helper()
+
+	origin, received := serving(ctx, t, msg, fw, nil, originEnv, receiverEnv)
+	if err := origin.RequestMessage(ctx, msg.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received.Messages():
+		return got.Payload
+	case <-ctx.Done():
+		t.Fatal("message never arrived")
+		return domain.Message{}
+	}
+}
+
+func TestPeer_ResumesBrokenTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := bytes.Repeat([]byte("belphegor"), (3*protocol.ChunkSize)/9+100)
+	src := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(src, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := &brokenOnce{FileStore: fs, limit: protocol.ChunkSize + 100}
+
+	got := fetch(ctx, t, domain.Message{
+		ID:            domain.NewMessageID(),
+		Data:          []byte(src),
+		Name:          "big.bin",
+		MimeType:      mime.TypePath,
+		ContentHash:   0xB16,
+		ContentLength: uint64(len(content)),
+	}, broken)
+
+	saved, err := os.ReadFile(string(got.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, content) {
+		t.Fatal("resumed file differs from the original")
+	}
+
+	broken.mu.Lock()
+	defer broken.mu.Unlock()
+	if len(broken.offsets) != 2 || broken.offsets[0] != 0 || broken.offsets[1] != protocol.ChunkSize+100 {
+		t.Fatalf("writes started at %v, want [0 %d]", broken.offsets, protocol.ChunkSize+100)
+	}
+}
+
+func TestPeer_ResumesDirectory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	src := filepath.Join(t.TempDir(), "album")
+	if err := os.MkdirAll(filepath.Join(src, "raw"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	photo := bytes.Repeat([]byte("pixel"), protocol.ChunkSize/5+1)
+	if err := os.WriteFile(filepath.Join(src, "raw", "photo.dng"), photo, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := archive.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the archive is built again for the resumed part
+	broken := &brokenOnce{FileStore: fs, limit: protocol.ChunkSize + 100}
+
+	got := fetch(ctx, t, domain.Message{
+		ID:            domain.NewMessageID(),
+		Data:          []byte(src),
+		Name:          "album",
+		MimeType:      mime.TypePath,
+		ContentHash:   0xA1B,
+		ContentLength: tree.Size,
+		Dir:           true,
+	}, broken)
+
+	if filepath.Base(string(got.Data)) != "album" {
+		t.Fatalf("saved to %s", got.Data)
+	}
+	saved, err := os.ReadFile(filepath.Join(string(got.Data), "raw", "photo.dng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, photo) {
+		t.Fatal("file in the directory differs from the original")
+	}
+	broken.mu.Lock()
+	defer broken.mu.Unlock()
+	if len(broken.offsets) != 2 || broken.offsets[1] != protocol.ChunkSize+100 {
+		t.Fatalf("writes started at %v, want [0 %d]", broken.offsets, protocol.ChunkSize+100)
+	}
+}
+
+func TestPeer_CancelTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := bytes.Repeat([]byte("belphegor"), protocol.ChunkSize)
+	src := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(src, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := domain.Message{
+		ID:            domain.NewMessageID(),
+		Data:          []byte(src),
+		Name:          "big.bin",
+		MimeType:      mime.TypePath,
+		ContentHash:   0xC0C,
+		ContentLength: uint64(len(content)),
+	}
+
+	uploads := transfer.NewManager()
+	finished := make(chan transfer.Transfer, 1)
+	uploads.Subscribe(func(tr transfer.Transfer) {
+		switch {
+		case tr.State == transfer.Started:
+			// the user cancels it before a byte is sent
+			_ = uploads.Cancel(tr.ID, 0)
+		case tr.Finished():
+			finished <- tr
+		}
+	})
+
+	origin, received := serving(ctx, t, msg, fs, uploads, nil, nil)
+	if err := origin.RequestMessage(ctx, msg.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case tr := <-finished:
+		if tr.State != transfer.Canceled || tr.Direction != transfer.Upload {
+			t.Fatalf("finished %+v, want a canceled upload", tr)
+		}
+	case <-ctx.Done():
+		t.Fatal("upload never finished")
+	}
+
+	// the receiver asks for the rest, a canceled transfer is not sent again
+	select {
+	case got := <-received.Messages():
+		t.Fatalf("canceled message arrived: %+v", got.Payload)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if !uploads.Canceled(msg.ID) {
+		t.Fatal("message not remembered as canceled")
+	}
+}
+
+// envelope seals payloads for the identities of peers and opens the ones they sealed
+type envelope struct {
+	id    security.Identity
+	peers []security.Identity
+}
+
+func (e envelope) Seal(dst io.Writer, size uint64) (io.WriteCloser, uint64, error) {
+	keys := make([]ed25519.PublicKey, 0, len(e.peers))
+	for _, p := range e.peers {
+		keys = append(keys, p.PublicKey())
+	}
+
+	w, err := e.id.Seal(dst, keys)
+	return w, security.SealedSize(size, len(keys)), err
+}
+
+func (e envelope) Open(src io.Reader) (io.Reader, error) {
+	return e.id.Open(src, e)
+}
+
+func (e envelope) Key(fp security.Fingerprint) ed25519.PublicKey {
+	for _, p := range e.peers {
+		if p.Fingerprint() == fp {
+			return p.PublicKey()
+		}
+	}
+	return nil
+}
+
+func newIdentity(t *testing.T) security.Identity {
+	t.Helper()
+
+	id, err := security.LoadIdentity(filepath.Join(t.TempDir(), "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPeer_SealedFields(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := bytes.Repeat([]byte("belphegor"), protocol.ChunkSize/9+100)
+	src := filepath.Join(t.TempDir(), "secret plans.txt")
+	if err := os.WriteFile(src, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	origin, recipient, relay := newIdentity(t), newIdentity(t), newIdentity(t)
+	msg := domain.Message{
+		ID:            domain.NewMessageID(),
+		Data:          []byte(src),
+		Name:          "secret plans.txt",
+		MimeType:      mime.TypePath,
+		ContentHash:   0x5EC,
+		ContentLength: uint64(len(content)),
+	}
+
+	t.Run("recipient", func(t *testing.T) {
+		fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := fetchWith(ctx, t, msg, fs, envelope{origin, []security.Identity{recipient}}, envelope{recipient, []security.Identity{origin}})
+		if got.Sealed || got.Name != msg.Name || got.ContentHash != msg.ContentHash || got.ContentLength != msg.ContentLength {
+			t.Fatalf("opened %+v, want the fields of the original", got)
+		}
+		saved, err := os.ReadFile(string(got.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved, content) {
+			t.Fatal("opened file differs from the original")
+		}
+	})
+
+	t.Run("relay", func(t *testing.T) {
+		fs, err := store.NewFileStore(t.TempDir(), zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := fetchWith(ctx, t, msg, fs, envelope{origin, []security.Identity{recipient}}, envelope{relay, []security.Identity{origin}})
+		if !got.Sealed || got.Name != "" || got.ContentHash != 0 || got.ContentLength != 0 {
+			t.Fatalf("relay learned %+v", got)
+		}
+		info, err := os.Stat(string(got.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(info.Size()) != got.SealedLength {
+			t.Fatalf("kept %d sealed bytes, want %d", info.Size(), got.SealedLength)
+		}
+	})
+}
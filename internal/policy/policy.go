@@ -0,0 +1,211 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+// Direction limits which way clipboard content flows between us and a peer
+type Direction string
+
+const (
+	Both Direction = "both"
+	// Send only gives our clipboard to the peer
+	Send Direction = "send"
+	// Receive only takes clipboard content from the peer
+	Receive Direction = "receive"
+)
+
+// Any matches every device
+const Any = "*"
+
+var ErrInvalid = errors.New("invalid policy")
+
+// Rule applies to the devices matching Match: a device name, a node id or Any
+type Rule struct {
+	Match     string
+	Direction Direction
+	// Mimes are the content types allowed in both directions, empty allows all
+	Mimes []mime.Type
+	// TTL clears content exchanged with the device from the clipboard after it, 0 keeps it
+	TTL time.Duration
+}
+
+// Default lets everything through, used for devices no rule matches
+var Default = Rule{Match: Any, Direction: Both}
+
+// Parse reads a rule written as match=direction[:mime,...][@ttl],
+// e.g. "work laptop=send:text", "*=receive" or "phone=both@30s"
+func Parse(s string) (Rule, error) {
+	match, spec, ok := strings.Cut(s, "=")
+	match = strings.TrimSpace(match)
+	if !ok || match == "" {
+		return Rule{}, fmt.Errorf("%w %q: expected match=direction[:mime,...][@ttl]", ErrInvalid, s)
+	}
+
+	spec, ttl, hasTTL := strings.Cut(spec, "@")
+	direction, mimes, _ := strings.Cut(spec, ":")
+
+	r := Rule{
+		Match:     match,
+		Direction: Direction(strings.ToLower(strings.TrimSpace(direction))),
+	}
+	switch r.Direction {
+	case Both, Send, Receive:
+	default:
+		return Rule{}, fmt.Errorf("%w %q: direction must be %s, %s or %s", ErrInvalid, s, Both, Send, Receive)
+	}
+
+	for name := range strings.SplitSeq(mimes, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		t, ok := mimeNames[name]
+		if !ok {
+			return Rule{}, fmt.Errorf("%w %q: unknown mime %q, expected text, image or file", ErrInvalid, s, name)
+		}
+		if !slices.Contains(r.Mimes, t) {
+			r.Mimes = append(r.Mimes, t)
+		}
+	}
+
+	if hasTTL {
+		d, err := time.ParseDuration(strings.TrimSpace(ttl))
+		if err != nil || d <= 0 {
+			return Rule{}, fmt.Errorf("%w %q: ttl must be a positive duration like 30s", ErrInvalid, s)
+		}
+		r.TTL = d
+	}
+
+	return r, nil
+}
+
+var mimeNames = map[string]mime.Type{
+	"text":  mime.TypeText,
+	"image": mime.TypeImage,
+	"file":  mime.TypePath,
+	"path":  mime.TypePath,
+}
+
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s=%s", r.Match, r.Direction)
+	if len(r.Mimes) > 0 {
+		names := make([]string, 0, len(r.Mimes))
+		for _, t := range r.Mimes {
+			names = append(names, t.String())
+		}
+		s += ":" + strings.Join(names, ",")
+	}
+	if r.TTL > 0 {
+		s += "@" + r.TTL.String()
+	}
+
+	return s
+}
+
+// Matches compares Match with the device name (case-insensitive) and node id
+func (r Rule) Matches(dev domain.Device) bool {
+	if r.Match == Any || strings.EqualFold(r.Match, dev.Name) {
+		return true
+	}
+
+	nid, err := strconv.ParseInt(r.Match, 10, 64)
+	return err == nil && domain.NodeID(nid) == dev.ID
+}
+
+// CanSend reports whether content of type t may be given to the peer
+func (r Rule) CanSend(t mime.Type) bool {
+	return r.Direction != Receive && r.allows(t)
+}
+
+// CanReceive reports whether content of type t may be taken from the peer
+func (r Rule) CanReceive(t mime.Type) bool {
+	return r.Direction != Send && r.allows(t)
+}
+
+// Expiry returns the shorter of ttl and the rule TTL, ignoring the unset one
+func (r Rule) Expiry(ttl time.Duration) time.Duration {
+	if r.TTL > 0 && (ttl <= 0 || r.TTL < ttl) {
+		return r.TTL
+	}
+
+	return ttl
+}
+
+func (r Rule) allows(t mime.Type) bool {
+	return len(r.Mimes) == 0 || slices.Contains(r.Mimes, t)
+}
+
+// Rules are checked in order, the first rule matching a device wins.
+// It is a pflag value, every --policy flag appends a rule
+type Rules []Rule
+
+// For returns the rule for dev, Default if none matches
+func (rs Rules) For(dev domain.Device) Rule {
+	for _, r := range rs {
+		if r.Matches(dev) {
+			return r
+		}
+	}
+
+	return Default
+}
+
+func (rs *Rules) String() string {
+	if len(*rs) == 0 {
+		return ""
+	}
+
+	return "[" + strings.Join(rs.GetSlice(), " ") + "]"
+}
+
+// Set appends a rule, the value is not split on commas, they separate mimes
+func (rs *Rules) Set(s string) error {
+	return rs.Append(s)
+}
+
+func (rs *Rules) Type() string {
+	return "policy"
+}
+
+func (rs *Rules) Append(s string) error {
+	r, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*rs = append(*rs, r)
+	return nil
+}
+
+func (rs *Rules) Replace(ss []string) error {
+	parsed := make(Rules, 0, len(ss))
+	for _, s := range ss {
+		r, err := Parse(s)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, r)
+	}
+
+	*rs = parsed
+	return nil
+}
+
+func (rs *Rules) GetSlice() []string {
+	res := make([]string, 0, len(*rs))
+	for _, r := range *rs {
+		res = append(res, r.String())
+	}
+
+	return res
+}
@@ -0,0 +1,115 @@
+package policy_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/policy"
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/mime"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"work laptop=send:text", "work laptop=send:text"},
+		{"*=receive", "*=receive"},
+		{"desktop = Both : image, file,path", "desktop=both:image,path"},
+		{"42=both:", "42=both"},
+		{"phone=both:text@90s", "phone=both:text@1m30s"},
+		{"*=receive @ 1h", "*=receive@1h0m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := policy.Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.String() != tt.want {
+				t.Fatalf("Parse(%q) = %q, want %q", tt.in, r.String(), tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"laptop", "=send", "laptop=sideways", "laptop=send:audio", "laptop=send@soon", "laptop=send@-1s"} {
+		if _, err := policy.Parse(bad); !errors.Is(err, policy.ErrInvalid) {
+			t.Fatalf("Parse(%q) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestRule_Direction(t *testing.T) {
+	kiosk, _ := policy.Parse("*=receive")
+	if kiosk.CanSend(mime.TypeText) || !kiosk.CanReceive(mime.TypeText) {
+		t.Fatal("receive-only rule lets our clipboard out")
+	}
+
+	work, _ := policy.Parse("work=send:text")
+	if !work.CanSend(mime.TypeText) || work.CanSend(mime.TypeImage) || work.CanReceive(mime.TypeText) {
+		t.Fatalf("send-only text rule: %+v", work)
+	}
+
+	if !policy.Default.CanSend(mime.TypePath) || !policy.Default.CanReceive(mime.TypeImage) {
+		t.Fatal("default rule must allow everything")
+	}
+}
+
+func TestRule_Expiry(t *testing.T) {
+	r, _ := policy.Parse("phone=both@30s")
+
+	tests := []struct {
+		ttl, want time.Duration
+	}{
+		{0, 30 * time.Second},
+		{time.Minute, 30 * time.Second},
+		{10 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := r.Expiry(tt.ttl); got != tt.want {
+			t.Fatalf("Expiry(%s) = %s, want %s", tt.ttl, got, tt.want)
+		}
+	}
+
+	if got := policy.Default.Expiry(time.Minute); got != time.Minute {
+		t.Fatalf("default rule changed the ttl to %s", got)
+	}
+}
+
+func TestRules_For(t *testing.T) {
+	var rules policy.Rules
+	for _, s := range []string{"Work Laptop=send:text", "7=receive", "*=both:image"} {
+		if err := rules.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dev  domain.Device
+		want string
+	}{
+		{domain.Device{ID: 1, Name: "work laptop"}, "Work Laptop=send:text"},
+		{domain.Device{ID: 7, Name: "kiosk"}, "7=receive"},
+		{domain.Device{ID: 9, Name: "desktop"}, "*=both:image"},
+	}
+	for _, tt := range tests {
+		if got := rules.For(tt.dev).String(); got != tt.want {
+			t.Fatalf("For(%+v) = %q, want %q", tt.dev, got, tt.want)
+		}
+	}
+
+	if got := (policy.Rules{}).For(domain.Device{ID: 1}); got.String() != policy.Default.String() {
+		t.Fatalf("empty rules = %q, want default", got)
+	}
+
+	if err := rules.Replace([]string{"a=send"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rules.GetSlice(), []string{"a=send"}) {
+		t.Fatalf("after Replace = %v", rules.GetSlice())
+	}
+}
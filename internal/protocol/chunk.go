@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cespare/xxhash"
+)
+
+// ChunkSize is how much of a file one checksum covers, a broken transfer resumes from the last whole chunk
+const ChunkSize = 1 << 20
+
+const chunkSumSize = 8
+
+var ErrChunkCorrupt = errors.New("chunk checksum mismatch")
+
+// ChunkedSize is the length of size bytes of content on the wire:
+// every chunk is followed by the xxhash of its bytes
+func ChunkedSize(size uint64) uint64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	return size + chunks*chunkSumSize
+}
+
+// Checksum is the xxhash of everything read from r, the Checksum of a message
+func Checksum(r io.Reader) (uint64, error) {
+	h := xxhash.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+type chunkWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewChunkWriter splits what is written into chunks, Close writes the last, shorter one
+func NewChunkWriter(w io.Writer) io.WriteCloser {
+	return &chunkWriter{w: w, buf: make([]byte, 0, ChunkSize+chunkSumSize)}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), ChunkSize-len(c.buf))
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(c.buf) == ChunkSize {
+			if err := c.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (c *chunkWriter) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	return c.flush()
+}
+
+func (c *chunkWriter) flush() error {
+	c.buf = binary.LittleEndian.AppendUint64(c.buf, xxhash.Sum64(c.buf))
+	if _, err := c.w.Write(c.buf); err != nil {
+		return err
+	}
+
+	c.buf = c.buf[:0]
+	return nil
+}
+
+type chunkReader struct {
+	r       io.Reader
+	left    uint64
+	buf     []byte
+	pending []byte
+}
+
+// NewChunkReader reads size bytes of content sent in chunks.
+// A chunk is returned only once its checksum matches, so what was read before an error is intact
+func NewChunkReader(r io.Reader, size uint64) io.Reader {
+	return &chunkReader{r: r, left: size}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.left == 0 {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *chunkReader) next() error {
+	if c.buf == nil {
+		c.buf = make([]byte, ChunkSize+chunkSumSize)
+	}
+
+	size := min(c.left, ChunkSize)
+	chunk := c.buf[:size+chunkSumSize]
+	if _, err := io.ReadFull(c.r, chunk); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("read chunk: %w", err)
+	}
+
+	data, sum := chunk[:size], binary.LittleEndian.Uint64(chunk[size:])
+	if xxhash.Sum64(data) != sum {
+		return ErrChunkCorrupt
+	}
+
+	c.pending = data
+	c.left -= size
+	return nil
+}
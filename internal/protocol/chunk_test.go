@@ -0,0 +1,75 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/protocol"
+)
+
+func chunked(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var wire bytes.Buffer
+	w := protocol.NewChunkWriter(&wire)
+	// odd writes, chunks must not depend on how the content is written
+	for part := range slices.Chunk(content, 1000) {
+		if _, err := w.Write(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return wire.Bytes()
+}
+
+func TestChunk_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, protocol.ChunkSize, protocol.ChunkSize + 1, 3*protocol.ChunkSize - 7} {
+		content := bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6}, size/7+1)[:size]
+
+		wire := chunked(t, content)
+		if uint64(len(wire)) != protocol.ChunkedSize(uint64(size)) {
+			t.Fatalf("size %d: %d bytes on the wire, ChunkedSize says %d", size, len(wire), protocol.ChunkedSize(uint64(size)))
+		}
+
+		got, err := io.ReadAll(protocol.NewChunkReader(bytes.NewReader(wire), uint64(size)))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("size %d: content mismatch", size)
+		}
+	}
+}
+
+func TestChunkReader_Corrupt(t *testing.T) {
+	content := bytes.Repeat([]byte{'x'}, 2*protocol.ChunkSize+10)
+	wire := chunked(t, content)
+	wire[protocol.ChunkSize+8+5] ^= 0xFF
+
+	got, err := io.ReadAll(protocol.NewChunkReader(bytes.NewReader(wire), uint64(len(content))))
+	if !errors.Is(err, protocol.ErrChunkCorrupt) {
+		t.Fatalf("err = %v, want ErrChunkCorrupt", err)
+	}
+	if len(got) != protocol.ChunkSize {
+		t.Fatalf("read %d bytes, want only the first chunk", len(got))
+	}
+}
+
+func TestChunkReader_Truncated(t *testing.T) {
+	content := bytes.Repeat([]byte{'y'}, protocol.ChunkSize+10)
+	wire := chunked(t, content)
+
+	got, err := io.ReadAll(protocol.NewChunkReader(bytes.NewReader(wire[:len(wire)-3]), uint64(len(content))))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want ErrUnexpectedEOF", err)
+	}
+	if len(got) != protocol.ChunkSize {
+		t.Fatalf("read %d bytes, want only the whole chunk", len(got))
+	}
+}
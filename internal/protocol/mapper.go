@@ -6,7 +6,6 @@ import (
 
 	"github.com/labi-le/belphegor/internal/types/domain"
 	"github.com/labi-le/belphegor/internal/types/proto"
-	"github.com/labi-le/belphegor/pkg/id"
 	"github.com/labi-le/belphegor/pkg/mime"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -55,6 +54,14 @@ func MapToProto(v any) *proto.Event {
 				Name:          e.Payload.Name,
 				BatchID:       e.Payload.BatchID.Int64(),
 				BatchTotal:    e.Payload.BatchTotal,
+				TTL:           e.Payload.TTL.Milliseconds(),
+				Sealed:        e.Payload.Sealed,
+				SealedLength:  e.Payload.SealedLength,
+				Hops:          e.Payload.Hops,
+				Offset:        e.Payload.Offset,
+				Checksum:      e.Payload.Checksum,
+				Dir:           e.Payload.Dir,
+				From:          e.From.Int64(),
 			},
 		}
 		return pb
@@ -69,6 +76,10 @@ func MapToProto(v any) *proto.Event {
 				ContentLength: e.Payload.ContentLength,
 				BatchID:       e.Payload.BatchID.Int64(),
 				BatchTotal:    e.Payload.BatchTotal,
+				Hops:          e.Payload.Hops,
+				Name:          e.Payload.Name,
+				From:          e.From.Int64(),
+				Sealed:        e.Payload.Sealed,
 			},
 		}
 		return pb
@@ -77,7 +88,8 @@ func MapToProto(v any) *proto.Event {
 		setCreated(pb, e.Created)
 		pb.Payload = &proto.Event_Request{
 			Request: &proto.RequestMessage{
-				ID: e.Payload.ID.Int64(),
+				ID:     e.Payload.ID.Int64(),
+				Offset: e.Payload.Offset,
 			},
 		}
 		return pb
@@ -86,8 +98,9 @@ func MapToProto(v any) *proto.Event {
 		setCreated(pb, e.Created)
 		pb.Payload = &proto.Event_Handshake{
 			Handshake: &proto.Handshake{
-				Version: e.Payload.Version,
-				Port:    e.Payload.Port,
+				Version:   e.Payload.Version,
+				Port:      e.Payload.Port,
+				Endpoints: toProtoEndpoints(e.Payload.Endpoints),
 				Device: &proto.Device{
 					Name: e.Payload.MetaData.Name,
 					Arch: e.Payload.MetaData.Arch,
@@ -104,7 +117,7 @@ func MapToProto(v any) *proto.Event {
 
 func toDomainMessage(ev *proto.Event, msg *proto.Message, data []byte) domain.EventMessage {
 	return domain.EventMessage{
-		From:    domain.NodeID(id.Author(msg.GetID())),
+		From:    domain.NodeID(msg.GetFrom()),
 		Created: ev.GetCreated().AsTime(),
 		Payload: domain.Message{
 			ID:            domain.MessageID(msg.GetID()),
@@ -115,13 +128,20 @@ func toDomainMessage(ev *proto.Event, msg *proto.Message, data []byte) domain.Ev
 			Name:          msg.GetName(),
 			BatchID:       domain.MessageID(msg.GetBatchID()),
 			BatchTotal:    msg.GetBatchTotal(),
+			TTL:           time.Duration(msg.GetTTL()) * time.Millisecond,
+			Sealed:        msg.GetSealed(),
+			SealedLength:  msg.GetSealedLength(),
+			Hops:          msg.GetHops(),
+			Offset:        msg.GetOffset(),
+			Checksum:      msg.GetChecksum(),
+			Dir:           msg.GetDir(),
 		},
 	}
 }
 
 func toDomainAnnounce(ev *proto.Event, ann *proto.Announce) domain.EventAnnounce {
 	return domain.EventAnnounce{
-		From:    domain.NodeID(id.Author(ann.GetID())),
+		From:    domain.NodeID(ann.GetFrom()),
 		Created: ev.GetCreated().AsTime(),
 		Payload: domain.Announce{
 			ID:            domain.MessageID(ann.GetID()),
@@ -130,16 +150,19 @@ func toDomainAnnounce(ev *proto.Event, ann *proto.Announce) domain.EventAnnounce
 			ContentLength: ann.GetContentLength(),
 			BatchID:       domain.MessageID(ann.GetBatchID()),
 			BatchTotal:    ann.GetBatchTotal(),
+			Hops:          ann.GetHops(),
+			Name:          ann.GetName(),
+			Sealed:        ann.GetSealed(),
 		},
 	}
 }
 
 func toDomainRequest(ev *proto.Event, req *proto.RequestMessage) domain.EventRequest {
 	return domain.EventRequest{
-		From:    domain.NodeID(id.Author(req.GetID())),
 		Created: ev.GetCreated().AsTime(),
 		Payload: domain.Request{
-			ID: domain.MessageID(req.GetID()),
+			ID:     domain.MessageID(req.GetID()),
+			Offset: req.GetOffset(),
 		},
 	}
 }
@@ -148,13 +171,38 @@ func toDomainHandshake(ev *proto.Event, hs *proto.Handshake) domain.EventHandsha
 	return domain.EventHandshake{
 		Created: ev.GetCreated().AsTime(),
 		Payload: domain.Handshake{
-			Version:  hs.GetVersion(),
-			Port:     hs.GetPort(),
-			MetaData: toDomainDevice(hs.GetDevice()),
+			Version:   hs.GetVersion(),
+			Port:      hs.GetPort(),
+			Endpoints: toDomainEndpoints(hs.GetEndpoints()),
+			MetaData:  toDomainDevice(hs.GetDevice()),
 		},
 	}
 }
 
+func toProtoEndpoints(endpoints []domain.Endpoint) []*proto.Endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	res := make([]*proto.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		res = append(res, &proto.Endpoint{Transport: e.Transport, Port: e.Port})
+	}
+	return res
+}
+
+func toDomainEndpoints(endpoints []*proto.Endpoint) []domain.Endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	res := make([]domain.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		res = append(res, domain.Endpoint{Transport: e.GetTransport(), Port: e.GetPort()})
+	}
+	return res
+}
+
 func toDomainDevice(d *proto.Device) domain.Device {
 	if d == nil {
 		return domain.Device{Name: "unknown", Arch: "unknown"}
@@ -31,6 +31,13 @@ var (
 			Name:          "image.png",
 			BatchID:       domain.MessageID(1),
 			BatchTotal:    1,
+			TTL:           30 * time.Second,
+			Sealed:        true,
+			SealedLength:  1170,
+			Hops:          2,
+			Offset:        512,
+			Checksum:      0xFEEDFACE,
+			Dir:           true,
 		},
 	}
 
@@ -44,6 +51,9 @@ var (
 			ContentLength: 2048,
 			BatchID:       domain.MessageID(1),
 			BatchTotal:    1,
+			Hops:          3,
+			Name:          "report.pdf",
+			Sealed:        []byte{0xBE, 0xEF},
 		},
 	}
 
@@ -51,7 +61,8 @@ var (
 		From:    domain.NodeID(301),
 		Created: testTime,
 		Payload: domain.Request{
-			ID: domain.MessageID(302),
+			ID:     domain.MessageID(302),
+			Offset: 4096,
 		},
 	}
 
@@ -61,6 +72,10 @@ var (
 		Payload: domain.Handshake{
 			Version: "1.2.3",
 			Port:    8080,
+			Endpoints: []domain.Endpoint{
+				{Transport: "quic", Port: 8080},
+				{Transport: "ws", Port: 8081},
+			},
 			MetaData: domain.Device{
 				ID:   domain.NodeID(401),
 				Name: "TestNode",
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/internal/types/proto"
+	"github.com/labi-le/belphegor/pkg/protoutil"
+)
+
+// MaxSealedFieldsSize bounds the sealed fields a peer can make us read
+const MaxSealedFieldsSize = 4 << 10
+
+var ErrSealedFields = errors.New("malformed sealed fields")
+
+// EncodeSealedFields encodes the fields of msg a relay must not learn, a sealed stream starts with them
+func EncodeSealedFields(msg domain.Message) ([]byte, error) {
+	b, err := protoutil.EncodeBytes(&proto.SealedFields{
+		ContentLength: msg.ContentLength,
+		ContentHash:   msg.ContentHash,
+		Name:          msg.Name,
+		Checksum:      msg.Checksum,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode sealed fields: %w", err)
+	}
+	if len(b) > MaxSealedFieldsSize {
+		return nil, fmt.Errorf("encode sealed fields: %d bytes, at most %d", len(b), MaxSealedFieldsSize)
+	}
+
+	return b, nil
+}
+
+// ReadSealedFields fills in the fields of msg sealed at the start of r
+func ReadSealedFields(r io.Reader, msg *domain.Message) error {
+	var size [protoutil.Length]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return fmt.Errorf("read sealed fields: %w", err)
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxSealedFieldsSize-protoutil.Length {
+		return fmt.Errorf("%w: %d bytes", ErrSealedFields, n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("read sealed fields: %w", err)
+	}
+
+	var fields proto.SealedFields
+	if err := fields.UnmarshalVT(data); err != nil {
+		return fmt.Errorf("%w: %w", ErrSealedFields, err)
+	}
+
+	msg.ContentLength = fields.GetContentLength()
+	msg.ContentHash = fields.GetContentHash()
+	msg.Name = fields.GetName()
+	msg.Checksum = fields.GetChecksum()
+
+	return nil
+}
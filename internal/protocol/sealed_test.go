@@ -0,0 +1,48 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/protocol"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+func TestSealedFields_RoundTrip(t *testing.T) {
+	msg := fullMsgEvent.Payload
+
+	fields, err := protocol.EncodeSealedFields(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the content follows the fields in the sealed stream
+	r := io.MultiReader(bytes.NewReader(fields), strings.NewReader("content"))
+
+	got := msg.Concealed()
+	if err := protocol.ReadSealedFields(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != msg.Name || got.ContentLength != msg.ContentLength || got.ContentHash != msg.ContentHash || got.Checksum != msg.Checksum {
+		t.Fatalf("read %+v, want the fields of %+v", got, msg)
+	}
+
+	if rest, _ := io.ReadAll(r); string(rest) != "content" {
+		t.Fatalf("content after the fields is %q", rest)
+	}
+}
+
+func TestSealedFields_Limit(t *testing.T) {
+	if _, err := protocol.EncodeSealedFields(domain.Message{Name: strings.Repeat("x", protocol.MaxSealedFieldsSize)}); err == nil {
+		t.Fatal("encoded fields over the limit")
+	}
+
+	huge := binary.BigEndian.AppendUint32(nil, 1<<31)
+	if err := protocol.ReadSealedFields(bytes.NewReader(huge), &domain.Message{}); !errors.Is(err, protocol.ErrSealedFields) {
+		t.Fatalf("ReadSealedFields of a huge length = %v, want ErrSealedFields", err)
+	}
+}
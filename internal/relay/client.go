@@ -0,0 +1,269 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/tcp"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+)
+
+// listInterval is how often the registered devices are listed again,
+// to reconnect the ones whose connection dropped
+const listInterval = time.Minute
+
+var errRelayLost = errors.New("connection to the relay lost")
+
+// Connector serves the connections to devices established through the relay
+type Connector interface {
+	// ServeConn blocks while the connection is served, dialed connections must be authorized
+	ServeConn(ctx context.Context, conn transport.Connection, dialed bool) error
+	// Connected reports whether the device is connected already, directly or through a relay
+	Connected(fp security.Fingerprint) bool
+}
+
+// Client keeps the device registered with a relay and connects it to the other registered devices.
+// Of two devices the one with the lower fingerprint dials, so they never dial each other at once
+type Client struct {
+	config
+	addr string
+	// transport reaches the relay, its TLS config must not take the relay for a device
+	transport transport.Transport
+	// tlsConf secures the sessions between devices
+	tlsConf *tls.Config
+	self    security.Fingerprint
+
+	mu     sync.Mutex
+	active map[security.Fingerprint]struct{}
+}
+
+func NewClient(addr string, tr transport.Transport, tlsConf *tls.Config, self security.Fingerprint, opts ...Option) *Client {
+	return &Client{
+		config:    newConfig(opts),
+		addr:      addr,
+		transport: tr,
+		tlsConf:   tlsConf,
+		self:      self,
+		active:    make(map[security.Fingerprint]struct{}),
+	}
+}
+
+// Run keeps the device registered until ctx is done, registering again with backoff when the relay is lost
+func (c *Client) Run(ctx context.Context, connector Connector) {
+	ctxLog := ctxlog.Op(c.logger, "relay.Run").With().Str("relay", c.addr).Logger()
+
+	delay := c.minDelay
+	for {
+		started := time.Now()
+		err := c.session(ctx, connector)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > c.maxDelay {
+			delay = c.minDelay
+		}
+		ctxLog.Warn().Err(err).Stringer("retry", delay).Msg("not registered with the relay")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, c.maxDelay)
+	}
+}
+
+// session registers with the relay and serves it until the connection is lost
+func (c *Client) session(ctx context.Context, connector Connector) error {
+	ctxLog := ctxlog.Op(c.logger, "relay.session").With().Str("relay", c.addr).Logger()
+
+	conn, err := c.transport.Dial(ctx, c.addr)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	events, err := conn.OpenStream(ctx)
+	if err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	if err := writeOp(events, opRegister, nil); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	if err := readStatus(events); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+
+	ctxLog.Info().Msg("registered with the relay")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	joined := make(chan struct{}, 1)
+	joined <- struct{}{}
+	go func() {
+		defer cancel()
+		for {
+			op, err := readByte(events)
+			if err != nil {
+				return
+			}
+			if op == opJoined {
+				select {
+				case joined <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	go c.accept(ctx, conn, connector)
+
+	ticker := time.NewTicker(listInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errRelayLost
+		case <-joined:
+		case <-ticker.C:
+		}
+
+		devices, err := c.list(ctx, conn)
+		if err != nil {
+			ctxLog.Debug().Err(err).Msg("failed to list devices")
+			continue
+		}
+
+		for _, d := range devices {
+			if bytes.Compare(c.self[:], d.Fingerprint[:]) >= 0 || !c.want(d.Fingerprint) || connector.Connected(d.Fingerprint) {
+				continue
+			}
+			go c.dial(ctx, conn, d, connector)
+		}
+	}
+}
+
+func (c *Client) list(ctx context.Context, conn transport.Connection) ([]Device, error) {
+	stream, err := conn.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if err := writeOp(stream, opList, nil); err != nil {
+		return nil, err
+	}
+	return readDevices(stream)
+}
+
+// dial connects to a registered device through the relay and serves the connection
+func (c *Client) dial(ctx context.Context, conn transport.Connection, d Device, connector Connector) {
+	ctxLog := ctxlog.Op(c.logger, "relay.dial").With().
+		Str("device", d.Name).
+		Str("fingerprint", d.Fingerprint.Short()).
+		Logger()
+
+	if !c.claim(d.Fingerprint) {
+		return
+	}
+	defer c.release(d.Fingerprint)
+
+	stream, err := conn.OpenStream(ctx)
+	if err != nil {
+		ctxLog.Debug().Err(err).Msg("failed to open stream")
+		return
+	}
+	if err := writeOp(stream, opConnect, &d.Fingerprint); err == nil {
+		err = readStatus(stream)
+	}
+	if err != nil {
+		_ = stream.Reset()
+		ctxLog.Debug().Err(err).Msg("relay refused to connect")
+		return
+	}
+
+	sess, err := tcp.Client(ctx, c.conn(stream, d.Fingerprint), c.tlsConf, c.keepAlive)
+	if err != nil {
+		ctxLog.Debug().Err(err).Msg("handshake through the relay failed")
+		return
+	}
+
+	ctxLog.Debug().Msg("connected through the relay")
+	if err := connector.ServeConn(ctx, sess, true); err != nil {
+		ctxLog.Debug().Err(err).Msg("connection through the relay closed")
+	}
+}
+
+// accept serves the devices that dial us through the relay
+func (c *Client) accept(ctx context.Context, conn transport.Connection, connector Connector) {
+	ctxLog := ctxlog.Op(c.logger, "relay.accept")
+
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+
+		go func() {
+			op, err := readByte(stream)
+			var from security.Fingerprint
+			if err == nil && op == opIncoming {
+				from, err = readFingerprint(stream)
+			} else if err == nil {
+				err = fmt.Errorf("%w: op %d", ErrBadRequest, op)
+			}
+			if err != nil {
+				_ = stream.Reset()
+				ctxLog.Debug().Err(err).Msg("bad incoming stream")
+				return
+			}
+
+			sess, err := tcp.Server(c.conn(stream, from), c.tlsConf, c.keepAlive)
+			if err != nil {
+				ctxLog.Debug().Err(err).Str("fingerprint", from.Short()).Msg("failed to accept")
+				return
+			}
+
+			if err := connector.ServeConn(ctx, sess, false); err != nil {
+				ctxLog.Debug().Err(err).Str("fingerprint", from.Short()).Msg("connection through the relay closed")
+			}
+		}()
+	}
+}
+
+func (c *Client) conn(stream transport.Stream, remote security.Fingerprint) *streamConn {
+	return &streamConn{
+		Stream: stream,
+		local:  Addr{Relay: c.addr, Device: c.self},
+		remote: Addr{Relay: c.addr, Device: remote},
+	}
+}
+
+// claim marks a device as being dialed, false if it is already
+func (c *Client) claim(fp security.Fingerprint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.active[fp]; ok {
+		return false
+	}
+	c.active[fp] = struct{}{}
+	return true
+}
+
+func (c *Client) release(fp security.Fingerprint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.active, fp)
+}
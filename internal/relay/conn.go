@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"net"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+)
+
+// streamConn is a spliced stream seen as a connection, devices run their TLS session over it
+type streamConn struct {
+	transport.Stream
+	local, remote Addr
+}
+
+var _ net.Conn = (*streamConn)(nil)
+
+func (c *streamConn) LocalAddr() net.Addr { return c.local }
+
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/rs/zerolog"
+)
+
+type config struct {
+	logger    zerolog.Logger
+	keepAlive time.Duration
+	want      func(security.Fingerprint) bool
+	minDelay  time.Duration
+	maxDelay  time.Duration
+}
+
+//nolint:mnd //shut up
+var defaultConfig = config{
+	logger:    zerolog.Nop(),
+	keepAlive: time.Minute,
+	want:      func(security.Fingerprint) bool { return true },
+	minDelay:  time.Second,
+	maxDelay:  2 * time.Minute,
+}
+
+type Option func(*config)
+
+func WithLogger(logger zerolog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithKeepAlive sets the keep alive of the sessions between devices
+func WithKeepAlive(keepAlive time.Duration) Option {
+	return func(c *config) {
+		c.keepAlive = keepAlive
+	}
+}
+
+// WithWant limits the registered devices the client connects to, e.g. to the paired ones
+func WithWant(want func(security.Fingerprint) bool) Option {
+	return func(c *config) {
+		c.want = want
+	}
+}
+
+// WithBackoff bounds the delay between attempts to register again after the relay is lost
+func WithBackoff(minDelay, maxDelay time.Duration) Option {
+	return func(c *config) {
+		c.minDelay = minDelay
+		c.maxDelay = max(minDelay, maxDelay)
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := defaultConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
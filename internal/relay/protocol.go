@@ -0,0 +1,158 @@
+// Package relay lets devices on different networks reach each other through a server
+// both of them can dial. Devices register with the relay over the usual transport,
+// the relay splices a stream between two of them and they run their own TLS session
+// over it, so the relay sees neither the payloads nor the metadata of the sync.
+//
+// Every stream to the relay starts with an op byte, answered with a status byte:
+//
+//	register                  the stream stays open, the relay writes joined on it when another device registers
+//	list                      uint16 count, then per device a fingerprint and a uint8 length prefixed name
+//	connect <fingerprint>     the stream is spliced to a stream the relay opens to the device,
+//	                          which starts with incoming <fingerprint of the dialing device>
+package relay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/labi-le/belphegor/internal/security"
+)
+
+const (
+	opRegister byte = iota + 1
+	opList
+	opConnect
+	opIncoming
+	opJoined
+)
+
+const (
+	statusOK byte = iota
+	statusNotRegistered
+	statusUnknownDevice
+	statusBadRequest
+)
+
+const maxNameLen = 255
+
+var (
+	ErrNotRegistered = errors.New("device is not registered with the relay")
+	ErrUnknownDevice = errors.New("device is not connected to the relay")
+	ErrBadRequest    = errors.New("malformed relay request")
+)
+
+// Device is a device registered with the relay
+type Device struct {
+	Fingerprint security.Fingerprint
+	Name        string
+}
+
+// Addr is the address of a device reached through a relay
+type Addr struct {
+	Relay  string
+	Device security.Fingerprint
+}
+
+var _ net.Addr = Addr{}
+
+func (a Addr) Network() string { return "relay" }
+
+func (a Addr) String() string { return a.Relay + "/" + a.Device.Short() }
+
+func statusError(status byte) error {
+	switch status {
+	case statusOK:
+		return nil
+	case statusNotRegistered:
+		return ErrNotRegistered
+	case statusUnknownDevice:
+		return ErrUnknownDevice
+	default:
+		return ErrBadRequest
+	}
+}
+
+func writeByte(w io.Writer, b byte) error {
+	_, err := w.Write([]byte{b})
+	return err
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+// writeOp sends an op with an optional fingerprint in a single write
+func writeOp(w io.Writer, op byte, fp *security.Fingerprint) error {
+	buf := []byte{op}
+	if fp != nil {
+		buf = append(buf, fp[:]...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFingerprint(r io.Reader) (security.Fingerprint, error) {
+	var fp security.Fingerprint
+	_, err := io.ReadFull(r, fp[:])
+	return fp, err
+}
+
+// readStatus reads the answer to a request
+func readStatus(r io.Reader) error {
+	status, err := readByte(r)
+	if err != nil {
+		return fmt.Errorf("read status: %w", err)
+	}
+	return statusError(status)
+}
+
+func writeDevices(w io.Writer, devices []Device) error {
+	buf := binary.BigEndian.AppendUint16([]byte{statusOK}, uint16(len(devices)))
+	for _, d := range devices {
+		name := d.Name
+		if len(name) > maxNameLen {
+			name = name[:maxNameLen]
+		}
+		buf = append(buf, d.Fingerprint[:]...)
+		buf = append(buf, byte(len(name)))
+		buf = append(buf, name...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readDevices(r io.Reader) ([]Device, error) {
+	if err := readStatus(r); err != nil {
+		return nil, err
+	}
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("read devices: %w", err)
+	}
+
+	devices := make([]Device, 0, count)
+	for range count {
+		fp, err := readFingerprint(r)
+		if err != nil {
+			return nil, fmt.Errorf("read devices: %w", err)
+		}
+		size, err := readByte(r)
+		if err != nil {
+			return nil, fmt.Errorf("read devices: %w", err)
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, fmt.Errorf("read devices: %w", err)
+		}
+		devices = append(devices, Device{Fingerprint: fp, Name: string(name)})
+	}
+
+	return devices, nil
+}
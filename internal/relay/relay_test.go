@@ -0,0 +1,142 @@
+package relay_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/relay"
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/tcp"
+	"github.com/rs/zerolog"
+)
+
+type device struct {
+	fp      security.Fingerprint
+	tlsConf *tls.Config
+	conns   chan transport.Connection
+}
+
+func newDevice(t *testing.T, name, secret string) *device {
+	t.Helper()
+
+	dir := t.TempDir()
+	id, err := security.LoadIdentity(filepath.Join(dir, "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust, err := security.OpenTrustStore(filepath.Join(dir, "trusted.json"), id.Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := security.MakeTLSConfig(id, security.NewVerifier(trust, secret), name, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &device{fp: id.Fingerprint(), tlsConf: conf, conns: make(chan transport.Connection, 1)}
+}
+
+func (d *device) ServeConn(ctx context.Context, conn transport.Connection, _ bool) error {
+	d.conns <- conn
+	<-ctx.Done()
+	return nil
+}
+
+func (d *device) Connected(security.Fingerprint) bool { return false }
+
+func (d *device) register(ctx context.Context, addr string) {
+	relayConf := d.tlsConf.Clone()
+	relayConf.VerifyPeerCertificate = nil
+
+	go relay.NewClient(addr, tcp.New(relayConf, time.Minute), d.tlsConf, d.fp,
+		relay.WithBackoff(50*time.Millisecond, 100*time.Millisecond),
+	).Run(ctx, d)
+}
+
+func startRelay(ctx context.Context, t *testing.T, secret string) (*relay.Server, string) {
+	t.Helper()
+
+	id, err := security.LoadIdentity(filepath.Join(t.TempDir(), "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := security.MakeTLSConfig(id, security.NewRelayVerifier(secret), "relay", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tcp.New(conf, time.Minute).Listen(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := relay.NewServer()
+	go func() { _ = srv.Serve(ctx, l) }()
+
+	return srv, l.Addr().String()
+}
+
+func TestRelay_Splice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, addr := startRelay(ctx, t, "s3cret")
+	a, b := newDevice(t, "laptop", "s3cret"), newDevice(t, "desktop", "s3cret")
+	stranger := newDevice(t, "stranger", "guess")
+
+	a.register(ctx, addr)
+	b.register(ctx, addr)
+	stranger.register(ctx, addr)
+
+	var dialed, accepted transport.Connection
+	for range 2 {
+		select {
+		case c := <-a.conns:
+			dialed, accepted = pick(a, b, c, dialed, accepted)
+		case c := <-b.conns:
+			dialed, accepted = pick(b, a, c, dialed, accepted)
+		case <-ctx.Done():
+			t.Fatal("devices were not connected through the relay")
+		}
+	}
+
+	// the session runs end to end between the devices
+	go func() {
+		s, err := dialed.OpenStream(ctx)
+		if err != nil {
+			return
+		}
+		_, _ = s.Write([]byte("ping"))
+		_ = s.Close()
+	}()
+
+	s, err := accepted.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(s)
+	if string(got) != "ping" {
+		t.Fatalf("read %q through the relay", got)
+	}
+
+	for _, d := range srv.Devices() {
+		if d.Fingerprint == stranger.fp {
+			t.Fatal("a device without the secret registered")
+		}
+	}
+	if n := len(srv.Devices()); n != 2 {
+		t.Fatalf("%d devices registered, want 2", n)
+	}
+}
+
+// pick tells the dialed connection from the accepted one, the device with the lower fingerprint dials
+func pick(self, other *device, c, dialed, accepted transport.Connection) (transport.Connection, transport.Connection) {
+	if string(self.fp[:]) < string(other.fp[:]) {
+		return c, accepted
+	}
+	return dialed, c
+}
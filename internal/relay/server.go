@@ -0,0 +1,246 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/pkg/ctxlog"
+)
+
+// Server keeps the registered devices and splices streams between them
+type Server struct {
+	config
+
+	mu      sync.Mutex
+	devices map[security.Fingerprint]*registration
+}
+
+type registration struct {
+	name string
+	conn transport.Connection
+
+	// events is the register stream, joined notifications are written to it
+	mu     sync.Mutex
+	events transport.Stream
+}
+
+func NewServer(opts ...Option) *Server {
+	return &Server{
+		config:  newConfig(opts),
+		devices: make(map[security.Fingerprint]*registration),
+	}
+}
+
+// Serve accepts devices until ctx is done, the listener decides which devices may connect
+func (s *Server) Serve(ctx context.Context, l transport.Listener) error {
+	for {
+		conn, err := l.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("relay.Serve: %w", err)
+		}
+
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// Devices returns the registered devices
+func (s *Server) Devices() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Device, 0, len(s.devices))
+	for fp, reg := range s.devices {
+		res = append(res, Device{Fingerprint: fp, Name: reg.name})
+	}
+	return res
+}
+
+func (s *Server) serveConn(ctx context.Context, conn transport.Connection) {
+	ctxLog := ctxlog.Op(s.logger, "relay.serveConn").With().Stringer("addr", conn.RemoteAddr()).Logger()
+	defer conn.Close()
+
+	cert := conn.PeerCertificate()
+	if cert == nil {
+		ctxLog.Debug().Err(security.ErrNoCertificate).Msg("refused")
+		return
+	}
+
+	dev := Device{Fingerprint: security.FingerprintOf(cert), Name: cert.Subject.CommonName}
+	defer s.unregister(dev, conn)
+
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			ctxLog.Trace().Err(err).Str("device", dev.Name).Msg("connection closed")
+			return
+		}
+
+		go func() {
+			if err := s.handleStream(ctx, dev, conn, stream); err != nil {
+				ctxLog.Debug().Err(err).Str("device", dev.Name).Msg("request failed")
+			}
+		}()
+	}
+}
+
+func (s *Server) handleStream(ctx context.Context, dev Device, conn transport.Connection, stream transport.Stream) error {
+	op, err := readByte(stream)
+	if err != nil {
+		_ = stream.Reset()
+		return fmt.Errorf("read op: %w", err)
+	}
+
+	switch op {
+	case opRegister:
+		s.register(dev, conn, stream)
+		return nil
+	case opList:
+		defer stream.Close()
+		return writeDevices(stream, s.others(dev.Fingerprint))
+	case opConnect:
+		return s.connect(ctx, dev, stream)
+	default:
+		_ = writeByte(stream, statusBadRequest)
+		_ = stream.Close()
+		return fmt.Errorf("%w: op %d", ErrBadRequest, op)
+	}
+}
+
+// register makes the device reachable, a device registering again replaces its old connection
+func (s *Server) register(dev Device, conn transport.Connection, events transport.Stream) {
+	ctxLog := ctxlog.Op(s.logger, "relay.register")
+
+	// the confirmation goes out before any joined notification
+	self := &registration{name: dev.Name, conn: conn, events: events}
+	self.mu.Lock()
+
+	s.mu.Lock()
+	old := s.devices[dev.Fingerprint]
+	s.devices[dev.Fingerprint] = self
+	others := make([]*registration, 0, len(s.devices))
+	for fp, reg := range s.devices {
+		if fp != dev.Fingerprint {
+			others = append(others, reg)
+		}
+	}
+	s.mu.Unlock()
+
+	err := writeByte(events, statusOK)
+	self.mu.Unlock()
+
+	if old != nil && old.conn != conn {
+		_ = old.conn.Close()
+	}
+	if err != nil {
+		ctxLog.Debug().Err(err).Str("device", dev.Name).Msg("failed to confirm registration")
+		return
+	}
+
+	ctxLog.Info().
+		Str("device", dev.Name).
+		Str("fingerprint", dev.Fingerprint.Short()).
+		Stringer("addr", conn.RemoteAddr()).
+		Msg("registered")
+
+	for _, reg := range others {
+		reg.mu.Lock()
+		_ = writeByte(reg.events, opJoined)
+		reg.mu.Unlock()
+	}
+}
+
+func (s *Server) unregister(dev Device, conn transport.Connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reg, ok := s.devices[dev.Fingerprint]; ok && reg.conn == conn {
+		delete(s.devices, dev.Fingerprint)
+
+		ctxLog := ctxlog.Op(s.logger, "relay.unregister")
+		ctxLog.Info().Str("device", dev.Name).Str("fingerprint", dev.Fingerprint.Short()).Msg("unregistered")
+	}
+}
+
+func (s *Server) others(self security.Fingerprint) []Device {
+	devices := s.Devices()
+	for i, d := range devices {
+		if d.Fingerprint == self {
+			return append(devices[:i], devices[i+1:]...)
+		}
+	}
+	return devices
+}
+
+// connect splices the stream of a registered device to a new stream to the target device
+func (s *Server) connect(ctx context.Context, from Device, stream transport.Stream) error {
+	target, err := readFingerprint(stream)
+	if err != nil {
+		_ = stream.Reset()
+		return fmt.Errorf("read target: %w", err)
+	}
+
+	s.mu.Lock()
+	_, registered := s.devices[from.Fingerprint]
+	reg, found := s.devices[target]
+	s.mu.Unlock()
+
+	switch {
+	case !registered:
+		_ = writeByte(stream, statusNotRegistered)
+		_ = stream.Close()
+		return ErrNotRegistered
+	case !found:
+		_ = writeByte(stream, statusUnknownDevice)
+		_ = stream.Close()
+		return fmt.Errorf("%w: %s", ErrUnknownDevice, target.Short())
+	}
+
+	out, err := reg.conn.OpenStream(ctx)
+	if err == nil {
+		err = writeOp(out, opIncoming, &from.Fingerprint)
+	}
+	if err != nil {
+		_ = writeByte(stream, statusUnknownDevice)
+		_ = stream.Close()
+		return fmt.Errorf("open stream to %s: %w", reg.name, err)
+	}
+
+	if err := writeByte(stream, statusOK); err != nil {
+		_ = out.Reset()
+		return fmt.Errorf("confirm connect: %w", err)
+	}
+
+	ctxLog := ctxlog.Op(s.logger, "relay.connect")
+	ctxLog.Debug().Str("from", from.Name).Str("to", reg.name).Msg("spliced")
+
+	splice(stream, out)
+	return nil
+}
+
+// splice copies both ways until both sides are done, a broken side resets the other
+func splice(a, b transport.Stream) {
+	var wg sync.WaitGroup
+	pipe := func(dst, src transport.Stream) {
+		defer wg.Done()
+		if _, err := io.Copy(dst, src); err != nil {
+			_ = dst.Reset()
+			_ = src.Reset()
+			return
+		}
+		_ = dst.Close()
+	}
+
+	wg.Add(2)
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+}
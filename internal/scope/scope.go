@@ -0,0 +1,189 @@
+// Package scope limits the networks peers are discovered on and accepted from
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid network")
+
+// Network is a network interface by name or a CIDR range
+type Network struct {
+	Iface  string
+	Prefix netip.Prefix
+}
+
+// Parse reads a CIDR range, a single address or an interface name, e.g. "192.168.1.0/24", "fd00::1" or "wlan0"
+func Parse(s string) (Network, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Network{}, fmt.Errorf("%w: empty", ErrInvalid)
+	}
+
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return Network{Prefix: prefix.Masked()}, nil
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.WithZone("").Unmap()
+		return Network{Prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+	}
+	if strings.ContainsAny(s, "/:") {
+		return Network{}, fmt.Errorf("%w %q: expected a CIDR range, an address or an interface name", ErrInvalid, s)
+	}
+
+	return Network{Iface: s}, nil
+}
+
+func (n Network) String() string {
+	if n.Iface != "" {
+		return n.Iface
+	}
+	return n.Prefix.String()
+}
+
+// Contains reports whether addr is in the range, or is reached through the interface:
+// its zone names it or it lies in a subnet the interface is on
+func (n Network) Contains(addr netip.Addr) bool {
+	if n.Iface == "" {
+		return n.Prefix.Contains(addr.WithZone("").Unmap())
+	}
+
+	if addr.Zone() != "" {
+		return addr.Zone() == n.Iface
+	}
+
+	iface, err := net.InterfaceByName(n.Iface)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes(*iface) {
+		if prefix.Masked().Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// On reports whether iface is the interface or has an address in the range
+func (n Network) On(iface net.Interface) bool {
+	if n.Iface != "" {
+		return n.Iface == iface.Name
+	}
+
+	for _, prefix := range prefixes(iface) {
+		if n.Prefix.Contains(prefix.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func prefixes(iface net.Interface) []netip.Prefix {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	res := make([]netip.Prefix, 0, len(addrs))
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+			res = append(res, prefix)
+		}
+	}
+	return res
+}
+
+// Networks is a pflag value, every flag appends its comma separated networks
+type Networks []Network
+
+func (ns Networks) Contains(addr netip.Addr) bool {
+	for _, n := range ns {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns Networks) On(iface net.Interface) bool {
+	for _, n := range ns {
+		if n.On(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns *Networks) String() string {
+	return strings.Join(ns.GetSlice(), ",")
+}
+
+func (ns *Networks) Set(s string) error {
+	for part := range strings.SplitSeq(s, ",") {
+		if err := ns.Append(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ns *Networks) Type() string {
+	return "strings"
+}
+
+func (ns *Networks) Append(s string) error {
+	n, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*ns = append(*ns, n)
+	return nil
+}
+
+func (ns *Networks) Replace(ss []string) error {
+	var parsed Networks
+	for _, s := range ss {
+		if err := parsed.Set(s); err != nil {
+			return err
+		}
+	}
+
+	*ns = parsed
+	return nil
+}
+
+func (ns *Networks) GetSlice() []string {
+	res := make([]string, 0, len(*ns))
+	for _, n := range *ns {
+		res = append(res, n.String())
+	}
+	return res
+}
+
+// Scope is where peers are looked for and accepted from
+type Scope struct {
+	// Allow limits discovery and incoming connections to these networks, empty allows every network
+	Allow Networks
+	// Untrusted networks are allowed, but the node neither announces itself nor connects to peers found there
+	Untrusted Networks
+}
+
+// Accepts reports whether a connection from addr may be served
+func (s Scope) Accepts(addr netip.Addr) bool {
+	return len(s.Allow) == 0 || s.Allow.Contains(addr)
+}
+
+// Discovers reports whether a peer found at addr is connected to automatically
+func (s Scope) Discovers(addr netip.Addr) bool {
+	return s.Accepts(addr) && !s.Untrusted.Contains(addr)
+}
+
+// DiscoversOn reports whether the node announces itself over iface
+func (s Scope) DiscoversOn(iface net.Interface) bool {
+	return (len(s.Allow) == 0 || s.Allow.On(iface)) && !s.Untrusted.On(iface)
+}
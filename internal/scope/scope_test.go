@@ -0,0 +1,173 @@
+package scope_test
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/scope"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24"},
+		{"192.168.1.7/24", "192.168.1.0/24"},
+		{" 10.0.0.1 ", "10.0.0.1/32"},
+		{"fd00::1", "fd00::1/128"},
+		{"fe80::1%eth0", "fe80::1/128"},
+		{"wlan0", "wlan0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			n, err := scope.Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.String() != tt.want {
+				t.Fatalf("Parse(%q) = %q, want %q", tt.in, n.String(), tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "10.0.0.0/33", "10.0.0/8", "fe80::zz"} {
+		if _, err := scope.Parse(bad); !errors.Is(err, scope.ErrInvalid) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestNetwork_Contains(t *testing.T) {
+	tests := []struct {
+		network string
+		addr    string
+		want    bool
+	}{
+		{"192.168.1.0/24", "192.168.1.50", true},
+		{"192.168.1.0/24", "::ffff:192.168.1.50", true},
+		{"192.168.1.0/24", "192.168.2.1", false},
+		{"fe80::/10", "fe80::1%eth0", true},
+		{"eth0", "fe80::1%eth0", true},
+		{"eth0", "fe80::1%wlan0", false},
+		{"no-such-iface", "192.168.1.50", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network+" "+tt.addr, func(t *testing.T) {
+			n, err := scope.Parse(tt.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Fatalf("Contains(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetwork_Interface(t *testing.T) {
+	lo := loopback(t)
+
+	byName, _ := scope.Parse(lo.Name)
+	byRange, _ := scope.Parse("127.0.0.0/8")
+	other, _ := scope.Parse("192.0.2.0/24")
+
+	if !byName.On(lo) || !byRange.On(lo) || other.On(lo) {
+		t.Fatalf("On(%s): by name %v, by range %v, other %v", lo.Name, byName.On(lo), byRange.On(lo), other.On(lo))
+	}
+	if !byName.Contains(netip.MustParseAddr("127.0.0.1")) {
+		t.Fatalf("%s does not contain the address of its subnet", lo.Name)
+	}
+}
+
+func TestScope(t *testing.T) {
+	var s scope.Scope
+	if err := s.Allow.Set("10.0.0.0/8,192.168.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Untrusted.Set("192.168.100.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr      string
+		accepts   bool
+		discovers bool
+	}{
+		{"10.1.2.3", true, true},
+		{"192.168.1.1", true, true},
+		{"192.168.100.5", true, false},
+		{"172.17.0.2", false, false},
+	}
+
+	for _, tt := range tests {
+		addr := netip.MustParseAddr(tt.addr)
+		if got := s.Accepts(addr); got != tt.accepts {
+			t.Errorf("Accepts(%s) = %v, want %v", tt.addr, got, tt.accepts)
+		}
+		if got := s.Discovers(addr); got != tt.discovers {
+			t.Errorf("Discovers(%s) = %v, want %v", tt.addr, got, tt.discovers)
+		}
+	}
+
+	var open scope.Scope
+	if !open.Accepts(netip.MustParseAddr("203.0.113.1")) || !open.Discovers(netip.MustParseAddr("203.0.113.1")) {
+		t.Fatal("an empty scope must allow every network")
+	}
+
+	lo := loopback(t)
+	if s.DiscoversOn(lo) || !open.DiscoversOn(lo) {
+		t.Fatalf("DiscoversOn(%s): scoped %v, open %v", lo.Name, s.DiscoversOn(lo), open.DiscoversOn(lo))
+	}
+}
+
+func TestNetworks_Flag(t *testing.T) {
+	var ns scope.Networks
+	if err := ns.Set("eth0,10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Set("wlan0"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"eth0", "10.0.0.0/8", "wlan0"}; !slices.Equal(ns.GetSlice(), want) {
+		t.Fatalf("GetSlice() = %v, want %v", ns.GetSlice(), want)
+	}
+
+	if err := ns.Replace([]string{"docker0"}); err != nil {
+		t.Fatal(err)
+	}
+	if ns.String() != "docker0" {
+		t.Fatalf("String() = %q after Replace", ns.String())
+	}
+
+	if err := ns.Replace([]string{"10.0.0.0/99"}); err == nil {
+		t.Fatal("Replace accepted an invalid range")
+	}
+	if ns.String() != "docker0" {
+		t.Fatal("failed Replace changed the networks")
+	}
+}
+
+func loopback(t *testing.T) net.Interface {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if prefix, err := netip.ParsePrefix(a.String()); err == nil && prefix.Addr() == netip.MustParseAddr("127.0.0.1") {
+				return iface
+			}
+		}
+	}
+	t.Skip("no interface with 127.0.0.1")
+	return net.Interface{}
+}
@@ -0,0 +1,461 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	"filippo.io/edwards25519"
+)
+
+// Payloads are sealed for the devices that may read them rather than for the next hop,
+// so a relay forwards content it cannot read. A random payload key is wrapped for every
+// recipient with X25519 derived from its ed25519 device key, the payload is split into
+// chunks sealed with AES-GCM, the last chunk is always shorter than the others.
+// The sealing device signs the header and the digest of the chunks with its device key,
+// so a recipient knows which paired device the payload comes from:
+//
+//	header: version | signer fingerprint | ephemeral key | count | count * (key id | wrapped payload key)
+//	body:   chunk * (chunkSize + tag) | last chunk (< chunkSize + tag) | signature
+const (
+	envelopeVersion = 2
+	chunkSize       = 64 << 10
+	tagSize         = 16
+	keyIDSize       = 8
+	wrappedKeySize  = 32 + tagSize
+	fixedSize       = 1 + len(Fingerprint{}) + 32 + 2
+	// maxRecipients bounds the header a peer can make us read
+	maxRecipients = 1024
+)
+
+const (
+	envelopeInfo  = "belphegor envelope"
+	payloadInfo   = "belphegor payload"
+	signatureInfo = "belphegor signature"
+)
+
+// SealedSize is the size of size bytes sealed for the number of recipients
+func SealedSize(size uint64, recipients int) uint64 {
+	header := uint64(fixedSize + recipients*(keyIDSize+wrappedKeySize))
+	return header + size/chunkSize*(chunkSize+tagSize) + size%chunkSize + tagSize + ed25519.SignatureSize
+}
+
+// MaxSealedSize bounds the sealed size of size bytes, for checking what a peer announces
+func MaxSealedSize(size uint64) uint64 {
+	return SealedSize(size, maxRecipients)
+}
+
+// Keys finds the device key of the paired device with the fingerprint, nil for an unknown device
+type Keys interface {
+	Key(fp Fingerprint) ed25519.PublicKey
+}
+
+// Seal returns a writer sealing everything written to it for recipients into dst, signed by this device.
+// Nothing is written to dst before the first Write or Close, Close must be called
+func (i Identity) Seal(dst io.Writer, recipients []ed25519.PublicKey) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("security.Seal: %d recipients, at most %d", len(recipients), maxRecipients)
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("security.Seal: %w", err)
+	}
+
+	payloadKey := make([]byte, 32)
+	_, _ = rand.Read(payloadKey)
+
+	header := make([]byte, 0, SealedSize(0, len(recipients))-tagSize)
+	header = append(header, envelopeVersion)
+	signer := i.Fingerprint()
+	header = append(header, signer[:]...)
+	header = append(header, eph.PublicKey().Bytes()...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(recipients)))
+
+	for _, pub := range recipients {
+		peer, convErr := montgomery(pub)
+		if convErr != nil {
+			return nil, fmt.Errorf("security.Seal: %w", convErr)
+		}
+
+		shared, ecdhErr := eph.ECDH(peer)
+		if ecdhErr != nil {
+			return nil, fmt.Errorf("security.Seal: %w", ecdhErr)
+		}
+
+		kek, kdfErr := wrapKey(shared, eph.PublicKey().Bytes(), peer.Bytes())
+		if kdfErr != nil {
+			return nil, fmt.Errorf("security.Seal: %w", kdfErr)
+		}
+
+		id := keyID(pub)
+		header = append(header, id[:]...)
+		header = kek.Seal(header, make([]byte, kek.NonceSize()), payloadKey, nil)
+	}
+
+	aead, err := payloadAEAD(payloadKey, header)
+	if err != nil {
+		return nil, fmt.Errorf("security.Seal: %w", err)
+	}
+
+	return &sealWriter{
+		dst:    dst,
+		header: header,
+		signed: header,
+		key:    i.key,
+		digest: sha256.New(),
+		aead:   aead,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Open returns a reader of the payload sealed in src, which fails at the end of the payload
+// unless the signature of its sealer matches the key keys hold for it. If the payload
+// is not sealed for this device it returns ErrNotRecipient with a reader of the whole
+// sealed payload, so that it can be forwarded as is
+func (i Identity) Open(src io.Reader, keys Keys) (io.Reader, error) {
+	fixed := make([]byte, fixedSize)
+	if _, err := io.ReadFull(src, fixed); err != nil {
+		return nil, fmt.Errorf("security.Open: %w: %w", ErrBadEnvelope, err)
+	}
+	if fixed[0] != envelopeVersion {
+		return nil, fmt.Errorf("security.Open: %w: version %d", ErrBadEnvelope, fixed[0])
+	}
+
+	signer := Fingerprint(fixed[1 : 1+len(Fingerprint{})])
+	ephPub := fixed[1+len(signer) : fixedSize-2]
+
+	count := int(binary.BigEndian.Uint16(fixed[fixedSize-2:]))
+	if count == 0 || count > maxRecipients {
+		return nil, fmt.Errorf("security.Open: %w: %d recipients", ErrBadEnvelope, count)
+	}
+
+	stanzas := make([]byte, count*(keyIDSize+wrappedKeySize))
+	if _, err := io.ReadFull(src, stanzas); err != nil {
+		return nil, fmt.Errorf("security.Open: %w: %w", ErrBadEnvelope, err)
+	}
+	header := slices.Concat(fixed, stanzas)
+
+	payloadKey, err := i.unwrap(ephPub, stanzas)
+	if errors.Is(err, ErrNotRecipient) {
+		return io.MultiReader(bytes.NewReader(header), src), err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("security.Open: %w", err)
+	}
+
+	key := keys.Key(signer)
+	if key == nil {
+		return nil, fmt.Errorf("security.Open: %w: %s", ErrUnknownSigner, signer.Short())
+	}
+
+	aead, err := payloadAEAD(payloadKey, header)
+	if err != nil {
+		return nil, fmt.Errorf("security.Open: %w", err)
+	}
+
+	return &openReader{
+		src:    src,
+		header: header,
+		key:    key,
+		digest: sha256.New(),
+		aead:   aead,
+		buf:    make([]byte, chunkSize+tagSize+ed25519.SignatureSize),
+		out:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Sealer seals payloads for the paired devices that may read them and opens the ones sealed for us
+type Sealer struct {
+	id    Identity
+	trust *TrustStore
+}
+
+func NewSealer(id Identity, trust *TrustStore) *Sealer {
+	return &Sealer{id: id, trust: trust}
+}
+
+// Seal returns a writer sealing size bytes for TrustStore.Recipients, and the size of the sealed payload
+func (s *Sealer) Seal(dst io.Writer, size uint64) (io.WriteCloser, uint64, error) {
+	recipients := s.trust.Recipients()
+
+	w, err := s.id.Seal(dst, recipients)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return w, SealedSize(size, len(recipients)), nil
+}
+
+// Open opens payloads sealed for us by paired devices
+func (s *Sealer) Open(src io.Reader) (io.Reader, error) {
+	return s.id.Open(src, s.trust)
+}
+
+func (i Identity) unwrap(ephPub, stanzas []byte) ([]byte, error) {
+	priv, err := i.x25519()
+	if err != nil {
+		return nil, err
+	}
+
+	eph, err := ecdh.X25519().NewPublicKey(ephPub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadEnvelope, err)
+	}
+
+	id := keyID(i.key.Public().(ed25519.PublicKey))
+	for s := range slices.Chunk(stanzas, keyIDSize+wrappedKeySize) {
+		if [keyIDSize]byte(s[:keyIDSize]) != id {
+			continue
+		}
+
+		shared, ecdhErr := priv.ECDH(eph)
+		if ecdhErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadEnvelope, ecdhErr)
+		}
+
+		kek, kdfErr := wrapKey(shared, ephPub, priv.PublicKey().Bytes())
+		if kdfErr != nil {
+			return nil, kdfErr
+		}
+
+		// ids are short, another device may share ours
+		if key, openErr := kek.Open(nil, make([]byte, kek.NonceSize()), s[keyIDSize:], nil); openErr == nil {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNotRecipient
+}
+
+// x25519 is the key agreement counterpart of the device key, the same scalar on the other curve form
+func (i Identity) x25519() (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(i.key.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// montgomery maps an ed25519 public key to X25519, the same point on the birationally equivalent curve
+func montgomery(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length %d", len(pub))
+	}
+
+	p, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ed25519 public key: %w", err)
+	}
+
+	return ecdh.X25519().NewPublicKey(p.BytesMontgomery())
+}
+
+func keyID(pub ed25519.PublicKey) [keyIDSize]byte {
+	spki, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(spki)
+	return [keyIDSize]byte(sum[:keyIDSize])
+}
+
+func wrapKey(shared, ephPub, peerPub []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, shared, slices.Concat(ephPub, peerPub), envelopeInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// payloadAEAD binds the payload to the header, so recipients cannot be swapped
+func payloadAEAD(payloadKey, header []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, payloadKey, header, payloadInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the chunk counter with a flag marking the last chunk, so chunks
+// cannot be reordered and the payload cannot be cut at a chunk boundary
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// signedData is what the sealer signs, the header and the digest of the sealed chunks
+func signedData(header []byte, digest hash.Hash) []byte {
+	return digest.Sum(slices.Clip(header))
+}
+
+type sealWriter struct {
+	dst     io.Writer
+	header  []byte
+	signed  []byte
+	key     ed25519.PrivateKey
+	digest  hash.Hash
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrBadEnvelope
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is flushed only when more data follows, the last one must be shorter
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *sealWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	if len(w.buf) == chunkSize {
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+
+	sig, err := w.key.Sign(nil, signedData(w.signed, w.digest), &ed25519.Options{Context: signatureInfo})
+	if err != nil {
+		return err
+	}
+	if _, err := w.dst.Write(sig); err != nil {
+		return err
+	}
+
+	w.closed = true
+	return nil
+}
+
+func (w *sealWriter) flush(last bool) error {
+	if w.header != nil {
+		if _, err := w.dst.Write(w.header); err != nil {
+			return err
+		}
+		w.header = nil
+	}
+
+	out := w.aead.Seal(nil, chunkNonce(w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	w.digest.Write(out)
+
+	_, err := w.dst.Write(out)
+	return err
+}
+
+type openReader struct {
+	src    io.Reader
+	header []byte
+	key    ed25519.PublicKey
+	digest hash.Hash
+	aead   cipher.AEAD
+	// buf holds a chunk and the bytes after it, the last chunk is the one followed by the signature only
+	buf     []byte
+	ahead   int
+	out     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (r *openReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *openReader) next() error {
+	n, err := io.ReadFull(r.src, r.buf[r.ahead:])
+	n += r.ahead
+	last := false
+	switch {
+	case err == nil:
+	case (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) && n >= tagSize+ed25519.SignatureSize:
+		last = true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: truncated", ErrBadEnvelope)
+	default:
+		return err
+	}
+
+	chunk := r.buf[:chunkSize+tagSize]
+	if last {
+		chunk = r.buf[:n-ed25519.SignatureSize]
+	}
+	r.digest.Write(chunk)
+
+	if last {
+		sig := r.buf[len(chunk):n]
+		if err := ed25519.VerifyWithOptions(r.key, signedData(r.header, r.digest), sig, &ed25519.Options{Context: signatureInfo}); err != nil {
+			return fmt.Errorf("%w: %w", ErrBadEnvelope, err)
+		}
+	}
+
+	plain, err := r.aead.Open(r.out[:0], chunkNonce(r.counter, last), chunk, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadEnvelope, err)
+	}
+
+	if !last {
+		r.ahead = copy(r.buf, r.buf[len(chunk):n])
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,184 @@
+package security_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/security"
+)
+
+func newIdentity(t *testing.T) security.Identity {
+	t.Helper()
+
+	id, err := security.LoadIdentity(filepath.Join(t.TempDir(), "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// keys trusts the devices it holds
+type keys []security.Identity
+
+func (k keys) Key(fp security.Fingerprint) ed25519.PublicKey {
+	for _, id := range k {
+		if id.Fingerprint() == fp {
+			return id.PublicKey()
+		}
+	}
+	return nil
+}
+
+func seal(t *testing.T, from security.Identity, plain []byte, recipients ...security.Identity) []byte {
+	t.Helper()
+
+	pubs := make([]ed25519.PublicKey, 0, len(recipients))
+	for _, r := range recipients {
+		pubs = append(pubs, r.PublicKey())
+	}
+
+	var buf bytes.Buffer
+	w, err := from.Seal(&buf, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	a, b, from := newIdentity(t), newIdentity(t), newIdentity(t)
+
+	for _, size := range []int{0, 5, 64 << 10, 2*(64<<10) + 7} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		sealed := seal(t, from, plain, a, b)
+		if want := security.SealedSize(uint64(size), 2); uint64(len(sealed)) != want {
+			t.Fatalf("size %d: sealed %d bytes, SealedSize says %d", size, len(sealed), want)
+		}
+
+		for _, id := range []security.Identity{a, b} {
+			r, err := id.Open(bytes.NewReader(sealed), keys{from})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("size %d: payload changed", size)
+			}
+		}
+	}
+}
+
+func TestEnvelope_NotRecipient(t *testing.T) {
+	a, relay, from := newIdentity(t), newIdentity(t), newIdentity(t)
+	sealed := seal(t, from, []byte("for a only"), a)
+
+	r, err := relay.Open(bytes.NewReader(sealed), keys{from})
+	if !errors.Is(err, security.ErrNotRecipient) {
+		t.Fatalf("Open by a stranger = %v, want ErrNotRecipient", err)
+	}
+
+	// what the relay forwards is the payload as sealed
+	forwarded, _ := io.ReadAll(r)
+	if !bytes.Equal(forwarded, sealed) {
+		t.Fatal("relay cannot forward the sealed payload unchanged")
+	}
+}
+
+func TestEnvelope_Tampered(t *testing.T) {
+	a, from := newIdentity(t), newIdentity(t)
+	sealed := seal(t, from, bytes.Repeat([]byte("x"), 70<<10), a)
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-ed25519.SignatureSize-1] ^= 1
+
+	unsigned := bytes.Clone(sealed)
+	unsigned[len(unsigned)-1] ^= 1
+
+	for name, data := range map[string][]byte{
+		"flipped":   flipped,
+		"unsigned":  unsigned,
+		"truncated": sealed[:len(sealed)-(70<<10-64<<10)-16-ed25519.SignatureSize],
+	} {
+		r, err := a.Open(bytes.NewReader(data), keys{from})
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, security.ErrBadEnvelope) {
+			t.Fatalf("%s: %v, want ErrBadEnvelope", name, err)
+		}
+	}
+}
+
+func TestEnvelope_Signer(t *testing.T) {
+	a, from, stranger := newIdentity(t), newIdentity(t), newIdentity(t)
+
+	// a payload sealed by a device we have not paired with is refused before it is read
+	if _, err := a.Open(bytes.NewReader(seal(t, stranger, []byte("hi"), a)), keys{from}); !errors.Is(err, security.ErrUnknownSigner) {
+		t.Fatalf("Open from a stranger = %v, want ErrUnknownSigner", err)
+	}
+
+	// a stranger cannot pass its payload for one of a paired device
+	forged := seal(t, stranger, []byte("hi"), a)
+	fp := from.Fingerprint()
+	copy(forged[1:], fp[:])
+
+	r, err := a.Open(bytes.NewReader(forged), keys{from})
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if !errors.Is(err, security.ErrBadEnvelope) {
+		t.Fatalf("Open of a forged signer = %v, want ErrBadEnvelope", err)
+	}
+}
+
+func TestSealer_Recipients(t *testing.T) {
+	a := newDevice(t, t.TempDir(), "laptop", "s3cret")
+	b := newDevice(t, t.TempDir(), "desktop", "s3cret")
+	_, _ = handshake(t, a, b)
+
+	sealer := security.NewSealer(b.id, b.trust)
+
+	var buf bytes.Buffer
+	w, size, err := sealer.Seal(&buf, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("ping"))
+	_ = w.Close()
+	if uint64(buf.Len()) != size {
+		t.Fatalf("sealed %d bytes, want %d", buf.Len(), size)
+	}
+
+	// the key of the paired device was learned during the handshake
+	r, err := security.NewSealer(a.id, a.trust).Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "ping" {
+		t.Fatalf("opened %q", got)
+	}
+
+	if _, err := b.trust.SetRelay("laptop", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sealer.Seal(io.Discard, 4); !errors.Is(err, security.ErrNoRecipients) {
+		t.Fatalf("Seal with only a relay = %v, want ErrNoRecipients", err)
+	}
+}
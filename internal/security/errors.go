@@ -3,7 +3,17 @@ package security
 import "errors"
 
 var (
-	ErrLocalSecretMissing = errors.New("local node has no secret configured")
-	ErrPeerSecretMissing  = errors.New("peer has no secret configured")
-	ErrSecretMismatch     = errors.New("different secrets configured")
+	ErrNoCertificate    = errors.New("peer presented no certificate")
+	ErrNotPaired        = errors.New("device is not paired")
+	ErrRevoked          = errors.New("device is revoked")
+	ErrPairingNotFound  = errors.New("pairing request not found")
+	ErrPairingAmbiguous = errors.New("more than one pairing request matches")
+	ErrDeviceNotFound   = errors.New("device not found")
+	ErrDeviceAmbiguous  = errors.New("more than one device matches")
+	ErrEmptyName        = errors.New("device name is empty")
+	ErrNoRecipients     = errors.New("no device to seal the payload for")
+	ErrNotRecipient     = errors.New("payload is not sealed for this device")
+	ErrBadEnvelope      = errors.New("malformed or tampered sealed payload")
+	ErrUnknownSigner    = errors.New("payload is sealed by a device that is not paired")
+	ErrSecretMismatch   = errors.New("device does not know the relay secret")
 )
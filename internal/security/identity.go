@@ -0,0 +1,151 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fingerprint is the sha256 of a device public key (SubjectPublicKeyInfo),
+// it stays the same when the certificate is regenerated
+type Fingerprint [sha256.Size]byte
+
+func FingerprintOf(cert *x509.Certificate) Fingerprint {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+func ParseFingerprint(s string) (Fingerprint, error) {
+	var fp Fingerprint
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(b) != len(fp) {
+		return fp, fmt.Errorf("invalid fingerprint %q", s)
+	}
+	copy(fp[:], b)
+	return fp, nil
+}
+
+func (f Fingerprint) String() string {
+	return hex.EncodeToString(f[:])
+}
+
+// Short is enough to tell devices apart in listings
+func (f Fingerprint) Short() string {
+	return hex.EncodeToString(f[:8])
+}
+
+// DeviceID is the id the device goes by on the wire, 63 bits of the fingerprint, never 0
+func (f Fingerprint) DeviceID() int64 {
+	return max(int64(binary.BigEndian.Uint64(f[:8])>>1), 1)
+}
+
+func (f Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Fingerprint) UnmarshalText(text []byte) error {
+	fp, err := ParseFingerprint(string(text))
+	if err != nil {
+		return err
+	}
+	*f = fp
+	return nil
+}
+
+// PairingCode is a short code both devices derive from their fingerprints.
+// Users compare it on both screens to make sure nobody is in the middle
+func PairingCode(a, b Fingerprint) string {
+	lo, hi := a, b
+	if bytes.Compare(a[:], b[:]) > 0 {
+		lo, hi = b, a
+	}
+
+	sum := sha256.Sum256(append(lo[:], hi[:]...))
+	n := binary.BigEndian.Uint32(sum[:4]) % 1_000_000
+
+	return fmt.Sprintf("%03d %03d", n/1000, n%1000)
+}
+
+// Identity is the persistent keypair of this device
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// LoadIdentity reads the key at path, generating it on the first run
+func LoadIdentity(path string) (Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generateIdentity(path)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("security.LoadIdentity: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Identity{}, fmt.Errorf("security.LoadIdentity: %s: no pem block", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return Identity{}, fmt.Errorf("security.LoadIdentity: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return Identity{}, fmt.Errorf("security.LoadIdentity: %s: not an ed25519 key", path)
+	}
+
+	return Identity{key: edKey}, nil
+}
+
+func generateIdentity(path string) (Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Identity{}, fmt.Errorf("security.generateIdentity: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return Identity{}, fmt.Errorf("security.generateIdentity: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return Identity{}, fmt.Errorf("security.generateIdentity: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return Identity{}, fmt.Errorf("security.generateIdentity: %w", err)
+	}
+
+	return Identity{key: key}, nil
+}
+
+func (i Identity) PublicKey() ed25519.PublicKey {
+	return i.key.Public().(ed25519.PublicKey)
+}
+
+func (i Identity) Fingerprint() Fingerprint {
+	spki, _ := x509.MarshalPKIXPublicKey(i.key.Public())
+	return sha256.Sum256(spki)
+}
+
+// secretProof binds the shared secret to a public key, so the proof
+// copied from one certificate is useless with another key
+func secretProof(secret string, spki []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(spki)
+	return mac.Sum(nil)
+}
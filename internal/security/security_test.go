@@ -0,0 +1,227 @@
+package security_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/rs/zerolog"
+)
+
+type device struct {
+	id       security.Identity
+	trust    *security.TrustStore
+	verifier *security.Verifier
+	conf     *tls.Config
+}
+
+func newDevice(t *testing.T, dir, name, secret string) device {
+	t.Helper()
+
+	id, err := security.LoadIdentity(filepath.Join(dir, "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust, err := security.OpenTrustStore(filepath.Join(dir, "trusted.json"), id.Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := security.NewVerifier(trust, secret)
+	conf, err := security.MakeTLSConfig(id, verifier, name, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return device{id: id, trust: trust, verifier: verifier, conf: conf}
+}
+
+// handshake connects client to server and returns both sides' errors,
+// the client error includes the post-handshake Authorize check
+func handshake(t *testing.T, client, server device) (error, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(s, server.conf).HandshakeContext(ctx)
+		_ = s.Close()
+	}()
+
+	cc := tls.Client(c, client.conf)
+	clientErr := cc.HandshakeContext(ctx)
+	if clientErr == nil {
+		clientErr = client.verifier.Authorize(cc.ConnectionState().PeerCertificates[0])
+	}
+	_ = c.Close()
+
+	return clientErr, <-serverErr
+}
+
+func TestLoadIdentity_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "identity.pem")
+
+	first, err := security.LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := security.LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Fingerprint() != second.Fingerprint() {
+		t.Fatal("identity changed between loads")
+	}
+}
+
+func TestPairingCode_Symmetric(t *testing.T) {
+	a := security.Fingerprint{1}
+	b := security.Fingerprint{2}
+
+	if security.PairingCode(a, b) != security.PairingCode(b, a) {
+		t.Fatal("code depends on the order of fingerprints")
+	}
+	if code := security.PairingCode(a, b); len(code) != 7 || code[3] != ' ' {
+		t.Fatalf("code = %q, want ddd ddd", code)
+	}
+}
+
+func TestPairing(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	a := newDevice(t, dirA, "laptop", "")
+	b := newDevice(t, dirB, "desktop", "")
+
+	clientErr, serverErr := handshake(t, a, b)
+	if !errors.Is(clientErr, security.ErrNotPaired) || !errors.Is(serverErr, security.ErrNotPaired) {
+		t.Fatalf("unpaired handshake: client %v, server %v", clientErr, serverErr)
+	}
+
+	reqA, reqB := a.trust.Pending(), b.trust.Pending()
+	if len(reqA) != 1 || len(reqB) != 1 {
+		t.Fatalf("pending: a %+v, b %+v", reqA, reqB)
+	}
+	if reqA[0].Code != reqB[0].Code {
+		t.Fatalf("codes differ: %s vs %s", reqA[0].Code, reqB[0].Code)
+	}
+	if reqB[0].Name != "laptop" || reqB[0].Fingerprint != a.id.Fingerprint() {
+		t.Fatalf("b sees %+v", reqB[0])
+	}
+
+	// only one side accepted, the other still rejects
+	if _, err := b.trust.Accept(reqB[0].Code); err != nil {
+		t.Fatal(err)
+	}
+	if clientErr, _ := handshake(t, a, b); !errors.Is(clientErr, security.ErrNotPaired) {
+		t.Fatalf("half paired: client %v", clientErr)
+	}
+
+	if _, err := a.trust.Accept(reqA[0].Fingerprint.Short()); err != nil {
+		t.Fatal(err)
+	}
+	if clientErr, serverErr := handshake(t, a, b); clientErr != nil || serverErr != nil {
+		t.Fatalf("paired handshake: client %v, server %v", clientErr, serverErr)
+	}
+
+	// trust survives a restart
+	reopened, err := security.OpenTrustStore(filepath.Join(dirB, "trusted.json"), b.id.Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Trusted(a.id.Fingerprint()) {
+		t.Fatal("paired device lost after reopen")
+	}
+}
+
+func TestPairing_Reject(t *testing.T) {
+	a := newDevice(t, t.TempDir(), "laptop", "")
+	b := newDevice(t, t.TempDir(), "desktop", "")
+	_, _ = handshake(t, a, b)
+
+	code := b.trust.Pending()[0].Code
+	if err := b.trust.Reject(code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.trust.Accept(code); !errors.Is(err, security.ErrPairingNotFound) {
+		t.Fatalf("accept after reject = %v", err)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	a := newDevice(t, t.TempDir(), "laptop", "s3cret")
+	b := newDevice(t, t.TempDir(), "desktop", "s3cret")
+	if clientErr, serverErr := handshake(t, a, b); clientErr != nil || serverErr != nil {
+		t.Fatalf("same secret: client %v, server %v", clientErr, serverErr)
+	}
+
+	c := newDevice(t, t.TempDir(), "phone", "other")
+	if clientErr, serverErr := handshake(t, c, b); !errors.Is(clientErr, security.ErrNotPaired) || serverErr == nil {
+		t.Fatalf("different secret: client %v, server %v", clientErr, serverErr)
+	}
+	if devices := b.trust.Devices(); len(devices) != 1 || devices[0].Name != "laptop" {
+		t.Fatalf("secret holder not listed: %+v", devices)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	a := newDevice(t, t.TempDir(), "laptop", "s3cret")
+	dirB := t.TempDir()
+	b := newDevice(t, dirB, "desktop", "s3cret")
+	_, _ = handshake(t, a, b)
+
+	var revoked []security.Device
+	b.trust.OnRevoke(func(d security.Device) { revoked = append(revoked, d) })
+
+	if _, err := b.trust.Revoke("LAPTOP"); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].Fingerprint != a.id.Fingerprint() {
+		t.Fatalf("OnRevoke got %+v", revoked)
+	}
+
+	// the secret no longer helps, not even after a restart
+	b = newDevice(t, dirB, "desktop", "s3cret")
+	if _, serverErr := handshake(t, a, b); !errors.Is(serverErr, security.ErrRevoked) {
+		t.Fatalf("revoked handshake: server %v", serverErr)
+	}
+	if len(b.trust.Devices()) != 0 {
+		t.Fatalf("devices after revoke: %+v", b.trust.Devices())
+	}
+
+	// pairing again on purpose lifts the revocation
+	if _, err := b.trust.Accept(a.id.Fingerprint().Short()); err != nil {
+		t.Fatal(err)
+	}
+	if clientErr, serverErr := handshake(t, a, b); clientErr != nil || serverErr != nil {
+		t.Fatalf("paired again: client %v, server %v", clientErr, serverErr)
+	}
+}
+
+func TestRename(t *testing.T) {
+	a := newDevice(t, t.TempDir(), "laptop", "s3cret")
+	b := newDevice(t, t.TempDir(), "desktop", "s3cret")
+	_, _ = handshake(t, a, b)
+
+	dev, err := b.trust.Rename(a.id.Fingerprint().Short()[:6], "work laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Name != "work laptop" {
+		t.Fatalf("renamed = %+v", dev)
+	}
+	if _, err := b.trust.Rename("work laptop", " "); !errors.Is(err, security.ErrEmptyName) {
+		t.Fatalf("empty name = %v", err)
+	}
+	if _, err := b.trust.Revoke("phone"); !errors.Is(err, security.ErrDeviceNotFound) {
+		t.Fatalf("unknown device = %v", err)
+	}
+}
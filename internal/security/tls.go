@@ -1,25 +1,107 @@
 package security
 
 import (
-	"bytes"
-	"crypto"
-	"crypto/ecdsa"
 	"crypto/ed25519"
-	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"fmt"
 	"math/big"
-	"net"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
-func MakeTLSConfig(secret string, logger zerolog.Logger) (*tls.Config, error) {
+// secretProofOID marks the certificate extension with the shared secret proof
+var secretProofOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 58913, 1, 1}
+
+// Verifier decides which devices may connect: paired ones,
+// and with a shared secret also every device that knows it, unless revoked
+type Verifier struct {
+	trust  *TrustStore
+	secret string
+}
+
+func NewVerifier(trust *TrustStore, secret string) *Verifier {
+	return &Verifier{trust: trust, secret: secret}
+}
+
+// NewRelayVerifier accepts the devices that know the secret, or every device when it is empty.
+// A relay has no paired devices, it only splices streams it cannot read
+func NewRelayVerifier(secret string) *Verifier {
+	return &Verifier{secret: secret}
+}
+
+// Authorize accepts a paired device, an unknown one is recorded as a pairing request.
+// A revoked device is refused even with the shared secret
+func (v *Verifier) Authorize(cert *x509.Certificate) error {
+	if cert == nil {
+		return ErrNoCertificate
+	}
+
+	fp := FingerprintOf(cert)
+	if v.trust == nil {
+		if v.secret == "" || v.knowsSecret(cert) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s (%s)", ErrSecretMismatch, cert.Subject.CommonName, fp.Short())
+	}
+
+	key, _ := cert.PublicKey.(ed25519.PublicKey)
+	if v.trust.Revoked(fp) {
+		v.trust.request(fp, cert.Subject.CommonName, key)
+		return fmt.Errorf("%w: %s (%s)", ErrRevoked, cert.Subject.CommonName, fp.Short())
+	}
+
+	if v.trust.Trusted(fp) {
+		return v.trust.learnKey(fp, key)
+	}
+
+	// remembered, so that the device can be listed and revoked like a paired one
+	if v.knowsSecret(cert) {
+		return v.trust.admit(fp, cert.Subject.CommonName, key)
+	}
+
+	v.trust.request(fp, cert.Subject.CommonName, key)
+	return fmt.Errorf("%w: %s (%s)", ErrNotPaired, cert.Subject.CommonName, fp.Short())
+}
+
+func (v *Verifier) knowsSecret(cert *x509.Certificate) bool {
+	if v.secret == "" {
+		return false
+	}
+
+	want := secretProof(v.secret, cert.RawSubjectPublicKeyInfo)
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(secretProofOID) {
+			return hmac.Equal(ext.Value, want)
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) verify(rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return ErrNoCertificate
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse peer cert: %w", err)
+	}
+
+	return v.Authorize(cert)
+}
+
+// MakeTLSConfig builds a config presenting a certificate for id, named after the device.
+// The listening side rejects devices the verifier does not accept during the handshake.
+// The dialing side lets the handshake finish, so that the listener sees our certificate
+// and gets a pairing request too, the caller must check the peer with Verifier.Authorize
+func MakeTLSConfig(id Identity, verifier *Verifier, name string, logger zerolog.Logger) (*tls.Config, error) {
 	//nolint:mnd,gosec //shut up
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
@@ -28,98 +110,54 @@ func MakeTLSConfig(secret string, logger zerolog.Logger) (*tls.Config, error) {
 
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: name},
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().Add(24 * time.Hour * 365 * 10),
-		IPAddresses: []net.IP{
-			net.ParseIP("127.0.0.1"), // localhost
-			net.ParseIP("0.0.0.0"),   // any
-			net.IPv4(192, 168, 0, 0), // 192.168.0.0/16
-			net.IPv4(10, 0, 0, 0),    // 10.0.0.0/8
-			net.IPv4(172, 16, 0, 0),  // 172.16.0.0/12
-		},
 	}
 
-	privateKey, publicKey, err2 := genKey(secret)
-	if err2 != nil {
-		return nil, err2
+	if verifier.secret != "" {
+		spki, mErr := x509.MarshalPKIXPublicKey(id.key.Public())
+		if mErr != nil {
+			return nil, fmt.Errorf("x509.MarshalPKIXPublicKey: %w", mErr)
+		}
+		template.ExtraExtensions = []pkix.Extension{{
+			Id:    secretProofOID,
+			Value: secretProof(verifier.secret, spki),
+		}}
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey, privateKey)
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, id.key.Public(), id.key)
 	if err != nil {
 		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
 	}
 
-	privBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
-	if err != nil {
-		return nil, fmt.Errorf("x509.MarshalPKCS8PrivateKey: %w", err)
-	}
-
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-
-	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
-	if err != nil {
-		return nil, fmt.Errorf("tls.X509KeyPair: %w", err)
-	}
-
 	conf := &tls.Config{
-		Certificates:       []tls.Certificate{tlsCert},
-		NextProtos:         []string{"belphegor"},
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certDER},
+			PrivateKey:  id.key,
+		}},
+		NextProtos: []string{"belphegor"},
+		// identities are self-signed, trust is decided by the verifier
 		InsecureSkipVerify: true,
+		ClientAuth:         tls.RequireAnyClientCert,
 		MinVersion:         tls.VersionTLS13,
 	}
 
 	populateKeyLog(logger, conf)
 
+	// recorded as a pairing request, the connection is dropped by the dialer right after the handshake
 	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-		if len(rawCerts) == 0 {
-			return ErrPeerSecretMissing
-		}
-
-		peerCert, pErr := x509.ParseCertificate(rawCerts[0])
-		if pErr != nil {
-			return fmt.Errorf("failed to parse peer cert: %w", pErr)
-		}
-
-		myCert, mErr := x509.ParseCertificate(certDER)
-		if mErr != nil {
-			return fmt.Errorf("failed to parse my cert: %w", mErr)
-		}
-
-		myPub, myOk := myCert.PublicKey.(ed25519.PublicKey)
-		peerPub, peerOk := peerCert.PublicKey.(ed25519.PublicKey)
-
-		if myOk != peerOk {
-			if myOk {
-				return ErrPeerSecretMissing
-			}
-			return ErrLocalSecretMissing
-		}
-
-		if !myOk && !peerOk {
-			return nil
-		}
-
-		if !bytes.Equal(myPub, peerPub) {
-			return ErrSecretMismatch
-		}
-
+		_ = verifier.verify(rawCerts)
 		return nil
 	}
 
-	return conf, nil
-}
-
-func genKey(secret string) (crypto.PrivateKey, crypto.PublicKey, error) {
-	if secret != "" {
-		seed := sha256.Sum256([]byte(secret))
-		pk := ed25519.NewKeyFromSeed(seed[:])
-		return pk, pk.Public(), nil
+	server := conf.Clone()
+	server.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifier.verify(rawCerts)
 	}
-
-	ecdsaPriv, eErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if eErr != nil {
-		return nil, nil, fmt.Errorf("ecdsa.GenerateKey: %w", eErr)
+	conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return server, nil
 	}
-	return ecdsaPriv, ecdsaPriv.Public(), nil
+
+	return conf, nil
 }
@@ -0,0 +1,476 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pairingTTL is how long an unanswered pairing request is kept
+	pairingTTL = 10 * time.Minute
+	// maxPending bounds the memory a flood of unknown devices can take
+	maxPending = 32
+)
+
+// Device is a paired device
+type Device struct {
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Name        string      `json:"name"`
+	Paired      time.Time   `json:"paired"`
+	// Key seals payloads for the device, learned when it connects
+	Key ed25519.PublicKey `json:"key,omitempty"`
+	// Relay devices connect and forward our payloads but cannot read them
+	Relay bool `json:"relay,omitempty"`
+}
+
+// PairingRequest is an unknown device that tried to connect
+type PairingRequest struct {
+	Fingerprint Fingerprint
+	Name        string
+	Code        string
+	Seen        time.Time
+	Key         ed25519.PublicKey
+}
+
+type trustFile struct {
+	Devices []Device      `json:"devices"`
+	Revoked []Fingerprint `json:"revoked,omitempty"`
+}
+
+// TrustStore keeps paired and revoked devices on disk and pairing requests in memory
+type TrustStore struct {
+	path string
+	self Fingerprint
+
+	mu        sync.Mutex
+	devices   map[Fingerprint]Device
+	revoked   map[Fingerprint]struct{}
+	pending   map[Fingerprint]PairingRequest
+	onRequest func(PairingRequest)
+	onRevoke  func(Device)
+}
+
+func OpenTrustStore(path string, self Fingerprint) (*TrustStore, error) {
+	t := &TrustStore{
+		path:    path,
+		self:    self,
+		devices: make(map[Fingerprint]Device),
+		revoked: make(map[Fingerprint]struct{}),
+		pending: make(map[Fingerprint]PairingRequest),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("security.OpenTrustStore: %w", err)
+	}
+
+	var file trustFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("security.OpenTrustStore: %s: %w", path, err)
+	}
+	for _, d := range file.Devices {
+		t.devices[d.Fingerprint] = d
+	}
+	for _, fp := range file.Revoked {
+		t.revoked[fp] = struct{}{}
+	}
+
+	return t, nil
+}
+
+// OnRequest sets the callback for a device seen for the first time
+func (t *TrustStore) OnRequest(fn func(PairingRequest)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onRequest = fn
+}
+
+// OnRevoke sets the callback for a revoked device, called after the change is saved
+func (t *TrustStore) OnRevoke(fn func(Device)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onRevoke = fn
+}
+
+func (t *TrustStore) Self() Fingerprint {
+	return t.self
+}
+
+func (t *TrustStore) Trusted(fp Fingerprint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.devices[fp]
+	return ok
+}
+
+// Revoked reports whether the device was revoked and not paired again since
+func (t *TrustStore) Revoked(fp Fingerprint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.revoked[fp]
+	return ok
+}
+
+// Devices returns paired devices sorted by name
+func (t *TrustStore) Devices() []Device {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]Device, 0, len(t.devices))
+	for _, d := range t.devices {
+		res = append(res, d)
+	}
+	slices.SortFunc(res, func(a, b Device) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return res
+}
+
+// Pending returns unanswered pairing requests, newest first
+func (t *TrustStore) Pending() []PairingRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+
+	res := make([]PairingRequest, 0, len(t.pending))
+	for _, r := range t.pending {
+		res = append(res, r)
+	}
+	slices.SortFunc(res, func(a, b PairingRequest) int {
+		return b.Seen.Compare(a.Seen)
+	})
+
+	return res
+}
+
+// Accept pairs the device whose request matches ref, a pairing code or a fingerprint prefix.
+// A revoked device is trusted again
+func (t *TrustStore) Accept(ref string) (Device, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	req, err := t.find(ref)
+	if err != nil {
+		return Device{}, err
+	}
+
+	dev := Device{
+		Fingerprint: req.Fingerprint,
+		Name:        req.Name,
+		Paired:      time.Now(),
+		Key:         req.Key,
+	}
+	_, wasRevoked := t.revoked[dev.Fingerprint]
+	t.devices[dev.Fingerprint] = dev
+	delete(t.revoked, dev.Fingerprint)
+	if err := t.save(); err != nil {
+		delete(t.devices, dev.Fingerprint)
+		if wasRevoked {
+			t.revoked[dev.Fingerprint] = struct{}{}
+		}
+		return Device{}, err
+	}
+	delete(t.pending, dev.Fingerprint)
+
+	return dev, nil
+}
+
+// admit pairs a device without a request, used for devices that know the shared secret
+func (t *TrustStore) admit(fp Fingerprint, name string, key ed25519.PublicKey) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.devices[fp]; ok {
+		return nil
+	}
+
+	t.devices[fp] = Device{Fingerprint: fp, Name: name, Paired: time.Now(), Key: key}
+	if err := t.save(); err != nil {
+		delete(t.devices, fp)
+		return err
+	}
+	delete(t.pending, fp)
+
+	return nil
+}
+
+// learnKey remembers the key of a device paired before keys were kept
+func (t *TrustStore) learnKey(fp Fingerprint, key ed25519.PublicKey) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dev, ok := t.devices[fp]
+	if !ok || dev.Key != nil || key == nil {
+		return nil
+	}
+
+	dev.Key = key
+	t.devices[fp] = dev
+	if err := t.save(); err != nil {
+		dev.Key = nil
+		t.devices[fp] = dev
+		return err
+	}
+
+	return nil
+}
+
+// Recipients returns the keys of the paired devices that may read our payloads
+func (t *TrustStore) Recipients() []ed25519.PublicKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]ed25519.PublicKey, 0, len(t.devices))
+	for _, d := range t.devices {
+		if d.Key != nil && !d.Relay {
+			res = append(res, d.Key)
+		}
+	}
+
+	return res
+}
+
+// Key returns the key of the paired device with the fingerprint, nil if it is not paired or its key is not known yet
+func (t *TrustStore) Key(fp Fingerprint) ed25519.PublicKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.devices[fp].Key
+}
+
+// SetRelay marks the paired device matching ref, a fingerprint prefix or the name,
+// as a relay: payloads are no longer sealed for it, so it forwards them without reading
+func (t *TrustStore) SetRelay(ref string, relay bool) (Device, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dev, err := t.findDevice(ref)
+	if err != nil {
+		return Device{}, err
+	}
+
+	old := dev
+	dev.Relay = relay
+	t.devices[dev.Fingerprint] = dev
+	if err := t.save(); err != nil {
+		t.devices[dev.Fingerprint] = old
+		return Device{}, err
+	}
+
+	return dev, nil
+}
+
+// Rename changes the local name of the paired device matching ref,
+// a fingerprint prefix or the current name
+func (t *TrustStore) Rename(ref, name string) (Device, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Device{}, ErrEmptyName
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dev, err := t.findDevice(ref)
+	if err != nil {
+		return Device{}, err
+	}
+
+	old := dev
+	dev.Name = name
+	t.devices[dev.Fingerprint] = dev
+	if err := t.save(); err != nil {
+		t.devices[dev.Fingerprint] = old
+		return Device{}, err
+	}
+
+	return dev, nil
+}
+
+// Revoke forgets the paired device matching ref, a fingerprint prefix or the name.
+// The device is refused even if it knows the shared secret, until it is paired again
+func (t *TrustStore) Revoke(ref string) (Device, error) {
+	t.mu.Lock()
+
+	dev, err := t.findDevice(ref)
+	if err != nil {
+		t.mu.Unlock()
+		return Device{}, err
+	}
+
+	delete(t.devices, dev.Fingerprint)
+	t.revoked[dev.Fingerprint] = struct{}{}
+	if err := t.save(); err != nil {
+		t.devices[dev.Fingerprint] = dev
+		delete(t.revoked, dev.Fingerprint)
+		t.mu.Unlock()
+		return Device{}, err
+	}
+	onRevoke := t.onRevoke
+
+	t.mu.Unlock()
+
+	if onRevoke != nil {
+		onRevoke(dev)
+	}
+
+	return dev, nil
+}
+
+// Reject drops the request matching ref, the device may ask again
+func (t *TrustStore) Reject(ref string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	req, err := t.find(ref)
+	if err != nil {
+		return err
+	}
+	delete(t.pending, req.Fingerprint)
+
+	return nil
+}
+
+// request records an unknown device, repeated attempts only refresh Seen.
+// A revoked device is recorded silently, it can only be paired again on purpose
+func (t *TrustStore) request(fp Fingerprint, name string, key ed25519.PublicKey) {
+	t.mu.Lock()
+
+	t.expire()
+
+	req, exists := t.pending[fp]
+	if !exists && len(t.pending) >= maxPending {
+		t.mu.Unlock()
+		return
+	}
+
+	req.Fingerprint, req.Name, req.Seen, req.Key = fp, name, time.Now(), key
+	req.Code = PairingCode(t.self, fp)
+	t.pending[fp] = req
+	onRequest := t.onRequest
+	_, revoked := t.revoked[fp]
+
+	t.mu.Unlock()
+
+	if !exists && !revoked && onRequest != nil {
+		onRequest(req)
+	}
+}
+
+func (t *TrustStore) find(ref string) (PairingRequest, error) {
+	t.expire()
+
+	ref = strings.ToLower(strings.ReplaceAll(ref, " ", ""))
+	if ref == "" {
+		return PairingRequest{}, ErrPairingNotFound
+	}
+
+	var found []PairingRequest
+	for _, r := range t.pending {
+		if strings.ReplaceAll(r.Code, " ", "") == ref || strings.HasPrefix(r.Fingerprint.String(), ref) {
+			found = append(found, r)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return PairingRequest{}, ErrPairingNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return PairingRequest{}, ErrPairingAmbiguous
+	}
+}
+
+func (t *TrustStore) findDevice(ref string) (Device, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return Device{}, ErrDeviceNotFound
+	}
+
+	var found []Device
+	for _, d := range t.devices {
+		if strings.EqualFold(d.Name, ref) {
+			found = append(found, d)
+		}
+	}
+	if len(found) == 0 {
+		prefix := strings.ToLower(strings.ReplaceAll(ref, ":", ""))
+		for _, d := range t.devices {
+			if strings.HasPrefix(d.Fingerprint.String(), prefix) {
+				found = append(found, d)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return Device{}, ErrDeviceNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return Device{}, ErrDeviceAmbiguous
+	}
+}
+
+func (t *TrustStore) expire() {
+	for fp, r := range t.pending {
+		if time.Since(r.Seen) > pairingTTL {
+			delete(t.pending, fp)
+		}
+	}
+}
+
+func (t *TrustStore) save() error {
+	file := trustFile{Devices: make([]Device, 0, len(t.devices))}
+	for _, d := range t.devices {
+		file.Devices = append(file.Devices, d)
+	}
+	slices.SortFunc(file.Devices, func(a, b Device) int {
+		return a.Paired.Compare(b.Paired)
+	})
+	for fp := range t.revoked {
+		file.Revoked = append(file.Revoked, fp)
+	}
+	slices.SortFunc(file.Revoked, func(a, b Fingerprint) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("security.TrustStore.save: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return fmt.Errorf("security.TrustStore.save: %w", err)
+	}
+
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("security.TrustStore.save: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fmt.Errorf("security.TrustStore.save: %w", err)
+	}
+
+	return nil
+}
@@ -26,6 +26,7 @@ ConditionEnvironment=DISPLAY
 [Service]
 Type=simple
 ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
 Environment="PATH=%s"
 Environment="DBUS_SESSION_BUS_ADDRESS=%s"
 Restart=on-failure
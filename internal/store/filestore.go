@@ -6,9 +6,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/labi-le/belphegor/internal/protocol"
 	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/archive"
 	"github.com/labi-le/belphegor/pkg/pool/byteslice"
 	"github.com/rs/zerolog"
 )
@@ -44,59 +47,190 @@ func MustFileStore(baseDir string, logger zerolog.Logger) *FileStore {
 }
 
 func (fs *FileStore) Write(r io.Reader, msg domain.Message) (string, error) {
-	if msg.Name == "" {
-		return "", errors.New("invalid filename: name is empty")
+	fullPath, err := fs.Path(msg)
+	if err != nil {
+		return "", err
 	}
 
-	var isolateDir string
-	if msg.BatchID != 0 {
-		isolateDir = filepath.Join(fs.baseDir, msg.BatchID.String())
-	} else {
-		isolateDir = filepath.Join(fs.baseDir, msg.ID.String())
+	if err := os.MkdirAll(fs.isolateDir(msg), 0750); err != nil {
+		return "", fmt.Errorf("filestore mkdir isolated: %w", err)
 	}
 
-	isolateDirClean := filepath.Clean(isolateDir)
-	if err := os.MkdirAll(isolateDirClean, 0750); err != nil {
-		return "", fmt.Errorf("filestore mkdir isolated: %w", err)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0750); err != nil {
+		return "", fmt.Errorf("filestore mkdir tree: %w", err)
 	}
 
-	fullPath := filepath.Join(isolateDirClean, msg.Name)
+	if info, err := os.Stat(fullPath); err == nil && saved(info, msg) {
+		fs.logger.Trace().Str("path", fullPath).Msg("file already exists, skipping download")
+		return fullPath, ErrFileExists
+	}
 
-	if !strings.HasPrefix(fullPath, isolateDirClean+string(filepath.Separator)) {
-		return "", fmt.Errorf("invalid filename: path traversal attempt detected (%q)", msg.Name)
+	if msg.Offset > msg.ContentLength {
+		return "", fmt.Errorf("filestore offset %d beyond content length %d", msg.Offset, msg.ContentLength)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0750); err != nil {
-		return "", fmt.Errorf("filestore mkdir tree: %w", err)
+	partPath := fs.partPath(msg)
+	if err := fs.writePart(partPath, r, msg); err != nil {
+		return "", err
+	}
+
+	if unpacked(msg) {
+		if err := fs.unpack(partPath, fullPath); err != nil {
+			return "", err
+		}
+	} else if err := os.Rename(partPath, fullPath); err != nil {
+		return "", fmt.Errorf("filestore rename part: %w", err)
+	}
+
+	fs.logger.Trace().Str("path", fullPath).Msg("file saved")
+	return fullPath, nil
+}
+
+// writePart appends the content to the part file at msg.Offset and verifies the whole of it
+func (fs *FileStore) writePart(partPath string, r io.Reader, msg domain.Message) error {
+	part, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("filestore create file: %w", err)
+	}
+	defer part.Close()
+
+	info, err := part.Stat()
+	if err != nil {
+		return fmt.Errorf("filestore stat part: %w", err)
+	}
+	if uint64(info.Size()) < msg.Offset {
+		return fmt.Errorf("filestore resume at %d, only %d written", msg.Offset, info.Size())
+	}
+	// a sender that does not resume starts over
+	if err := part.Truncate(int64(msg.Offset)); err != nil {
+		return fmt.Errorf("filestore truncate part: %w", err)
+	}
+	if _, err := part.Seek(int64(msg.Offset), io.SeekStart); err != nil {
+		return fmt.Errorf("filestore seek part: %w", err)
 	}
 
 	buf := byteslice.Get(bufSize)
 	defer byteslice.Put(buf)
 
-	if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
-		if uint64(info.Size()) == msg.ContentLength {
-			fs.logger.Trace().Str("path", fullPath).Msg("file already exists, skipping download")
-			return fullPath, ErrFileExists
-		}
+	left := msg.ContentLength - msg.Offset
+	n, err := io.CopyBuffer(part, io.LimitReader(r, int64(left)), buf)
+	if err != nil {
+		fs.logger.Trace().Str("path", partPath).Uint64("written", msg.Offset+uint64(n)).Msg("part kept")
+		return fmt.Errorf("filestore write content: %w", err)
+	}
+
+	if uint64(n) != left {
+		fs.logger.Trace().Str("path", partPath).Uint64("written", msg.Offset+uint64(n)).Msg("part kept")
+		return fmt.Errorf("filestore incomplete write: expected %d, got %d", msg.ContentLength, msg.Offset+uint64(n))
 	}
 
-	file, err := os.Create(fullPath)
+	if msg.Checksum == 0 {
+		return part.Close()
+	}
+
+	sum, err := checksum(partPath)
 	if err != nil {
-		return "", fmt.Errorf("filestore create file: %w", err)
+		return fmt.Errorf("filestore checksum: %w", err)
 	}
-	defer file.Close()
+	if sum != msg.Checksum {
+		_ = part.Close()
+		_ = os.Remove(partPath)
+		return fmt.Errorf("filestore %s: %w", msg.Name, ErrChecksum)
+	}
+
+	return part.Close()
+}
 
-	n, err := io.CopyBuffer(file, io.LimitReader(r, int64(msg.ContentLength)), buf)
+// unpack rebuilds the directory archived in the part file at fullPath
+func (fs *FileStore) unpack(partPath, fullPath string) error {
+	part, err := os.Open(partPath)
 	if err != nil {
-		_ = os.Remove(fullPath)
-		return "", fmt.Errorf("filestore write content: %w", err)
+		return fmt.Errorf("filestore open part: %w", err)
+	}
+	defer part.Close()
+
+	// unpacked next to the part, a broken archive leaves nothing at fullPath
+	tree := strings.TrimSuffix(partPath, ".part") + ".tree"
+	_ = os.RemoveAll(tree)
+	if err := archive.Extract(part, tree); err != nil {
+		_ = os.RemoveAll(tree)
+		return fmt.Errorf("filestore unpack: %w", err)
 	}
 
-	if uint64(n) != msg.ContentLength {
-		_ = os.Remove(fullPath)
-		return "", fmt.Errorf("filestore incomplete write: expected %d, got %d", msg.ContentLength, n)
+	if err := os.Rename(tree, fullPath); err != nil {
+		_ = os.RemoveAll(tree)
+		return fmt.Errorf("filestore rename tree: %w", err)
+	}
+
+	_ = part.Close()
+	return os.Remove(partPath)
+}
+
+// saved reports whether info is the content of msg written before
+func saved(info os.FileInfo, msg domain.Message) bool {
+	if unpacked(msg) {
+		return info.IsDir()
+	}
+	return !info.IsDir() && uint64(info.Size()) == msg.ContentLength
+}
+
+// unpacked is true when the content of msg is a directory archive to rebuild,
+// a relay keeps one sealed for other devices as it is
+func unpacked(msg domain.Message) bool {
+	return msg.Dir && !msg.Sealed
+}
+
+// Path is where the content of msg is saved
+func (fs *FileStore) Path(msg domain.Message) (string, error) {
+	if msg.Name == "" {
+		return "", errors.New("invalid filename: name is empty")
+	}
+
+	isolateDirClean := fs.isolateDir(msg)
+	fullPath := filepath.Join(isolateDirClean, msg.Name)
+
+	if !strings.HasPrefix(fullPath, isolateDirClean+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid filename: path traversal attempt detected (%q)", msg.Name)
 	}
 
-	fs.logger.Trace().Str("path", fullPath).Msg("file saved")
 	return fullPath, nil
 }
+
+// Offset is the size of the part file of msg, 0 without one
+func (fs *FileStore) Offset(msg domain.Message) uint64 {
+	info, err := os.Stat(fs.partPath(msg))
+	if err != nil || uint64(info.Size()) > msg.ContentLength {
+		return 0
+	}
+
+	return uint64(info.Size())
+}
+
+func (fs *FileStore) isolateDir(msg domain.Message) string {
+	if msg.BatchID != 0 {
+		return filepath.Clean(filepath.Join(fs.baseDir, msg.BatchID.String()))
+	}
+
+	return filepath.Clean(filepath.Join(fs.baseDir, msg.ID.String()))
+}
+
+// partPath is where the content is written until it is complete.
+// It is named by the content hash, a copy of the same file announced again continues it
+func (fs *FileStore) partPath(msg domain.Message) string {
+	key := msg.ID.String()
+	if msg.ContentHash != 0 {
+		key = strconv.FormatUint(msg.ContentHash, 16)
+	}
+
+	return filepath.Join(fs.isolateDir(msg), "."+key+".part")
+}
+
+func checksum(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return protocol.Checksum(f)
+}
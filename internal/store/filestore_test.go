@@ -1,14 +1,17 @@
 package store_test
 
 import (
+	"bytes"
 	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/cespare/xxhash"
 	"github.com/labi-le/belphegor/internal/store"
 	"github.com/labi-le/belphegor/internal/types/domain"
+	"github.com/labi-le/belphegor/pkg/archive"
 	"github.com/rs/zerolog"
 )
 
@@ -127,3 +130,129 @@ func TestFileStore_Write_BatchIsolatesByBatchID(t *testing.T) {
 		t.Fatalf("batch file dir = %q, want %q (isolated by BatchID)", filepath.Dir(path), wantDir)
 	}
 }
+
+func TestFileStore_Write_ResumesPart(t *testing.T) {
+	fs, _ := newStore(t)
+	msg := domain.Message{
+		ID:            domain.MessageID(3),
+		BatchID:       domain.MessageID(30),
+		Name:          "resumed.txt",
+		ContentHash:   0xABC,
+		ContentLength: 11,
+	}
+
+	if _, err := fs.Write(strings.NewReader("hello"), msg); err == nil {
+		t.Fatal("Write must error when the transfer breaks")
+	}
+	if got := fs.Offset(msg); got != 5 {
+		t.Fatalf("Offset = %d, want 5 bytes kept", got)
+	}
+
+	// announced again under another id, the content hash names the part
+	msg.ID = domain.MessageID(4)
+	msg.Offset = fs.Offset(msg)
+	msg.Checksum = xxhash.Sum64String("hello world")
+
+	path, err := fs.Write(strings.NewReader(" world"), msg)
+	if err != nil {
+		t.Fatalf("resumed Write: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "hello world" {
+		t.Fatalf("content = %q, want %q", got, "hello world")
+	}
+	if got := fs.Offset(msg); got != 0 {
+		t.Fatalf("Offset = %d after the file is complete, want 0", got)
+	}
+}
+
+func TestFileStore_Write_RestartsWhenSenderDoesNot(t *testing.T) {
+	fs, _ := newStore(t)
+	msg := domain.Message{ID: 5, Name: "restart.txt", ContentHash: 0xDEF, ContentLength: 5}
+
+	_, _ = fs.Write(strings.NewReader("xyz"), msg)
+
+	path, err := fs.Write(strings.NewReader("hello"), msg)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "hello" {
+		t.Fatalf("content = %q, want %q", got, "hello")
+	}
+}
+
+func TestFileStore_Write_OffsetBeyondPart(t *testing.T) {
+	fs, _ := newStore(t)
+	msg := domain.Message{ID: 6, Name: "gap.txt", ContentHash: 0x123, ContentLength: 5, Offset: 3}
+
+	if _, err := fs.Write(strings.NewReader("lo"), msg); err == nil {
+		t.Fatal("Write must not leave a gap before Offset")
+	}
+}
+
+func TestFileStore_Write_ChecksumMismatch(t *testing.T) {
+	fs, base := newStore(t)
+	msg := domain.Message{
+		ID:            domain.MessageID(8),
+		Name:          "corrupt.txt",
+		ContentHash:   0x456,
+		ContentLength: 5,
+		Checksum:      xxhash.Sum64String("hello"),
+	}
+
+	_, err := fs.Write(strings.NewReader("jello"), msg)
+	if !errors.Is(err, store.ErrChecksum) {
+		t.Fatalf("Write err = %v, want ErrChecksum", err)
+	}
+	if got := fs.Offset(msg); got != 0 {
+		t.Fatalf("Offset = %d, a corrupt part must be dropped", got)
+	}
+	if _, statErr := os.Stat(filepath.Join(base, msg.ID.String(), msg.Name)); statErr == nil {
+		t.Fatal("corrupt content reached its final path")
+	}
+}
+
+func TestFileStore_Write_UnpacksDirectory(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "project")
+	if err := os.MkdirAll(filepath.Join(src, "docs"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "docs", "readme.md"), []byte("# hi"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarball bytes.Buffer
+	tree, err := archive.Write(&tarball, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, base := newStore(t)
+	msg := domain.Message{
+		ID:            domain.MessageID(9),
+		Name:          "project",
+		ContentHash:   0x789,
+		ContentLength: tree.Size,
+		Checksum:      xxhash.Sum64(tarball.Bytes()),
+		Dir:           true,
+	}
+
+	path, err := fs.Write(bytes.NewReader(tarball.Bytes()), msg)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if path != filepath.Join(base, msg.ID.String(), "project") {
+		t.Fatalf("written to %q", path)
+	}
+
+	got, err := os.ReadFile(filepath.Join(path, "docs", "readme.md"))
+	if err != nil || string(got) != "# hi" {
+		t.Fatalf("readme.md = %q, %v", got, err)
+	}
+	if fs.Offset(msg) != 0 {
+		t.Fatal("archive part kept after unpacking")
+	}
+
+	if _, err := fs.Write(bytes.NewReader(tarball.Bytes()), msg); !errors.Is(err, store.ErrFileExists) {
+		t.Fatalf("second Write err = %v, want ErrFileExists", err)
+	}
+}
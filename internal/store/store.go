@@ -7,8 +7,17 @@ import (
 	"github.com/labi-le/belphegor/internal/types/domain"
 )
 
-var ErrFileExists = errors.New("file already exists")
+var (
+	ErrFileExists = errors.New("file already exists")
+	ErrChecksum   = errors.New("checksum mismatch")
+)
 
 type FileWriter interface {
+	// Write saves the content of msg read from r, which starts at msg.Offset.
+	// A broken write keeps what was written, so the next one can continue from Offset
 	Write(r io.Reader, msg domain.Message) (string, error)
+	// Offset is how much of the content of msg was written by the broken writes
+	Offset(msg domain.Message) uint64
+	// Path is where Write saves the content of msg
+	Path(msg domain.Message) (string, error)
 }
@@ -0,0 +1,339 @@
+// Package transfer keeps track of the payloads streamed to and from peers,
+// how far along they are and how fast they go, and cancels them on demand
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+// sampleInterval is how often a running transfer reports progress and its rate is measured
+const sampleInterval = time.Second
+
+var (
+	ErrNotFound = errors.New("transfer not found")
+	ErrCanceled = errors.New("transfer canceled")
+)
+
+type Direction string
+
+const (
+	Upload   Direction = "upload"
+	Download Direction = "download"
+)
+
+type State string
+
+const (
+	// Started is only reported once, the transfer is Running after it
+	Started  State = "started"
+	Running  State = "running"
+	Done     State = "done"
+	Failed   State = "failed"
+	Canceled State = "canceled"
+)
+
+// Transfer is a payload streamed to or from a peer as it was last sampled
+type Transfer struct {
+	ID        domain.MessageID
+	Peer      domain.NodeID
+	PeerName  string
+	Name      string
+	Direction Direction
+	State     State
+	// Size is the length of the content, Done the part of it held by the receiver,
+	// a resumed transfer starts at the offset it was resumed from
+	Size uint64
+	Done uint64
+	// Rate is in bytes per second over the last sample
+	Rate    uint64
+	Started time.Time
+	Err     error
+}
+
+func (t Transfer) Finished() bool {
+	return t.State != Started && t.State != Running
+}
+
+func (t Transfer) Percent() float64 {
+	if t.Size == 0 {
+		return 100
+	}
+	return float64(t.Done) * 100 / float64(t.Size)
+}
+
+type key struct {
+	id        domain.MessageID
+	peer      domain.NodeID
+	direction Direction
+}
+
+// Manager registers the running transfers and tells its subscribers how they go
+type Manager struct {
+	mu        sync.Mutex
+	active    map[key]*Progress
+	listeners map[int]func(Transfer)
+	next      int
+	// canceled are the messages not to be sent again when their transfer was cancelled,
+	// an id is added per cancel by hand, so it does not grow on its own
+	canceled map[domain.MessageID]struct{}
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		active:    make(map[key]*Progress),
+		listeners: make(map[int]func(Transfer)),
+		canceled:  make(map[domain.MessageID]struct{}),
+	}
+}
+
+// Subscribe calls fn when a transfer starts, makes progress and finishes until unsubscribe is called,
+// fn is called on the goroutine doing the transfer and must not block
+func (m *Manager) Subscribe(fn func(Transfer)) (unsubscribe func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.next
+	m.next++
+	m.listeners[id] = fn
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.listeners, id)
+	}
+}
+
+// Start registers a transfer, cancel aborts the stream it runs on
+func (m *Manager) Start(t Transfer, cancel func() error) *Progress {
+	now := time.Now()
+	t.State, t.Started, t.Rate = Running, now, 0
+
+	p := &Progress{
+		m:        m,
+		key:      key{id: t.ID, peer: t.Peer, direction: t.Direction},
+		cancel:   cancel,
+		t:        t,
+		first:    t.Done,
+		sampled:  now,
+		lastDone: t.Done,
+	}
+
+	m.mu.Lock()
+	m.active[p.key] = p
+	m.mu.Unlock()
+
+	t.State = Started
+	m.emit(t)
+	return p
+}
+
+// List returns the running transfers, oldest first
+func (m *Manager) List() []Transfer {
+	m.mu.Lock()
+	progress := make([]*Progress, 0, len(m.active))
+	for _, p := range m.active {
+		progress = append(progress, p)
+	}
+	m.mu.Unlock()
+
+	res := make([]Transfer, 0, len(progress))
+	for _, p := range progress {
+		res = append(res, p.Transfer())
+	}
+	slices.SortFunc(res, func(a, b Transfer) int {
+		return a.Started.Compare(b.Started)
+	})
+
+	return res
+}
+
+// Cancel aborts the transfers of the message, with the peer only when it is not zero.
+// The message is not sent again when the receiver asks for the rest of it
+func (m *Manager) Cancel(id domain.MessageID, peer domain.NodeID) error {
+	m.mu.Lock()
+	var found []*Progress
+	for k, p := range m.active {
+		if k.id == id && (peer == 0 || k.peer == peer) {
+			found = append(found, p)
+		}
+	}
+	if len(found) > 0 {
+		m.canceled[id] = struct{}{}
+	}
+	m.mu.Unlock()
+
+	if len(found) == 0 {
+		return fmt.Errorf("transfer.Cancel: %w", ErrNotFound)
+	}
+
+	var errs []error
+	for _, p := range found {
+		errs = append(errs, p.abort())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("transfer.Cancel: %w", err)
+	}
+
+	return nil
+}
+
+// Canceled reports whether a transfer of the message was cancelled by hand
+func (m *Manager) Canceled(id domain.MessageID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.canceled[id]
+	return ok
+}
+
+// Summary describes the running transfers in a line, empty when there are none
+func (m *Manager) Summary() string {
+	list := m.List()
+	if len(list) == 0 {
+		return ""
+	}
+
+	var size, done, rate uint64
+	for _, t := range list {
+		size += t.Size
+		done += t.Done
+		rate += t.Rate
+	}
+	total := Transfer{Size: size, Done: done}
+
+	if len(list) == 1 {
+		return fmt.Sprintf("%s %s: %.0f%%, %s/s", list[0].Direction, list[0].Name, total.Percent(), humanize.Bytes(rate))
+	}
+	return fmt.Sprintf("%d transfers: %.0f%%, %s/s", len(list), total.Percent(), humanize.Bytes(rate))
+}
+
+func (m *Manager) finish(p *Progress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.active[p.key] == p {
+		delete(m.active, p.key)
+	}
+}
+
+func (m *Manager) emit(t Transfer) {
+	m.mu.Lock()
+	listeners := make([]func(Transfer), 0, len(m.listeners))
+	for _, fn := range m.listeners {
+		listeners = append(listeners, fn)
+	}
+	m.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(t)
+	}
+}
+
+// Progress counts the bytes of a running transfer
+type Progress struct {
+	m        *Manager
+	key      key
+	cancel   func() error
+	canceled atomic.Bool
+
+	mu sync.Mutex
+	t  Transfer
+	// first is where the transfer started, the rest is what it carried
+	first    uint64
+	sampled  time.Time
+	lastDone uint64
+}
+
+func (p *Progress) Transfer() Transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.t
+}
+
+// Canceled reports whether the transfer was cancelled on this side
+func (p *Progress) Canceled() bool {
+	return p.canceled.Load()
+}
+
+// Reader counts the content read through r
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, p: p}
+}
+
+// Finish reports the result of the transfer and forgets it, later calls do nothing
+func (p *Progress) Finish(err error) {
+	p.m.finish(p)
+
+	p.mu.Lock()
+	if p.t.Finished() {
+		p.mu.Unlock()
+		return
+	}
+	switch {
+	case p.canceled.Load(), errors.Is(err, ErrCanceled), errors.Is(err, transport.ErrStreamCanceled):
+		p.t.State = Canceled
+	case err != nil:
+		p.t.State = Failed
+	default:
+		p.t.State, p.t.Done = Done, p.t.Size
+	}
+	p.t.Err = err
+	if elapsed := time.Since(p.t.Started); p.t.State == Done && elapsed > 0 {
+		// the mean rate of the whole transfer
+		p.t.Rate = uint64(float64(p.t.Size-p.first) / elapsed.Seconds())
+	}
+	t := p.t
+	p.mu.Unlock()
+
+	p.m.emit(t)
+}
+
+func (p *Progress) add(n int) {
+	if n <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	p.t.Done = min(p.t.Done+uint64(n), p.t.Size)
+
+	now := time.Now()
+	elapsed := now.Sub(p.sampled)
+	if elapsed < sampleInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.t.Rate = uint64(float64(p.t.Done-p.lastDone) / elapsed.Seconds())
+	p.sampled, p.lastDone = now, p.t.Done
+	t := p.t
+	p.mu.Unlock()
+
+	p.m.emit(t)
+}
+
+func (p *Progress) abort() error {
+	if !p.canceled.CompareAndSwap(false, true) {
+		return nil
+	}
+	return p.cancel()
+}
+
+type countingReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.add(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package transfer_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/transfer"
+	"github.com/labi-le/belphegor/internal/types/domain"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []transfer.Transfer
+}
+
+func (r *recorder) record(t transfer.Transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, t)
+}
+
+func (r *recorder) states() []transfer.State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []transfer.State
+	for _, t := range r.events {
+		res = append(res, t.State)
+	}
+	return res
+}
+
+func TestManager_Progress(t *testing.T) {
+	m := transfer.NewManager()
+	rec := &recorder{}
+	unsubscribe := m.Subscribe(rec.record)
+
+	p := m.Start(transfer.Transfer{ID: 1, Peer: 2, Name: "video.mkv", Direction: transfer.Download, Size: 10, Done: 4}, nil)
+
+	if _, err := io.Copy(io.Discard, p.Reader(strings.NewReader("abc"))); err != nil {
+		t.Fatal(err)
+	}
+	list := m.List()
+	if len(list) != 1 || list[0].Done != 7 || list[0].State != transfer.Running {
+		t.Fatalf("List() = %+v, want one running transfer 7 bytes in", list)
+	}
+	if summary := m.Summary(); !strings.Contains(summary, "video.mkv") || !strings.Contains(summary, "70%") {
+		t.Fatalf("Summary() = %q", summary)
+	}
+
+	p.Finish(nil)
+	p.Finish(errors.New("late"))
+	if len(m.List()) != 0 || m.Summary() != "" {
+		t.Fatalf("finished transfer still listed: %+v", m.List())
+	}
+
+	unsubscribe()
+	m.Start(transfer.Transfer{ID: 3}, nil).Finish(nil)
+
+	got := rec.states()
+	if len(got) != 2 || got[0] != transfer.Started || got[1] != transfer.Done {
+		t.Fatalf("events = %v, want [started done]", got)
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		peer    domain.NodeID
+		wantErr error
+		reset   []domain.NodeID
+	}{
+		{name: "every peer", reset: []domain.NodeID{1, 2}},
+		{name: "one peer", peer: 2, reset: []domain.NodeID{2}},
+		{name: "unknown peer", peer: 3, wantErr: transfer.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := transfer.NewManager()
+
+			var reset []domain.NodeID
+			progress := make(map[domain.NodeID]*transfer.Progress)
+			for _, peer := range []domain.NodeID{1, 2} {
+				progress[peer] = m.Start(transfer.Transfer{ID: 9, Peer: peer, Direction: transfer.Upload}, func() error {
+					reset = append(reset, peer)
+					return nil
+				})
+			}
+
+			err := m.Cancel(9, tt.peer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Cancel() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(reset) != len(tt.reset) {
+				t.Fatalf("reset streams of %v, want %v", reset, tt.reset)
+			}
+			if m.Canceled(9) != (tt.wantErr == nil) {
+				t.Fatalf("Canceled() = %v", m.Canceled(9))
+			}
+
+			for _, peer := range tt.reset {
+				p := progress[peer]
+				p.Finish(io.ErrUnexpectedEOF)
+				if got := p.Transfer().State; got != transfer.Canceled {
+					t.Fatalf("state of the transfer to %d = %s, want canceled", peer, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package mem connects transports inside one process over pipes, so a mesh of nodes
+// runs in a test without sockets. Sessions are secured and multiplexed as over tcp
+package mem
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/tcp"
+)
+
+var (
+	ErrAddrInUse   = errors.New("address already in use")
+	ErrUnreachable = errors.New("nothing listens on the address")
+)
+
+// Addr is a host:port address on a Network
+type Addr string
+
+var _ net.Addr = Addr("")
+
+func (a Addr) Network() string { return "mem" }
+
+func (a Addr) String() string { return string(a) }
+
+// Network is the space the transports created from it listen and dial in.
+// A listener with no host, e.g. ":7777", answers on every host with its port,
+// port 0 picks a free one
+type Network struct {
+	mu        sync.Mutex
+	listeners map[string]*listenerAdapter
+	lastPort  int
+}
+
+func NewNetwork() *Network {
+	return &Network{listeners: make(map[string]*listenerAdapter)}
+}
+
+// Transport returns a transport in the network, sessions use tlsConf as they would over tcp
+func (n *Network) Transport(tlsConf *tls.Config, keepAlive time.Duration) *Transport {
+	return &Transport{
+		network:   n,
+		tlsConf:   tlsConf,
+		keepAlive: keepAlive,
+	}
+}
+
+func (n *Network) listen(addr string) (*listenerAdapter, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if port == "0" {
+		for {
+			n.lastPort++
+			port = strconv.Itoa(n.lastPort)
+			if _, ok := n.listeners[net.JoinHostPort(host, port)]; !ok {
+				break
+			}
+		}
+	}
+
+	key := net.JoinHostPort(host, port)
+	if _, ok := n.listeners[key]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAddrInUse, key)
+	}
+
+	l := &listenerAdapter{
+		network: n,
+		addr:    Addr(key),
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	n.listeners[key] = l
+	return l, nil
+}
+
+// lookup finds the listener on addr, or the one on its port with no host
+func (n *Network) lookup(addr string) (*listenerAdapter, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if l, ok := n.listeners[addr]; ok {
+		return l, nil
+	}
+	if l, ok := n.listeners[net.JoinHostPort("", port)]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnreachable, addr)
+}
+
+func (n *Network) remove(l *listenerAdapter) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listeners[l.addr.String()] == l {
+		delete(n.listeners, l.addr.String())
+	}
+}
+
+// nextAddr is the address a dialing side is seen from
+func (n *Network) nextAddr() Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lastPort++
+	return Addr(net.JoinHostPort("mem", strconv.Itoa(n.lastPort)))
+}
+
+type Transport struct {
+	network   *Network
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+func (t *Transport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	l, err := t.network.listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	l.tlsConf = t.tlsConf
+	l.keepAlive = t.keepAlive
+
+	ctx, cancel := context.WithCancel(ctx)
+	l.cancel = cancel
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	return l, nil
+}
+
+func (t *Transport) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	l, err := t.network.lookup(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	local, remote := net.Pipe()
+	self := t.network.nextAddr()
+
+	select {
+	case l.conns <- &pipeConn{Conn: remote, local: l.addr, remote: self}:
+	case <-l.done:
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return tcp.Client(ctx, &pipeConn{Conn: local, local: self, remote: l.addr}, t.tlsConf, t.keepAlive)
+}
+
+type listenerAdapter struct {
+	network   *Network
+	addr      Addr
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+	conns     chan net.Conn
+	cancel    context.CancelFunc
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (a *listenerAdapter) Accept(ctx context.Context) (transport.Connection, error) {
+	select {
+	case conn := <-a.conns:
+		return tcp.Server(conn, a.tlsConf, a.keepAlive)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-a.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (a *listenerAdapter) Close() error {
+	a.once.Do(func() {
+		a.cancel()
+		a.network.remove(a)
+		close(a.done)
+	})
+	return nil
+}
+
+func (a *listenerAdapter) Addr() net.Addr { return a.addr }
+
+// pipeConn is one end of a pipe with the addresses of the network
+type pipeConn struct {
+	net.Conn
+	local, remote Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr { return c.local }
+
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
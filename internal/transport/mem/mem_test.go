@@ -0,0 +1,82 @@
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/mem"
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+)
+
+func TestNetwork_Mesh(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	network := mem.NewNetwork()
+
+	const size = 4
+	transports := make([]*mem.Transport, size)
+	fingerprints := make([]security.Fingerprint, size)
+	listeners := make([]transport.Listener, size)
+	for i := range size {
+		conf, fp := transporttest.TLSConfig(t, "node")
+		transports[i], fingerprints[i] = network.Transport(conf, time.Minute), fp
+
+		l, err := transports[i].Listen(ctx, ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		listeners[i] = l
+
+		go transporttest.Serve(ctx, l)
+	}
+
+	for i := range size {
+		for j := range size {
+			if i == j {
+				continue
+			}
+
+			_, port, _ := net.SplitHostPort(listeners[j].Addr().String())
+			conn, err := transports[i].Dial(ctx, net.JoinHostPort("127.0.0.1", port))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cert := conn.PeerCertificate(); cert == nil || security.FingerprintOf(cert) != fingerprints[j] {
+				t.Fatalf("%d dialed %d and got another certificate", i, j)
+			}
+
+			transporttest.Ping(ctx, t, conn)
+			_ = conn.Close()
+		}
+	}
+}
+
+func TestNetwork_Listen(t *testing.T) {
+	ctx := context.Background()
+	conf, _ := transporttest.TLSConfig(t, "node")
+	tr := mem.NewNetwork().Transport(conf, time.Minute)
+
+	l, err := tr.Listen(ctx, ":7777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Listen(ctx, ":7777"); !errors.Is(err, mem.ErrAddrInUse) {
+		t.Fatalf("listen twice: got %v, want %v", err, mem.ErrAddrInUse)
+	}
+
+	_ = l.Close()
+	if _, err := tr.Dial(ctx, "127.0.0.1:7777"); !errors.Is(err, mem.ErrUnreachable) {
+		t.Fatalf("dial closed listener: got %v, want %v", err, mem.ErrUnreachable)
+	}
+	if _, err := tr.Listen(ctx, ":7777"); err != nil {
+		t.Fatalf("listen after close: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package multi listens on several transports at once and dials through the first one that works,
+// so devices that prefer different transports still reach each other
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+)
+
+// attemptTimeout bounds a dial that is followed by another transport,
+// a filtered port often drops packets instead of refusing them
+const attemptTimeout = 3 * time.Second
+
+var ErrNoTransport = errors.New("no transport to dial with")
+
+// Member is a transport of the set, it listens and dials Offset ports above the address
+type Member struct {
+	Name      string
+	Transport transport.Transport
+	Offset    int
+}
+
+type Transport struct {
+	members []Member
+	timeout time.Duration
+
+	mu sync.Mutex
+	// preferred is the member that reached an address last time, tried first next time
+	preferred map[string]string
+}
+
+type Option func(*Transport)
+
+// WithAttemptTimeout bounds the dials that fall back to another transport
+func WithAttemptTimeout(timeout time.Duration) Option {
+	return func(t *Transport) {
+		t.timeout = timeout
+	}
+}
+
+// New returns a transport over members, dials try them in the given order
+func New(members []Member, opts ...Option) *Transport {
+	t := &Transport{
+		members:   members,
+		timeout:   attemptTimeout,
+		preferred: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+// Listen listens with every member, the connections of all of them are accepted together
+func (t *Transport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &listenerAdapter{
+		conns:  make(chan accepted),
+		cancel: cancel,
+		done:   ctx.Done(),
+	}
+
+	for _, m := range t.members {
+		ml, err := m.Transport.Listen(ctx, shift(addr, m.Offset))
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("%s: %w", m.Name, err)
+		}
+		l.listeners = append(l.listeners, ml)
+	}
+
+	for _, ml := range l.listeners {
+		go l.accept(ctx, ml)
+	}
+
+	return l, nil
+}
+
+// Dial reaches addr through the member its scheme names, e.g. tcp://host:port as it is,
+// otherwise through the members in order until one of them connects
+func (t *Transport) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		for _, m := range t.members {
+			if m.Name != scheme {
+				continue
+			}
+			// a member may take a URL of its own, e.g. ws://host/prefix
+			if _, _, err := net.SplitHostPort(rest); err != nil {
+				return m.Transport.Dial(ctx, addr)
+			}
+			return m.Transport.Dial(ctx, rest)
+		}
+	}
+
+	members := t.ordered(addr)
+	if len(members) == 0 {
+		return nil, ErrNoTransport
+	}
+
+	var errs []error
+	for i, m := range members {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if i < len(members)-1 {
+			attemptCtx, cancel = context.WithTimeout(ctx, t.timeout)
+		}
+
+		conn, err := m.Transport.Dial(attemptCtx, shift(addr, m.Offset))
+		cancel()
+		if err == nil {
+			t.prefer(addr, m.Name)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// ordered is the members to dial addr with, the one that reached it last time first
+func (t *Transport) ordered(addr string) []Member {
+	t.mu.Lock()
+	name := t.preferred[addr]
+	t.mu.Unlock()
+
+	res := make([]Member, 0, len(t.members))
+	for _, m := range t.members {
+		if m.Name == name {
+			res = append([]Member{m}, res...)
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func (t *Transport) prefer(addr, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.preferred[addr] = name
+}
+
+// shift moves the port of a host:port address up by offset, other addresses are left as they are
+func shift(addr string, offset int) string {
+	if offset == 0 {
+		return addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+offset))
+}
+
+type listenerAdapter struct {
+	listeners []transport.Listener
+	conns     chan accepted
+	cancel    context.CancelFunc
+	done      <-chan struct{}
+}
+
+type accepted struct {
+	conn transport.Connection
+	err  error
+}
+
+// accept passes on the connections of a member, and its error once it fails
+func (a *listenerAdapter) accept(ctx context.Context, l transport.Listener) {
+	for {
+		conn, err := l.Accept(ctx)
+		if err != nil && (ctx.Err() != nil || errors.Is(err, net.ErrClosed)) {
+			return
+		}
+
+		select {
+		case a.conns <- accepted{conn: conn, err: err}:
+		case <-ctx.Done():
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *listenerAdapter) Accept(ctx context.Context) (transport.Connection, error) {
+	select {
+	case res := <-a.conns:
+		return res.conn, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-a.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (a *listenerAdapter) Close() error {
+	a.cancel()
+
+	var errs []error
+	for _, l := range a.listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Addr is the address of the first member
+func (a *listenerAdapter) Addr() net.Addr {
+	if len(a.listeners) == 0 {
+		return nil
+	}
+	return a.listeners[0].Addr()
+}
@@ -0,0 +1,88 @@
+package multi_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/mem"
+	"github.com/labi-le/belphegor/internal/transport/multi"
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+)
+
+// filtered drops every dial like a firewall that does not answer
+type filtered struct {
+	dials atomic.Int32
+}
+
+func (f *filtered) Listen(context.Context, string) (transport.Listener, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (f *filtered) Dial(ctx context.Context, _ string) (transport.Connection, error) {
+	f.dials.Add(1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTransport_Fallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	quic, tcp := mem.NewNetwork(), mem.NewNetwork()
+	serverConf, _ := transporttest.TLSConfig(t, "server")
+	clientConf, _ := transporttest.TLSConfig(t, "client")
+
+	server := multi.New([]multi.Member{
+		{Name: "quic", Transport: quic.Transport(serverConf, time.Minute)},
+		{Name: "tcp", Transport: tcp.Transport(serverConf, time.Minute), Offset: 1},
+	})
+	l, err := server.Listen(ctx, ":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() {
+				<-ctx.Done()
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	dropped := new(filtered)
+	client := multi.New([]multi.Member{
+		{Name: "quic", Transport: dropped},
+		{Name: "tcp", Transport: tcp.Transport(clientConf, time.Minute), Offset: 1},
+	}, multi.WithAttemptTimeout(50*time.Millisecond))
+
+	for range 2 {
+		conn, err := client.Dial(ctx, "127.0.0.1:7000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+	if got := dropped.dials.Load(); got != 1 {
+		t.Errorf("filtered transport dialed %d times, want once before falling back for good", got)
+	}
+
+	conn, err := client.Dial(ctx, "tcp://127.0.0.1:7001")
+	if err != nil {
+		t.Fatalf("dial the named transport: %v", err)
+	}
+	_ = conn.Close()
+
+	if _, err := client.Dial(ctx, "127.0.0.1:7100"); !errors.Is(err, mem.ErrUnreachable) {
+		t.Errorf("dial nowhere: got %v, want %v", err, mem.ErrUnreachable)
+	}
+}
@@ -3,7 +3,9 @@ package quic
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -95,6 +97,13 @@ func (c *connAdapter) AcceptStream(ctx context.Context) (transport.Stream, error
 
 func (c *connAdapter) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
 
+func (c *connAdapter) PeerCertificate() *x509.Certificate {
+	if certs := c.conn.ConnectionState().TLS.PeerCertificates; len(certs) > 0 {
+		return certs[0]
+	}
+	return nil
+}
+
 func (c *connAdapter) Close() error {
 	return c.conn.CloseWithError(0, "closed")
 }
@@ -135,5 +144,11 @@ func mapQuicError(err error) error {
 		}
 	}
 
+	// a tls alert of the peer is carried as a crypto error code
+	var transportErr *quic.TransportError
+	if errors.As(err, &transportErr) && transportErr.Remote && transportErr.ErrorCode.IsCryptoError() {
+		return fmt.Errorf("%w%.0w", err, tls.AlertError(transportErr.ErrorCode-0x100))
+	}
+
 	return err
 }
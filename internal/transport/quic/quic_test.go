@@ -0,0 +1,67 @@
+package quic_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport/quic"
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+)
+
+func TestTransport_DualStack(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverConf, serverFP := transporttest.TLSConfig(t, "server")
+	clientConf, _ := transporttest.TLSConfig(t, "client")
+
+	l, err := quic.New(serverConf, time.Minute).Listen(ctx, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go transporttest.Serve(ctx, l)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	for name, addr := range transporttest.LocalAddrs(t, p) {
+		t.Run(name, func(t *testing.T) {
+			conn, err := quic.New(clientConf, time.Minute).Dial(ctx, addr)
+			if err != nil {
+				t.Fatalf("dial %s: %v", addr, err)
+			}
+			defer conn.Close()
+
+			if cert := conn.PeerCertificate(); cert == nil || security.FingerprintOf(cert) != serverFP {
+				t.Fatal("dialer does not see the server certificate")
+			}
+			transporttest.Ping(ctx, t, conn)
+		})
+	}
+}
+
+func TestTransport_Refused(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverConf, _ := transporttest.TLSConfig(t, "server")
+
+	l, err := quic.New(serverConf, time.Minute).Listen(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go transporttest.Serve(ctx, l)
+
+	conn, err := quic.New(transporttest.Stranger(t, "stranger"), time.Minute).Dial(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	transporttest.Refused(ctx, t, conn)
+}
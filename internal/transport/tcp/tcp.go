@@ -3,9 +3,13 @@ package tcp
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/yamux"
@@ -55,22 +59,52 @@ func (t *Transport) Dial(ctx context.Context, addr string) (transport.Connection
 		return nil, err
 	}
 
-	if tlsConn, isTLS := rawConn.(*tls.Conn); isTLS {
+	tlsConn, isTLS := rawConn.(*tls.Conn)
+	if isTLS {
 		if tc, ok := tlsConn.NetConn().(*net.TCPConn); ok {
 			_ = tc.SetKeepAlive(true)
 			_ = tc.SetKeepAlivePeriod(t.keepAlive)
 		}
 	}
 
-	sess, err := yamux.Client(rawConn, yamuxConfig(t.keepAlive))
+	conn, err := newConn(rawConn, tlsConn, true, t.keepAlive)
 	if err != nil {
 		_ = rawConn.Close()
 		return nil, err
 	}
 
-	return &connAdapter{
-		sess: sess,
-	}, nil
+	return conn, nil
+}
+
+// Client runs a session as the dialing side over an established connection,
+// e.g. a stream spliced by a relay
+func Client(ctx context.Context, conn net.Conn, tlsConf *tls.Config, keepAlive time.Duration) (transport.Connection, error) {
+	tlsConn := tls.Client(conn, tlsConf)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	sess, err := newConn(tlsConn, tlsConn, true, keepAlive)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// Server is the accepting side of Client, the peer is verified on the first read of the session
+func Server(conn net.Conn, tlsConf *tls.Config, keepAlive time.Duration) (transport.Connection, error) {
+	tlsConn := tls.Server(conn, tlsConf)
+
+	sess, err := newConn(tlsConn, tlsConn, false, keepAlive)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return sess, nil
 }
 
 type listenerAdapter struct {
@@ -92,15 +126,14 @@ func (a *listenerAdapter) Accept(_ context.Context) (transport.Connection, error
 		}
 	}
 
-	sess, err := yamux.Server(conn, yamuxConfig(a.keepAlive))
+	tlsConn, _ := conn.(*tls.Conn)
+	sess, err := newConn(conn, tlsConn, false, a.keepAlive)
 	if err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
 
-	return &connAdapter{
-		sess: sess,
-	}, nil
+	return sess, nil
 }
 
 func (a *listenerAdapter) Close() error {
@@ -111,7 +144,29 @@ func (a *listenerAdapter) Close() error {
 func (a *listenerAdapter) Addr() net.Addr { return a.l.Addr() }
 
 type connAdapter struct {
-	sess *yamux.Session
+	sess  *yamux.Session
+	tls   *tls.Conn
+	alert *alertConn
+}
+
+// newConn runs a yamux session over conn, tlsConn is the same connection when it is one
+func newConn(conn net.Conn, tlsConn *tls.Conn, client bool, keepAlive time.Duration) (*connAdapter, error) {
+	alert := &alertConn{Conn: conn}
+
+	open := yamux.Server
+	if client {
+		open = yamux.Client
+	}
+	sess, err := open(alert, yamuxConfig(keepAlive))
+	if err != nil {
+		return nil, err
+	}
+
+	return &connAdapter{
+		sess:  sess,
+		tls:   tlsConn,
+		alert: alert,
+	}, nil
 }
 
 func (c *connAdapter) OpenStream(ctx context.Context) (transport.Stream, error) {
@@ -120,27 +175,54 @@ func (c *connAdapter) OpenStream(ctx context.Context) (transport.Stream, error)
 	}
 	s, err := c.sess.OpenStream()
 	if err != nil {
-		return nil, mapError(err)
+		return nil, c.alert.mapError(err)
 	}
-	return &streamAdapter{s}, nil
+	return &streamAdapter{Stream: s, alert: c.alert}, nil
 }
 
 func (c *connAdapter) AcceptStream(ctx context.Context) (transport.Stream, error) {
 	s, err := c.sess.AcceptStreamWithContext(ctx)
 	if err != nil {
-		return nil, mapError(err)
+		return nil, c.alert.mapError(err)
 	}
-	return &streamAdapter{s}, nil
+	return &streamAdapter{Stream: s, alert: c.alert}, nil
 }
 
 func (c *connAdapter) RemoteAddr() net.Addr { return c.sess.RemoteAddr() }
 
+func (c *connAdapter) PeerCertificate() *x509.Certificate {
+	if c.tls == nil {
+		return nil
+	}
+
+	// the listener side handshakes lazily, on the first read of the session
+	if err := c.tls.Handshake(); err != nil {
+		return nil
+	}
+
+	if certs := c.tls.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0]
+	}
+	return nil
+}
+
 func (c *connAdapter) Close() error {
 	return c.sess.Close()
 }
 
 type streamAdapter struct {
 	*yamux.Stream
+	alert *alertConn
+}
+
+func (s *streamAdapter) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	return n, s.alert.mapError(err)
+}
+
+func (s *streamAdapter) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	return n, s.alert.mapError(err)
 }
 
 func (s *streamAdapter) SetReadDeadline(t time.Time) error {
@@ -180,3 +262,44 @@ func mapError(err error) error {
 
 	return err
 }
+
+// alertConn keeps the tls alert the peer ended the session with, yamux only reports a closed session
+type alertConn struct {
+	net.Conn
+	alert atomic.Pointer[tls.AlertError]
+}
+
+func (c *alertConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if alert, ok := remoteAlert(err); ok {
+		c.alert.Store(&alert)
+	}
+	return n, err
+}
+
+// mapError reports the errors of a session ended by an alert as that alert
+func (c *alertConn) mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if alert := c.alert.Load(); alert != nil {
+		return fmt.Errorf("%w: %w", transport.ErrConnectionClosed, *alert)
+	}
+
+	return mapError(err)
+}
+
+// remoteAlert unwraps the alert received from the peer, crypto/tls reports it with an unexported type
+func remoteAlert(err error) (tls.AlertError, bool) {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return 0, false
+	}
+
+	if v := reflect.ValueOf(opErr.Err); v.Kind() == reflect.Uint8 {
+		return tls.AlertError(v.Uint()), true
+	}
+
+	return 0, false
+}
@@ -2,6 +2,8 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"io"
 	"net"
@@ -13,6 +15,10 @@ var (
 	ErrConnectionClosed = errors.New("connection closed")
 )
 
+// AlertBadCertificate is the tls alert of a peer refusing our certificate,
+// transports report the alert of the peer as an error wrapping tls.AlertError
+const AlertBadCertificate tls.AlertError = 42
+
 type Stream interface {
 	io.Reader
 	io.Writer
@@ -27,6 +33,8 @@ type Connection interface {
 	AcceptStream(ctx context.Context) (Stream, error)
 
 	RemoteAddr() net.Addr
+	// PeerCertificate is the certificate presented by the remote side, nil if it has none
+	PeerCertificate() *x509.Certificate
 	Close() error
 }
 
@@ -0,0 +1,169 @@
+// Package transporttest helps testing the transports against each other
+package transporttest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/rs/zerolog"
+)
+
+// Secret is shared by the configs of TLSConfig, so they accept each other
+const Secret = "transporttest"
+
+// TLSConfig returns the config of a new device
+func TLSConfig(t *testing.T, name string) (*tls.Config, security.Fingerprint) {
+	t.Helper()
+	return tlsConfig(t, name, Secret)
+}
+
+// Stranger returns the config of a device the ones of TLSConfig refuse, it does not know the secret
+func Stranger(t *testing.T, name string) *tls.Config {
+	t.Helper()
+	conf, _ := tlsConfig(t, name, "stranger")
+	return conf
+}
+
+func tlsConfig(t *testing.T, name, secret string) (*tls.Config, security.Fingerprint) {
+	t.Helper()
+
+	dir := t.TempDir()
+	id, err := security.LoadIdentity(filepath.Join(dir, "identity.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust, err := security.OpenTrustStore(filepath.Join(dir, "trusted.json"), id.Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := security.MakeTLSConfig(id, security.NewVerifier(trust, secret), name, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conf, id.Fingerprint()
+}
+
+// LocalAddrs are the addresses a dual-stack listener on port is reached at from this host:
+// IPv4 and IPv6 loopback, and the first link-local IPv6 address with its zone if there is one
+func LocalAddrs(t *testing.T, port int) map[string]string {
+	t.Helper()
+
+	p := strconv.Itoa(port)
+	addrs := map[string]string{
+		"ipv4": net.JoinHostPort("127.0.0.1", p),
+	}
+	if ipv6Loopback() {
+		addrs["ipv6"] = net.JoinHostPort("::1", p)
+	}
+	if addr, ok := linkLocal(); ok {
+		addrs["link-local"] = net.JoinHostPort(addr.String(), p)
+	}
+
+	return addrs
+}
+
+func ipv6Loopback() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+func linkLocal() (netip.Addr, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			prefix, err := netip.ParsePrefix(a.String())
+			if err == nil && prefix.Addr().Is6() && prefix.Addr().IsLinkLocalUnicast() {
+				return prefix.Addr().WithZone(iface.Name), true
+			}
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// Serve accepts connections until ctx is done and echoes their streams
+func Serve(ctx context.Context, l transport.Listener) {
+	for {
+		conn, err := l.Accept(ctx)
+		if err != nil {
+			return
+		}
+		go Echo(ctx, conn)
+	}
+}
+
+// Echo writes back what the streams of conn read until ctx is done
+func Echo(ctx context.Context, conn transport.Connection) {
+	defer conn.Close()
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			_, _ = io.Copy(stream, stream)
+		}()
+	}
+}
+
+// Ping checks a round trip over a new stream of conn
+func Ping(ctx context.Context, t *testing.T, conn transport.Connection) {
+	t.Helper()
+
+	stream, err := conn.OpenStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("got %q, want ping", buf)
+	}
+}
+
+// Refused checks that the peer of conn refused our certificate, dialing succeeds
+// and the tls alert ends the first round trip
+func Refused(ctx context.Context, t *testing.T, conn transport.Connection) {
+	t.Helper()
+
+	stream, err := conn.OpenStream(ctx)
+	if err == nil {
+		defer stream.Close()
+		_, _ = stream.Write([]byte("ping"))
+		_, err = stream.Read(make([]byte, 4))
+	}
+
+	var alert tls.AlertError
+	if !errors.As(err, &alert) || alert != transport.AlertBadCertificate {
+		t.Fatalf("got %v, want the bad_certificate alert", err)
+	}
+}
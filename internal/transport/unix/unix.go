@@ -0,0 +1,134 @@
+// Package unix carries sessions over Unix sockets, e.g. between containers that share a mounted
+// directory but no network. Sessions are secured and multiplexed as over tcp
+package unix
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/tcp"
+)
+
+// abstractPrefix names the sockets in the abstract namespace of Linux, they live as long as the listener
+const abstractPrefix = "@belphegor-"
+
+type Transport struct {
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+	dir       string
+}
+
+// New returns a transport that maps host:port addresses to <dir>/<port>.sock,
+// or to abstract sockets when dir is empty. Socket paths and @names are used as is
+func New(tlsConf *tls.Config, keepAlive time.Duration, dir string) *Transport {
+	return &Transport{
+		tlsConf:   tlsConf,
+		keepAlive: keepAlive,
+		dir:       dir,
+	}
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+// Path is the socket addr resolves to, a unix:// prefix is dropped
+func (t *Transport) Path(addr string) string {
+	addr = strings.TrimPrefix(addr, "unix://")
+	if strings.HasPrefix(addr, "@") || strings.ContainsRune(addr, filepath.Separator) || strings.HasSuffix(addr, ".sock") {
+		return addr
+	}
+
+	port := addr
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		port = p
+	}
+
+	if t.dir == "" {
+		return abstractPrefix + port
+	}
+	return filepath.Join(t.dir, port+".sock")
+}
+
+func (t *Transport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	path := t.Path(addr)
+
+	lc := net.ListenConfig{}
+	l, err := lc.Listen(ctx, "unix", path)
+	if err != nil && removeStale(ctx, path) {
+		l, err = lc.Listen(ctx, "unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	return &listenerAdapter{
+		l:         l,
+		tlsConf:   t.tlsConf,
+		keepAlive: t.keepAlive,
+		cancel:    cancel,
+	}, nil
+}
+
+func (t *Transport) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", t.Path(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	return tcp.Client(ctx, conn, t.tlsConf, t.keepAlive)
+}
+
+// removeStale removes the socket file a crashed process left behind, true if it did
+func removeStale(ctx context.Context, path string) bool {
+	if strings.HasPrefix(path, "@") {
+		return false
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return false
+	}
+
+	return !errors.Is(err, context.Canceled) && os.Remove(path) == nil
+}
+
+type listenerAdapter struct {
+	l         net.Listener
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+	cancel    context.CancelFunc
+}
+
+func (a *listenerAdapter) Accept(_ context.Context) (transport.Connection, error) {
+	conn, err := a.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return tcp.Server(conn, a.tlsConf, a.keepAlive)
+}
+
+func (a *listenerAdapter) Close() error {
+	a.cancel()
+	return a.l.Close()
+}
+
+func (a *listenerAdapter) Addr() net.Addr { return a.l.Addr() }
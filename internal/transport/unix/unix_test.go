@@ -0,0 +1,116 @@
+package unix_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+	"github.com/labi-le/belphegor/internal/transport/unix"
+)
+
+func newTransport(t *testing.T, dir string) *unix.Transport {
+	t.Helper()
+
+	conf, _ := transporttest.TLSConfig(t, "node")
+	return unix.New(conf, time.Minute, dir)
+}
+
+func TestTransport_Dial(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		dir    string
+		listen string
+		dial   string
+	}{
+		{name: "port in dir", dir: dir, listen: ":7001", dial: "127.0.0.1:7001"},
+		{name: "socket path", dir: dir, listen: ":7002", dial: filepath.Join(dir, "7002.sock")},
+		{name: "socket url", dir: dir, listen: ":7004", dial: "unix://" + filepath.Join(dir, "7004.sock")},
+		{name: "abstract", listen: ":7003", dial: "@belphegor-7003"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dir == "" && runtime.GOOS != "linux" {
+				t.Skip("abstract sockets are linux only")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			l, err := newTransport(t, tt.dir).Listen(ctx, tt.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			go func() {
+				conn, err := l.Accept(ctx)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				stream, err := conn.AcceptStream(ctx)
+				if err != nil {
+					return
+				}
+				_, _ = io.Copy(stream, stream)
+			}()
+
+			conn, err := newTransport(t, tt.dir).Dial(ctx, tt.dial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if conn.PeerCertificate() == nil {
+				t.Fatal("no peer certificate")
+			}
+
+			stream, err := conn.OpenStream(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(stream, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "ping" {
+				t.Fatalf("got %q", buf)
+			}
+		})
+	}
+}
+
+func TestTransport_Listen_Stale(t *testing.T) {
+	dir := t.TempDir()
+	tr := newTransport(t, dir)
+	path := tr.Path(":7005")
+
+	// a socket file without a listener, as a crashed process leaves it
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := tr.Listen(context.Background(), ":7005")
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	_ = l.Close()
+}
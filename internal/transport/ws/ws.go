@@ -0,0 +1,312 @@
+// Package ws carries sessions over WebSocket, for networks that let nothing but HTTP(S) through.
+// The WebSocket may be terminated by a reverse proxy on any path, so devices run their own
+// TLS session inside it and multiplex streams over it the way the tcp transport does
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/tcp"
+	"golang.org/x/net/websocket"
+)
+
+// handshakeTimeout bounds reading the upgrade request
+const handshakeTimeout = 10 * time.Second
+
+var (
+	ErrScheme = errors.New("unsupported websocket scheme")
+	ErrProxy  = errors.New("proxy refused to connect")
+)
+
+type Transport struct {
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+}
+
+func New(tlsConf *tls.Config, keepAlive time.Duration) *Transport {
+	return &Transport{
+		tlsConf:   tlsConf,
+		keepAlive: keepAlive,
+	}
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+// Listen serves the upgrade on every path, a reverse proxy may forward its prefix or strip it
+func (t *Transport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	lc := net.ListenConfig{}
+	rawListener, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &listenerAdapter{
+		l:         rawListener,
+		tlsConf:   t.tlsConf,
+		keepAlive: t.keepAlive,
+		conns:     make(chan transport.Connection),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	l.srv = &http.Server{
+		Handler:           websocket.Server{Handler: l.serve},
+		ReadHeaderTimeout: handshakeTimeout,
+	}
+
+	go func() { _ = l.srv.Serve(rawListener) }()
+	go func() {
+		<-ctx.Done()
+		_ = l.srv.Close()
+	}()
+
+	return l, nil
+}
+
+// Dial accepts host:port as well as a ws:// or wss:// URL, e.g. one of a path-prefixed reverse proxy.
+// The proxy from HTTPS_PROXY or HTTP_PROXY is used when set
+func (t *Transport) Dial(ctx context.Context, addr string) (transport.Connection, error) {
+	u, err := endpoint(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rawConn, err := t.dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = rawConn.Close() })
+	conf, err := websocket.NewConfig(u.String(), origin(u))
+	var ws *websocket.Conn
+	if err == nil {
+		ws, err = websocket.NewClient(conf, rawConn)
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = rawConn.Close()
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	return tcp.Client(ctx, newConn(ws, rawConn.RemoteAddr()), t.tlsConf, t.keepAlive)
+}
+
+// dial connects to the host of u, through the proxy if there is one, and secures wss
+func (t *Transport) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	target := hostPort(u)
+	dialer := &net.Dialer{KeepAlive: t.keepAlive}
+
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: httpScheme(u.Scheme), Host: target}})
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if proxy == nil {
+		conn, err = dialer.DialContext(ctx, "tcp", target)
+	} else {
+		conn, err = connectProxy(ctx, dialer, proxy, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "wss" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectProxy opens a tunnel to target with HTTP CONNECT
+func connectProxy(ctx context.Context, dialer *net.Dialer, proxy *url.URL, target string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	// nothing follows the answer until we speak, the reader buffers no tunnel bytes
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrProxy, resp.Status)
+	}
+
+	return conn, nil
+}
+
+type listenerAdapter struct {
+	l         net.Listener
+	srv       *http.Server
+	tlsConf   *tls.Config
+	keepAlive time.Duration
+	conns     chan transport.Connection
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// serve runs a session over an upgraded connection, the connection lives as long as the handler
+func (a *listenerAdapter) serve(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	c := newConn(ws, remoteAddr(ws))
+	sess, err := tcp.Server(c, a.tlsConf, a.keepAlive)
+	if err != nil {
+		return
+	}
+
+	select {
+	case a.conns <- sess:
+	case <-a.ctx.Done():
+		_ = sess.Close()
+		return
+	}
+
+	<-c.done
+}
+
+func (a *listenerAdapter) Accept(ctx context.Context) (transport.Connection, error) {
+	select {
+	case conn := <-a.conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-a.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (a *listenerAdapter) Close() error {
+	a.cancel()
+	return a.srv.Close()
+}
+
+func (a *listenerAdapter) Addr() net.Addr { return a.l.Addr() }
+
+// conn is a WebSocket seen as a connection, done is closed with it
+type conn struct {
+	*websocket.Conn
+	remote net.Addr
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newConn(ws *websocket.Conn, remote net.Addr) *conn {
+	return &conn{Conn: ws, remote: remote, done: make(chan struct{})}
+}
+
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// remoteAddr is the address the upgrade came from, a WebSocket reports its origin instead
+func remoteAddr(ws *websocket.Conn) net.Addr {
+	if addr, err := netip.ParseAddrPort(ws.Request().RemoteAddr); err == nil {
+		return net.TCPAddrFromAddrPort(addr)
+	}
+	return ws.RemoteAddr()
+}
+
+func endpoint(addr string) (*url.URL, error) {
+	// host:port is not parsed as a URL, a zone would have to be escaped there
+	if !strings.Contains(addr, "://") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, err
+		}
+		return &url.URL{Scheme: "ws", Host: addr, Path: "/"}, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("%w: %s", ErrScheme, u.Scheme)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u, nil
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	port := "80"
+	if u.Scheme == "wss" || u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func httpScheme(scheme string) string {
+	if scheme == "wss" {
+		return "https"
+	}
+	return "http"
+}
+
+// origin is required by the handshake, the server does not check it
+func origin(u *url.URL) string {
+	return (&url.URL{Scheme: httpScheme(u.Scheme), Host: u.Host, Path: "/"}).String()
+}
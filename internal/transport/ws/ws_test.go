@@ -0,0 +1,152 @@
+package ws_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/internal/security"
+	"github.com/labi-le/belphegor/internal/transport"
+	"github.com/labi-le/belphegor/internal/transport/transporttest"
+	"github.com/labi-le/belphegor/internal/transport/ws"
+)
+
+// proxy forwards /belphegor/ to target without the prefix, as a path-prefixed reverse proxy does
+func proxy(t *testing.T, target string) string {
+	t.Helper()
+
+	backend := &url.URL{Scheme: "http", Host: target}
+	mux := http.NewServeMux()
+	mux.Handle("/belphegor/", http.StripPrefix("/belphegor", httputil.NewSingleHostReverseProxy(backend)))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return "ws://" + l.Addr().String() + "/belphegor/"
+}
+
+func TestTransport_Dial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverConf, serverFP := transporttest.TLSConfig(t, "server")
+	clientConf, clientFP := transporttest.TLSConfig(t, "client")
+
+	l, err := ws.New(serverConf, time.Minute).Listen(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{name: "direct", addr: l.Addr().String()},
+		{name: "reverse proxy", addr: proxy(t, l.Addr().String())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted := make(chan transport.Connection, 1)
+			go func() {
+				conn, err := l.Accept(ctx)
+				if err == nil {
+					accepted <- conn
+				}
+			}()
+
+			conn, err := ws.New(clientConf, time.Minute).Dial(ctx, tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if cert := conn.PeerCertificate(); cert == nil || security.FingerprintOf(cert) != serverFP {
+				t.Fatal("dialer does not see the server certificate")
+			}
+
+			stream, err := conn.OpenStream(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+
+			var remote transport.Connection
+			select {
+			case remote = <-accepted:
+			case <-ctx.Done():
+				t.Fatal("connection not accepted")
+			}
+			defer remote.Close()
+
+			in, err := remote.AcceptStream(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(in, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "ping" {
+				t.Fatalf("got %q", buf)
+			}
+
+			if cert := remote.PeerCertificate(); cert == nil || security.FingerprintOf(cert) != clientFP {
+				t.Fatal("listener does not see the client certificate")
+			}
+		})
+	}
+}
+
+func TestTransport_Dial_Scheme(t *testing.T) {
+	conf, _ := transporttest.TLSConfig(t, "client")
+	if _, err := ws.New(conf, time.Minute).Dial(context.Background(), "http://127.0.0.1:1/"); err == nil {
+		t.Fatal("expected an error for http://")
+	}
+}
+
+func TestTransport_DualStack(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverConf, serverFP := transporttest.TLSConfig(t, "server")
+	clientConf, _ := transporttest.TLSConfig(t, "client")
+
+	l, err := ws.New(serverConf, time.Minute).Listen(ctx, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go transporttest.Serve(ctx, l)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	for name, addr := range transporttest.LocalAddrs(t, p) {
+		t.Run(name, func(t *testing.T) {
+			conn, err := ws.New(clientConf, time.Minute).Dial(ctx, addr)
+			if err != nil {
+				t.Fatalf("dial %s: %v", addr, err)
+			}
+			defer conn.Close()
+
+			if cert := conn.PeerCertificate(); cert == nil || security.FingerprintOf(cert) != serverFP {
+				t.Fatal("dialer does not see the server certificate")
+			}
+			transporttest.Ping(ctx, t, conn)
+		})
+	}
+}
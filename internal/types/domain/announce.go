@@ -15,6 +15,13 @@ type Announce struct {
 	ContentLength uint64
 	BatchID       MessageID
 	BatchTotal    uint32
+	// Hops counts the connections crossed from the origin, incremented on receipt
+	Hops uint32
+	// Name of a file, empty from senders that do not announce it
+	Name string
+	// Sealed holds Name, ContentLength and ContentHash sealed for the recipient devices,
+	// which fill them in. A relay that is not one of them forwards it as it is
+	Sealed []byte
 }
 
 func (an Announce) MarshalZerologObject(e *zerolog.Event) {
@@ -25,10 +32,20 @@ func (an Announce) MarshalZerologObject(e *zerolog.Event) {
 	e.Uint64("hash", an.ContentHash)
 	e.Int64("batch_id", an.BatchID.Int64())
 	e.Uint32("batch_total", an.BatchTotal)
+	e.Uint32("hops", an.Hops)
+	if an.Sealed != nil {
+		e.Bool("sealed", true)
+	}
 }
 
 func (an Announce) Zero() bool {
-	return an.ID == 0 || an.ContentHash == 0
+	return an.ID == 0 || (an.ContentHash == 0 && an.Sealed == nil)
+}
+
+// Concealed is the announce without what a relay must not learn, sent along with Sealed
+func (an Announce) Concealed() Announce {
+	an.Name, an.ContentLength, an.ContentHash = "", 0, 0
+	return an
 }
 
 func (an Announce) Duplicate(other Announce) bool {
@@ -20,6 +20,8 @@ type Event[T payloadConstraint] struct {
 	From    OwnerID
 	Created time.Time
 	Payload T
+	// Via is the peer the event was received from, zero for local events
+	Via NodeID
 }
 
 func (e Event[T]) isEvent() {}
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"strconv"
+
 	"github.com/labi-le/belphegor/internal/metadata"
 )
 
@@ -10,6 +12,18 @@ type Handshake struct {
 	Version  string
 	MetaData Device
 	Port     uint32
+	// Endpoints are the transports the sender listens with, older nodes send none
+	Endpoints []Endpoint
+}
+
+// Endpoint is a transport a node listens with and its port
+type Endpoint struct {
+	Transport string
+	Port      uint32
+}
+
+func (e Endpoint) String() string {
+	return e.Transport + ":" + strconv.FormatUint(uint64(e.Port), 10)
 }
 
 func NewGreet(opts ...GreetOption) EventHandshake {
@@ -37,3 +51,9 @@ func WithPort(port uint16) GreetOption {
 		g.Port = uint32(port)
 	}
 }
+
+func WithEndpoints(endpoints []Endpoint) GreetOption {
+	return func(g *Handshake) {
+		g.Endpoints = endpoints
+	}
+}
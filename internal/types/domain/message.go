@@ -47,12 +47,33 @@ type Message struct {
 	Name          string
 	BatchID       MessageID
 	BatchTotal    uint32
+	// TTL after which the content is cleared from the clipboard, 0 keeps it
+	TTL time.Duration
+	// Sealed payloads are encrypted for the recipient devices. A relay that is not
+	// one of them keeps the SealedLength bytes as they are, Data is their path then.
+	// Name, ContentLength, ContentHash and Checksum are sealed with the content
+	Sealed       bool
+	SealedLength uint64
+	// Hops counts the connections crossed from the origin, incremented on receipt
+	Hops uint32
+	// Offset is where the content of the raw stream starts, a resumed file skips what the receiver holds
+	Offset uint64
+	// Checksum is the xxhash of the whole content of a file, 0 when unknown
+	Checksum uint64
+	// Dir content is a tar archive of the directory Name, the receiver unpacks it
+	Dir bool
 }
 
 func (m Message) Zero() bool {
 	return m.ID == 0 || m.ContentHash == 0 || m.ContentLength == 0
 }
 
+// Concealed is the message without what a relay must not learn, its recipients read it from the sealed stream
+func (m Message) Concealed() Message {
+	m.Name, m.ContentLength, m.ContentHash, m.Checksum = "", 0, 0, 0
+	return m
+}
+
 func (m Message) Event() EventMessage {
 	return EventMessage{
 		From:    NodeID(id.MyID),
@@ -89,6 +110,8 @@ func (m Message) Announce() Announce {
 		ContentLength: m.ContentLength,
 		BatchID:       m.BatchID,
 		BatchTotal:    m.BatchTotal,
+		Hops:          m.Hops,
+		Name:          m.Name,
 	}
 }
 
@@ -100,4 +123,13 @@ func (m Message) MarshalZerologObject(e *zerolog.Event) {
 	e.Uint64("hash", m.ContentHash)
 	e.Int64("batch_id", m.BatchID.Int64())
 	e.Uint32("batch_total", m.BatchTotal)
+	e.Dur("ttl", m.TTL)
+	e.Bool("sealed", m.Sealed)
+	e.Uint32("hops", m.Hops)
+	if m.Offset > 0 {
+		e.Str("offset", humanize.Bytes(m.Offset))
+	}
+	if m.Dir {
+		e.Bool("dir", m.Dir)
+	}
 }
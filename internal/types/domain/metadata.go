@@ -28,12 +28,14 @@ func initDefaultMetadata() Device {
 	return Device{
 		Name: name,
 		Arch: runtime.GOARCH,
-		ID:   NodeID(id.MyID),
 	}
 }
 
+// SelfMetaData describes this device, ID follows id.SetMine
 func SelfMetaData() Device {
-	return defaultMetadata
+	meta := defaultMetadata
+	meta.ID = NodeID(id.MyID)
+	return meta
 }
 
 func (meta Device) UniqueID() NodeID {
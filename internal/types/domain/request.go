@@ -4,8 +4,10 @@ type EventRequest = Event[Request]
 
 type Request struct {
 	ID MessageID
+	// Offset is how much of the content the requester already holds
+	Offset uint64
 }
 
-func NewRequest(id MessageID) Event[Request] {
-	return NewEvent(Request{ID: id})
+func NewRequest(id MessageID, offset uint64) Event[Request] {
+	return NewEvent(Request{ID: id, Offset: offset})
 }
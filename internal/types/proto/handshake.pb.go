@@ -22,10 +22,12 @@ const (
 )
 
 type Handshake struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Version       string                 `protobuf:"bytes,1,opt,name=Version,proto3" json:"Version,omitempty"`
-	Device        *Device                `protobuf:"bytes,2,opt,name=Device,proto3" json:"Device,omitempty"`
-	Port          uint32                 `protobuf:"varint,3,opt,name=Port,proto3" json:"Port,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Version string                 `protobuf:"bytes,1,opt,name=Version,proto3" json:"Version,omitempty"`
+	Device  *Device                `protobuf:"bytes,2,opt,name=Device,proto3" json:"Device,omitempty"`
+	Port    uint32                 `protobuf:"varint,3,opt,name=Port,proto3" json:"Port,omitempty"`
+	// transports the sender listens with, empty means the transport of the connection on Port
+	Endpoints     []*Endpoint `protobuf:"bytes,4,rep,name=Endpoints,proto3" json:"Endpoints,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -81,15 +83,78 @@ func (x *Handshake) GetPort() uint32 {
 	return 0
 }
 
+func (x *Handshake) GetEndpoints() []*Endpoint {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+type Endpoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transport     string                 `protobuf:"bytes,1,opt,name=Transport,proto3" json:"Transport,omitempty"`
+	Port          uint32                 `protobuf:"varint,2,opt,name=Port,proto3" json:"Port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Endpoint) Reset() {
+	*x = Endpoint{}
+	mi := &file_handshake_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Endpoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Endpoint) ProtoMessage() {}
+
+func (x *Endpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_handshake_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Endpoint.ProtoReflect.Descriptor instead.
+func (*Endpoint) Descriptor() ([]byte, []int) {
+	return file_handshake_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Endpoint) GetTransport() string {
+	if x != nil {
+		return x.Transport
+	}
+	return ""
+}
+
+func (x *Endpoint) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
 var File_handshake_proto protoreflect.FileDescriptor
 
 const file_handshake_proto_rawDesc = "" +
 	"\n" +
-	"\x0fhandshake.proto\x12\tbelphegor\x1a\fdevice.proto\"d\n" +
+	"\x0fhandshake.proto\x12\tbelphegor\x1a\fdevice.proto\"\x97\x01\n" +
 	"\tHandshake\x12\x18\n" +
 	"\aVersion\x18\x01 \x01(\tR\aVersion\x12)\n" +
 	"\x06Device\x18\x02 \x01(\v2\x11.belphegor.DeviceR\x06Device\x12\x12\n" +
-	"\x04Port\x18\x03 \x01(\rR\x04PortB\x16Z\x14internal/types/protob\x06proto3"
+	"\x04Port\x18\x03 \x01(\rR\x04Port\x121\n" +
+	"\tEndpoints\x18\x04 \x03(\v2\x13.belphegor.EndpointR\tEndpoints\"<\n" +
+	"\bEndpoint\x12\x1c\n" +
+	"\tTransport\x18\x01 \x01(\tR\tTransport\x12\x12\n" +
+	"\x04Port\x18\x02 \x01(\rR\x04PortB\x16Z\x14internal/types/protob\x06proto3"
 
 var (
 	file_handshake_proto_rawDescOnce sync.Once
@@ -103,18 +168,20 @@ func file_handshake_proto_rawDescGZIP() []byte {
 	return file_handshake_proto_rawDescData
 }
 
-var file_handshake_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_handshake_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_handshake_proto_goTypes = []any{
 	(*Handshake)(nil), // 0: belphegor.Handshake
-	(*Device)(nil),    // 1: belphegor.Device
+	(*Endpoint)(nil),  // 1: belphegor.Endpoint
+	(*Device)(nil),    // 2: belphegor.Device
 }
 var file_handshake_proto_depIdxs = []int32{
-	1, // 0: belphegor.Handshake.Device:type_name -> belphegor.Device
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2, // 0: belphegor.Handshake.Device:type_name -> belphegor.Device
+	1, // 1: belphegor.Handshake.Endpoints:type_name -> belphegor.Endpoint
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_handshake_proto_init() }
@@ -129,7 +196,7 @@ func file_handshake_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_handshake_proto_rawDesc), len(file_handshake_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -79,8 +79,23 @@ type Message struct {
 	// file name if mime == path
 	Name string `protobuf:"bytes,5,opt,name=Name,proto3" json:"Name,omitempty"`
 	// data as raw stream after write metadata
-	BatchID       int64  `protobuf:"varint,6,opt,name=BatchID,proto3" json:"BatchID,omitempty"`
-	BatchTotal    uint32 `protobuf:"varint,7,opt,name=BatchTotal,proto3" json:"BatchTotal,omitempty"`
+	BatchID    int64  `protobuf:"varint,6,opt,name=BatchID,proto3" json:"BatchID,omitempty"`
+	BatchTotal uint32 `protobuf:"varint,7,opt,name=BatchTotal,proto3" json:"BatchTotal,omitempty"`
+	// milliseconds the receiver keeps the content in its clipboard, 0 = forever
+	TTL int64 `protobuf:"varint,8,opt,name=TTL,proto3" json:"TTL,omitempty"`
+	// the raw stream is sealed for the recipient devices, SealedLength bytes long
+	Sealed       bool   `protobuf:"varint,9,opt,name=Sealed,proto3" json:"Sealed,omitempty"`
+	SealedLength uint64 `protobuf:"varint,10,opt,name=SealedLength,proto3" json:"SealedLength,omitempty"`
+	// connections crossed from the origin to the sender, 0 = the sender copied it
+	Hops uint32 `protobuf:"varint,11,opt,name=Hops,proto3" json:"Hops,omitempty"`
+	// byte of the content the raw stream starts at, a file is streamed in chunks from it
+	Offset uint64 `protobuf:"varint,12,opt,name=Offset,proto3" json:"Offset,omitempty"`
+	// xxhash of the whole content of a file, checked before it goes into the clipboard
+	Checksum uint64 `protobuf:"varint,13,opt,name=Checksum,proto3" json:"Checksum,omitempty"`
+	// the content is a tar archive of the directory Name, the receiver unpacks it
+	Dir bool `protobuf:"varint,14,opt,name=Dir,proto3" json:"Dir,omitempty"`
+	// id of the device the content was copied on
+	From          int64 `protobuf:"varint,15,opt,name=From,proto3" json:"From,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -164,6 +179,62 @@ func (x *Message) GetBatchTotal() uint32 {
 	return 0
 }
 
+func (x *Message) GetTTL() int64 {
+	if x != nil {
+		return x.TTL
+	}
+	return 0
+}
+
+func (x *Message) GetSealed() bool {
+	if x != nil {
+		return x.Sealed
+	}
+	return false
+}
+
+func (x *Message) GetSealedLength() uint64 {
+	if x != nil {
+		return x.SealedLength
+	}
+	return 0
+}
+
+func (x *Message) GetHops() uint32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+func (x *Message) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *Message) GetChecksum() uint64 {
+	if x != nil {
+		return x.Checksum
+	}
+	return 0
+}
+
+func (x *Message) GetDir() bool {
+	if x != nil {
+		return x.Dir
+	}
+	return false
+}
+
+func (x *Message) GetFrom() int64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
 type Announce struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ID            int64                  `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
@@ -172,6 +243,14 @@ type Announce struct {
 	ContentHash   uint64                 `protobuf:"varint,4,opt,name=ContentHash,proto3" json:"ContentHash,omitempty"`
 	BatchID       int64                  `protobuf:"varint,5,opt,name=BatchID,proto3" json:"BatchID,omitempty"`
 	BatchTotal    uint32                 `protobuf:"varint,6,opt,name=BatchTotal,proto3" json:"BatchTotal,omitempty"`
+	// connections crossed from the origin to the sender, 0 = the sender copied it
+	Hops uint32 `protobuf:"varint,7,opt,name=Hops,proto3" json:"Hops,omitempty"`
+	// file name if mime == path, the receiver can offer the file before fetching it
+	Name string `protobuf:"bytes,8,opt,name=Name,proto3" json:"Name,omitempty"`
+	// id of the device the content was copied on
+	From int64 `protobuf:"varint,9,opt,name=From,proto3" json:"From,omitempty"`
+	// SealedFields of the message sealed for the recipient devices, its own fields are left empty then
+	Sealed        []byte `protobuf:"bytes,10,opt,name=Sealed,proto3" json:"Sealed,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -248,16 +327,116 @@ func (x *Announce) GetBatchTotal() uint32 {
 	return 0
 }
 
-type RequestMessage struct {
+func (x *Announce) GetHops() uint32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+func (x *Announce) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Announce) GetFrom() int64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *Announce) GetSealed() []byte {
+	if x != nil {
+		return x.Sealed
+	}
+	return nil
+}
+
+// what a relay must not learn of a sealed message: a sealed stream starts with it
+// and a sealed announce carries it instead of the fields
+type SealedFields struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	ID            int64                  `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	ContentLength uint64                 `protobuf:"varint,1,opt,name=ContentLength,proto3" json:"ContentLength,omitempty"`
+	ContentHash   uint64                 `protobuf:"varint,2,opt,name=ContentHash,proto3" json:"ContentHash,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	Checksum      uint64                 `protobuf:"varint,4,opt,name=Checksum,proto3" json:"Checksum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SealedFields) Reset() {
+	*x = SealedFields{}
+	mi := &file_message_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SealedFields) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SealedFields) ProtoMessage() {}
+
+func (x *SealedFields) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SealedFields.ProtoReflect.Descriptor instead.
+func (*SealedFields) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SealedFields) GetContentLength() uint64 {
+	if x != nil {
+		return x.ContentLength
+	}
+	return 0
+}
+
+func (x *SealedFields) GetContentHash() uint64 {
+	if x != nil {
+		return x.ContentHash
+	}
+	return 0
+}
+
+func (x *SealedFields) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SealedFields) GetChecksum() uint64 {
+	if x != nil {
+		return x.Checksum
+	}
+	return 0
+}
+
+type RequestMessage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	ID    int64                  `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	// the requester holds the content up to it, only the rest is sent
+	Offset        uint64 `protobuf:"varint,2,opt,name=Offset,proto3" json:"Offset,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *RequestMessage) Reset() {
 	*x = RequestMessage{}
-	mi := &file_message_proto_msgTypes[2]
+	mi := &file_message_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -269,7 +448,7 @@ func (x *RequestMessage) String() string {
 func (*RequestMessage) ProtoMessage() {}
 
 func (x *RequestMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_message_proto_msgTypes[2]
+	mi := &file_message_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -282,7 +461,7 @@ func (x *RequestMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RequestMessage.ProtoReflect.Descriptor instead.
 func (*RequestMessage) Descriptor() ([]byte, []int) {
-	return file_message_proto_rawDescGZIP(), []int{2}
+	return file_message_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *RequestMessage) GetID() int64 {
@@ -292,11 +471,18 @@ func (x *RequestMessage) GetID() int64 {
 	return 0
 }
 
+func (x *RequestMessage) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
 var File_message_proto protoreflect.FileDescriptor
 
 const file_message_proto_rawDesc = "" +
 	"\n" +
-	"\rmessage.proto\x12\tbelphegor\"\xdc\x01\n" +
+	"\rmessage.proto\x12\tbelphegor\"\x98\x03\n" +
 	"\aMessage\x12\x0e\n" +
 	"\x02ID\x18\x01 \x01(\x03R\x02ID\x12$\n" +
 	"\rContentLength\x18\x02 \x01(\x04R\rContentLength\x12+\n" +
@@ -306,7 +492,16 @@ const file_message_proto_rawDesc = "" +
 	"\aBatchID\x18\x06 \x01(\x03R\aBatchID\x12\x1e\n" +
 	"\n" +
 	"BatchTotal\x18\a \x01(\rR\n" +
-	"BatchTotal\"\xc9\x01\n" +
+	"BatchTotal\x12\x10\n" +
+	"\x03TTL\x18\b \x01(\x03R\x03TTL\x12\x16\n" +
+	"\x06Sealed\x18\t \x01(\bR\x06Sealed\x12\"\n" +
+	"\fSealedLength\x18\n" +
+	" \x01(\x04R\fSealedLength\x12\x12\n" +
+	"\x04Hops\x18\v \x01(\rR\x04Hops\x12\x16\n" +
+	"\x06Offset\x18\f \x01(\x04R\x06Offset\x12\x1a\n" +
+	"\bChecksum\x18\r \x01(\x04R\bChecksum\x12\x10\n" +
+	"\x03Dir\x18\x0e \x01(\bR\x03Dir\x12\x12\n" +
+	"\x04From\x18\x0f \x01(\x03R\x04From\"\x9d\x02\n" +
 	"\bAnnounce\x12\x0e\n" +
 	"\x02ID\x18\x01 \x01(\x03R\x02ID\x12$\n" +
 	"\rContentLength\x18\x02 \x01(\x04R\rContentLength\x12+\n" +
@@ -315,9 +510,20 @@ const file_message_proto_rawDesc = "" +
 	"\aBatchID\x18\x05 \x01(\x03R\aBatchID\x12\x1e\n" +
 	"\n" +
 	"BatchTotal\x18\x06 \x01(\rR\n" +
-	"BatchTotal\" \n" +
+	"BatchTotal\x12\x12\n" +
+	"\x04Hops\x18\a \x01(\rR\x04Hops\x12\x12\n" +
+	"\x04Name\x18\b \x01(\tR\x04Name\x12\x12\n" +
+	"\x04From\x18\t \x01(\x03R\x04From\x12\x16\n" +
+	"\x06Sealed\x18\n" +
+	" \x01(\fR\x06Sealed\"\x86\x01\n" +
+	"\fSealedFields\x12$\n" +
+	"\rContentLength\x18\x01 \x01(\x04R\rContentLength\x12 \n" +
+	"\vContentHash\x18\x02 \x01(\x04R\vContentHash\x12\x12\n" +
+	"\x04Name\x18\x03 \x01(\tR\x04Name\x12\x1a\n" +
+	"\bChecksum\x18\x04 \x01(\x04R\bChecksum\"8\n" +
 	"\x0eRequestMessage\x12\x0e\n" +
-	"\x02ID\x18\x01 \x01(\x03R\x02ID*%\n" +
+	"\x02ID\x18\x01 \x01(\x03R\x02ID\x12\x16\n" +
+	"\x06Offset\x18\x02 \x01(\x04R\x06Offset*%\n" +
 	"\x04Mime\x12\b\n" +
 	"\x04TEXT\x10\x00\x12\t\n" +
 	"\x05IMAGE\x10\x01\x12\b\n" +
@@ -336,12 +542,13 @@ func file_message_proto_rawDescGZIP() []byte {
 }
 
 var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_message_proto_goTypes = []any{
 	(Mime)(0),              // 0: belphegor.Mime
 	(*Message)(nil),        // 1: belphegor.Message
 	(*Announce)(nil),       // 2: belphegor.Announce
-	(*RequestMessage)(nil), // 3: belphegor.RequestMessage
+	(*SealedFields)(nil),   // 3: belphegor.SealedFields
+	(*RequestMessage)(nil), // 4: belphegor.RequestMessage
 }
 var file_message_proto_depIdxs = []int32{
 	0, // 0: belphegor.Message.MimeType:type_name -> belphegor.Mime
@@ -364,7 +571,7 @@ func file_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_message_proto_rawDesc), len(file_message_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   3,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
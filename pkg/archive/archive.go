@@ -0,0 +1,298 @@
+// Package archive packs a directory tree into a tar stream and unpacks it.
+// Symbolic links are kept only when they point inside the tree, others are left out
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const blockSize = 512
+
+var (
+	ErrChanged = errors.New("directory changed while it was read")
+	ErrUnsafe  = errors.New("entry outside the archive root")
+)
+
+// Tree sums up a directory as it is archived
+type Tree struct {
+	// Size is the length of the archive
+	Size uint64
+	// ModTime is the newest modification time in the tree, unix nanoseconds
+	ModTime uint64
+}
+
+// Stat walks the directory at root without reading its files
+func Stat(root string) (Tree, error) {
+	var tree Tree
+
+	err := walk(root, func(_ *os.Root, hdr *tar.Header) error {
+		// a writer per entry, the header length does not depend on what came before it
+		var header counter
+		if err := tar.NewWriter(&header).WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		tree.ModTime = max(tree.ModTime, uint64(hdr.ModTime.UnixNano()))
+		tree.Size += header.n + padded(hdr.Size)
+		return nil
+	})
+	if err != nil {
+		return Tree{}, fmt.Errorf("archive.Stat: %w", err)
+	}
+
+	// the archive ends with two zero blocks
+	tree.Size += 2 * blockSize
+	return tree, nil
+}
+
+// Write writes the archive of the directory at root to w,
+// the Tree it returns matches Stat unless the directory changed in between
+func Write(w io.Writer, root string) (Tree, error) {
+	var (
+		tree  Tree
+		count = &counter{w: w}
+		tw    = tar.NewWriter(count)
+	)
+
+	err := walk(root, func(dir *os.Root, hdr *tar.Header) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		tree.ModTime = max(tree.ModTime, uint64(hdr.ModTime.UnixNano()))
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		return copyFile(tw, dir, hdr)
+	})
+	if err != nil {
+		return Tree{}, fmt.Errorf("archive.Write: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return Tree{}, fmt.Errorf("archive.Write: %w", err)
+	}
+
+	tree.Size = count.n
+	return tree, nil
+}
+
+func copyFile(w io.Writer, dir *os.Root, hdr *tar.Header) error {
+	f, err := dir.Open(filepath.FromSlash(hdr.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// a file that grew is cut at the size in its header
+	n, err := io.Copy(w, io.LimitReader(f, hdr.Size))
+	if err != nil {
+		return err
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("%w: %s shrank", ErrChanged, hdr.Name)
+	}
+
+	return nil
+}
+
+// walk calls fn for every entry of the tree in lexical order, the root itself comes first as "./"
+func walk(root string, fn func(dir *os.Root, hdr *tar.Header) error) error {
+	dir, err := os.OpenRoot(root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return fs.WalkDir(dir.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr, ok, err := header(dir, name, info)
+		if err != nil || !ok {
+			return err
+		}
+		return fn(dir, hdr)
+	})
+}
+
+// header describes the entry, ok is false for the ones left out of the archive
+func header(dir *os.Root, name string, info fs.FileInfo) (hdr *tar.Header, ok bool, err error) {
+	hdr = &tar.Header{
+		Name: name,
+		Mode: int64(info.Mode().Perm()),
+		// whole seconds fit the plain ustar header
+		ModTime: info.ModTime().Truncate(time.Second),
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case mode.IsRegular():
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+	case mode&fs.ModeSymlink != 0:
+		target, err := dir.Readlink(filepath.FromSlash(name))
+		if err != nil {
+			return nil, false, err
+		}
+		if filepath.IsAbs(target) || !inside(name, filepath.ToSlash(target)) {
+			return nil, false, nil
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = filepath.ToSlash(target)
+	default:
+		// devices, sockets and pipes mean nothing on another machine
+		return nil, false, nil
+	}
+
+	return hdr, true, nil
+}
+
+// inside reports whether the link at name resolves to a path in the tree
+func inside(name, target string) bool {
+	if path.IsAbs(target) {
+		return false
+	}
+	return filepath.IsLocal(filepath.FromSlash(path.Join(path.Dir(name), target)))
+}
+
+// Extract unpacks the archive read from r into dir, which must not exist.
+// Nothing is written outside dir, whatever the archive holds
+func Extract(r io.Reader, dir string) error {
+	if err := os.Mkdir(dir, 0750); err != nil {
+		return fmt.Errorf("archive.Extract: %w", err)
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("archive.Extract: %w", err)
+	}
+	defer root.Close()
+
+	var (
+		tr    = tar.NewReader(r)
+		dirs  []*tar.Header
+		links = make(map[string]bool)
+	)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("archive.Extract: %w", err)
+		}
+
+		hdr.Name = path.Clean(hdr.Name)
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive.Extract: %w: %q", ErrUnsafe, hdr.Name)
+		}
+		// a link may lead anywhere inside the tree, nothing is written through one
+		if underLink(hdr.Name, links) {
+			continue
+		}
+
+		if err := root.MkdirAll(filepath.Dir(name), 0750); err != nil {
+			return fmt.Errorf("archive.Extract: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0750); err != nil {
+				return fmt.Errorf("archive.Extract: %w", err)
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := extractFile(root, name, hdr, tr); err != nil {
+				return fmt.Errorf("archive.Extract: %w", err)
+			}
+		case tar.TypeSymlink:
+			if !inside(hdr.Name, hdr.Linkname) {
+				continue
+			}
+			if err := root.Symlink(filepath.FromSlash(hdr.Linkname), name); err != nil {
+				return fmt.Errorf("archive.Extract: %w", err)
+			}
+			links[hdr.Name] = true
+		}
+	}
+
+	// directories last and deepest first, filling them changes their mtime and may need write permission
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := filepath.FromSlash(dirs[i].Name)
+		if err := root.Chmod(name, fs.FileMode(dirs[i].Mode)&fs.ModePerm); err != nil {
+			return fmt.Errorf("archive.Extract: %w", err)
+		}
+		if err := root.Chtimes(name, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return fmt.Errorf("archive.Extract: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func extractFile(root *os.Root, name string, hdr *tar.Header, r io.Reader) error {
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := root.Chmod(name, fs.FileMode(hdr.Mode)&fs.ModePerm); err != nil {
+		return err
+	}
+	return root.Chtimes(name, hdr.ModTime, hdr.ModTime)
+}
+
+func underLink(name string, links map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if links[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+func padded(size int64) uint64 {
+	return uint64((size + blockSize - 1) / blockSize * blockSize)
+}
+
+type counter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	if c.w == nil {
+		c.n += uint64(len(p))
+		return len(p), nil
+	}
+
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,187 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labi-le/belphegor/pkg/archive"
+)
+
+func tree(t *testing.T) string {
+	t.Helper()
+
+	root := filepath.Join(t.TempDir(), "src")
+	long := strings.Repeat("long", 40)
+	files := map[string]string{
+		"a.txt":                  "alpha",
+		"sub/b.txt":              "bravo",
+		"sub/deep/c.bin":         strings.Repeat("c", 1000),
+		filepath.Join(long, "d"): "delta",
+		"empty":                  "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Chmod(filepath.Join(root, "a.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "sub", "b.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("../a.txt", filepath.Join(root, "sub", "inside")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("../../outside", filepath.Join(root, "sub", "outside")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	root := tree(t)
+
+	stat, err := archive.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	written, err := archive.Write(&buf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != stat || stat.Size != uint64(buf.Len()) {
+		t.Fatalf("Stat %+v, Write %+v, %d bytes written", stat, written, buf.Len())
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := archive.Extract(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "sub", "deep", "c.bin"))
+	if err != nil || string(got) != strings.Repeat("c", 1000) {
+		t.Fatalf("c.bin = %d bytes, %v", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, strings.Repeat("long", 40), "d")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "a.txt"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("a.txt mode %v, %v", info.Mode(), err)
+	}
+	info, err = os.Stat(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil || !info.ModTime().Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("b.txt mtime %v, %v", info.ModTime(), err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "sub", "inside")); err != nil || target != "../a.txt" {
+		t.Fatalf("inside link = %q, %v", target, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "sub", "outside")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("link out of the tree was kept: %v", err)
+	}
+}
+
+func TestArchive_Changed(t *testing.T) {
+	root := tree(t)
+
+	stat, err := archive.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "new.txt"), []byte("x"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := archive.Write(new(bytes.Buffer), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Size == stat.Size {
+		t.Fatal("a new file did not change the archive size")
+	}
+}
+
+func TestExtract_Unsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		wantErr error
+		absent  string
+	}{
+		{
+			name:    "parent traversal",
+			entries: []tar.Header{{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644}},
+			wantErr: archive.ErrUnsafe,
+		},
+		{
+			name:    "absolute path",
+			entries: []tar.Header{{Name: "/etc/escape", Typeflag: tar.TypeReg, Mode: 0644}},
+			wantErr: archive.ErrUnsafe,
+		},
+		{
+			name: "written through a link",
+			entries: []tar.Header{
+				{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "up/file", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+			absent: "file",
+		},
+		{
+			name:    "link out of the tree",
+			entries: []tar.Header{{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+			absent:  filepath.Join("sub", "up"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range tt.entries {
+				if err := tw.WriteHeader(&hdr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := filepath.Join(t.TempDir(), "dst")
+			err := archive.Extract(&buf, dst)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.absent == "" {
+				return
+			}
+			if _, err := os.Lstat(filepath.Join(dst, tt.absent)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s was extracted: %v", tt.absent, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Package bytesize is a byte count written for humans, usable as a flag value
+package bytesize
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Size is a byte count, set from values like 64MiB or 500MB
+type Size uint64
+
+func (s Size) String() string {
+	return humanize.IBytes(uint64(s))
+}
+
+func (s *Size) Set(v string) error {
+	size, err := humanize.ParseBytes(v)
+	if err != nil {
+		return fmt.Errorf("invalid size: %w", err)
+	}
+	*s = Size(size)
+	return nil
+}
+
+func (s *Size) Type() string {
+	return "size"
+}
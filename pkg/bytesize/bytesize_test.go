@@ -0,0 +1,36 @@
+package bytesize_test
+
+import (
+	"testing"
+
+	"github.com/labi-le/belphegor/pkg/bytesize"
+)
+
+func TestSize_Set(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bytesize.Size
+	}{
+		{"64MiB", 64 << 20},
+		{"500 MB", 500_000_000},
+		{"1024", 1024},
+	}
+
+	for _, tt := range tests {
+		var s bytesize.Size
+		if err := s.Set(tt.in); err != nil {
+			t.Fatal(err)
+		}
+		if s != tt.want {
+			t.Fatalf("Set(%q) = %d, want %d", tt.in, s, tt.want)
+		}
+	}
+
+	var s bytesize.Size
+	if err := s.Set("lots"); err == nil {
+		t.Fatal("Set(lots) succeeded")
+	}
+	if got := bytesize.Size(64 << 20).String(); got != "64 MiB" {
+		t.Fatalf("String() = %q, want 64 MiB", got)
+	}
+}
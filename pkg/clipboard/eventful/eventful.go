@@ -2,9 +2,8 @@ package eventful
 
 import (
 	"context"
-	"fmt"
 
-	"github.com/dustin/go-humanize"
+	"github.com/labi-le/belphegor/pkg/bytesize"
 	"github.com/labi-le/belphegor/pkg/mime"
 	"github.com/rs/zerolog"
 )
@@ -16,6 +15,15 @@ type Eventful interface {
 	Write(t mime.Type, src []byte) (int, error)
 }
 
+// Lazy is implemented by clipboards that hand out their content only when an application pastes it
+type Lazy interface {
+	// WriteLazy offers content of type t, fetch is called on every paste and returns the data to hand out
+	WriteLazy(t mime.Type, fetch Fetch) error
+}
+
+// Fetch gets the content offered by WriteLazy, a paste waits for it
+type Fetch func() ([]byte, error)
+
 type Update struct {
 	// Data or path to data. if is a path, then, according to the contract,
 	// it is necessary to return clean path without a trash
@@ -25,6 +33,10 @@ type Update struct {
 	Hash       uint64
 	BatchID    uint64
 	BatchTotal uint32
+	// Sensitive the source marked the content as secret, see IsSensitive
+	Sensitive bool
+	// Dir the path is a directory, Size is the length of its archive
+	Dir bool
 }
 
 func (u Update) MarshalZerologObject(e *zerolog.Event) {
@@ -33,29 +45,35 @@ func (u Update) MarshalZerologObject(e *zerolog.Event) {
 	e.Stringer("mime", u.MimeType)
 	e.Uint64("batch_id", u.BatchID)
 	e.Uint32("batch_total", u.BatchTotal)
+	e.Bool("sensitive", u.Sensitive)
+	if u.Dir {
+		e.Bool("dir", u.Dir)
+	}
 }
 
-type Options struct {
-	AllowCopyFiles    bool
-	MaxFileSize       MaxFileSize
-	MaxClipboardFiles int
-}
-
-type MaxFileSize uint64
-
-func (m MaxFileSize) String() string {
-	return humanize.Bytes(uint64(m))
-}
+// Formats that password managers add next to the content to mark it as secret
+const (
+	HintKDEPasswordManager = "x-kde-passwordManagerHint"
+	HintWindowsExclude     = "ExcludeClipboardContentFromMonitorProcessing"
+	HintMacConcealed       = "org.nspasteboard.ConcealedType"
+)
 
-func (m *MaxFileSize) Set(s string) error {
-	size, err := humanize.ParseBytes(s)
-	if err != nil {
-		return fmt.Errorf("invalid max_file_size: %w", err)
+// IsSensitive reports whether the formats offered by the source contain a secret hint
+func IsSensitive(formats []string) bool {
+	for _, f := range formats {
+		switch f {
+		case HintKDEPasswordManager, HintWindowsExclude, HintMacConcealed:
+			return true
+		}
 	}
-	*m = MaxFileSize(size)
-	return nil
+
+	return false
 }
 
-func (m *MaxFileSize) Type() string {
-	return "uint64"
+type Options struct {
+	AllowCopyFiles    bool
+	MaxFileSize       bytesize.Size
+	MaxClipboardFiles int
+	// LazyFiles offers received files at once and fetches them on paste, where the clipboard is Lazy
+	LazyFiles bool
 }
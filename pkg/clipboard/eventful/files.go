@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/cespare/xxhash"
+	"github.com/labi-le/belphegor/pkg/archive"
 	"github.com/labi-le/belphegor/pkg/mime"
 	"github.com/labi-le/belphegor/pkg/strutil"
 )
@@ -15,6 +16,36 @@ type FileInfo struct {
 	Path    string
 	Size    uint64
 	ModTime uint64
+	// Dir is sent as an archive of its tree, Size is the archive length and ModTime the newest in it
+	Dir bool
+}
+
+// Stat describes the file or the directory at path
+func Stat(path string) (FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if !info.IsDir() {
+		return FileInfo{
+			Path:    path,
+			Size:    uint64(info.Size()),
+			ModTime: uint64(info.ModTime().UnixNano()),
+		}, nil
+	}
+
+	tree, err := archive.Stat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		Path:    path,
+		Size:    tree.Size,
+		ModTime: tree.ModTime,
+		Dir:     true,
+	}, nil
 }
 
 func UpdatesFromFileInfo(files []FileInfo) ([]Update, []byte) {
@@ -50,6 +81,7 @@ func UpdatesFromFileInfo(files []FileInfo) ([]Update, []byte) {
 			Hash:       xxhash.Sum64(buf),
 			BatchID:    batchID,
 			BatchTotal: batchTotal,
+			Dir:        file.Dir,
 		})
 
 		buf = buf[:0]
@@ -104,16 +136,12 @@ func parseFileURI(line []byte) (FileInfo, bool) {
 		}
 	}
 
-	info, err := os.Lstat(path)
-	if err != nil || info.IsDir() {
+	info, err := Stat(path)
+	if err != nil {
 		return FileInfo{}, false
 	}
 
-	return FileInfo{
-		Path:    path,
-		Size:    uint64(info.Size()),
-		ModTime: uint64(info.ModTime().UnixNano()),
-	}, true
+	return info, true
 }
 
 func UpdatesFromRawPath(data []byte, limit int) ([]Update, []byte) {
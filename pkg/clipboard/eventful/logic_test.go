@@ -127,13 +127,13 @@ func TestUpdatesFromRawPath(t *testing.T) {
 
 	raw := "file://" + fileA + "\r\n" +
 		"file://" + fileB + "\r\n" +
-		"file://" + subdir + "\r\n" + // directory -> skipped
+		"file://" + subdir + "\r\n" + // directory -> sent as an archive
 		"file://" + filepath.Join(dir, "missing.txt") + "\r\n" + // nonexistent -> skipped
 		"plain text line\r\n" // not a file uri -> skipped
 
 	updates, batchHash := eventful.UpdatesFromRawPath([]byte(raw), 0)
-	if len(updates) != 2 {
-		t.Fatalf("got %d updates, want 2 (dir/missing/non-uri skipped)", len(updates))
+	if len(updates) != 3 {
+		t.Fatalf("got %d updates, want 3 (missing/non-uri skipped)", len(updates))
 	}
 	if len(batchHash) == 0 {
 		t.Fatal("batch hash must not be empty")
@@ -144,6 +144,9 @@ func TestUpdatesFromRawPath(t *testing.T) {
 		if u.MimeType != mime.TypePath {
 			t.Errorf("mime = %v, want path", u.MimeType)
 		}
+		if u.Dir != (string(u.Data) == subdir) {
+			t.Errorf("dir(%s) = %v", u.Data, u.Dir)
+		}
 		sizeByPath[string(u.Data)] = u.Size
 	}
 	if sizeByPath[fileA] != 5 {
@@ -152,6 +155,10 @@ func TestUpdatesFromRawPath(t *testing.T) {
 	if sizeByPath[fileB] != 9 {
 		t.Errorf("size(%s) = %d, want 9", fileB, sizeByPath[fileB])
 	}
+	// the empty directory is the root entry and the end of the archive
+	if sizeByPath[subdir] != 3*512 {
+		t.Errorf("size(%s) = %d, want 1536", subdir, sizeByPath[subdir])
+	}
 }
 
 func TestUpdatesFromRawPath_Limit(t *testing.T) {
@@ -197,3 +204,12 @@ func TestUpdatesFromRawPath_NoValidReturnsEmpty(t *testing.T) {
 		t.Errorf("batchHash = %v, want nil", batchHash)
 	}
 }
+
+func TestIsSensitive(t *testing.T) {
+	if !eventful.IsSensitive([]string{"text/plain", eventful.HintKDEPasswordManager}) {
+		t.Fatal("password manager hint not recognised")
+	}
+	if eventful.IsSensitive([]string{"text/plain", "UTF8_STRING"}) {
+		t.Fatal("plain text reported as sensitive")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"runtime"
 	"sync/atomic"
 	"time"
@@ -75,6 +74,7 @@ func (m *Clipboard) Watch(ctx context.Context, update chan<- eventful.Update) er
 	nsTypeText := makeNSString(clsNSString, "public.utf8-plain-text")
 	nsTypePNG := makeNSString(clsNSString, "public.png")
 	nsTypeFile := makeNSString(clsNSString, "NSFilenamesPboardType")
+	nsTypeConcealed := makeNSString(clsNSString, eventful.HintMacConcealed)
 
 	pb := objc.ID(clsNSPasteboard).Send(selGeneralPasteboard)
 	lastCount := pb.Send(selChangeCount)
@@ -100,6 +100,9 @@ func (m *Clipboard) Watch(ctx context.Context, update chan<- eventful.Update) er
 
 			pool := objc.ID(clsNSAutoreleasePool).Send(selNew)
 
+			// password managers add a concealed type next to the secret
+			sensitive := pb.Send(selDataForType, nsTypeConcealed) != 0
+
 			nsData := pb.Send(selDataForType, nsTypePNG)
 			if nsData != 0 {
 				length := nsData.Send(selLength)
@@ -112,9 +115,10 @@ func (m *Clipboard) Watch(ctx context.Context, update chan<- eventful.Update) er
 						copy(dataCopy, data)
 
 						update <- eventful.Update{
-							MimeType: mime.TypeImage,
-							Data:     dataCopy,
-							Hash:     h,
+							MimeType:  mime.TypeImage,
+							Data:      dataCopy,
+							Hash:      h,
+							Sensitive: sensitive,
 						}
 						pool.Send(selRelease)
 						continue
@@ -145,26 +149,19 @@ func (m *Clipboard) Watch(ctx context.Context, update chan<- eventful.Update) er
 						}
 
 						path := string(cStringToGoBytes(uintptr(utf8Ptr)))
-						info, err := os.Lstat(path)
+						info, err := eventful.Stat(path)
 						if err != nil {
 							continue
 						}
 
-						if info.IsDir() {
-							continue
-						}
-
-						files = append(files, eventful.FileInfo{
-							Path:    path,
-							Size:    uint64(info.Size()),
-							ModTime: uint64(info.ModTime().UnixNano()),
-						})
+						files = append(files, info)
 					}
 
 					if len(files) > 0 {
 						updates, hash := eventful.UpdatesFromFileInfo(files)
 						if _, ok := m.dedup.Check(hash); ok {
 							for _, u := range updates {
+								u.Sensitive = sensitive
 								update <- u
 							}
 							pool.Send(selRelease)
@@ -185,9 +182,10 @@ func (m *Clipboard) Watch(ctx context.Context, update chan<- eventful.Update) er
 						copy(dataCopy, data)
 
 						update <- eventful.Update{
-							MimeType: mime.TypeText,
-							Data:     dataCopy,
-							Hash:     h,
+							MimeType:  mime.TypeText,
+							Data:      dataCopy,
+							Hash:      h,
+							Sensitive: sensitive,
 						}
 					}
 				}
@@ -89,6 +89,7 @@ func (w *Clipboard) Watch(ctx context.Context, upd chan<- eventful.Update) error
 			if err != nil {
 				return 0
 			}
+			capture.Sensitive = excludedFromMonitors()
 
 			w.signAndSend(capture, upd)
 			return 0
@@ -171,10 +172,11 @@ func (w *Clipboard) signAndSend(capture capturedData, upd chan<- eventful.Update
 	if capture.Type.IsText() {
 		if h, ok := w.dedup.Check(capture.Bytes); ok {
 			upd <- eventful.Update{
-				Data:     capture.Bytes,
-				MimeType: capture.Type,
-				Hash:     h,
-				Size:     uint64(len(capture.Bytes)),
+				Data:      capture.Bytes,
+				MimeType:  capture.Type,
+				Hash:      h,
+				Size:      uint64(len(capture.Bytes)),
+				Sensitive: capture.Sensitive,
 			}
 		}
 		return
@@ -187,6 +189,7 @@ func (w *Clipboard) signAndSend(capture capturedData, upd chan<- eventful.Update
 	updates, hash := eventful.UpdatesFromFileInfo(capture.Files)
 	if _, ok := w.dedup.Check(hash); ok {
 		for i := range updates {
+			updates[i].Sensitive = capture.Sensitive
 			upd <- updates[i]
 		}
 	}
@@ -53,8 +53,11 @@ func (w *Clipboard) readFiles() ([]eventful.FileInfo, error) {
 		}
 
 		if r1 != 0 {
-			// skip folders
+			// folders are sent as an archive of their tree
 			if attr.FileAttributes&syscall.FILE_ATTRIBUTE_DIRECTORY != 0 {
+				if dir, err := eventful.Stat(info.Path); err == nil {
+					result = append(result, dir)
+				}
 				continue
 			}
 
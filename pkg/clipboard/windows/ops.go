@@ -8,15 +8,34 @@ import (
 	"runtime"
 	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
 	"github.com/labi-le/belphegor/pkg/mime"
 )
 
 type capturedData struct {
-	Bytes []byte
-	Files []eventful.FileInfo
-	Type  mime.Type
+	Bytes     []byte
+	Files     []eventful.FileInfo
+	Type      mime.Type
+	Sensitive bool
+}
+
+// excludedFromMonitors reports whether the owner, usually a password manager,
+// asked clipboard monitors to ignore the current content
+func excludedFromMonitors() bool {
+	name, err := syscall.UTF16PtrFromString(eventful.HintWindowsExclude)
+	if err != nil {
+		return false
+	}
+
+	format, _, _ := registerClipboardFormat.Call(uintptr(unsafe.Pointer(name)))
+	if format == 0 {
+		return false
+	}
+
+	available, _, _ := isClipboardFormatAvailable.Call(format)
+	return available != 0
 }
 
 func (w *Clipboard) readDetected(t uintptr) (capturedData, error) {
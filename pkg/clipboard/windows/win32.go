@@ -79,4 +79,6 @@ var (
 	getFileAttributesEx = kernel32.NewProc("GetFileAttributesExW")
 
 	getPriorityClipboardFormat = user32.MustFindProc("GetPriorityClipboardFormat")
+	registerClipboardFormat    = user32.MustFindProc("RegisterClipboardFormatW")
+	isClipboardFormatAvailable = user32.MustFindProc("IsClipboardFormatAvailable")
 )
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
@@ -70,9 +71,10 @@ func (c *Clipboard) Watch(ctx context.Context, upd chan<- eventful.Update) error
 
 			if h, ok := c.dedup.Check(get); ok {
 				upd <- eventful.Update{
-					Data:     get,
-					MimeType: mime.From(get),
-					Hash:     h,
+					Data:      get,
+					MimeType:  mime.From(get),
+					Hash:      h,
+					Sensitive: sensitive(),
 				}
 			}
 		}
@@ -90,6 +92,16 @@ func (c *Clipboard) Write(t mime.Type, data []byte) (int, error) {
 	return len(data), nil
 }
 
+// sensitive checks the offered types for a password manager hint
+func sensitive() bool {
+	types, err := clipboardGet(exec.Command("wl-paste", "--list-types"))
+	if err != nil {
+		return false
+	}
+
+	return eventful.IsSensitive(strings.Fields(string(types)))
+}
+
 func clipboardGet(cmd *exec.Cmd) ([]byte, error) {
 	return cmd.Output()
 }
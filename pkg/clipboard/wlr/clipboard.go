@@ -14,7 +14,10 @@ import (
 	"github.com/rs/zerolog"
 )
 
-var _ eventful.Eventful = (*Clipboard)(nil)
+var (
+	_ eventful.Eventful = (*Clipboard)(nil)
+	_ eventful.Lazy     = (*Clipboard)(nil)
+)
 
 var Supported = (func() bool {
 	_, exist1 := os.LookupEnv("WAYLAND_DISPLAY")
@@ -75,6 +78,14 @@ func (w *Clipboard) Write(t mime.Type, data []byte) (int, error) {
 	return w.writer.Write(t, data)
 }
 
+func (w *Clipboard) WriteLazy(t mime.Type, fetch eventful.Fetch) error {
+	if w.closed.Load() {
+		return errors.New("clipboard is closed")
+	}
+
+	return w.writer.WriteLazy(t, fetch)
+}
+
 func (w *Clipboard) run(ctx context.Context) error {
 	log := w.logger.With().Str("op", "wlr.run").Logger()
 
@@ -86,7 +86,7 @@ func (r *reader) Selection(offer *controlOffer) {
 
 	offer.Receive(selectedMime, p.Fd())
 
-	go r.readPipeData(selectedMime, p)
+	go r.readPipeData(selectedMime, p, eventful.IsSensitive(r.mimeTypes))
 }
 
 // allowed sliding window debounce
@@ -119,7 +119,7 @@ func (r *reader) selectBestMimeType() string {
 	return ""
 }
 
-func (r *reader) readPipeData(mimeType string, p *pipe.Pipe) {
+func (r *reader) readPipeData(mimeType string, p *pipe.Pipe, sensitive bool) {
 	defer func() { _ = p.Close() }()
 
 	timer := time.AfterFunc(debounce, func() { _ = p.Close() })
@@ -154,6 +154,7 @@ func (r *reader) readPipeData(mimeType string, p *pipe.Pipe) {
 		if _, ok := r.dedup.Check(batchHash); ok {
 			for _, u := range updates {
 				if !r.closed.Load() {
+					u.Sensitive = sensitive
 					r.dataChan <- u
 				}
 			}
@@ -164,9 +165,10 @@ func (r *reader) readPipeData(mimeType string, p *pipe.Pipe) {
 	if h, ok := r.dedup.Check(data); ok {
 		if !r.closed.Load() {
 			r.dataChan <- eventful.Update{
-				Data:     data,
-				MimeType: typ,
-				Hash:     h,
+				Data:      data,
+				MimeType:  typ,
+				Hash:      h,
+				Sensitive: sensitive,
 			}
 		}
 	}
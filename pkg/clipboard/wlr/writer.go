@@ -7,6 +7,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
 	"github.com/labi-le/belphegor/pkg/mime"
 	"github.com/labi-le/belphegor/pkg/rfc8089"
 	"github.com/rs/zerolog"
@@ -35,7 +36,9 @@ func newWriter(preset *preset, reader *reader, log zerolog.Logger) *writer {
 }
 
 type sourceListener struct {
-	data   []byte
+	data []byte
+	// fetch gets the data on paste when it is not known yet
+	fetch  eventful.Fetch
 	source *controlSource
 	logger zerolog.Logger
 	once   sync.Once
@@ -47,14 +50,24 @@ func (s *sourceListener) Send(_ string, f *os.File) {
 
 		ctxLog := s.logger.With().Str("op", "Send").Logger()
 
+		data := s.data
+		if s.fetch != nil {
+			var err error
+			if data, err = s.fetch(); err != nil {
+				ctxLog.Debug().Err(err).Msg("fetch failed")
+				return
+			}
+		}
+
+		// the timeout counts from when the data is there, fetching may take long
 		timer := time.AfterFunc(writeTimeout, func() { f.Close() })
 		defer timer.Stop()
 
 		var total int
 		var writeErr error
 
-		for total < len(s.data) {
-			n, err := f.Write(s.data[total:])
+		for total < len(data) {
+			n, err := f.Write(data[total:])
 			if n > 0 {
 				total += n
 			}
@@ -116,21 +129,36 @@ func (w *writer) Write(t mime.Type, data []byte) (n int, err error) {
 		return 0, errors.New("data control manager not initialized")
 	}
 
-	source := w.deviceManager.CreateDataSource()
+	w.offer(t, &sourceListener{data: format(t, data), logger: w.logger})
+	return len(data), nil
+}
 
-	var dataCopy []byte
-	if t == mime.TypePath {
-		dataCopy = rfc8089.FormatURIList(data)
-	} else {
-		dataCopy = make([]byte, len(data))
-		copy(dataCopy, data)
+// WriteLazy offers t without the data, fetch is called when it is pasted
+func (w *writer) WriteLazy(t mime.Type, fetch eventful.Fetch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("writer is closed")
 	}
 
-	listener := &sourceListener{
-		data:   dataCopy,
-		source: source,
-		logger: w.logger,
+	if w.deviceManager == nil {
+		return errors.New("data control manager not initialized")
 	}
+
+	w.offer(t, &sourceListener{
+		fetch: func() ([]byte, error) {
+			data, err := fetch()
+			return format(t, data), err
+		},
+		logger: w.logger,
+	})
+	return nil
+}
+
+func (w *writer) offer(t mime.Type, listener *sourceListener) {
+	source := w.deviceManager.CreateDataSource()
+	listener.source = source
 	source.Listener = listener
 
 	for _, o := range w.convertMimeType(t) {
@@ -143,8 +171,16 @@ func (w *writer) Write(t mime.Type, data []byte) (n int, err error) {
 
 	w.device.SetSelection(source)
 	w.activeSource = source
+}
 
-	return len(data), nil
+func format(t mime.Type, data []byte) []byte {
+	if t == mime.TypePath {
+		return rfc8089.FormatURIList(data)
+	}
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	return dataCopy
 }
 
 func (w *writer) Close() error {
@@ -3,6 +3,7 @@ package x11
 import (
 	"github.com/jezek/xgb"
 	"github.com/jezek/xgb/xproto"
+	"github.com/labi-le/belphegor/pkg/clipboard/eventful"
 )
 
 type atomCache struct {
@@ -17,13 +18,15 @@ type atomCache struct {
 	ImagePng    xproto.Atom
 	UriList     xproto.Atom
 	LocalProp   xproto.Atom
+	// PasswordHint is offered by password managers next to a secret
+	PasswordHint xproto.Atom
 }
 
 func loadAtoms(c *xgb.Conn) (*atomCache, error) {
 	names := []string{
 		"CLIPBOARD", "TARGETS", "TIMESTAMP", "SAVE_TARGETS", "DELETE", "INCR",
 		"UTF8_STRING", "STRING", "image/png", "text/uri-list",
-		"BELPHEGOR_SELECTION",
+		"BELPHEGOR_SELECTION", eventful.HintKDEPasswordManager,
 	}
 
 	cookies := make([]xproto.InternAtomCookie, len(names))
@@ -41,16 +44,17 @@ func loadAtoms(c *xgb.Conn) (*atomCache, error) {
 	}
 
 	return &atomCache{
-		Clipboard:   atoms[0],
-		Targets:     atoms[1],
-		Timestamp:   atoms[2],
-		SaveTargets: atoms[3],
-		Delete:      atoms[4],
-		Incr:        atoms[5],
-		Utf8String:  atoms[6],
-		String:      atoms[7],
-		ImagePng:    atoms[8],
-		UriList:     atoms[9],
-		LocalProp:   atoms[10],
+		Clipboard:    atoms[0],
+		Targets:      atoms[1],
+		Timestamp:    atoms[2],
+		SaveTargets:  atoms[3],
+		Delete:       atoms[4],
+		Incr:         atoms[5],
+		Utf8String:   atoms[6],
+		String:       atoms[7],
+		ImagePng:     atoms[8],
+		UriList:      atoms[9],
+		LocalProp:    atoms[10],
+		PasswordHint: atoms[11],
 	}, nil
 }
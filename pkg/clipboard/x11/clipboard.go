@@ -25,7 +25,10 @@ const (
 	xFixesClientMinor = 0
 )
 
-var _ eventful.Eventful = (*Clipboard)(nil)
+var (
+	_ eventful.Eventful = (*Clipboard)(nil)
+	_ eventful.Lazy     = (*Clipboard)(nil)
+)
 
 type Clipboard struct {
 	logger zerolog.Logger
@@ -34,10 +37,16 @@ type Clipboard struct {
 	atoms  *atomCache
 	opts   eventful.Options
 
+	// sensitive the current selection carries a password manager hint, set from TARGETS
+	sensitive bool
+
 	mu       sync.Mutex
 	dedup    eventful.Deduplicator
 	serving  []byte
 	serveTyp xproto.Atom
+	// lazy gets serving on the first paste, generation tells a fetch whether its selection is still ours
+	lazy       eventful.Fetch
+	generation uint64
 }
 
 func New(log zerolog.Logger, opts eventful.Options) *Clipboard {
@@ -154,30 +163,58 @@ func (c *Clipboard) Write(t mime.Type, data []byte) (int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var dataCopy []byte
+	c.dedup.Mark(data)
+	if err := c.own(t, format(t, data), nil); err != nil {
+		return 0, err
+	}
 
-	if t == mime.TypePath {
-		dataCopy = rfc8089.FormatURIList(data)
+	return len(data), nil
+}
+
+// WriteLazy takes the selection without the data, fetch is called when it is pasted
+func (c *Clipboard) WriteLazy(t mime.Type, fetch eventful.Fetch) error {
+	if c.conn == nil {
+		return errors.New("x11 not initialized")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.own(t, nil, func() ([]byte, error) {
+		data, err := fetch()
+		return format(t, data), err
+	})
+}
+
+func (c *Clipboard) own(t mime.Type, data []byte, lazy eventful.Fetch) error {
+	switch t {
+	case mime.TypePath:
 		c.serveTyp = c.atoms.UriList
-	} else {
-		dataCopy = make([]byte, len(data))
-		copy(dataCopy, data)
-		if t == mime.TypeImage {
-			c.serveTyp = c.atoms.ImagePng
-		} else {
-			c.serveTyp = c.atoms.Utf8String
-		}
+	case mime.TypeImage:
+		c.serveTyp = c.atoms.ImagePng
+	default:
+		c.serveTyp = c.atoms.Utf8String
 	}
 
-	c.serving = dataCopy
-	c.dedup.Mark(data)
+	c.serving, c.lazy = data, lazy
+	c.generation++
 
 	err := xproto.SetSelectionOwnerChecked(c.conn, c.win, c.atoms.Clipboard, xproto.TimeCurrentTime).Check()
 	if err != nil {
-		return 0, fmt.Errorf("set selection owner: %w", err)
+		return fmt.Errorf("set selection owner: %w", err)
 	}
 
-	return len(data), nil
+	return nil
+}
+
+func format(t mime.Type, data []byte) []byte {
+	if t == mime.TypePath {
+		return rfc8089.FormatURIList(data)
+	}
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	return dataCopy
 }
 
 func (c *Clipboard) handleRequest(e xproto.SelectionRequestEvent) {
@@ -197,6 +234,15 @@ func (c *Clipboard) handleRequest(e xproto.SelectionRequestEvent) {
 		resp.Property = prop
 	}
 
+	isTextReq := e.Target == c.atoms.Utf8String || e.Target == c.atoms.String
+	isTextSrv := c.serveTyp == c.atoms.Utf8String || c.serveTyp == c.atoms.UriList
+	wantsData := e.Target == c.serveTyp || (isTextReq && isTextSrv)
+	if wantsData && c.lazy != nil {
+		// answered once fetched, the event loop goes on meanwhile
+		go c.replyLazy(e, resp, c.lazy, c.generation)
+		return
+	}
+
 	switch e.Target {
 	case c.atoms.Targets:
 		targets := []xproto.Atom{c.atoms.Targets, c.atoms.Timestamp, c.atoms.SaveTargets, c.serveTyp}
@@ -217,17 +263,36 @@ func (c *Clipboard) handleRequest(e xproto.SelectionRequestEvent) {
 		resp.Property = e.Property
 
 	default:
-		if e.Target == c.serveTyp {
-			reply(e.Property, e.Target, 8, c.serving)
-			break
-		}
-		isTextReq := e.Target == c.atoms.Utf8String || e.Target == c.atoms.String
-		isTextSrv := c.serveTyp == c.atoms.Utf8String || c.serveTyp == c.atoms.UriList
-		if isTextReq && isTextSrv {
+		if wantsData {
 			reply(e.Property, e.Target, 8, c.serving)
 		}
 	}
 
+	c.notify(resp)
+}
+
+// replyLazy fetches the data of a lazy selection and answers the request with it,
+// the data is kept for later requests while the selection is still ours
+func (c *Clipboard) replyLazy(e xproto.SelectionRequestEvent, resp xproto.SelectionNotifyEvent, fetch eventful.Fetch, generation uint64) {
+	data, err := fetch()
+	if err != nil {
+		c.logger.Debug().Err(err).Msg("fetch failed")
+		c.notify(resp)
+		return
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.serving, c.lazy = data, nil
+	}
+	c.mu.Unlock()
+
+	xproto.ChangeProperty(c.conn, xproto.PropModeReplace, e.Requestor, e.Property, e.Target, 8, uint32(len(data)), data)
+	resp.Property = e.Property
+	c.notify(resp)
+}
+
+func (c *Clipboard) notify(resp xproto.SelectionNotifyEvent) {
 	buf := new(bytes.Buffer)
 	buf.WriteByte(31)
 	buf.WriteByte(0)
@@ -239,7 +304,7 @@ func (c *Clipboard) handleRequest(e xproto.SelectionRequestEvent) {
 	_ = binary.Write(buf, binary.LittleEndian, resp.Property)
 	buf.Write(make([]byte, 8))
 
-	xproto.SendEvent(c.conn, false, e.Requestor, xproto.EventMaskNoEvent, string(buf.Bytes()))
+	xproto.SendEvent(c.conn, false, resp.Requestor, xproto.EventMaskNoEvent, string(buf.Bytes()))
 }
 
 func (c *Clipboard) fetch() {
@@ -281,6 +346,7 @@ func (c *Clipboard) handleNotify(e xproto.SelectionNotifyEvent, upd chan<- event
 			requestFormat = c.atoms.String
 		}
 
+		c.sensitive = hasAtom(c.atoms.PasswordHint)
 		if requestFormat != 0 {
 			xproto.ConvertSelection(c.conn, c.win, c.atoms.Clipboard, requestFormat, c.atoms.LocalProp, xproto.TimeCurrentTime)
 		}
@@ -332,6 +398,7 @@ func (c *Clipboard) handleNotify(e xproto.SelectionNotifyEvent, upd chan<- event
 
 		if _, ok := c.dedup.Check(batchHash); ok {
 			for _, u := range updates {
+				u.Sensitive = c.sensitive
 				upd <- u
 			}
 		}
@@ -348,9 +415,10 @@ func (c *Clipboard) handleNotify(e xproto.SelectionNotifyEvent, upd chan<- event
 		}
 
 		upd <- eventful.Update{
-			Data:     data,
-			MimeType: mTyp,
-			Hash:     h,
+			Data:      data,
+			MimeType:  mTyp,
+			Hash:      h,
+			Sensitive: c.sensitive,
 		}
 	}
 }
@@ -1,14 +1,19 @@
 package id
 
 import (
+	"errors"
 	"fmt"
-	"net"
+	"io/fs"
+	"math"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bwmarrin/snowflake"
-	"github.com/cespare/xxhash"
 )
 
 type Unique = int64
@@ -21,18 +26,26 @@ const (
 )
 
 var (
-	MyID      = getNodeID()
+	// MyID is the id of this device on the wire, SetMine derives it from the device identity
+	MyID = randomDeviceID()
+	// MyNode is the generator node of this device, the 10 bits of the ids it makes.
+	// It only keeps the ids unique, devices are told apart by MyID
+	MyNode    = getNodeID()
 	generator = new(idGenerator)
+
+	ErrStarted = errors.New("node id is already in use by generated ids")
 )
 
 type idGenerator struct {
-	node *snowflake.Node
-	once sync.Once
+	node    *snowflake.Node
+	once    sync.Once
+	started atomic.Bool
 }
 
 func (g *idGenerator) nextID() int64 {
 	g.once.Do(func() {
-		node, err := snowflake.NewNode(MyID)
+		g.started.Store(true)
+		node, err := snowflake.NewNode(MyNode)
 		if err != nil {
 			panic(fmt.Sprintf("failed to initialize snowflake node: %s", err))
 		}
@@ -45,39 +58,94 @@ func New() Unique {
 	return generator.nextID()
 }
 
+// Mine reports whether the id was made by this generator node
 func Mine(id Unique) bool {
-	return Author(id) == MyID
+	return Author(id) == MyNode
 }
 
-func getNodeID() int64 {
-	if v, ok := os.LookupEnv("BELPHEGOR_NODE_ID"); ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			nid := int64(n) % nodeIDCount
-			if nid < 0 {
-				nid += nodeIDCount
-			}
-			return nid
+// Load reads the generator node persisted at path, generating a random one on the first run,
+// so it survives restarts. BELPHEGOR_NODE_ID overrides it
+func Load(path string) (Unique, error) {
+	if nid, ok := envNodeID(); ok {
+		return nid, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		nid := randomNodeID()
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return 0, fmt.Errorf("id.Load: %w", err)
 		}
+		if err := os.WriteFile(path, []byte(strconv.FormatInt(nid, 10)+"\n"), 0600); err != nil {
+			return 0, fmt.Errorf("id.Load: %w", err)
+		}
+		return nid, nil
+	case err != nil:
+		return 0, fmt.Errorf("id.Load: %w", err)
+	}
+
+	raw := strings.TrimSpace(string(data))
+	nid, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || nid <= 0 || nid >= nodeIDCount {
+		return 0, fmt.Errorf("id.Load: %s: invalid node id %q", path, raw)
+	}
+
+	return nid, nil
+}
+
+// SetNode makes node the author of generated ids, it must be called before the first New
+func SetNode(node Unique) error {
+	if generator.started.Load() {
+		return ErrStarted
+	}
+
+	MyNode = node
+	return nil
+}
+
+// SetMine sets the id of this device, it must be called before the device is described to peers
+func SetMine(device Unique) {
+	MyID = device
+}
+
+func getNodeID() int64 {
+	if nid, ok := envNodeID(); ok {
+		return nid
 	}
 
-	interfaces, err := net.Interfaces()
+	return randomNodeID()
+}
+
+func envNodeID() (int64, bool) {
+	v, ok := os.LookupEnv("BELPHEGOR_NODE_ID")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		return 1
+		return 0, false
 	}
 
-	for _, i := range interfaces {
-		if (i.Flags&net.FlagUp) != 0 && i.HardwareAddr != nil && len(i.HardwareAddr) > 0 {
-			h := xxhash.New()
-			if _, writeErr := h.Write(i.HardwareAddr); writeErr != nil {
-				panic(fmt.Sprintf("failed to generate node id: %s", writeErr))
-			}
-			return int64(h.Sum64() % nodeIDCount)
-		}
+	nid := int64(n) % nodeIDCount
+	if nid < 0 {
+		nid += nodeIDCount
 	}
+	return nid, true
+}
+
+// randomNodeID never returns 0, it stands for an unknown node
+func randomNodeID() int64 {
+	return 1 + rand.Int64N(nodeIDCount-1) //nolint:gosec // not a secret
+}
 
-	return 1 // fallback
+// randomDeviceID stands in for the id of a device without an identity, e.g. in tests
+func randomDeviceID() int64 {
+	return 1 + rand.Int64N(math.MaxInt64-1) //nolint:gosec // not a secret
 }
 
+// Author is the generator node of the id
 func Author(id Unique) Unique {
 	return (id >> nodeIDShift) & nodeIDMask
 }
@@ -1,6 +1,9 @@
 package id_test
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/labi-le/belphegor/pkg/id"
@@ -29,12 +32,12 @@ func TestAuthor(t *testing.T) {
 }
 
 func TestMine(t *testing.T) {
-	self := (id.MyID << 12) | 0x42
+	self := (id.MyNode << 12) | 0x42
 	if !id.Mine(self) {
-		t.Fatalf("Mine(id with author=MyID=%d) = false, want true", id.MyID)
+		t.Fatalf("Mine(id with author=MyNode=%d) = false, want true", id.MyNode)
 	}
 
-	other := (((id.MyID + 1) & 0x3FF) << 12) | 0x42
+	other := (((id.MyNode + 1) & 0x3FF) << 12) | 0x42
 	if id.Mine(other) {
 		t.Fatal("Mine(id from another author) = true, want false")
 	}
@@ -52,7 +55,61 @@ func TestNew_UniqueAndAttributedToSelf(t *testing.T) {
 		seen[got] = struct{}{}
 
 		if !id.Mine(got) {
-			t.Fatalf("New() id %d not attributed to MyID %d", got, id.MyID)
+			t.Fatalf("New() id %d not attributed to MyNode %d", got, id.MyNode)
 		}
 	}
 }
+
+func TestLoad_Persistent(t *testing.T) {
+	t.Setenv("BELPHEGOR_NODE_ID", "")
+	os.Unsetenv("BELPHEGOR_NODE_ID")
+	path := filepath.Join(t.TempDir(), "state", "node_id")
+
+	first, err := id.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first <= 0 || first > 1023 {
+		t.Fatalf("Load() = %d, want 1..1023", first)
+	}
+
+	second, err := id.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("Load() = %d then %d", first, second)
+	}
+}
+
+func TestLoad_Env(t *testing.T) {
+	t.Setenv("BELPHEGOR_NODE_ID", "1030")
+
+	got, err := id.Load(filepath.Join(t.TempDir(), "node_id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 6 {
+		t.Fatalf("Load() = %d, want 1030 %% 1024", got)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node_id")
+	if err := os.WriteFile(path, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BELPHEGOR_NODE_ID", "")
+	os.Unsetenv("BELPHEGOR_NODE_ID")
+
+	if _, err := id.Load(path); err == nil {
+		t.Fatal("Load() accepted a broken file")
+	}
+}
+
+func TestSetNode_AfterNew(t *testing.T) {
+	_ = id.New()
+	if err := id.SetNode(id.MyNode); !errors.Is(err, id.ErrStarted) {
+		t.Fatalf("SetNode() after New = %v, want ErrStarted", err)
+	}
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"maps"
 	"net"
+	"net/netip"
 )
 
 var localIPs = map[string]struct{}{
@@ -42,8 +43,28 @@ func LocalIPs() map[string]struct{} {
 }
 
 func IsLocalIP(ip net.IP) bool {
-	_, ok := LocalIPs()[ip.String()]
-	return ok
+	addr, ok := netip.AddrFromSlice(ip)
+	return ok && IsLocalAddr(addr)
+}
+
+// IsLocalAddr reports whether addr belongs to this host, the zone of a link-local address is ignored
+func IsLocalAddr(addr netip.Addr) bool {
+	addr = addr.WithZone("").Unmap()
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err == nil && prefix.Addr().Unmap() == addr {
+			return true
+		}
+	}
+	return false
 }
 
 func copyMap[T comparable, V comparable](m map[T]V) map[T]V {
@@ -2,6 +2,7 @@ package network_test
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/labi-le/belphegor/pkg/network"
@@ -28,6 +29,52 @@ func TestIsLocalIP(t *testing.T) {
 	}
 }
 
+func TestIsLocalAddr(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  string
+		local bool
+	}{
+		{"loopback v6 with zone", "::1%lo", true},
+		{"v4 mapped loopback", "::ffff:127.0.0.1", true},
+		{"unspecified v6", "::", true},
+		{"remote link-local", "fe80::dead:beef%eth0", false},
+		{"public documentation ip v6", "2001:db8::1", false}, // RFC 3849, never assigned
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := network.IsLocalAddr(netip.MustParseAddr(tt.addr)); got != tt.local {
+				t.Fatalf("IsLocalAddr(%s) = %v, want %v", tt.addr, got, tt.local)
+			}
+		})
+	}
+}
+
+func TestIsLocalAddr_Interfaces(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			prefix, err := netip.ParsePrefix(a.String())
+			if err != nil {
+				continue
+			}
+			addr := prefix.Addr()
+			if addr.IsLinkLocalUnicast() {
+				addr = addr.WithZone(iface.Name)
+			}
+			if !network.IsLocalAddr(addr) {
+				t.Errorf("IsLocalAddr(%s) = false for an address of %s", addr, iface.Name)
+			}
+		}
+	}
+}
+
 func TestLocalIPs_ContainsBuiltins(t *testing.T) {
 	local := network.LocalIPs()
 	for _, want := range []string{"127.0.0.1", "::1", "0.0.0.0", "localhost"} {